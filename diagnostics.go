@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+)
+
+// runNodeDiagnostics prints what the node knows of its pods, for
+// troubleshooting.
+func runNodeDiagnostics() error {
+	return multitenant.PodDiagnostics(os.Stdout, podstate.DefaultDir)
+}
@@ -148,7 +148,7 @@ os::build::create_gopath_tree() {
 
 # os::build::setup_env will check that the `go` commands is available in
 # ${PATH}. If not running on Travis, it will also check that the Go version is
-# good enough for the Kubernetes build.
+# good enough for the build, which uses GOPATH mode.
 #
 # Input Vars:
 #   OS_EXTRA_GOPATH - If set, this is included in created GOPATH
@@ -178,12 +178,12 @@ EOF
   if [[ "${TRAVIS:-}" != "true" ]]; then
     local go_version
     go_version=($(go version))
-    if [[ "${go_version[2]}" < "go1.2" ]]; then
+    if [[ -z $(echo "${go_version[2]}" | grep -E '^go1\.(2[1-9]|[3-9][0-9])') ]]; then
       echo <<EOF
 
 Detected go version: ${go_version[*]}.
-Kubernetes requires go version 1.2 or greater.
-Please install Go version 1.2 or later.
+openshift-sdn requires go version 1.21 or greater.
+Please install Go version 1.21 or later.
 
 EOF
       exit 2
@@ -191,6 +191,7 @@ EOF
   fi
 
   GOPATH=${OS_GOPATH}
+  export GO111MODULE=off
 
   # Append OS_EXTRA_GOPATH to the GOPATH if it is defined.
   if [[ -n ${OS_EXTRA_GOPATH:-} ]]; then
@@ -1,3 +1,20 @@
 #!/bin/bash
 go test -v github.com/openshift/openshift-sdn/pkg/netutils
 go test -v github.com/openshift/openshift-sdn/pkg/netutils/server
+go test -v github.com/openshift/openshift-sdn/pkg/netutils/client
+go test -v github.com/openshift/openshift-sdn/pkg/ovs/openflow
+go test -v github.com/openshift/openshift-sdn/pkg/ovs/ovsdb
+go test -v github.com/openshift/openshift-sdn/pkg/ovs/ofctl
+go test -v github.com/openshift/openshift-sdn/pkg/exec
+go test -v github.com/openshift/openshift-sdn/pkg/netlink
+go test -v github.com/openshift/openshift-sdn/pkg/netns
+go test -v github.com/openshift/openshift-sdn/ovssubnet
+go test -v github.com/openshift/openshift-sdn/ovssubnet/registry
+go test -v github.com/openshift/openshift-sdn/ovssubnet/podstate
+go test -v github.com/openshift/openshift-sdn/ovssubnet/nodesetup
+go test -v github.com/openshift/openshift-sdn/ovssubnet/cookie
+go test -v github.com/openshift/openshift-sdn/ovssubnet/controller/kube
+go test -v github.com/openshift/openshift-sdn/ovssubnet/controller/lbr
+go test -v github.com/openshift/openshift-sdn/ovssubnet/controller/hostgw
+go test -v github.com/openshift/openshift-sdn/ovssubnet/controller/vxlan
+go test -v github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant
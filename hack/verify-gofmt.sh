@@ -10,7 +10,7 @@ GO_VERSION=($(go version))
 
 GO_VERSION=($(go version))
 
-if [[ -z $(echo "${GO_VERSION[2]}" | grep -E 'go1.2|go1.3|go1.4') ]]; then
+if [[ -z $(echo "${GO_VERSION[2]}" | grep -E '^go1\.(2[1-9]|[3-9][0-9])') ]]; then
   echo "Unknown go version '${GO_VERSION}', skipping gofmt."
   exit 0
 fi
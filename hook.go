@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
+)
+
+// runMultitenantHook runs the network plugin action the kubelet asked the
+// openshift-ovs-multitenant script for. Setup looks up the pod's annotations,
+// such as its bandwidth limits, under -pod-path in etcd; ANNOTATION=VALUE
+// arguments override them.
+func runMultitenantHook(args []string) error {
+	if len(args) == 1 && args[0] == "init" {
+		return nil
+	}
+	usage := fmt.Errorf("Usage: multitenant-hook init|setup|teardown NAMESPACE NAME CONTAINER [ANNOTATION=VALUE]...")
+	if len(args) < 4 || (args[0] != "setup" && args[0] != "teardown") || (args[0] == "teardown" && len(args) > 4) {
+		return usage
+	}
+	action, namespace, name, containerID := args[0], args[1], args[2], args[3]
+	annotations := map[string]string{}
+	for _, arg := range args[4:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return usage
+		}
+		annotations[parts[0]] = parts[1]
+	}
+
+	sub, err := newSubnetRegistry()
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the registry: %v", err)
+	}
+	bridge, err := ovsdb.Dial("unix", ovsdb.DefaultSocket)
+	if err != nil {
+		return err
+	}
+	defer bridge.Close()
+
+	hook := multitenant.NewPodHook(sub, exec.New(), bridge)
+	if action == "setup" {
+		err = hook.Setup(namespace, name, containerID, annotations)
+	} else {
+		err = hook.Teardown(namespace, name, containerID)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to %s pod %s/%s: %v", action, namespace, name, err)
+	}
+	return nil
+}
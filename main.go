@@ -17,7 +17,7 @@ import (
 )
 
 type NetworkManager interface {
-	StartMaster(sync bool, containerNetwork string, containerSubnetLength uint) error
+	StartMaster(sync bool, containerNetwork string, containerSubnetLength uint, tunnel api.Tunnel) error
 	StartNode(sync, skipsetup bool) error
 	Stop()
 }
@@ -25,6 +25,8 @@ type NetworkManager interface {
 type CmdLineOpts struct {
 	containerNetwork      string
 	containerSubnetLength uint
+	tunnelType            string
+	tunnelPort            uint
 	etcdEndpoints         string
 	etcdPath              string
 	etcdKeyfile           string
@@ -33,12 +35,17 @@ type CmdLineOpts struct {
 	ip                    string
 	hostname              string
 	minionPath            string
+	podPath               string
 	master                bool
 	minion                bool
 	skipsetup             bool
 	sync                  bool
 	kube                  bool
 	multitenant           bool
+	hostGateway           bool
+	kernelVXLAN           bool
+	serviceLoadBalancing  bool
+	serviceNetwork        string
 	help                  bool
 }
 
@@ -47,9 +54,12 @@ var opts CmdLineOpts
 func init() {
 	flag.StringVar(&opts.containerNetwork, "container-network", "10.1.0.0/16", "container network")
 	flag.UintVar(&opts.containerSubnetLength, "container-subnet-length", 8, "container subnet length")
+	flag.StringVar(&opts.tunnelType, "tunnel-type", string(api.TunnelVXLAN), "encapsulation between nodes: vxlan, geneve or gre (for master mode)")
+	flag.UintVar(&opts.tunnelPort, "tunnel-port", 0, "UDP port of the tunnel, 0 for the standard port of the type: 4789 for vxlan, 6081 for geneve (for master mode)")
 	flag.StringVar(&opts.etcdEndpoints, "etcd-endpoints", "http://127.0.0.1:4001", "a comma-delimited list of etcd endpoints")
 	flag.StringVar(&opts.etcdPath, "etcd-path", "/registry/sdn/", "etcd path")
 	flag.StringVar(&opts.minionPath, "minion-path", "/kubernetes.io/minions/", "etcd path that will be watched for minion creation/deletion (Note: -sync flag will override this path with -etcd-path)")
+	flag.StringVar(&opts.podPath, "pod-path", "/kubernetes.io/pods/", "etcd path of the pods of Kubernetes, where the multitenant hook looks up the annotations of a pod")
 	flag.StringVar(&opts.etcdKeyfile, "etcd-keyfile", "", "SSL key file used to secure etcd communication")
 	flag.StringVar(&opts.etcdCertfile, "etcd-certfile", "", "SSL certification file used to secure etcd communication")
 	flag.StringVar(&opts.etcdCAFile, "etcd-cafile", "", "SSL Certificate Authority file used to secure etcd communication")
@@ -63,6 +73,10 @@ func init() {
 	flag.BoolVar(&opts.sync, "sync", false, "Sync the minions directly to etcd-path (Do not wait for PaaS to do so!)")
 	flag.BoolVar(&opts.kube, "kube", false, "Use kubernetes hooks for optimal integration with OVS. This option bypasses the Linux bridge. Any docker containers started manually (not through OpenShift/Kubernetes) will stay local and not connect to the SDN.")
 	flag.BoolVar(&opts.multitenant, "multitenant", false, "Same as 'kube' but with multitenant capabilities. This option will only be examined if 'kube' option is 'false'.")
+	flag.BoolVar(&opts.hostGateway, "host-gateway", false, "Route the subnets of other nodes through their IPs instead of tunneling to them. All nodes must share a network segment; cannot be combined with 'kube' or 'multitenant'.")
+	flag.BoolVar(&opts.kernelVXLAN, "kernel-vxlan", false, "Tunnel to other nodes through a kernel vxlan device on lbr0 instead of Open vSwitch, for hosts without it. Needs a vxlan tunnel; cannot be combined with 'kube', 'multitenant' or 'host-gateway'.")
+	flag.StringVar(&opts.serviceNetwork, "service-network", "172.30.0.0/16", "network of the service IPs, which pods reach through the host without egress network policies and egress IPs applying (for multitenant minion mode)")
+	flag.BoolVar(&opts.serviceLoadBalancing, "service-load-balancing", false, "Load-balance service IPs in OVS instead of an external proxy (for multitenant minion mode, needs OVS 2.6 or later for conntrack)")
 
 	flag.BoolVar(&opts.help, "help", false, "print this message")
 }
@@ -81,13 +95,35 @@ func newNetworkManager() (NetworkManager, error) {
 		host = strings.TrimSpace(string(output))
 	}
 
+	if opts.hostGateway && (opts.kube || opts.multitenant) {
+		return nil, fmt.Errorf("Host-gateway mode cannot be combined with the kube or multitenant plugin")
+	}
+	if opts.kernelVXLAN && (opts.kube || opts.multitenant || opts.hostGateway) {
+		return nil, fmt.Errorf("Kernel VXLAN mode cannot be combined with host-gateway mode or the kube or multitenant plugin")
+	}
 	if opts.kube {
 		return ovssubnet.NewKubeController(sub, string(host), opts.ip, nil)
 	} else {
 		if opts.multitenant {
-			return ovssubnet.NewMultitenantController(sub, string(host), opts.ip, nil)
+			mtController, err := ovssubnet.NewMultitenantController(sub, string(host), opts.ip, nil)
+			if err == nil && opts.serviceNetwork != "" {
+				err = mtController.SetServiceNetwork(opts.serviceNetwork)
+			}
+			if err == nil && opts.serviceLoadBalancing {
+				err = mtController.EnableServiceLoadBalancing()
+			}
+			return mtController, err
 		}
 	}
+	if opts.serviceLoadBalancing {
+		return nil, fmt.Errorf("Service load balancing needs the multitenant plugin")
+	}
+	if opts.hostGateway {
+		return ovssubnet.NewHostGatewayController(sub, string(host), opts.ip, nil)
+	}
+	if opts.kernelVXLAN {
+		return ovssubnet.NewKernelVXLANController(sub, string(host), opts.ip, nil)
+	}
 	// default OVS controller
 	return ovssubnet.NewDefaultController(sub, string(host), opts.ip, nil)
 }
@@ -103,13 +139,23 @@ func newSubnetRegistry() (api.SubnetRegistry, error) {
 	}
 
 	cfg := &registry.EtcdConfig{
-		Endpoints:        peers,
-		Keyfile:          opts.etcdKeyfile,
-		Certfile:         opts.etcdCertfile,
-		CAFile:           opts.etcdCAFile,
-		SubnetPath:       subnetPath,
-		SubnetConfigPath: subnetConfigPath,
-		MinionPath:       minionPath,
+		Endpoints:               peers,
+		Keyfile:                 opts.etcdKeyfile,
+		Certfile:                opts.etcdCertfile,
+		CAFile:                  opts.etcdCAFile,
+		SubnetPath:              subnetPath,
+		SubnetConfigPath:        subnetConfigPath,
+		MinionPath:              minionPath,
+		NetNamespacePath:        path.Join(opts.etcdPath, "netnamespaces"),
+		NetworkPolicyPath:       path.Join(opts.etcdPath, "networkpolicies"),
+		EgressNetworkPolicyPath: path.Join(opts.etcdPath, "egressnetworkpolicies"),
+		EgressIPPath:            path.Join(opts.etcdPath, "egressips"),
+		HeartbeatPath:           path.Join(opts.etcdPath, "heartbeats"),
+		ServicePath:             path.Join(opts.etcdPath, "services"),
+		MulticastPath:           path.Join(opts.etcdPath, "multicast"),
+		NodeVNIDPath:            path.Join(opts.etcdPath, "nodevnids"),
+		NetNamespaceRequestPath: path.Join(opts.etcdPath, "netnamespacerequests"),
+		PodPath:                 opts.podPath,
 	}
 
 	return registry.NewEtcdSubnetRegistry(cfg)
@@ -125,10 +171,35 @@ func main() {
 
 	if opts.help {
 		fmt.Fprintf(os.Stderr, "Usage: %s [OPTION]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [OPTION]... multitenant-hook init|setup|teardown NAMESPACE NAME CONTAINER [ANNOTATION=VALUE]...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s node-diagnostics\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [OPTION]... netnamespace join NAMESPACE TARGET|isolate NAMESPACE|global NAMESPACE\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "multitenant-hook" {
+		if err := runMultitenantHook(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if flag.Arg(0) == "netnamespace" {
+		if err := runNetNamespace(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if flag.Arg(0) == "node-diagnostics" {
+		if err := runNodeDiagnostics(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Register for SIGINT and SIGTERM and wait for one of them to arrive
 	log.Info("Installing signal handlers")
 	sigs := make(chan os.Signal, 1)
@@ -144,7 +215,8 @@ func main() {
 			log.Fatalf("Failed to start openshift sdn in node mode: %v", err)
 		}
 	} else if opts.master {
-		err := be.StartMaster(opts.sync, opts.containerNetwork, opts.containerSubnetLength)
+		tunnel := api.Tunnel{Type: api.TunnelType(opts.tunnelType), Port: opts.tunnelPort}
+		err := be.StartMaster(opts.sync, opts.containerNetwork, opts.containerSubnetLength, tunnel)
 		if err != nil {
 			log.Fatalf("Failed to start openshift sdn in master mode: %v", err)
 		}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+)
+
+// runNetNamespace asks the master to change the network of a namespace: to
+// join the network of another namespace, to get a private network back or
+// to become global. The master carries the request out asynchronously.
+func runNetNamespace(args []string) error {
+	usage := fmt.Errorf("Usage: netnamespace join NAMESPACE TARGET|isolate NAMESPACE|global NAMESPACE")
+	if len(args) < 2 {
+		return usage
+	}
+	request := api.NetNamespaceRequest{Action: api.NetNamespaceAction(args[0]), Namespace: args[1]}
+	if request.Action == api.JoinNetNamespace {
+		if len(args) != 3 {
+			return usage
+		}
+		request.Target = args[2]
+	} else if len(args) != 2 {
+		return usage
+	}
+	if err := request.Validate(); err != nil {
+		return err
+	}
+
+	sub, err := newSubnetRegistry()
+	if err != nil {
+		return fmt.Errorf("Failed to connect to the registry: %v", err)
+	}
+	if err := sub.WriteNetNamespaceRequest(request); err != nil {
+		return fmt.Errorf("Failed to request %s of namespace %s: %v", request.Action, request.Namespace, err)
+	}
+	return nil
+}
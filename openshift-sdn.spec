@@ -17,7 +17,7 @@ ExclusiveArch:  x86_64
 Source0:        https://%{import_path}/archive/%{commit}/%{name}-%{version}.tar.gz
 
 BuildRequires:  systemd
-BuildRequires:  golang >= 1.2-7
+BuildRequires:  golang >= 1.21
 
 
 %description
@@ -63,14 +63,12 @@ export GOPATH=$(pwd)/%{osdn_gopath}:$(pwd)/Godeps/_workspace:%{buildroot}%{gopat
 
 # Default to building all of the components
 go build %{import_path}
-cp $(pwd)/ovssubnet/bin/openshift-sdn-simple-setup-node.sh $(pwd)/openshift-sdn-simple-setup-node.sh
-cp $(pwd)/ovssubnet/bin/openshift-sdn-kube-subnet-setup.sh $(pwd)/openshift-sdn-kube-subnet-setup.sh
 cp $(pwd)/ovssubnet/bin/openshift-ovs-subnet $(pwd)/openshift-ovs-subnet
 
 %install
 
 install -d %{buildroot}%{_bindir}
-for bin in openshift-sdn openshift-sdn-simple-setup-node.sh openshift-sdn-kube-subnet-setup.sh
+for bin in openshift-sdn
 do
   install -p -m 755 ${bin} %{buildroot}%{_bindir}/${bin}
 done
@@ -95,8 +93,6 @@ install -p -m 0644 rel-eng/docker-sdn-ovs.conf %{buildroot}%{_prefix}/lib/system
 # TODO - add LICENSE: %doc README.md LICENSE
 %doc README.md
 %{_bindir}/openshift-sdn
-%{_bindir}/openshift-sdn-simple-setup-node.sh
-%{_bindir}/openshift-sdn-kube-subnet-setup.sh
 %{kube_plugin_path}/openshift-ovs-subnet
 
 %files master
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"net"
+)
+
+// EgressIP is the source address of the traffic of a namespace leaving the
+// cluster network. The traffic is sent out by one node, which owns the
+// address.
+type EgressIP struct {
+	Namespace string
+	IP        string
+	// Nodes are the IP addresses of the nodes eligible to own the address,
+	// in order of preference. They must be able to take IP on their
+	// network.
+	Nodes []string
+	// Node is the current owner, set by the master to the first of Nodes
+	// that is alive; empty while none is
+	Node string
+}
+
+type EgressIPEvent struct {
+	Type     EventType
+	EgressIP EgressIP
+}
+
+// Validate reports an egress IP that cannot be assigned.
+func (e *EgressIP) Validate() error {
+	if e.Namespace == "" {
+		return fmt.Errorf("Egress IP %s needs a namespace", e.IP)
+	}
+	if ip := net.ParseIP(e.IP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("Invalid egress IP %q of namespace %s", e.IP, e.Namespace)
+	}
+	if len(e.Nodes) == 0 {
+		return fmt.Errorf("Egress IP %s of namespace %s has no eligible nodes", e.IP, e.Namespace)
+	}
+	return nil
+}
+
+// Eligible tells whether node may own the address.
+func (e *EgressIP) Eligible(node string) bool {
+	for _, n := range e.Nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
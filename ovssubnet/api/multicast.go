@@ -0,0 +1,14 @@
+package api
+
+// NodeVNIDs are the VNIDs of the pods on a node, which tell the other nodes
+// where to send the multicast traffic of a VNID.
+type NodeVNIDs struct {
+	// Node is the IP address of the node
+	Node  string
+	VNIDs []uint
+}
+
+type NodeVNIDsEvent struct {
+	Type      EventType
+	NodeVNIDs NodeVNIDs
+}
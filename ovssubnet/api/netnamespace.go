@@ -0,0 +1,49 @@
+package api
+
+import "fmt"
+
+// NetNamespaceAction is a change to the network of a namespace.
+type NetNamespaceAction string
+
+const (
+	// JoinNetNamespace gives the namespace the VNID of Target
+	JoinNetNamespace NetNamespaceAction = "join"
+	// IsolateNetNamespace gives the namespace a private VNID again
+	IsolateNetNamespace NetNamespaceAction = "isolate"
+	// MakeNetNamespaceGlobal gives the namespace VNID 0
+	MakeNetNamespaceGlobal NetNamespaceAction = "global"
+)
+
+// NetNamespaceRequest asks the master to change the network of a namespace.
+// The master, which allocates the VNIDs, carries it out and deletes it.
+type NetNamespaceRequest struct {
+	Namespace string
+	Action    NetNamespaceAction
+	// Target is the namespace whose network Namespace joins
+	Target string `json:",omitempty"`
+}
+
+type NetNamespaceRequestEvent struct {
+	Type    EventType
+	Request NetNamespaceRequest
+}
+
+// Validate reports a request the master cannot carry out.
+func (r *NetNamespaceRequest) Validate() error {
+	if r.Namespace == "" {
+		return fmt.Errorf("Network namespace request needs a namespace")
+	}
+	switch r.Action {
+	case JoinNetNamespace:
+		if r.Target == "" {
+			return fmt.Errorf("Namespace %s needs a namespace to join", r.Namespace)
+		}
+	case IsolateNetNamespace, MakeNetNamespaceGlobal:
+		if r.Target != "" {
+			return fmt.Errorf("Only joining a namespace takes a target, not %s", r.Action)
+		}
+	default:
+		return fmt.Errorf("Unknown action %q for namespace %s", r.Action, r.Namespace)
+	}
+	return nil
+}
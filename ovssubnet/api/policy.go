@@ -0,0 +1,132 @@
+package api
+
+import (
+	"fmt"
+	"net"
+)
+
+// NetworkPolicy admits traffic into the pods of a namespace that multitenant
+// isolation would drop because it comes from another VNID.
+type NetworkPolicy struct {
+	Namespace string
+	Name      string
+	Ingress   []PolicyRule
+}
+
+// PolicyRule admits traffic from the pods of FromNamespace or from the
+// addresses in FromCIDR; exactly one of them is set. Protocol ("tcp" or
+// "udp") and Port narrow the rule down to a destination port; an empty
+// Protocol admits all IP traffic.
+type PolicyRule struct {
+	FromNamespace string
+	FromCIDR      string
+	Protocol      string
+	Port          uint
+}
+
+type NetworkPolicyEvent struct {
+	Type   EventType
+	Policy NetworkPolicy
+}
+
+// Validate reports a policy the flow controller cannot compile.
+func (p *NetworkPolicy) Validate() error {
+	if p.Namespace == "" || p.Name == "" {
+		return fmt.Errorf("Network policy %q in namespace %q needs a name and a namespace", p.Name, p.Namespace)
+	}
+	for i, r := range p.Ingress {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("Invalid rule %d of network policy %s/%s: %v", i, p.Namespace, p.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *PolicyRule) validate() error {
+	switch {
+	case r.FromNamespace != "" && r.FromCIDR != "":
+		return fmt.Errorf("both a namespace and a CIDR to admit traffic from")
+	case r.FromNamespace == "" && r.FromCIDR == "":
+		return fmt.Errorf("neither a namespace nor a CIDR to admit traffic from")
+	case r.FromCIDR != "":
+		if _, _, err := net.ParseCIDR(r.FromCIDR); err != nil {
+			return err
+		}
+	}
+	return validatePort(r.Protocol, r.Port)
+}
+
+func validatePort(protocol string, port uint) error {
+	switch protocol {
+	case "tcp", "udp":
+		if port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	case "":
+		if port != 0 {
+			return fmt.Errorf("port %d without a protocol", port)
+		}
+	default:
+		return fmt.Errorf("unknown protocol %q, expected tcp or udp", protocol)
+	}
+	return nil
+}
+
+// EgressNetworkPolicy decides which destinations outside the cluster network
+// the pods of a namespace may reach. The first rule that matches a packet
+// applies; packets no rule matches are allowed.
+type EgressNetworkPolicy struct {
+	Namespace string
+	Egress    []EgressRule
+}
+
+type EgressRuleType string
+
+const (
+	EgressAllow EgressRuleType = "Allow"
+	EgressDeny  EgressRuleType = "Deny"
+)
+
+// MaxEgressRules is how many rules an egress policy may have.
+const MaxEgressRules = 1000
+
+// EgressRule allows or denies traffic to the addresses in To, a CIDR.
+// Protocol ("tcp" or "udp") and Port narrow the rule down to a destination
+// port; an empty Protocol matches all IP traffic.
+type EgressRule struct {
+	Type     EgressRuleType
+	To       string
+	Protocol string
+	Port     uint
+}
+
+type EgressNetworkPolicyEvent struct {
+	Type   EventType
+	Policy EgressNetworkPolicy
+}
+
+// Validate reports a policy the flow controller cannot compile.
+func (p *EgressNetworkPolicy) Validate() error {
+	if p.Namespace == "" {
+		return fmt.Errorf("Egress network policy needs a namespace")
+	}
+	if len(p.Egress) > MaxEgressRules {
+		return fmt.Errorf("Egress network policy of namespace %s has %d rules, at most %d are supported", p.Namespace, len(p.Egress), MaxEgressRules)
+	}
+	for i, r := range p.Egress {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("Invalid rule %d of the egress network policy of namespace %s: %v", i, p.Namespace, err)
+		}
+	}
+	return nil
+}
+
+func (r *EgressRule) validate() error {
+	if r.Type != EgressAllow && r.Type != EgressDeny {
+		return fmt.Errorf("unknown type %q, expected %s or %s", r.Type, EgressAllow, EgressDeny)
+	}
+	if _, _, err := net.ParseCIDR(r.To); err != nil {
+		return err
+	}
+	return validatePort(r.Protocol, r.Port)
+}
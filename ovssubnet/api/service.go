@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net"
+)
+
+// Service is a cluster IP whose ports nodes load-balance across endpoints in
+// br0 when service load balancing is enabled.
+type Service struct {
+	Namespace string
+	Name      string
+	IP        string
+	Ports     []ServicePort
+}
+
+// ServicePort spreads the connections to one port of the service across
+// Endpoints. A port without endpoints drops its traffic.
+type ServicePort struct {
+	// Protocol is "tcp" or "udp"
+	Protocol  string
+	Port      uint
+	Endpoints []Endpoint
+}
+
+// Endpoint is a pod serving a service port.
+type Endpoint struct {
+	IP   string
+	Port uint
+}
+
+type ServiceEvent struct {
+	Type    EventType
+	Service Service
+}
+
+// Validate reports a service the flow controller cannot load-balance.
+func (s *Service) Validate() error {
+	if s.Namespace == "" || s.Name == "" {
+		return fmt.Errorf("Service %q in namespace %q needs a name and a namespace", s.Name, s.Namespace)
+	}
+	if ip := net.ParseIP(s.IP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("Invalid IP %q of service %s/%s", s.IP, s.Namespace, s.Name)
+	}
+	for i, p := range s.Ports {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("Invalid port %d of service %s/%s: %v", i, s.Namespace, s.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *ServicePort) validate() error {
+	if p.Protocol == "" || p.Port == 0 {
+		return fmt.Errorf("a port needs a protocol and a number")
+	}
+	if err := validatePort(p.Protocol, p.Port); err != nil {
+		return err
+	}
+	for _, e := range p.Endpoints {
+		if ip := net.ParseIP(e.IP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid endpoint IP %q", e.IP)
+		}
+		if e.Port == 0 || e.Port > 65535 {
+			return fmt.Errorf("invalid endpoint port %d", e.Port)
+		}
+	}
+	return nil
+}
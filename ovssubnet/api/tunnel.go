@@ -0,0 +1,95 @@
+package api
+
+import (
+	"fmt"
+)
+
+// TunnelType is the encapsulation nodes use to reach each other.
+type TunnelType string
+
+const (
+	TunnelVXLAN  TunnelType = "vxlan"
+	TunnelGeneve TunnelType = "geneve"
+	TunnelGRE    TunnelType = "gre"
+)
+
+// TunnelTypes are the supported encapsulations.
+var TunnelTypes = []TunnelType{TunnelVXLAN, TunnelGeneve, TunnelGRE}
+
+// Tunnel is the cluster-wide encapsulation, kept in the network config.
+type Tunnel struct {
+	Type TunnelType
+	// Port is the UDP destination port; 0 means the standard port of the
+	// type. GRE is not carried over UDP and has no port.
+	Port uint
+}
+
+// DefaultTunnel is used by clusters that have no tunnel configured.
+var DefaultTunnel = Tunnel{Type: TunnelVXLAN}
+
+// Validate reports an unknown type or a port that cannot be used.
+func (t Tunnel) Validate() error {
+	switch t.Type {
+	case TunnelVXLAN, TunnelGeneve:
+		if t.Port > 65535 {
+			return fmt.Errorf("Invalid %s port %d", t.Type, t.Port)
+		}
+	case TunnelGRE:
+		if t.Port != 0 {
+			return fmt.Errorf("GRE tunnels do not use a UDP port")
+		}
+	default:
+		return fmt.Errorf("Unknown tunnel type %q, expected one of %v", t.Type, TunnelTypes)
+	}
+	return nil
+}
+
+// UDPPort returns the UDP destination port of the tunnel, or 0 for GRE.
+func (t Tunnel) UDPPort() uint {
+	switch {
+	case t.Type == TunnelGRE:
+		return 0
+	case t.Port != 0:
+		return t.Port
+	case t.Type == TunnelGeneve:
+		return 6081
+	default:
+		return 4789
+	}
+}
+
+// PortName returns the name of the tunnel port on br0, e.g. "vxlan0".
+func (t Tunnel) PortName() string {
+	return string(t.Type) + "0"
+}
+
+// KeyBits returns the width of the tunnel key carrying the VNID: 24 bits for
+// the VNI of VXLAN and Geneve, 32 for the GRE key.
+func (t Tunnel) KeyBits() uint {
+	if t.Type == TunnelGRE {
+		return 32
+	}
+	return 24
+}
+
+// Overhead returns how many bytes encapsulation adds to a packet on the
+// node's network: the outer IPv4 header, the tunnel headers and the inner
+// Ethernet header. For Geneve it leaves room for 8 bytes of options.
+func (t Tunnel) Overhead() uint {
+	switch t.Type {
+	case TunnelGRE:
+		// IPv4 20, GRE with key 8, Ethernet 14
+		return 42
+	case TunnelGeneve:
+		// IPv4 20, UDP 8, Geneve 8 plus options 8, Ethernet 14
+		return 58
+	default:
+		// IPv4 20, UDP 8, VXLAN 8, Ethernet 14
+		return 50
+	}
+}
+
+// MaxVNID returns the largest VNID the tunnel key can carry.
+func (t Tunnel) MaxVNID() uint {
+	return 1<<t.KeyBits() - 1
+}
@@ -20,9 +20,10 @@ type SubnetRegistry interface {
 	CreateMinion(minion string, data string) error
 	WatchMinions(receiver chan *MinionEvent, stop chan bool) error
 
-	WriteNetworkConfig(network string, subnetLength uint) error
+	WriteNetworkConfig(network string, subnetLength uint, tunnel Tunnel) error
 	GetContainerNetwork() (string, error)
 	GetSubnetLength() (uint64, error)
+	GetTunnel() (Tunnel, error)
 	CheckEtcdIsAlive(seconds uint64) bool
 
 	WatchNamespaces(receiver chan *NamespaceEvent, stop chan bool) error
@@ -31,6 +32,54 @@ type SubnetRegistry interface {
 	GetNetNamespace(name string) (NetNamespace, error)
 	WriteNetNamespace(name string, id uint) error
 	DeleteNetNamespace(name string) error
+
+	// The requests to change the network of namespaces, which the master
+	// carries out.
+	GetNetNamespaceRequests() ([]NetNamespaceRequest, error)
+	WriteNetNamespaceRequest(request NetNamespaceRequest) error
+	DeleteNetNamespaceRequest(namespace string) error
+	WatchNetNamespaceRequests(receiver chan *NetNamespaceRequestEvent, stop chan bool) error
+
+	GetNetworkPolicies() ([]NetworkPolicy, error)
+	WriteNetworkPolicy(policy NetworkPolicy) error
+	DeleteNetworkPolicy(namespace, name string) error
+	WatchNetworkPolicies(receiver chan *NetworkPolicyEvent, stop chan bool) error
+
+	GetEgressNetworkPolicies() ([]EgressNetworkPolicy, error)
+	WriteEgressNetworkPolicy(policy EgressNetworkPolicy) error
+	DeleteEgressNetworkPolicy(namespace string) error
+	WatchEgressNetworkPolicies(receiver chan *EgressNetworkPolicyEvent, stop chan bool) error
+
+	GetEgressIPs() ([]EgressIP, error)
+	WriteEgressIP(egressIP EgressIP) error
+	DeleteEgressIP(namespace string) error
+	WatchEgressIPs(receiver chan *EgressIPEvent, stop chan bool) error
+
+	// WriteNodeHeartbeat tells that node is alive for the next ttl seconds.
+	WriteNodeHeartbeat(node string, ttl uint64) error
+	GetNodeHeartbeats() ([]string, error)
+	WatchNodeHeartbeats(receiver chan *MinionEvent, stop chan bool) error
+
+	GetServices() ([]Service, error)
+	WriteService(service Service) error
+	DeleteService(namespace, name string) error
+	WatchServices(receiver chan *ServiceEvent, stop chan bool) error
+
+	// GetMulticastNamespaces returns the namespaces that have multicast
+	// enabled.
+	GetMulticastNamespaces() ([]string, error)
+	EnableMulticast(namespace string) error
+	DisableMulticast(namespace string) error
+	WatchMulticastNamespaces(receiver chan *NamespaceEvent, stop chan bool) error
+
+	GetNodeVNIDs() ([]NodeVNIDs, error)
+	WriteNodeVNIDs(nodeVNIDs NodeVNIDs) error
+	DeleteNodeVNIDs(node string) error
+	WatchNodeVNIDs(receiver chan *NodeVNIDsEvent, stop chan bool) error
+
+	// GetPodAnnotations returns the annotations of a Kubernetes pod, none
+	// if the pod is not found.
+	GetPodAnnotations(namespace, name string) (map[string]string, error)
 }
 
 type SubnetEvent struct {
@@ -47,6 +96,9 @@ type MinionEvent struct {
 type Subnet struct {
 	Minion string
 	Sub    string
+	// MTU is the MTU of the node's pods, recorded by the node once it has
+	// detected it; 0 until then
+	MTU uint
 }
 
 type NetNamespace struct {
@@ -0,0 +1,110 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/golang/glog"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// flowMod is one line of an ovs-ofctl flow file: command is "add" or
+// "delete" and flow is in add-flow or del-flows syntax respectively.
+type flowMod struct {
+	command string
+	flow    string
+}
+
+func addFlows(flows []*ofctl.Flow) []flowMod {
+	mods := make([]flowMod, 0, len(flows))
+	for _, flow := range flows {
+		mods = append(mods, flowMod{"add", flow.String()})
+	}
+	return mods
+}
+
+// applyFlowMods applies mods to br0 as a single OpenFlow 1.4 bundle, so that
+// the switch either takes all of them at once or none. If the bundle fails,
+// e.g. because one flow is rejected, the mods are applied one command at a
+// time instead so that the good ones still get in. If the switch does not
+// support bundles at all they are not tried again.
+func (oc *OvsController) applyFlowMods(mods []flowMod) error {
+	if len(mods) == 0 {
+		return nil
+	}
+	if atomic.LoadUint32(&oc.bundlesUnsupported) == 0 {
+		err := oc.applyFlowBundle(mods)
+		if err == nil {
+			return nil
+		}
+		if isBundleUnsupported(err) {
+			log.Warningf("OpenFlow bundles are not supported, installing flows one at a time: %v", err)
+			atomic.StoreUint32(&oc.bundlesUnsupported, 1)
+		} else {
+			log.Warningf("%v; installing flows one at a time", err)
+		}
+	}
+
+	// keep going on errors: the reconciler repairs whatever is left out
+	var lastErr error
+	for _, mod := range mods {
+		cmd := "add-flow"
+		if mod.command == "delete" {
+			cmd = "del-flows"
+		}
+		if out, err := oc.executor.Exec("ovs-ofctl", "-O", "OpenFlow13", cmd, "br0", mod.flow); err != nil {
+			log.Errorf("Failed to %s flow %s: %v (%s)", mod.command, mod.flow, err, out)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (oc *OvsController) applyFlowBundle(mods []flowMod) error {
+	f, err := ioutil.TempFile("", "openshift-sdn-flows")
+	if err != nil {
+		return fmt.Errorf("Failed to create flow file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	for _, mod := range mods {
+		fmt.Fprintf(f, "%s %s\n", mod.command, mod.flow)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Failed to write flow file: %v", err)
+	}
+
+	out, err := oc.executor.Exec("ovs-ofctl", "-O", "OpenFlow14", "--bundle", "add-flows", "br0", f.Name())
+	if err != nil {
+		return &bundleError{err: err, output: string(out)}
+	}
+	log.V(5).Infof("Installed %d flow changes in one bundle", len(mods))
+	return nil
+}
+
+type bundleError struct {
+	err    error
+	output string
+}
+
+func (e *bundleError) Error() string {
+	return fmt.Sprintf("Failed to apply flow bundle: %v (%s)", e.err, strings.TrimSpace(e.output))
+}
+
+// isBundleUnsupported tells whether a bundle failed because the switch or
+// ovs-ofctl cannot do bundles at all, rather than because of a bad flow.
+func isBundleUnsupported(err error) bool {
+	e, ok := err.(*bundleError)
+	if !ok {
+		return false
+	}
+	out := strings.ToLower(e.output)
+	for _, s := range []string{"version negotiation failed", "unrecognized option", "ofpbrc_bad_type"} {
+		if strings.Contains(out, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package ovssubnet
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/exec"
+)
+
+const bundleCommand = "ovs-ofctl -O OpenFlow14 --bundle add-flows br0 "
+
+// readFlowFile returns the flow file passed to a bundle command.
+func readFlowFile(t *testing.T, cmd []string) string {
+	data, err := ioutil.ReadFile(cmd[len(cmd)-1])
+	if err != nil {
+		t.Fatalf("Error reading flow file of %q: %v", cmd, err)
+	}
+	return string(data)
+}
+
+func TestApplyFlowMods(t *testing.T) {
+	ofctl := "ovs-ofctl -O OpenFlow13 "
+	mods := []flowMod{
+		{"delete", "table=0,cookie=0xac110003/-1"},
+		{"add", "table=0,cookie=0xac110003,priority=100,ip,nw_dst=10.1.3.0/24,actions=set_field:172.17.0.3->tun_dst,output:1"},
+	}
+	sequential := []string{
+		ofctl + "del-flows br0 table=0,cookie=0xac110003/-1",
+		ofctl + "add-flow br0 table=0,cookie=0xac110003,priority=100,ip,nw_dst=10.1.3.0/24,actions=set_field:172.17.0.3->tun_dst,output:1",
+	}
+
+	tests := []struct {
+		name        string
+		bundle      exec.FakeResult
+		sequential  bool
+		unsupported bool
+	}{
+		{
+			name: "bundle applied",
+		},
+		{
+			name:        "old ovs-ofctl",
+			bundle:      exec.FakeResult{Output: "ovs-ofctl: unrecognized option '--bundle'", ExitStatus: 1},
+			sequential:  true,
+			unsupported: true,
+		},
+		{
+			name:        "switch without OpenFlow 1.4",
+			bundle:      exec.FakeResult{Output: "ovs-ofctl: br0: version negotiation failed (we support version 0x05, peer supports version 0x04)", ExitStatus: 1},
+			sequential:  true,
+			unsupported: true,
+		},
+		{
+			name:       "flow rejected",
+			bundle:     exec.FakeResult{Output: "OFPT_ERROR (OF1.4) (xid=0x5): OFPBAC_BAD_OUT_PORT", ExitStatus: 1},
+			sequential: true,
+		},
+	}
+
+	for _, test := range tests {
+		var flowFile string
+		test.bundle.Run = func(cmd []string) {
+			flowFile = readFlowFile(t, cmd)
+		}
+		executor := exec.NewFake(test.bundle)
+		oc := &OvsController{executor: executor}
+
+		if err := oc.applyFlowMods(mods); err != nil {
+			t.Fatalf("%s: error applying flows: %v", test.name, err)
+		}
+		commands := executor.CommandLines()
+		if !strings.HasPrefix(commands[0], bundleCommand) {
+			t.Fatalf("%s: expected a bundle first, got %q", test.name, commands)
+		}
+		expected := "delete table=0,cookie=0xac110003/-1\nadd table=0,cookie=0xac110003,priority=100,ip,nw_dst=10.1.3.0/24,actions=set_field:172.17.0.3->tun_dst,output:1\n"
+		if flowFile != expected {
+			t.Fatalf("%s: wrong flow file.\nExpected %q\nGot      %q", test.name, expected, flowFile)
+		}
+		if test.sequential && !reflect.DeepEqual(commands[1:], sequential) {
+			t.Fatalf("%s: wrong fallback commands.\nExpected %q\nGot      %q", test.name, sequential, commands[1:])
+		} else if !test.sequential && len(commands) != 1 {
+			t.Fatalf("%s: expected only the bundle, got %q", test.name, commands)
+		}
+		if unsupported := atomic.LoadUint32(&oc.bundlesUnsupported) == 1; unsupported != test.unsupported {
+			t.Fatalf("%s: expected bundlesUnsupported %v", test.name, test.unsupported)
+		}
+
+		// bundles are only retried if they may work
+		executor.Commands = nil
+		oc.applyFlowMods(mods[:1])
+		if retried := strings.HasPrefix(executor.CommandLines()[0], bundleCommand); retried == test.unsupported {
+			t.Fatalf("%s: bundle retried %v after unsupported %v", test.name, retried, test.unsupported)
+		}
+	}
+}
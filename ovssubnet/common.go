@@ -5,13 +5,21 @@ import (
 	"fmt"
 	log "github.com/golang/glog"
 	"net"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/hostgw"
 	"github.com/openshift/openshift-sdn/ovssubnet/controller/kube"
 	"github.com/openshift/openshift-sdn/ovssubnet/controller/lbr"
 	"github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant"
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/vxlan"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
 	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
 )
 
 const (
@@ -19,6 +27,8 @@ const (
 )
 
 type OvsController struct {
+	// flowRepairs is first to keep it 64-bit aligned for atomic access
+	flowRepairs     uint64
 	subnetRegistry  api.SubnetRegistry
 	localIP         string
 	localSubnet     *api.Subnet
@@ -28,19 +38,62 @@ type OvsController struct {
 	ready           chan struct{}
 	flowController  FlowController
 	VnidMap         map[string]uint
+	vnidLock        sync.Mutex
+	// networkPolicies are the network policies by namespace and name,
+	// egressPolicies the egress network policies and egressIPs the egress
+	// IPs by namespace, guarded by vnidLock as they name namespaces too
+	networkPolicies map[string]api.NetworkPolicy
+	egressPolicies  map[string]api.EgressNetworkPolicy
+	egressIPs       map[string]api.EgressIP
 	netIDManager    *netutils.NetIDAllocator
+	executor        exec.Interface
+	podStateDir     string
+	// mtu is the MTU of this node's pods
+	mtu uint
+	// flowLock serializes the changes the node makes to br0 as a whole, and
+	// lockFile is the lock it shares with the pod hooks, see lockFlows
+	flowLock sync.Mutex
+	lockFile string
+	// bundlesUnsupported is set to 1 once br0 turns out not to take OpenFlow
+	// bundles; it is accessed atomically
+	bundlesUnsupported uint32
+	// flowReconcileInterval is how often the node repairs br0; 0 disables it
+	flowReconcileInterval time.Duration
+	// services are the services to load-balance in br0 by namespace and
+	// name, guarded by serviceLock
+	serviceLock sync.Mutex
+	services    map[string]api.Service
+	// multicastNamespaces are the namespaces with multicast enabled and
+	// nodeVNIDs the VNIDs of the pods of every node, guarded by vnidLock
+	multicastNamespaces map[string]bool
+	nodeVNIDs           map[string]api.NodeVNIDs
 }
 
 type FlowController interface {
-	Setup(localSubnet, globalSubnet string) error
+	// SetTunnel sets the encapsulation used to reach other nodes; it must be
+	// called before Setup and before any flows are added.
+	SetTunnel(tunnel api.Tunnel)
+	// Setup sets the node up with pods on localSubnet getting mtu.
+	Setup(localSubnet, globalSubnet string, mtu uint) error
 	AddOFRules(minionIP, localSubnet, localIP string) error
 	DelOFRules(minionIP, localIP string) error
+	// DesiredFlows returns the node and pod flows that br0 should have for
+	// the given cluster subnets and local pods.
+	DesiredFlows(subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Flow
+}
+
+// RouteSyncer is a FlowController that reaches other nodes through kernel
+// routes and neighbour entries instead of br0 flows.
+type RouteSyncer interface {
+	// SyncRoutes brings the kernel entries for the subnets of other nodes
+	// in line with subnets and returns how many it changed.
+	SyncRoutes(subnets []api.Subnet, localIP string) (int, error)
 }
 
 func NewKubeController(sub api.SubnetRegistry, hostname string, selfIP string, ready chan struct{}) (*OvsController, error) {
 	kubeController, err := NewController(sub, hostname, selfIP, ready)
 	if err == nil {
-		kubeController.flowController = kube.NewFlowController()
+		kubeController.flowController = kube.NewFlowController(exec.New())
 	}
 	return kubeController, err
 }
@@ -48,7 +101,7 @@ func NewKubeController(sub api.SubnetRegistry, hostname string, selfIP string, r
 func NewMultitenantController(sub api.SubnetRegistry, hostname string, selfIP string, ready chan struct{}) (*OvsController, error) {
 	mtController, err := NewController(sub, hostname, selfIP, ready)
 	if err == nil {
-		mtController.flowController = multitenant.NewFlowController()
+		mtController.flowController = multitenant.NewFlowController(exec.New())
 	}
 	return mtController, err
 }
@@ -56,11 +109,32 @@ func NewMultitenantController(sub api.SubnetRegistry, hostname string, selfIP st
 func NewDefaultController(sub api.SubnetRegistry, hostname string, selfIP string, ready chan struct{}) (*OvsController, error) {
 	defaultController, err := NewController(sub, hostname, selfIP, ready)
 	if err == nil {
-		defaultController.flowController = lbr.NewFlowController()
+		defaultController.flowController = lbr.NewFlowController(exec.New())
 	}
 	return defaultController, err
 }
 
+// NewHostGatewayController returns a controller that routes the subnets of
+// other nodes through their IPs instead of tunneling to them, for nodes that
+// share a network segment.
+func NewHostGatewayController(sub api.SubnetRegistry, hostname string, selfIP string, ready chan struct{}) (*OvsController, error) {
+	hgController, err := NewController(sub, hostname, selfIP, ready)
+	if err == nil {
+		hgController.flowController = hostgw.NewFlowController(exec.New())
+	}
+	return hgController, err
+}
+
+// NewKernelVXLANController returns a controller that tunnels to other nodes
+// through a kernel vxlan device on lbr0, for hosts without Open vSwitch.
+func NewKernelVXLANController(sub api.SubnetRegistry, hostname string, selfIP string, ready chan struct{}) (*OvsController, error) {
+	kvController, err := NewController(sub, hostname, selfIP, ready)
+	if err == nil {
+		kvController.flowController = vxlan.NewFlowController(exec.New())
+	}
+	return kvController, err
+}
+
 func NewController(sub api.SubnetRegistry, hostname string, selfIP string, ready chan struct{}) (*OvsController, error) {
 	if selfIP == "" {
 		addrs, err := net.LookupIP(hostname)
@@ -86,12 +160,26 @@ func NewController(sub api.SubnetRegistry, hostname string, selfIP string, ready
 		localSubnet:     nil,
 		subnetAllocator: nil,
 		VnidMap:         make(map[string]uint),
+		networkPolicies: make(map[string]api.NetworkPolicy),
+		egressPolicies:  make(map[string]api.EgressNetworkPolicy),
+		egressIPs:       make(map[string]api.EgressIP),
+		services:        make(map[string]api.Service),
 		sig:             make(chan struct{}),
 		ready:           ready,
+		executor:        exec.New(),
+		podStateDir:     podstate.DefaultDir,
+		lockFile:        nodesetup.LockFile,
+
+		flowReconcileInterval: DefaultFlowReconcileInterval,
+		multicastNamespaces:   make(map[string]bool),
+		nodeVNIDs:             make(map[string]api.NodeVNIDs),
 	}, nil
 }
 
-func (oc *OvsController) StartMaster(sync bool, containerNetwork string, containerSubnetLength uint) error {
+func (oc *OvsController) StartMaster(sync bool, containerNetwork string, containerSubnetLength uint, tunnel api.Tunnel) error {
+	if err := tunnel.Validate(); err != nil {
+		return err
+	}
 	// wait a minute for etcd to come alive
 	status := oc.subnetRegistry.CheckEtcdIsAlive(60)
 	if !status {
@@ -118,7 +206,7 @@ func (oc *OvsController) StartMaster(sync bool, containerNetwork string, contain
 		subrange = append(subrange, sub.Sub)
 	}
 
-	err = oc.subnetRegistry.WriteNetworkConfig(containerNetwork, containerSubnetLength)
+	err = oc.subnetRegistry.WriteNetworkConfig(containerNetwork, containerSubnetLength, tunnel)
 	if err != nil {
 		return err
 	}
@@ -142,11 +230,13 @@ func (oc *OvsController) StartMaster(sync bool, containerNetwork string, contain
 			inUse = append(inUse, net.NetID)
 			oc.VnidMap[net.Name] = net.NetID
 		}
-		oc.netIDManager, err = netutils.NewNetIDAllocator(10, MaxUint, inUse)
+		oc.netIDManager, err = netutils.NewNetIDAllocator(10, tunnel.MaxVNID(), inUse)
 		if err != nil {
 			return err
 		}
 		go oc.watchNetworks()
+		go oc.watchNetNamespaceRequests()
+		go oc.assignEgressIPs()
 	}
 	go oc.watchMinions()
 	return nil
@@ -159,31 +249,34 @@ func (oc *OvsController) watchNetworks() {
 	for {
 		select {
 		case ev := <-nsevent:
+			oc.vnidLock.Lock()
 			switch ev.Type {
 			case api.Added:
 				_, err := oc.subnetRegistry.GetNetNamespace(ev.Name)
 				if err != nil {
 					netid, err := oc.netIDManager.GetNetID()
 					if err != nil {
-						log.Error("Error getting new network IDS: %v", err)
-						continue
+						log.Errorf("Error getting new network IDS: %v", err)
+						break
 					}
 					err = oc.subnetRegistry.WriteNetNamespace(ev.Name, netid)
 					if err != nil {
-						log.Error("Error writing new network ID: %v", err)
-						continue
+						log.Errorf("Error writing new network ID: %v", err)
+						break
 					}
 					oc.VnidMap[ev.Name] = netid
 				}
 			case api.Deleted:
 				err := oc.subnetRegistry.DeleteNetNamespace(ev.Name)
 				if err != nil {
-					log.Error("Error while deleting Net Id: %v", err)
+					log.Errorf("Error while deleting Net Id: %v", err)
 				}
 				netid := oc.VnidMap[ev.Name]
-				oc.netIDManager.ReleaseNetID(netid)
 				delete(oc.VnidMap, ev.Name)
+				// other namespaces may have joined its network
+				oc.releaseUnusedNetID(netid)
 			}
+			oc.vnidLock.Unlock()
 		case <-oc.sig:
 			log.Error("Signal received. Stopping watching of minions.")
 			stop <- true
@@ -257,7 +350,14 @@ func (oc *OvsController) DeleteNode(minion string) error {
 		return err
 	}
 	oc.subnetAllocator.ReleaseNetwork(ipnet)
-	return oc.subnetRegistry.DeleteSubnet(minion)
+	if err := oc.subnetRegistry.DeleteSubnet(minion); err != nil {
+		return err
+	}
+	if err := oc.subnetRegistry.DeleteNodeVNIDs(sub.Minion); err != nil {
+		// nodes ignore the VNIDs of nodes without a subnet
+		log.Warningf("Failed to delete the VNIDs of minion %s: %v", minion, err)
+	}
+	return nil
 }
 
 func (oc *OvsController) syncWithMaster() error {
@@ -278,6 +378,30 @@ func (oc *OvsController) StartNode(sync, skipsetup bool) error {
 		return err
 	}
 
+	tunnel, err := oc.subnetRegistry.GetTunnel()
+	if err != nil {
+		log.Errorf("Failed to obtain the tunnel configuration: %v", err)
+		return err
+	}
+	oc.flowController.SetTunnel(tunnel)
+	_, hostGatewayMode := oc.flowController.(*hostgw.FlowController)
+	overhead := tunnel.Overhead()
+	if hostGatewayMode {
+		// packets to other nodes are routed as they are
+		overhead = 0
+	}
+	oc.mtu, err = nodeMTU(oc.localIP, overhead)
+	if err != nil {
+		log.Errorf("Failed to detect the MTU: %v", err)
+		return err
+	}
+	if oc.localSubnet.MTU != oc.mtu {
+		oc.localSubnet.MTU = oc.mtu
+		if err := oc.subnetRegistry.CreateSubnet(oc.hostName, oc.localSubnet); err != nil {
+			log.Errorf("Failed to record MTU %d for this host: %v", oc.mtu, err)
+		}
+	}
+
 	// call flow controller's setup
 	if !skipsetup {
 		// Assume we are working with IPv4
@@ -286,29 +410,133 @@ func (oc *OvsController) StartNode(sync, skipsetup bool) error {
 			log.Errorf("Failed to obtain ContainerNetwork: %v", err)
 			return err
 		}
-		err = oc.flowController.Setup(oc.localSubnet.Sub, containerNetwork)
+		err = oc.flowController.Setup(oc.localSubnet.Sub, containerNetwork, oc.mtu)
 		if err != nil {
 			return err
 		}
 	}
-	subnets, err := oc.subnetRegistry.GetSubnets()
-	if err != nil {
-		log.Errorf("Could not fetch existing subnets: %v", err)
-	}
-	for _, s := range *subnets {
-		oc.flowController.AddOFRules(s.Minion, s.Sub, oc.localIP)
-	}
 	if _, ok := oc.flowController.(*multitenant.FlowController); ok {
 		nslist, err := oc.subnetRegistry.GetNetNamespaces()
 		if err != nil {
 			return err
 		}
+		policies, err := oc.subnetRegistry.GetNetworkPolicies()
+		if err != nil {
+			return err
+		}
+		egress, err := oc.subnetRegistry.GetEgressNetworkPolicies()
+		if err != nil {
+			return err
+		}
+		egressIPs, err := oc.subnetRegistry.GetEgressIPs()
+		if err != nil {
+			return err
+		}
+		multicast, err := oc.subnetRegistry.GetMulticastNamespaces()
+		if err != nil {
+			return err
+		}
+		nodeVNIDs, err := oc.subnetRegistry.GetNodeVNIDs()
+		if err != nil {
+			return err
+		}
+		oc.vnidLock.Lock()
 		for _, ns := range nslist {
 			oc.VnidMap[ns.Name] = ns.NetID
 		}
+		for _, p := range policies {
+			oc.setNetworkPolicy(p)
+		}
+		for _, p := range egress {
+			oc.setEgressNetworkPolicy(p)
+		}
+		for _, eip := range egressIPs {
+			oc.setEgressIP(eip)
+		}
+		for _, namespace := range multicast {
+			oc.multicastNamespaces[namespace] = true
+		}
+		for _, n := range nodeVNIDs {
+			oc.nodeVNIDs[n.Node] = n
+		}
+		oc.vnidLock.Unlock()
+		oc.updatePolicies()
+		oc.updateNodeVNIDs()
+		oc.publishNodeVNIDs()
+	}
+	fc, multitenantMode := oc.flowController.(*multitenant.FlowController)
+	loadBalancing := multitenantMode && fc.ServiceLoadBalancing()
+	if loadBalancing {
+		services, err := oc.subnetRegistry.GetServices()
+		if err != nil {
+			return err
+		}
+		oc.serviceLock.Lock()
+		for _, s := range services {
+			oc.services[s.Namespace+"/"+s.Name] = s
+		}
+		oc.serviceLock.Unlock()
+		oc.updateServices()
+	}
+	subnets, err := oc.subnetRegistry.GetSubnets()
+	if err != nil {
+		log.Errorf("Could not fetch existing subnets: %v", err)
+	}
+	rs, kernelMode := oc.flowController.(RouteSyncer)
+	if subnets != nil && kernelMode {
+		for _, s := range *subnets {
+			oc.checkMTU(s)
+		}
+		if hostGatewayMode {
+			offLink, err := hostgw.OffLinkNodes(*subnets, oc.localIP)
+			if err != nil {
+				log.Errorf("Could not check which nodes are on-link: %v", err)
+			}
+			for _, node := range offLink {
+				log.Warningf("Node %s is not on a network of this node, its pods are unreachable in host-gateway mode", node)
+			}
+		}
+		if _, err := rs.SyncRoutes(*subnets, oc.localIP); err != nil {
+			log.Errorf("Error adding node routes, they will be repaired by reconciliation: %v", err)
+		}
+	} else if subnets != nil {
+		// install all node flows in one go so that br0 never routes with a
+		// partially built table
+		for _, s := range *subnets {
+			oc.checkMTU(s)
+		}
+		flows := oc.flowController.DesiredFlows(*subnets, nil, oc.localIP)
+		install := func() error { return oc.applyFlowMods(addFlows(flows)) }
+		unlock, installErr := oc.lockFlows()
+		if installErr == nil {
+			if multitenantMode {
+				// the multicast and service flows need their groups
+				installErr = oc.syncGroups(fc.DesiredGroups(*subnets, nil, oc.localIP), false, install)
+			} else {
+				installErr = install()
+			}
+			unlock()
+		}
+		if installErr != nil {
+			log.Errorf("Error adding node flows, they will be repaired by reconciliation: %v", installErr)
+		}
+	}
+	if multitenantMode {
 		go oc.watchVnids()
+		go oc.watchNetworkPolicies()
+		go oc.watchEgressNetworkPolicies()
+		go oc.watchEgressIPs()
+		go oc.watchMulticastNamespaces()
+		go oc.watchNodeVNIDs()
+		go oc.heartbeat(nodeHeartbeatInterval)
+	}
+	if loadBalancing {
+		go oc.watchServices()
 	}
 	go oc.watchCluster()
+	if oc.flowReconcileInterval > 0 {
+		go oc.reconcileFlowsPeriodically(oc.flowReconcileInterval)
+	}
 
 	if oc.ready != nil {
 		close(oc.ready)
@@ -324,12 +552,22 @@ func (oc *OvsController) watchVnids() {
 	for {
 		select {
 		case ev := <-netNsEvent:
+			oc.vnidLock.Lock()
 			switch ev.Type {
 			case api.Added:
 				oc.VnidMap[ev.Name] = ev.NetID
 			case api.Deleted:
 				delete(oc.VnidMap, ev.Name)
 			}
+			oc.vnidLock.Unlock()
+			// policies may admit traffic from the namespace
+			oc.updatePolicies()
+			// pods of the namespace may move to another VNID
+			oc.publishNodeVNIDs()
+			// move the running pods of the namespace to the new VNID
+			if err := oc.syncFlows(false); err != nil {
+				log.Errorf("Failed to update flows after namespace %s changed, reconciliation will retry: %v", ev.Name, err)
+			}
 		case <-oc.sig:
 			log.Error("Signal received. Stopping watching of NetNamespaces.")
 			stop <- true
@@ -338,6 +576,171 @@ func (oc *OvsController) watchVnids() {
 	}
 }
 
+// watchNetworkPolicies applies changes to network policies to br0 as they
+// happen, rather than at the next reconciliation.
+func (oc *OvsController) watchNetworkPolicies() {
+	policyEvent := make(chan *api.NetworkPolicyEvent)
+	stop := make(chan bool)
+	go oc.subnetRegistry.WatchNetworkPolicies(policyEvent, stop)
+	for {
+		select {
+		case ev := <-policyEvent:
+			oc.vnidLock.Lock()
+			switch ev.Type {
+			case api.Added:
+				oc.setNetworkPolicy(ev.Policy)
+			case api.Deleted:
+				for key, p := range oc.networkPolicies {
+					if p.Namespace == ev.Policy.Namespace && (ev.Policy.Name == "" || p.Name == ev.Policy.Name) {
+						delete(oc.networkPolicies, key)
+					}
+				}
+			}
+			oc.vnidLock.Unlock()
+			oc.updatePolicies()
+			if err := oc.syncFlows(false); err != nil {
+				log.Errorf("Failed to apply network policy change, it will be applied by reconciliation: %v", err)
+			}
+		case <-oc.sig:
+			log.Error("Signal received. Stopping watching of network policies.")
+			stop <- true
+			return
+		}
+	}
+}
+
+// watchEgressNetworkPolicies applies changes to egress network policies to
+// br0 as they happen.
+func (oc *OvsController) watchEgressNetworkPolicies() {
+	policyEvent := make(chan *api.EgressNetworkPolicyEvent)
+	stop := make(chan bool)
+	go oc.subnetRegistry.WatchEgressNetworkPolicies(policyEvent, stop)
+	for {
+		select {
+		case ev := <-policyEvent:
+			oc.vnidLock.Lock()
+			switch ev.Type {
+			case api.Added:
+				oc.setEgressNetworkPolicy(ev.Policy)
+			case api.Deleted:
+				delete(oc.egressPolicies, ev.Policy.Namespace)
+			}
+			oc.vnidLock.Unlock()
+			oc.updatePolicies()
+			if err := oc.syncFlows(false); err != nil {
+				log.Errorf("Failed to apply egress network policy change, it will be applied by reconciliation: %v", err)
+			}
+		case <-oc.sig:
+			log.Error("Signal received. Stopping watching of egress network policies.")
+			stop <- true
+			return
+		}
+	}
+}
+
+// setEgressNetworkPolicy adds or replaces an egress policy, ignoring invalid
+// ones. The caller holds vnidLock.
+func (oc *OvsController) setEgressNetworkPolicy(policy api.EgressNetworkPolicy) {
+	if err := policy.Validate(); err != nil {
+		log.Errorf("Ignoring egress network policy: %v", err)
+		return
+	}
+	oc.egressPolicies[policy.Namespace] = policy
+}
+
+// setNetworkPolicy adds or replaces a policy, ignoring invalid ones. The
+// caller holds vnidLock.
+func (oc *OvsController) setNetworkPolicy(policy api.NetworkPolicy) {
+	if err := policy.Validate(); err != nil {
+		log.Errorf("Ignoring network policy: %v", err)
+		return
+	}
+	oc.networkPolicies[policy.Namespace+"/"+policy.Name] = policy
+}
+
+// updatePolicies hands the current policies, egress IPs and VNIDs to the
+// flow controller, for flows computed from now on, and sets the host up for
+// the egress IPs this node owns.
+func (oc *OvsController) updatePolicies() {
+	fc, ok := oc.flowController.(*multitenant.FlowController)
+	if !ok {
+		return
+	}
+	policies := oc.currentPolicies()
+	fc.SetPolicies(policies)
+	if err := fc.SetupEgressIPs(policies.EgressIPs, policies.VNIDs, oc.localIP); err != nil {
+		log.Errorf("Failed to set up egress IPs: %v", err)
+	}
+}
+
+// currentPolicies returns a copy of the policies, egress IPs, multicast
+// namespaces and VNIDs in a stable order.
+func (oc *OvsController) currentPolicies() *multitenant.Policies {
+	oc.vnidLock.Lock()
+	defer oc.vnidLock.Unlock()
+	keys := make([]string, 0, len(oc.networkPolicies))
+	for key := range oc.networkPolicies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	policies := make([]api.NetworkPolicy, 0, len(keys))
+	for _, key := range keys {
+		policies = append(policies, oc.networkPolicies[key])
+	}
+	namespaces := make([]string, 0, len(oc.egressPolicies))
+	for namespace := range oc.egressPolicies {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	egress := make([]api.EgressNetworkPolicy, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		egress = append(egress, oc.egressPolicies[namespace])
+	}
+	multicast := make([]string, 0, len(oc.multicastNamespaces))
+	for namespace := range oc.multicastNamespaces {
+		multicast = append(multicast, namespace)
+	}
+	sort.Strings(multicast)
+	namespaces = make([]string, 0, len(oc.egressIPs))
+	for namespace := range oc.egressIPs {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	egressIPs := make([]api.EgressIP, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		egressIPs = append(egressIPs, oc.egressIPs[namespace])
+	}
+	vnids := make(map[string]uint, len(oc.VnidMap))
+	for name, id := range oc.VnidMap {
+		vnids[name] = id
+	}
+	return &multitenant.Policies{Network: policies, Egress: egress, EgressIPs: egressIPs, Multicast: multicast, VNIDs: vnids}
+}
+
+// minMTU is the smallest MTU an IPv4 host must accept.
+const minMTU = 576
+
+// nodeMTU returns the MTU for pods: that of the interface carrying localIP
+// less the overhead of encapsulation.
+func nodeMTU(localIP string, overhead uint) (uint, error) {
+	linkMTU, err := netutils.GetInterfaceMTU(localIP)
+	if err != nil {
+		return 0, err
+	}
+	if linkMTU-int(overhead) < minMTU {
+		return 0, fmt.Errorf("MTU %d of the interface with %s leaves too little room for %d bytes of encapsulation", linkMTU, localIP, overhead)
+	}
+	return uint(linkMTU) - overhead, nil
+}
+
+// checkMTU warns when a node records a different pod MTU than this one, as
+// pods on the two nodes may then be unable to exchange large packets.
+func (oc *OvsController) checkMTU(sub api.Subnet) {
+	if sub.MTU != 0 && oc.mtu != 0 && sub.MTU != oc.mtu {
+		log.Warningf("Node %s uses MTU %d but this node uses %d", sub.Minion, sub.MTU, oc.mtu)
+	}
+}
+
 func (oc *OvsController) initSelfSubnet() error {
 	// get subnet for self
 	for {
@@ -387,11 +790,22 @@ func (oc *OvsController) watchCluster() {
 		case ev := <-clusterEvent:
 			switch ev.Type {
 			case api.Added:
+				oc.checkMTU(ev.Sub)
 				// add openflow rules
-				oc.flowController.AddOFRules(ev.Sub.Minion, ev.Sub.Sub, oc.localIP)
+				if err := oc.updateNodeFlows(ev); err != nil {
+					log.Errorf("Error adding flows for node %s (%s), they will be repaired by reconciliation: %v", ev.Sub.Minion, ev.Sub.Sub, err)
+				}
 			case api.Deleted:
 				// delete openflow rules meant for the minion
-				oc.flowController.DelOFRules(ev.Sub.Minion, oc.localIP)
+				if err := oc.updateNodeFlows(ev); err != nil {
+					log.Errorf("Error deleting flows for node %s, they will be removed by reconciliation: %v", ev.Sub.Minion, err)
+				}
+			}
+			if _, ok := oc.flowController.(*multitenant.FlowController); ok {
+				// the node joins or leaves the multicast groups
+				if err := oc.syncFlows(false); err != nil {
+					log.Errorf("Error updating multicast groups for node %s, reconciliation will retry: %v", ev.Sub.Minion, err)
+				}
 			}
 		case <-oc.sig:
 			stop <- true
@@ -400,6 +814,21 @@ func (oc *OvsController) watchCluster() {
 	}
 }
 
+// updateNodeFlows adds or deletes the flows of the node of a subnet event
+// under the flow locks, so that a reconcile pass that read the subnets before
+// the event does not remove the new flows as stray or put back deleted ones.
+func (oc *OvsController) updateNodeFlows(ev *api.SubnetEvent) error {
+	unlock, err := oc.lockFlows()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if ev.Type == api.Deleted {
+		return oc.flowController.DelOFRules(ev.Sub.Minion, oc.localIP)
+	}
+	return oc.flowController.AddOFRules(ev.Sub.Minion, ev.Sub.Sub, oc.localIP)
+}
+
 func (oc *OvsController) Stop() {
 	close(oc.sig)
 	//oc.sig <- struct{}{}
@@ -0,0 +1,241 @@
+// Package hostgw connects the nodes of a cluster that share a network segment
+// without an overlay: containers sit on lbr0, which carries the subnet
+// gateway, and every remote subnet is routed in the kernel through the IP of
+// the node it belongs to. There is no br0 and no encapsulation.
+package hostgw
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// RouteProtocol marks the routes to the subnets of other nodes ("proto 42"
+// in "ip route"), so that they can be told from the routes of others.
+const RouteProtocol = 42
+
+type FlowController struct {
+	executor exec.Interface
+}
+
+func NewFlowController(executor exec.Interface) *FlowController {
+	return &FlowController{executor: executor}
+}
+
+// SetTunnel does nothing: nodes reach each other without encapsulation.
+func (c *FlowController) SetTunnel(tunnel api.Tunnel) {
+}
+
+func (c *FlowController) Setup(localSubnet, containerNetwork string, mtu uint) error {
+	_, ipnet, err := net.ParseCIDR(localSubnet)
+	if err != nil {
+		return err
+	}
+	unlock, err := nodesetup.Lock(nodesetup.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// no step needs Open vSwitch
+	node := nodesetup.NewLinuxNode(c.executor)
+	return nodesetup.Run(setupSteps(node, ipnet, containerNetwork, mtu))
+}
+
+// setupSteps returns the node setup: containers on lbr0, which carries the
+// subnet gateway, and the node forwarding their traffic to other nodes and
+// masquerading what leaves the cluster network.
+func setupSteps(node *nodesetup.Node, ipnet *net.IPNet, containerNetwork string, mtu uint) []nodesetup.Step {
+	ones, _ := ipnet.Mask.Size()
+	gateway := netutils.GenerateDefaultGateway(ipnet).String()
+	return []nodesetup.Step{
+		nodesetup.LinuxBridge("lbr0"),
+		nodesetup.Address("lbr0", fmt.Sprintf("%s/%d", gateway, ones)),
+		nodesetup.MTU("lbr0", mtu),
+		node.Sysctl("net/ipv4/ip_forward", "1", ""),
+		node.IPTables(nodesetup.IPTablesRule{Table: "nat", Chain: "POSTROUTING", Args: []string{"-s", containerNetwork, "!", "-d", containerNetwork, "-j", "MASQUERADE"}}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "INPUT", Args: []string{"-i", "lbr0", "-m", "comment", "--comment", "traffic from docker", "-j", "ACCEPT"}, Before: "RELATED,ESTABLISHED"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-d", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-s", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.DockerNetwork(nodesetup.DockerNetworkFile, mtu),
+	}
+}
+
+// AddOFRules routes subnet through minionIP, which must be on a network of
+// this node. The local subnet is on lbr0 already.
+func (c *FlowController) AddOFRules(minionIP, subnet, localIP string) error {
+	if minionIP == localIP {
+		return nil
+	}
+	_, dst, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
+	gw := net.ParseIP(minionIP)
+	if gw == nil {
+		return fmt.Errorf("Invalid node IP %q", minionIP)
+	}
+	if ok, err := OnLink(gw); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("Node %s is not on a network of this node, not routing %s to it", minionIP, subnet)
+	}
+	routes, err := nodeRoutes()
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if route.Dst.String() == dst.String() {
+			if route.Gw.Equal(gw) {
+				return nil
+			}
+			// the subnet moved to another node
+			if err := netlink.RouteDel(route); err != nil {
+				return err
+			}
+		}
+	}
+	log.Infof("Routing %s through %s", subnet, minionIP)
+	return netlink.RouteAdd(&netlink.Route{Dst: dst, Gw: gw, Protocol: RouteProtocol})
+}
+
+// DelOFRules removes the routes through minion.
+func (c *FlowController) DelOFRules(minion, localIP string) error {
+	routes, err := nodeRoutes()
+	if err != nil {
+		return err
+	}
+	gw := net.ParseIP(minion)
+	for _, route := range routes {
+		if route.Gw.Equal(gw) {
+			log.Infof("Removing the route of %s through %s", route.Dst, minion)
+			if err := netlink.RouteDel(route); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DesiredFlows returns no flows, as there is no br0.
+func (c *FlowController) DesiredFlows(subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Flow {
+	return []*ofctl.Flow{}
+}
+
+// SyncRoutes makes the routes to the subnets of other nodes match subnets:
+// it adds missing routes, fixes those through the wrong node and removes
+// those of subnets that are gone. Nodes that are not on a network of this
+// node get no route. It returns how many routes it changed.
+func (c *FlowController) SyncRoutes(subnets []api.Subnet, localIP string) (int, error) {
+	desired := map[string]net.IP{}
+	for _, s := range subnets {
+		if s.Minion == localIP {
+			continue
+		}
+		gw := net.ParseIP(s.Minion)
+		if gw == nil {
+			continue
+		}
+		if ok, err := OnLink(gw); err != nil {
+			return 0, err
+		} else if ok {
+			desired[s.Sub] = gw
+		}
+	}
+	routes, err := nodeRoutes()
+	if err != nil {
+		return 0, err
+	}
+	changes := 0
+	for _, route := range routes {
+		dst := route.Dst.String()
+		if gw, ok := desired[dst]; ok && route.Gw.Equal(gw) {
+			delete(desired, dst)
+			continue
+		}
+		if err := netlink.RouteDel(route); err != nil {
+			return changes, err
+		}
+		changes++
+	}
+	missing := make([]string, 0, len(desired))
+	for dst := range desired {
+		missing = append(missing, dst)
+	}
+	sort.Strings(missing)
+	for _, dst := range missing {
+		_, ipnet, err := net.ParseCIDR(dst)
+		if err != nil {
+			return changes, err
+		}
+		if err := netlink.RouteAdd(&netlink.Route{Dst: ipnet, Gw: desired[dst], Protocol: RouteProtocol}); err != nil {
+			return changes, err
+		}
+		changes++
+	}
+	return changes, nil
+}
+
+// OffLinkNodes returns the nodes among subnets other than localIP that are
+// not on a network of this node, which host-gateway mode cannot reach.
+func OffLinkNodes(subnets []api.Subnet, localIP string) ([]string, error) {
+	nodes := []string{}
+	for _, s := range subnets {
+		if s.Minion == localIP {
+			continue
+		}
+		gw := net.ParseIP(s.Minion)
+		if gw == nil {
+			nodes = append(nodes, s.Minion)
+			continue
+		}
+		ok, err := OnLink(gw)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			nodes = append(nodes, s.Minion)
+		}
+	}
+	return nodes, nil
+}
+
+// OnLink tells whether ip is on a network directly attached to this node,
+// going by the routes the kernel adds with addresses.
+func OnLink(ip net.IP) (bool, error) {
+	routes, err := netlink.RouteList(0)
+	if err != nil {
+		return false, err
+	}
+	for _, route := range routes {
+		if route.Dst != nil && route.Gw == nil && route.Scope == syscall.RT_SCOPE_LINK && route.Dst.Contains(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// nodeRoutes returns the routes to the subnets of other nodes.
+func nodeRoutes() ([]*netlink.Route, error) {
+	all, err := netlink.RouteList(0)
+	if err != nil {
+		return nil, err
+	}
+	routes := []*netlink.Route{}
+	for _, route := range all {
+		if route.Protocol == RouteProtocol && route.Dst != nil {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,154 @@
+package hostgw
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+)
+
+// inNewNetns runs fn in a network namespace of its own, so that routes can
+// be added without touching the host.
+func inNewNetns(t *testing.T, fn func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root")
+	}
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			done <- err
+			return
+		}
+		fn()
+		done <- nil
+	}()
+	if err := <-done; err != nil {
+		t.Skipf("Cannot create a network namespace: %v", err)
+	}
+}
+
+func TestSetupSteps(t *testing.T) {
+	expected := []string{
+		"Linux bridge lbr0",
+		"address 10.1.2.1/24 on lbr0",
+		"MTU 1500 on lbr0",
+		"sysctl net.ipv4.ip_forward=1",
+		"iptables rule -t nat POSTROUTING -s 10.1.0.0/16 ! -d 10.1.0.0/16 -j MASQUERADE",
+		"iptables rule -t filter INPUT -i lbr0 -m comment --comment traffic from docker -j ACCEPT",
+		"iptables rule -t filter FORWARD -d 10.1.0.0/16 -j ACCEPT",
+		"iptables rule -t filter FORWARD -s 10.1.0.0/16 -j ACCEPT",
+		"docker network options",
+	}
+	_, ipnet, _ := net.ParseCIDR("10.1.2.0/24")
+	names := []string{}
+	for _, step := range setupSteps(&nodesetup.Node{}, ipnet, "10.1.0.0/16", 1500) {
+		names = append(names, step.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Wrong setup steps.\nExpected %q\nGot      %q", expected, names)
+	}
+}
+
+// routes returns the routes to the subnets of other nodes as "dst via gw".
+func routes(t *testing.T) []string {
+	list, err := nodeRoutes()
+	if err != nil {
+		t.Fatalf("Error listing routes: %v", err)
+	}
+	out := []string{}
+	for _, route := range list {
+		out = append(out, route.Dst.String()+" via "+route.Gw.String())
+	}
+	return out
+}
+
+func TestRoutes(t *testing.T) {
+	inNewNetns(t, func() {
+		// eth0 stands in for the interface on the network of the nodes
+		if err := netlink.LinkAdd("eth0", "bridge"); err != nil {
+			t.Errorf("Error adding eth0: %v", err)
+			return
+		}
+		link, err := netlink.LinkByName("eth0")
+		if err != nil {
+			t.Errorf("Error getting eth0: %v", err)
+			return
+		}
+		_, ipnet, _ := net.ParseCIDR("192.168.1.0/24")
+		ipnet.IP = net.ParseIP("192.168.1.10").To4()
+		if err := netlink.AddrAdd(link.Index, ipnet); err != nil {
+			t.Errorf("Error adding address: %v", err)
+			return
+		}
+		if err := netlink.LinkSetUp(link.Index); err != nil {
+			t.Errorf("Error bringing eth0 up: %v", err)
+			return
+		}
+
+		c := NewFlowController(exec.NewFake())
+		subnets := []api.Subnet{
+			{Minion: "192.168.1.10", Sub: "10.1.0.0/24"},
+			{Minion: "192.168.1.11", Sub: "10.1.1.0/24"},
+			{Minion: "10.9.9.9", Sub: "10.1.2.0/24"},
+		}
+		offLink, err := OffLinkNodes(subnets, "192.168.1.10")
+		if err != nil || !reflect.DeepEqual(offLink, []string{"10.9.9.9"}) {
+			t.Errorf("Wrong off-link nodes %q (%v)", offLink, err)
+		}
+
+		if changes, err := c.SyncRoutes(subnets, "192.168.1.10"); err != nil || changes != 1 {
+			t.Errorf("Wrong sync result %d (%v)", changes, err)
+		}
+		if got := routes(t); !reflect.DeepEqual(got, []string{"10.1.1.0/24 via 192.168.1.11"}) {
+			t.Errorf("Wrong routes after sync %q", got)
+		}
+		if changes, err := c.SyncRoutes(subnets, "192.168.1.10"); err != nil || changes != 0 {
+			t.Errorf("Wrong second sync result %d (%v)", changes, err)
+		}
+
+		if err := c.AddOFRules("10.9.9.9", "10.1.3.0/24", "192.168.1.10"); err == nil {
+			t.Errorf("Expected an error routing through an off-link node")
+		}
+		if err := c.AddOFRules("192.168.1.10", "10.1.0.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error for the local node: %v", err)
+		}
+		// the subnet moves to another node
+		if err := c.AddOFRules("192.168.1.12", "10.1.1.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error adding a route: %v", err)
+		}
+		if err := c.AddOFRules("192.168.1.11", "10.1.4.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error adding a route: %v", err)
+		}
+		expected := []string{"10.1.1.0/24 via 192.168.1.12", "10.1.4.0/24 via 192.168.1.11"}
+		if got := routes(t); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Wrong routes after adding.\nExpected %q\nGot      %q", expected, got)
+		}
+
+		if err := c.DelOFRules("192.168.1.11", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error deleting routes: %v", err)
+		}
+		if got := routes(t); !reflect.DeepEqual(got, []string{"10.1.1.0/24 via 192.168.1.12"}) {
+			t.Errorf("Wrong routes after deleting %q", got)
+		}
+
+		// a route left by a node that is gone and a wrong one are repaired
+		subnets = subnets[:2]
+		if err := c.AddOFRules("192.168.1.12", "10.1.5.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error adding a route: %v", err)
+		}
+		if changes, err := c.SyncRoutes(subnets, "192.168.1.10"); err != nil || changes != 3 {
+			t.Errorf("Wrong repair result %d (%v)", changes, err)
+		}
+		if got := routes(t); !reflect.DeepEqual(got, []string{"10.1.1.0/24 via 192.168.1.11"}) {
+			t.Errorf("Wrong routes after repair %q", got)
+		}
+	})
+}
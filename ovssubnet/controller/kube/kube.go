@@ -1,55 +1,102 @@
 package kube
 
 import (
-	"encoding/hex"
 	"fmt"
 	log "github.com/golang/glog"
 	"net"
 	"os"
-	"os/exec"
-	"strconv"
-	"syscall"
 
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
 	"github.com/openshift/openshift-sdn/pkg/netutils"
 	netutils_server "github.com/openshift/openshift-sdn/pkg/netutils/server"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
 )
 
+// configEnv tells the pod hook the gateway and container network.
+const configEnv = "/etc/openshift-sdn/config.env"
+
 type FlowController struct {
+	executor exec.Interface
+	tunnel   api.Tunnel
+	// br0, which the flows of nodes are added to and deleted from over
+	// OpenFlow
+	bridge *openflow.Bridge
+}
+
+func NewFlowController(executor exec.Interface) *FlowController {
+	return &FlowController{executor: executor, tunnel: api.DefaultTunnel, bridge: openflow.NewOVSBridge("br0")}
 }
 
-func NewFlowController() *FlowController {
-	return &FlowController{}
+// SetTunnel sets the encapsulation used to reach other nodes.
+func (c *FlowController) SetTunnel(tunnel api.Tunnel) {
+	c.tunnel = tunnel
 }
 
-func (c *FlowController) Setup(localSubnet, containerNetwork string) error {
+func (c *FlowController) Setup(localSubnet, containerNetwork string, mtu uint) error {
 	_, ipnet, err := net.ParseCIDR(localSubnet)
-	subnetMaskLength, _ := ipnet.Mask.Size()
-	gateway := netutils.GenerateDefaultGateway(ipnet).String()
-	out, err := exec.Command("openshift-sdn-kube-subnet-setup.sh", gateway, ipnet.String(), containerNetwork, strconv.Itoa(subnetMaskLength), gateway).CombinedOutput()
-	log.Infof("Output of setup script:\n%s", out)
 	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			status := exitErr.ProcessState.Sys().(syscall.WaitStatus)
-			if status.Exited() && status.ExitStatus() == 140 {
-				// valid, do nothing, its just a benevolent restart
-				return nil
-			}
-		}
-		log.Errorf("Error executing setup script. \n\tOutput: %s\n\tError: %v\n", out, err)
 		return err
 	}
-	//go c.manageLocalIpam(ipnet)
-	_, err = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0").CombinedOutput()
+	unlock, err := nodesetup.Lock(nodesetup.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	node, err := nodesetup.NewNode(c.executor)
 	if err != nil {
 		return err
 	}
-	_, err = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", "cookie=0x0,table=0,priority=50,actions=output:2").CombinedOutput()
-	arprule := fmt.Sprintf("cookie=0x0,table=0,priority=100,arp,nw_dst=%s,actions=output:2", gateway)
-	iprule := fmt.Sprintf("cookie=0x0,table=0,priority=100,ip,nw_dst=%s,actions=output:2", gateway)
-	_, err = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", arprule).CombinedOutput()
-	_, err = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", iprule).CombinedOutput()
-	return err
+	defer node.Close()
+	//go c.manageLocalIpam(ipnet)
+	return nodesetup.Run(setupSteps(node, ipnet, containerNetwork, c.tunnel, mtu))
+}
+
+// setupSteps returns the node setup: pods on lbr0 reach br0 through the
+// vlinuxbr/vovsbr veth pair, other nodes through the tunnel port and the host
+// through tun0, which carries the subnet gateway. br0 answers ARP requests
+// for the gateway itself and drops those for addresses outside the cluster
+// network.
+func setupSteps(node *nodesetup.Node, ipnet *net.IPNet, containerNetwork string, tunnel api.Tunnel, mtu uint) []nodesetup.Step {
+	ones, _ := ipnet.Mask.Size()
+	gatewayIP := netutils.GenerateDefaultGateway(ipnet)
+	gateway := gatewayIP.String()
+	gatewayCIDR := fmt.Sprintf("%s/%d", gateway, ones)
+	return []nodesetup.Step{
+		node.OVSBridge("br0"),
+		node.TunnelPort("br0", tunnel, 1),
+		node.OVSPort("br0", "tun0", &ovsdb.Interface{Type: "internal", OfportRequest: 2}),
+		nodesetup.VethPair("vlinuxbr", "vovsbr"),
+		nodesetup.MTU("vlinuxbr", mtu),
+		nodesetup.MTU("vovsbr", mtu),
+		node.OVSPort("br0", "vovsbr", &ovsdb.Interface{OfportRequest: 9}),
+		nodesetup.LinuxBridge("lbr0"),
+		nodesetup.Address("lbr0", gatewayCIDR),
+		nodesetup.BridgePort("lbr0", "vlinuxbr"),
+		nodesetup.MTU("lbr0", mtu),
+		nodesetup.Address("tun0", gatewayCIDR),
+		nodesetup.MTU("tun0", mtu),
+		nodesetup.HardwareAddr("tun0", netutils.GenerateMAC(gatewayIP)),
+		nodesetup.Route(containerNetwork, "tun0", ""),
+		node.Flows("br0", baseFlows(gatewayIP)),
+		node.IPTables(nodesetup.IPTablesRule{Table: "nat", Chain: "POSTROUTING", Args: []string{"-s", containerNetwork, "!", "-d", containerNetwork, "-j", "MASQUERADE"}}),
+		node.TunnelIPTables(tunnel),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "INPUT", Args: []string{"-i", "tun0", "-m", "comment", "--comment", "traffic from docker for internet", "-j", "ACCEPT"}, Before: "RELATED,ESTABLISHED"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-d", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-s", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.DockerNetwork(nodesetup.DockerNetworkFile, mtu),
+		// keep lbr0 traffic out of iptables; on kernels 3.18+ the sysctl
+		// comes with br_netfilter
+		node.Sysctl("net/bridge/bridge-nf-call-iptables", "0", "br_netfilter"),
+		// pods reach the local subnet through br0, not lbr0
+		nodesetup.NoRoute(ipnet.String(), "lbr0"),
+		nodesetup.File(configEnv, fmt.Sprintf("export OPENSHIFT_SDN_TAP1_ADDR=%s\nexport OPENSHIFT_CLUSTER_SUBNET=%s\n", gateway, containerNetwork), nil),
+	}
 }
 
 func (c *FlowController) manageLocalIpam(ipnet *net.IPNet) error {
@@ -57,11 +104,11 @@ func (c *FlowController) manageLocalIpam(ipnet *net.IPNet) error {
 	ipamPort := uint(9080)
 	inuse := make([]string, 0)
 	ipam, _ := netutils.NewIPAllocator(ipnet.String(), inuse)
-	f, err := os.Create("/etc/openshift-sdn/config.env")
+	f, err := os.Create(configEnv)
 	if err != nil {
 		return err
 	}
-	_, err = f.WriteString(fmt.Sprintf("OPENSHIFT_SDN_TAP1_ADDR=%s\nOPENSHIFT_SDN_IPAM_SERVER=http://%s:%s", netutils.GenerateDefaultGateway(ipnet), ipamHost, ipamPort))
+	_, err = f.WriteString(fmt.Sprintf("OPENSHIFT_SDN_TAP1_ADDR=%s\nOPENSHIFT_SDN_IPAM_SERVER=http://%s:%d", netutils.GenerateDefaultGateway(ipnet), ipamHost, ipamPort))
 	if err != nil {
 		return err
 	}
@@ -71,53 +118,110 @@ func (c *FlowController) manageLocalIpam(ipnet *net.IPNet) error {
 	return nil
 }
 
+// baseFlows returns the flows br0 needs before any node or pod is added:
+// traffic goes to tun0 unless a node or pod flow says otherwise, and br0
+// answers ARP requests for the gateway itself and drops other ARP requests.
+// The local node's flows, at a higher priority than the drop, send ARP for
+// the local subnet to the containers on lbr0.
+func baseFlows(gatewayIP net.IP) []*ofctl.Flow {
+	gateway := gatewayIP.String()
+	gatewayMAC := netutils.GenerateMAC(gatewayIP).String()
+	system := uint64(cookie.New(cookie.System, 0))
+	return []*ofctl.Flow{
+		{Cookie: system, Priority: 50, Actions: []ofctl.Action{ofctl.Output(2)}},
+		{Cookie: system, Priority: 60, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1")}},
+		{Cookie: system, Priority: 150, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("arp_tpa", gateway)}, Actions: ofctl.ARPReply(gateway, gatewayMAC)},
+		{Cookie: system, Priority: 100, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("nw_dst", gateway)}, Actions: []ofctl.Action{ofctl.Output(2)}},
+		{Cookie: system, Priority: 100, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", gateway)}, Actions: []ofctl.Action{ofctl.Output(2)}},
+	}
+}
+
 func (c *FlowController) AddOFRules(minionIP, subnet, localIP string) error {
-	cookie := generateCookie(minionIP)
-	if minionIP == localIP {
+	if err := c.bridge.AddFlows(nodeFlows(minionIP, subnet, localIP)); err != nil {
+		return fmt.Errorf("Failed to add the flows of node %s: %v", minionIP, err)
+	}
+	log.Infof("Added the flows of node %s", minionIP)
+	return nil
+}
+
+// nodeFlows returns the flows that route traffic to minionIP's subnet over
+// the tunnel, or deliver it locally when minionIP is localIP. br0 answers ARP
+// requests for the pods of other nodes itself with the MAC address docker
+// gives them, rather than sending the requests through the tunnel.
+func nodeFlows(minionIP, subnet, localIP string) []*ofctl.Flow {
+	owner := uint64(cookie.ForNode(minionIP))
+	if minionIP != localIP {
+		return []*ofctl.Flow{
+			{
+				Cookie:   owner,
+				Priority: 100,
+				Match:    []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", subnet)},
+				Actions:  []ofctl.Action{ofctl.SetField(minionIP, "tun_dst"), ofctl.Output(1)},
+			},
+			{
+				Cookie:   owner,
+				Priority: 100,
+				Match:    []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("arp_tpa", subnet)},
+				Actions:  ofctl.GeneratedARPReply(),
+			},
+		}
+	}
+	flows := []*ofctl.Flow{}
+	for _, proto := range []ofctl.Field{ofctl.IP, ofctl.ARP} {
 		// self, so add the input rules for containers that are not processed through kube-hooks
 		// for the input rules to pods, see the kube-hook
-		iprule := fmt.Sprintf("table=0,cookie=0x%s,priority=75,ip,nw_dst=%s,actions=output:9", cookie, subnet)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s,priority=75,arp,nw_dst=%s,actions=output:9", cookie, subnet)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", iprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", iprule, o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", arprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", arprule, o, e)
-		return e
-	} else {
-		iprule := fmt.Sprintf("table=0,cookie=0x%s,priority=100,ip,nw_dst=%s,actions=set_field:%s->tun_dst,output:1", cookie, subnet, minionIP)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s,priority=100,arp,nw_dst=%s,actions=set_field:%s->tun_dst,output:1", cookie, subnet, minionIP)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", iprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", iprule, o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", arprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", arprule, o, e)
-		return e
+		flows = append(flows, &ofctl.Flow{
+			Cookie:   owner,
+			Priority: 75,
+			Match:    []ofctl.Field{proto, ofctl.Eq("nw_dst", subnet)},
+			Actions:  []ofctl.Action{ofctl.Output(9)},
+		})
 	}
-	return nil
+	return flows
 }
 
-func (c *FlowController) DelOFRules(minion, localIP string) error {
-	log.Infof("Calling del rules for %s", minion)
-	cookie := generateCookie(minion)
-	if minion == localIP {
-		iprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,ip,in_port=10", cookie)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,arp,in_port=10", cookie)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", iprule).CombinedOutput()
-		log.Infof("Output of deleting local ip rules %s (%v)", o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", arprule).CombinedOutput()
-		log.Infof("Output of deleting local arp rules %s (%v)", o, e)
-		return e
-	} else {
-		iprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,ip", cookie)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,arp", cookie)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", iprule).CombinedOutput()
-		log.Infof("Output of deleting %s: %s (%v)", iprule, o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", arprule).CombinedOutput()
-		log.Infof("Output of deleting %s: %s (%v)", arprule, o, e)
-		return e
+// podFlows returns the flows the kube hook installs for a local pod: br0
+// answers ARP requests for the pod and delivers the rest of its traffic.
+func podFlows(pod *podstate.Pod) []*ofctl.Flow {
+	owner := uint64(cookie.ForPod(pod.Ofport))
+	flows := []*ofctl.Flow{}
+	if mac := pod.MACAddress(); mac != "" {
+		flows = append(flows, &ofctl.Flow{
+			Cookie:   owner,
+			Priority: 150,
+			Match:    []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("arp_tpa", pod.IP)},
+			Actions:  ofctl.ARPReply(pod.IP, mac),
+		})
 	}
-	return nil
+	for _, proto := range []ofctl.Field{ofctl.IP, ofctl.ARP} {
+		flows = append(flows, &ofctl.Flow{
+			Cookie:   owner,
+			Priority: 100,
+			Match:    []ofctl.Field{proto, ofctl.Eq("nw_dst", pod.IP)},
+			Actions:  []ofctl.Action{ofctl.Output(pod.Ofport)},
+		})
+	}
+	return flows
+}
+
+// DesiredFlows returns the node flows for every subnet in the cluster and the
+// flows of every local pod.
+func (c *FlowController) DesiredFlows(subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Flow {
+	flows := []*ofctl.Flow{}
+	for _, s := range subnets {
+		flows = append(flows, nodeFlows(s.Minion, s.Sub, localIP)...)
+	}
+	for i := range pods {
+		flows = append(flows, podFlows(&pods[i])...)
+	}
+	return flows
 }
 
-func generateCookie(ip string) string {
-	return hex.EncodeToString(net.ParseIP(ip).To4())
+func (c *FlowController) DelOFRules(minion, localIP string) error {
+	owner := uint64(cookie.ForNode(minion))
+	if err := c.bridge.Transact(openflow.NewFlowDelete(0, owner, cookie.OwnerMask, nil)); err != nil {
+		return fmt.Errorf("Failed to delete the flows of node %s: %v", minion, err)
+	}
+	log.Infof("Deleted the flows of node %s", minion)
+	return nil
 }
@@ -1,81 +1,147 @@
 package lbr
 
 import (
-	"encoding/hex"
 	"fmt"
 	log "github.com/golang/glog"
 	"net"
-	"os/exec"
-	"strconv"
 
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
 	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
 )
 
 type FlowController struct {
+	executor exec.Interface
+	tunnel   api.Tunnel
+	// br0, which the flows of nodes are added to and deleted from over
+	// OpenFlow
+	bridge *openflow.Bridge
 }
 
-func NewFlowController() *FlowController {
-	return &FlowController{}
+func NewFlowController(executor exec.Interface) *FlowController {
+	return &FlowController{executor: executor, tunnel: api.DefaultTunnel, bridge: openflow.NewOVSBridge("br0")}
 }
 
-func (c *FlowController) Setup(localSubnet, containerNetwork string) error {
+// SetTunnel sets the encapsulation used to reach other nodes.
+func (c *FlowController) SetTunnel(tunnel api.Tunnel) {
+	c.tunnel = tunnel
+}
+
+func (c *FlowController) Setup(localSubnet, containerNetwork string, mtu uint) error {
 	_, ipnet, err := net.ParseCIDR(localSubnet)
-	subnetMaskLength, _ := ipnet.Mask.Size()
-	out, err := exec.Command("openshift-sdn-simple-setup-node.sh", netutils.GenerateDefaultGateway(ipnet).String(), ipnet.String(), containerNetwork, strconv.Itoa(subnetMaskLength)).CombinedOutput()
-	log.Infof("Output of setup script:\n%s", out)
 	if err != nil {
-		log.Errorf("Error executing setup script. \n\tOutput: %s\n\tError: %v\n", out, err)
+		return err
+	}
+	unlock, err := nodesetup.Lock(nodesetup.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	node, err := nodesetup.NewNode(c.executor)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	return nodesetup.Run(setupSteps(node, ipnet, containerNetwork, c.tunnel, mtu))
+}
+
+// setupSteps returns the node setup: containers on lbr0, which carries the
+// subnet gateway, reach br0 through the vlinuxbr/vovsbr veth pair and other
+// nodes through the tunnel port. lbr0 has the MAC address docker would give
+// the gateway, which br0 on other nodes answers ARP requests for.
+func setupSteps(node *nodesetup.Node, ipnet *net.IPNet, containerNetwork string, tunnel api.Tunnel, mtu uint) []nodesetup.Step {
+	ones, _ := ipnet.Mask.Size()
+	gatewayIP := netutils.GenerateDefaultGateway(ipnet)
+	gateway := gatewayIP.String()
+	return []nodesetup.Step{
+		node.OVSBridge("br0"),
+		node.TunnelPort("br0", tunnel, 10),
+		nodesetup.VethPair("vlinuxbr", "vovsbr"),
+		nodesetup.MTU("vlinuxbr", mtu),
+		nodesetup.MTU("vovsbr", mtu),
+		node.OVSPort("br0", "vovsbr", &ovsdb.Interface{OfportRequest: 9}),
+		nodesetup.LinuxBridge("lbr0"),
+		nodesetup.Address("lbr0", fmt.Sprintf("%s/%d", gateway, ones)),
+		nodesetup.BridgePort("lbr0", "vlinuxbr"),
+		nodesetup.MTU("lbr0", mtu),
+		nodesetup.HardwareAddr("lbr0", netutils.GenerateMAC(gatewayIP)),
+		nodesetup.NoRoute(ipnet.String(), "lbr0"),
+		nodesetup.Route(containerNetwork, "lbr0", gateway),
+		node.IPTables(nodesetup.IPTablesRule{Table: "nat", Chain: "POSTROUTING", Args: []string{"-s", containerNetwork, "!", "-d", containerNetwork, "-j", "MASQUERADE"}}),
+		node.TunnelIPTables(tunnel),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "INPUT", Args: []string{"-i", "lbr0", "-m", "comment", "--comment", "traffic from docker", "-j", "ACCEPT"}, Before: "RELATED,ESTABLISHED"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-d", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-s", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.DockerNetwork(nodesetup.DockerNetworkFile, mtu),
 	}
-	_, err = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0").CombinedOutput()
-	return err
 }
 
 func (c *FlowController) AddOFRules(minionIP, subnet, localIP string) error {
-	cookie := generateCookie(minionIP)
-	if minionIP == localIP {
-		// self, so add the input rules
-		iprule := fmt.Sprintf("table=0,cookie=0x%s,priority=200,ip,in_port=10,nw_dst=%s,actions=output:9", cookie, subnet)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s,priority=200,arp,in_port=10,nw_dst=%s,actions=output:9", cookie, subnet)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", iprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", iprule, o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", arprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", arprule, o, e)
-		return e
-	} else {
-		iprule := fmt.Sprintf("table=0,cookie=0x%s,priority=200,ip,in_port=9,nw_dst=%s,actions=set_field:%s->tun_dst,output:10", cookie, subnet, minionIP)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s,priority=200,arp,in_port=9,nw_dst=%s,actions=set_field:%s->tun_dst,output:10", cookie, subnet, minionIP)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", iprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", iprule, o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", arprule).CombinedOutput()
-		log.Infof("Output of adding %s: %s (%v)", arprule, o, e)
-		return e
+	if err := c.bridge.AddFlows(nodeFlows(minionIP, subnet, localIP)); err != nil {
+		return fmt.Errorf("Failed to add the flows of node %s: %v", minionIP, err)
 	}
+	log.Infof("Added the flows of node %s", minionIP)
 	return nil
 }
 
-func (c *FlowController) DelOFRules(minion, localIP string) error {
-	log.Infof("Calling del rules for %s.", minion)
-	cookie := generateCookie(minion)
-	if minion == localIP {
-		iprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,ip,in_port=10", cookie)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,arp,in_port=10", cookie)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", iprule).CombinedOutput()
-		log.Infof("Output of deleting local ip rules: %s (%v)", o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", arprule).CombinedOutput()
-		log.Infof("Output of deleting local arp rules: %s (%v)", o, e)
-		return e
-	} else {
-		iprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,ip,in_port=9", cookie)
-		arprule := fmt.Sprintf("table=0,cookie=0x%s/0xffffffff,arp,in_port=9", cookie)
-		o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", iprule).CombinedOutput()
-		log.Infof("Output of deleting %s: %s (%v)", iprule, o, e)
-		o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", arprule).CombinedOutput()
-		log.Infof("Output of deleting %s: %s (%v)", arprule, o, e)
-		return e
+// nodeFlows returns the flows that route traffic to minionIP's subnet, or
+// deliver traffic arriving for the local subnet when minionIP is localIP.
+// br0 answers ARP requests from lbr0 for a remote subnet itself, with the
+// MAC address docker gives the requested IP; there is no record of the
+// containers of other nodes to answer from.
+func nodeFlows(minionIP, subnet, localIP string) []*ofctl.Flow {
+	owner := uint64(cookie.ForNode(minionIP))
+	flows := []*ofctl.Flow{}
+	if minionIP != localIP {
+		flows = append(flows, &ofctl.Flow{
+			Cookie:   owner,
+			Priority: 250,
+			Match:    []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("in_port", "9"), ofctl.Eq("arp_tpa", subnet)},
+			Actions:  ofctl.GeneratedARPReply(),
+		})
 	}
-	return nil
+	for _, proto := range []ofctl.Field{ofctl.IP, ofctl.ARP} {
+		if minionIP == localIP {
+			// self, so add the input rules
+			flows = append(flows, &ofctl.Flow{
+				Cookie:   owner,
+				Priority: 200,
+				Match:    []ofctl.Field{proto, ofctl.Eq("in_port", "10"), ofctl.Eq("nw_dst", subnet)},
+				Actions:  []ofctl.Action{ofctl.Output(9)},
+			})
+		} else {
+			flows = append(flows, &ofctl.Flow{
+				Cookie:   owner,
+				Priority: 200,
+				Match:    []ofctl.Field{proto, ofctl.Eq("in_port", "9"), ofctl.Eq("nw_dst", subnet)},
+				Actions:  []ofctl.Action{ofctl.SetField(minionIP, "tun_dst"), ofctl.Output(10)},
+			})
+		}
+	}
+	return flows
 }
 
-func generateCookie(ip string) string {
-	return hex.EncodeToString(net.ParseIP(ip).To4())
+// DesiredFlows returns the node flows for every subnet in the cluster. Pods
+// are attached to lbr0 rather than br0, so they need no flows of their own.
+func (c *FlowController) DesiredFlows(subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Flow {
+	flows := []*ofctl.Flow{}
+	for _, s := range subnets {
+		flows = append(flows, nodeFlows(s.Minion, s.Sub, localIP)...)
+	}
+	return flows
+}
+
+func (c *FlowController) DelOFRules(minion, localIP string) error {
+	owner := uint64(cookie.ForNode(minion))
+	if err := c.bridge.Transact(openflow.NewFlowDelete(0, owner, cookie.OwnerMask, nil)); err != nil {
+		return fmt.Errorf("Failed to delete the flows of node %s: %v", minion, err)
+	}
+	log.Infof("Deleted the flows of node %s", minion)
+	return nil
 }
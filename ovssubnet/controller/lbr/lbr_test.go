@@ -0,0 +1,157 @@
+package lbr
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow/fakeswitch"
+)
+
+func TestSetupSteps(t *testing.T) {
+	expected := []string{
+		"OVS bridge br0",
+		"OVS port vxlan0 on br0",
+		"veth pair vlinuxbr/vovsbr",
+		"MTU 1450 on vlinuxbr",
+		"MTU 1450 on vovsbr",
+		"OVS port vovsbr on br0",
+		"Linux bridge lbr0",
+		"address 10.1.2.1/24 on lbr0",
+		"port vlinuxbr on lbr0",
+		"MTU 1450 on lbr0",
+		"MAC address 02:42:0a:01:02:01 on lbr0",
+		"no route 10.1.2.0/24 dev lbr0",
+		"route 10.1.0.0/16 dev lbr0 src 10.1.2.1",
+		"iptables rule -t nat POSTROUTING -s 10.1.0.0/16 ! -d 10.1.0.0/16 -j MASQUERADE",
+		"iptables rule -t filter INPUT -p udp -m multiport --dports 4789 -m comment --comment 001 vxlan incoming -j ACCEPT",
+		"iptables rule -t filter INPUT -i lbr0 -m comment --comment traffic from docker -j ACCEPT",
+		"iptables rule -t filter FORWARD -d 10.1.0.0/16 -j ACCEPT",
+		"iptables rule -t filter FORWARD -s 10.1.0.0/16 -j ACCEPT",
+		"docker network options",
+	}
+	_, ipnet, _ := net.ParseCIDR("10.1.2.0/24")
+	names := []string{}
+	for _, step := range setupSteps(&nodesetup.Node{}, ipnet, "10.1.0.0/16", api.DefaultTunnel, 1450) {
+		names = append(names, step.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Wrong setup steps.\nExpected %q\nGot      %q", expected, names)
+	}
+}
+
+// generatedARPReplyDump is ofctl.GeneratedARPReply as the fake switch prints it
+const generatedARPReplyDump = "move:eth_src[0..47]->eth_dst[0..47],set_field:arp_op=0x0002,move:arp_sha[0..47]->arp_tha[0..47],set_field:arp_sha=02:42:00:00:00:00," +
+	"move:arp_tpa[0..31]->arp_sha[0..31],move:arp_sha[0..47]->eth_src[0..47],move:arp_spa[0..31]->reg1[0..31],move:arp_tpa[0..31]->arp_spa[0..31]," +
+	"move:reg1[0..31]->arp_tpa[0..31],output:in_port"
+
+// localFlowDump and remoteFlowDump are the flows of the local node with
+// subnet 10.1.2.0/24 and of node 172.17.0.3 with subnet 10.1.3.0/24 as the
+// fake switch prints them
+var (
+	localFlowDump = []string{
+		"cookie=0x2000000ac110002,table=0,priority=200,eth_type=0x0800,in_port=10,nw_dst=10.1.2.0/24 actions=output:9",
+		"cookie=0x2000000ac110002,table=0,priority=200,eth_type=0x0806,in_port=10,arp_tpa=10.1.2.0/24 actions=output:9",
+	}
+	remoteFlowDump = []string{
+		"cookie=0x2000000ac110003,table=0,priority=250,eth_type=0x0806,arp_op=0x0001,in_port=9,arp_tpa=10.1.3.0/24 actions=" + generatedARPReplyDump,
+		"cookie=0x2000000ac110003,table=0,priority=200,eth_type=0x0800,in_port=9,nw_dst=10.1.3.0/24 actions=set_field:tun_dst=172.17.0.3,output:10",
+		"cookie=0x2000000ac110003,table=0,priority=200,eth_type=0x0806,in_port=9,arp_tpa=10.1.3.0/24 actions=set_field:tun_dst=172.17.0.3,output:10",
+	}
+)
+
+func TestAddOFRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		minionIP string
+		subnet   string
+		reject   func(*openflow.FlowMod) *openflow.Error
+		flows    []string
+		fail     bool
+	}{
+		{
+			name:     "local node",
+			minionIP: "172.17.0.2",
+			subnet:   "10.1.2.0/24",
+			flows:    localFlowDump,
+		},
+		{
+			name:     "remote node",
+			minionIP: "172.17.0.3",
+			subnet:   "10.1.3.0/24",
+			flows:    remoteFlowDump,
+		},
+		{
+			name:     "flow rejected",
+			minionIP: "172.17.0.3",
+			subnet:   "10.1.3.0/24",
+			reject: func(f *openflow.FlowMod) *openflow.Error {
+				if f.Match.Covers(openflow.Match{openflow.MatchEthType(openflow.EthTypeIPv4)}) {
+					return &openflow.Error{Type: openflow.ErrFlowModFailed, Code: openflow.FlowModTableFull}
+				}
+				return nil
+			},
+			flows: []string{remoteFlowDump[0], remoteFlowDump[2]},
+			fail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		sw := fakeswitch.New()
+		sw.RejectFlows(test.reject)
+		executor := exec.NewFake()
+		c := NewFlowController(executor)
+		c.bridge = openflow.NewBridge(sw.Dial)
+		err := c.AddOFRules(test.minionIP, test.subnet, "172.17.0.2")
+		if (err != nil) != test.fail {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+		if flows := sw.DumpFlows(); !reflect.DeepEqual(flows, test.flows) {
+			t.Errorf("%s: wrong flows.\nExpected %q\nGot      %q", test.name, test.flows, flows)
+		}
+		if len(executor.CommandLines()) != 0 {
+			t.Errorf("%s: unexpected commands %q", test.name, executor.CommandLines())
+		}
+	}
+}
+
+func TestDelOFRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		minionIP string
+		flows    []string
+	}{
+		{
+			name:     "local node",
+			minionIP: "172.17.0.2",
+			flows:    remoteFlowDump,
+		},
+		{
+			name:     "remote node",
+			minionIP: "172.17.0.3",
+			flows:    localFlowDump,
+		},
+	}
+
+	for _, test := range tests {
+		sw := fakeswitch.New()
+		c := NewFlowController(exec.NewFake())
+		c.bridge = openflow.NewBridge(sw.Dial)
+		if err := c.AddOFRules("172.17.0.2", "10.1.2.0/24", "172.17.0.2"); err != nil {
+			t.Fatalf("%s: error adding flows: %v", test.name, err)
+		}
+		if err := c.AddOFRules("172.17.0.3", "10.1.3.0/24", "172.17.0.2"); err != nil {
+			t.Fatalf("%s: error adding flows: %v", test.name, err)
+		}
+		if err := c.DelOFRules(test.minionIP, "172.17.0.2"); err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if flows := sw.DumpFlows(); !reflect.DeepEqual(flows, test.flows) {
+			t.Errorf("%s: wrong flows.\nExpected %q\nGot      %q", test.name, test.flows, flows)
+		}
+	}
+}
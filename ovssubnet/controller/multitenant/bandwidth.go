@@ -0,0 +1,108 @@
+package multitenant
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+)
+
+const (
+	// IngressBandwidthAnnotation limits the traffic to a pod, in bits per
+	// second.
+	IngressBandwidthAnnotation = "kubernetes.io/ingress-bandwidth"
+	// EgressBandwidthAnnotation limits the traffic from a pod, in bits per
+	// second.
+	EgressBandwidthAnnotation = "kubernetes.io/egress-bandwidth"
+
+	// OVS polices in kbps and shaping a port below that is pointless
+	minBandwidth = 1000
+	maxBandwidth = 1000000000000
+)
+
+// bandwidthSuffixes are the multipliers of the quantity suffixes, binary
+// ones first so that "Mi" is not taken for "M".
+var bandwidthSuffixes = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"k", 1000}, {"M", 1000000}, {"G", 1000000000}, {"T", 1000000000000},
+}
+
+// ParseBandwidth parses a bandwidth in bits per second written like a
+// Kubernetes quantity: a whole number with an optional decimal (k, M, G, T)
+// or binary (Ki, Mi, Gi, Ti) suffix, e.g. "10M".
+func ParseBandwidth(s string) (uint64, error) {
+	number, multiplier := s, uint64(1)
+	for _, sfx := range bandwidthSuffixes {
+		if strings.HasSuffix(s, sfx.suffix) {
+			number, multiplier = strings.TrimSuffix(s, sfx.suffix), sfx.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid bandwidth %q", s)
+	}
+	if n > maxBandwidth/multiplier || n*multiplier < minBandwidth || n*multiplier > maxBandwidth {
+		return 0, fmt.Errorf("Bandwidth %s is not between 1k and 1T", s)
+	}
+	return n * multiplier, nil
+}
+
+// FormatBandwidth writes bps the way ParseBandwidth reads it, with the
+// largest decimal suffix that keeps it whole.
+func FormatBandwidth(bps uint64) string {
+	for i := len(bandwidthSuffixes) - 1; i >= 0 && bps > 0; i-- {
+		sfx := bandwidthSuffixes[i]
+		if strings.HasSuffix(sfx.suffix, "i") {
+			break
+		}
+		if bps%sfx.multiplier == 0 {
+			return strconv.FormatUint(bps/sfx.multiplier, 10) + sfx.suffix
+		}
+	}
+	return strconv.FormatUint(bps, 10)
+}
+
+// bandwidthLimits returns the limits the annotations of a pod set on the
+// traffic to and from it, 0 for none.
+func bandwidthLimits(annotations map[string]string) (ingress, egress uint64, err error) {
+	if value, ok := annotations[IngressBandwidthAnnotation]; ok {
+		if ingress, err = ParseBandwidth(value); err != nil {
+			return 0, 0, fmt.Errorf("Invalid %s annotation: %v", IngressBandwidthAnnotation, err)
+		}
+	}
+	if value, ok := annotations[EgressBandwidthAnnotation]; ok {
+		if egress, err = ParseBandwidth(value); err != nil {
+			return 0, 0, fmt.Errorf("Invalid %s annotation: %v", EgressBandwidthAnnotation, err)
+		}
+	}
+	return ingress, egress, nil
+}
+
+// PodDiagnostics writes a table of the pods recorded in dir, the local pods,
+// with their ports, VNIDs and bandwidth limits.
+func PodDiagnostics(w io.Writer, dir string) error {
+	pods, err := podstate.List(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to read the pod records: %v", err)
+	}
+	limit := func(bps uint64) string {
+		if bps == 0 {
+			return "-"
+		}
+		return FormatBandwidth(bps)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "POD\tPORT\tIP\tVNID\tINGRESS\tEGRESS")
+	for _, pod := range pods {
+		fmt.Fprintf(tw, "%s/%s\t%d\t%s\t%d\t%s\t%s\n", pod.Namespace, pod.Name, pod.Ofport, pod.IP, pod.VNID,
+			limit(pod.IngressBandwidth), limit(pod.EgressBandwidth))
+	}
+	return tw.Flush()
+}
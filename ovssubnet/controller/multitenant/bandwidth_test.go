@@ -0,0 +1,73 @@
+package multitenant
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+)
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		value     string
+		bps       uint64
+		formatted string
+		fail      bool
+	}{
+		{value: "10M", bps: 10000000, formatted: "10M"},
+		{value: "1500k", bps: 1500000, formatted: "1500k"},
+		{value: "1Gi", bps: 1073741824, formatted: "1073741824"},
+		{value: "2Mi", bps: 2097152, formatted: "2097152"},
+		{value: "1T", bps: 1000000000000, formatted: "1T"},
+		{value: "64000", bps: 64000, formatted: "64k"},
+		{value: "999", fail: true},
+		{value: "2T", fail: true},
+		{value: "20000000000000000000k", fail: true},
+		{value: "10Mbps", fail: true},
+		{value: "-10M", fail: true},
+		{value: "", fail: true},
+	}
+	for _, test := range tests {
+		bps, err := ParseBandwidth(test.value)
+		if (err != nil) != test.fail || bps != test.bps {
+			t.Errorf("%q: wrong bandwidth %d (%v)", test.value, bps, err)
+			continue
+		}
+		if !test.fail && FormatBandwidth(bps) != test.formatted {
+			t.Errorf("%q: wrong formatting %q", test.value, FormatBandwidth(bps))
+		}
+	}
+}
+
+func TestPodDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "podstate")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	pods := []*podstate.Pod{
+		{Namespace: "shop", Name: "db", Veth: "veth1", Ofport: 4, IP: "10.1.2.3", VNID: 12, IngressBandwidth: 20000000},
+		{Namespace: "team", Name: "web", Veth: "veth2", Ofport: 3, IP: "10.1.2.2", VNID: 10, IngressBandwidth: 1000000, EgressBandwidth: 512000},
+		{Namespace: "default", Name: "router", Veth: "veth3", Ofport: 5, IP: "10.1.2.4"},
+	}
+	for _, pod := range pods {
+		if err := podstate.Write(dir, pod); err != nil {
+			t.Fatalf("Error writing record: %v", err)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	if err := PodDiagnostics(out, dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `POD             PORT  IP        VNID  INGRESS  EGRESS
+default/router  5     10.1.2.4  0     -        -
+shop/db         4     10.1.2.3  12    20M      -
+team/web        3     10.1.2.2  10    1M       512k
+`
+	if out.String() != expected {
+		t.Errorf("Wrong diagnostics:\n%s", out.String())
+	}
+}
@@ -0,0 +1,214 @@
+package multitenant
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// The packet mark of traffic leaving through an egress IP carries the VNID
+// of its namespace and egressMarkFlag, which tells it from the marks of
+// others. The VNID goes around bits 14 and 15, which kube-proxy uses for its
+// masquerade (0x4000) and drop (0x8000) marks, so it fits in 24 bits and the
+// SNAT rule matches on egressMarkMask only.
+const (
+	egressMarkFlag = 1 << 26
+	egressMarkMask = egressMarkFlag | 0x3ff<<16 | 0x3fff
+	maxEgressVNID  = 1<<24 - 1
+)
+
+// egressMark returns the packet mark of the egress IP traffic of vnid.
+func egressMark(vnid uint) uint32 {
+	return egressMarkFlag | uint32(vnid>>14)<<16 | uint32(vnid&0x3fff)
+}
+
+// egressMarkActions returns the actions setting the egress IP mark of vnid,
+// leaving the bits of kube-proxy alone.
+func egressMarkActions(vnid uint) []ofctl.Action {
+	return []ofctl.Action{
+		ofctl.Load(uint64(vnid&0x3fff), "NXM_NX_PKT_MARK[0..13]"),
+		ofctl.Load(uint64(vnid>>14), "NXM_NX_PKT_MARK[16..25]"),
+		ofctl.Load(1, "NXM_NX_PKT_MARK[26]"),
+	}
+}
+
+// egressIPsByVNID returns the egress IPs that apply, by the VNID of their
+// namespace. Namespaces sharing a VNID share one egress IP, the first given.
+// VNID 0 is also that of the node's docker containers, so global namespaces
+// get none, and VNIDs wider than 24 bits, possible with GRE, do not fit the
+// packet mark.
+func egressIPsByVNID(egressIPs []api.EgressIP, vnids map[string]uint) map[uint]api.EgressIP {
+	byVNID := map[uint]api.EgressIP{}
+	for _, eip := range egressIPs {
+		vnid, ok := vnids[eip.Namespace]
+		if !ok {
+			log.Warningf("Egress IP %s of unknown namespace %s", eip.IP, eip.Namespace)
+			continue
+		}
+		if vnid == 0 {
+			log.Warningf("Egress IP %s of global namespace %s is not used", eip.IP, eip.Namespace)
+			continue
+		}
+		if vnid > maxEgressVNID {
+			log.Warningf("Egress IP %s of namespace %s is not used, VNID %d does not fit in 24 bits", eip.IP, eip.Namespace, vnid)
+			continue
+		}
+		if other, ok := byVNID[vnid]; ok {
+			log.Warningf("Egress IP %s of namespace %s is not used, VNID %d already has %s of namespace %s", eip.IP, eip.Namespace, vnid, other.IP, other.Namespace)
+			continue
+		}
+		byVNID[vnid] = eip
+	}
+	return byVNID
+}
+
+// sortedVNIDs returns the VNIDs of egress IPs in order, for stable flows and
+// steps.
+func sortedVNIDs(m map[uint]string) []uint {
+	vnids := make([]uint, 0, len(m))
+	for vnid := range m {
+		vnids = append(vnids, vnid)
+	}
+	sort.Slice(vnids, func(i, j int) bool { return vnids[i] < vnids[j] })
+	return vnids
+}
+
+// egressIPFlows returns the flows that send the external traffic of
+// namespaces with an egress IP out through the node owning the IP. Other
+// nodes tunnel it to the owner in table 10, which takes it from the tunnel in
+// table 2; the owner marks it with the VNID for the SNAT rule of the IP
+// before it leaves through tun0, see egressMark. While the IP has no owner the traffic is
+// dropped rather than sent from another address.
+func egressIPFlows(egressIPs []api.EgressIP, vnids map[string]uint, localIP string, tunnel api.Tunnel) []*ofctl.Flow {
+	flows := []*ofctl.Flow{}
+	key, reg := vnidFields(tunnel)
+	byVNID := egressIPsByVNID(egressIPs, vnids)
+	ips := map[uint]string{}
+	for vnid, eip := range byVNID {
+		ips[vnid] = eip.IP
+	}
+	for _, vnid := range sortedVNIDs(ips) {
+		eip := byVNID[vnid]
+		owner := uint64(cookie.ForNamespace(vnid))
+		id := strconv.FormatUint(uint64(vnid), 10)
+		flow := &ofctl.Flow{
+			Table:    10,
+			Cookie:   owner,
+			Priority: 100,
+			Match:    []ofctl.Field{ofctl.IP, ofctl.Eq("reg0", id)},
+		}
+		switch eip.Node {
+		case "":
+		case localIP:
+			flow.Actions = append(egressMarkActions(vnid), ofctl.Output(2))
+			flows = append(flows, &ofctl.Flow{
+				Table:    2,
+				Cookie:   owner,
+				Priority: 50,
+				Match:    []ofctl.Field{ofctl.IP, ofctl.Eq("tun_id", id)},
+				Actions:  []ofctl.Action{ofctl.Move(key, reg), ofctl.GotoTable(10)},
+			})
+		default:
+			flow.Actions = []ofctl.Action{ofctl.Move(reg, key), ofctl.SetField(eip.Node, "tun_dst"), ofctl.Output(1)}
+		}
+		flows = append(flows, flow)
+	}
+	return flows
+}
+
+// egressIPHost is the host configuration of the egress IPs the node owns.
+type egressIPHost struct {
+	lock sync.Mutex
+	// the owned egress IPs by VNID, as last set up
+	owned map[uint]string
+}
+
+// SetupEgressIPs configures the host for the egress IPs the node owns: the
+// node's interface carries each IP and traffic marked with the VNID of its
+// namespace is SNATed to it. Egress IPs the node no longer owns are removed.
+func (c *FlowController) SetupEgressIPs(egressIPs []api.EgressIP, vnids map[string]uint, localIP string) error {
+	c.egressIPHost.lock.Lock()
+	defer c.egressIPHost.lock.Unlock()
+
+	owned := map[uint]string{}
+	for vnid, eip := range egressIPsByVNID(egressIPs, vnids) {
+		if eip.Node == localIP {
+			owned[vnid] = eip.IP
+		}
+	}
+	if len(owned) == 0 && len(c.egressIPHost.owned) == 0 {
+		return nil
+	}
+
+	iface, err := netutils.GetInterfaceName(localIP)
+	if err != nil {
+		return fmt.Errorf("Failed to find the interface of %s: %v", localIP, err)
+	}
+	unlock, err := nodesetup.Lock(nodesetup.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	node, err := nodesetup.NewNode(c.executor)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	if err := nodesetup.Run(egressIPSteps(node, iface, c.egressIPHost.owned, owned)); err != nil {
+		return err
+	}
+	for vnid, ip := range owned {
+		if c.egressIPHost.owned[vnid] != ip {
+			c.announceEgressIP(iface, ip)
+		}
+	}
+	c.egressIPHost.owned = owned
+	return nil
+}
+
+// egressIPSteps returns the steps that move the host from the old to the new
+// owned egress IPs, removing the old ones first.
+func egressIPSteps(node *nodesetup.Node, iface string, old, owned map[uint]string) []nodesetup.Step {
+	steps := []nodesetup.Step{}
+	for _, vnid := range sortedVNIDs(old) {
+		if owned[vnid] != old[vnid] {
+			steps = append(steps,
+				node.NoIPTables(egressIPRule(vnid, old[vnid])),
+				nodesetup.NoAddress(iface, old[vnid]+"/32"))
+		}
+	}
+	for _, vnid := range sortedVNIDs(owned) {
+		steps = append(steps,
+			nodesetup.Address(iface, owned[vnid]+"/32"),
+			node.IPTables(egressIPRule(vnid, owned[vnid])))
+	}
+	return steps
+}
+
+// egressIPRule SNATs the traffic marked with the VNID to the egress IP, ahead
+// of the masquerading of the cluster network.
+func egressIPRule(vnid uint, ip string) nodesetup.IPTablesRule {
+	return nodesetup.IPTablesRule{
+		Table:  "nat",
+		Chain:  "POSTROUTING",
+		Args:   []string{"-m", "mark", "--mark", fmt.Sprintf("0x%x/0x%x", egressMark(vnid), egressMarkMask), "-j", "SNAT", "--to-source", ip},
+		Before: "MASQUERADE",
+	}
+}
+
+// announceEgressIP sends gratuitous ARP for an egress IP the node took over,
+// so that its neighbors stop sending replies to the previous owner.
+func (c *FlowController) announceEgressIP(iface, ip string) {
+	if out, err := c.executor.Exec("arping", "-q", "-U", "-c", "1", "-I", iface, ip); err != nil {
+		log.Warningf("Failed to announce egress IP %s on %s: %v (%s)", ip, iface, err, out)
+	}
+}
@@ -0,0 +1,82 @@
+package multitenant
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+)
+
+func TestEgressIPFlows(t *testing.T) {
+	vnids := map[string]uint{"default": 0, "team": 10, "shop": 10, "bank": 12, "shelf": 13}
+	egressIPs := []api.EgressIP{
+		{Namespace: "bank", IP: "192.168.1.100", Nodes: []string{"172.17.0.2"}, Node: "172.17.0.2"},
+		// not used
+		{Namespace: "default", IP: "192.168.1.101", Nodes: []string{"172.17.0.2"}, Node: "172.17.0.2"},
+		{Namespace: "nobody", IP: "192.168.1.102", Nodes: []string{"172.17.0.2"}, Node: "172.17.0.2"},
+		// namespaces sharing a VNID share the first egress IP
+		{Namespace: "shop", IP: "192.168.1.103", Nodes: []string{"172.17.0.3"}, Node: "172.17.0.3"},
+		{Namespace: "team", IP: "192.168.1.104", Nodes: []string{"172.17.0.2"}, Node: "172.17.0.2"},
+		// no owner alive
+		{Namespace: "shelf", IP: "192.168.1.105", Nodes: []string{"172.17.0.4"}},
+	}
+	expected := []string{
+		"table=10,cookie=0x40000000000000a,priority=100,ip,reg0=10,actions=move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23],set_field:172.17.0.3->tun_dst,output:1",
+		"table=2,cookie=0x40000000000000c,priority=50,ip,tun_id=12,actions=move:NXM_NX_TUN_ID[0..23]->NXM_NX_REG0[0..23],goto_table:10",
+		"table=10,cookie=0x40000000000000c,priority=100,ip,reg0=12,actions=load:12->NXM_NX_PKT_MARK[0..13],load:0->NXM_NX_PKT_MARK[16..25],load:1->NXM_NX_PKT_MARK[26],output:2",
+		"table=10,cookie=0x40000000000000d,priority=100,ip,reg0=13,actions=drop",
+	}
+	flows := []string{}
+	for _, flow := range egressIPFlows(egressIPs, vnids, "172.17.0.2", api.DefaultTunnel) {
+		flows = append(flows, flow.String())
+	}
+	if !reflect.DeepEqual(flows, expected) {
+		t.Errorf("Wrong egress IP flows.\nExpected %q\nGot      %q", expected, flows)
+	}
+}
+
+func TestEgressMark(t *testing.T) {
+	tests := []struct {
+		vnid uint
+		mark uint32
+	}{
+		{10, 0x400000a},
+		// bit 14 of the VNID must not turn into kube-proxy's masquerade mark
+		{0x4005, 0x4010005},
+		{maxEgressVNID, 0x7ff3fff},
+	}
+	for _, test := range tests {
+		mark := egressMark(test.vnid)
+		if mark != test.mark || mark&0xc000 != 0 || mark&^egressMarkMask != 0 {
+			t.Errorf("Wrong mark 0x%x for VNID 0x%x, expected 0x%x", mark, test.vnid, test.mark)
+		}
+	}
+
+	// a GRE key can carry VNIDs that do not fit the mark
+	vnids := map[string]uint{"big": maxEgressVNID + 1}
+	egressIPs := []api.EgressIP{{Namespace: "big", IP: "192.168.1.100", Nodes: []string{"172.17.0.2"}, Node: "172.17.0.2"}}
+	if byVNID := egressIPsByVNID(egressIPs, vnids); len(byVNID) != 0 {
+		t.Errorf("Expected no egress IP for a VNID wider than 24 bits, got %v", byVNID)
+	}
+}
+
+func TestEgressIPSteps(t *testing.T) {
+	old := map[uint]string{10: "192.168.1.100", 12: "192.168.1.102"}
+	owned := map[uint]string{12: "192.168.1.102", 13: "192.168.1.103"}
+	expected := []string{
+		"no iptables rule -t nat POSTROUTING -m mark --mark 0x400000a/0x7ff3fff -j SNAT --to-source 192.168.1.100",
+		"no address 192.168.1.100/32 on eth0",
+		"address 192.168.1.102/32 on eth0",
+		"iptables rule -t nat POSTROUTING -m mark --mark 0x400000c/0x7ff3fff -j SNAT --to-source 192.168.1.102",
+		"address 192.168.1.103/32 on eth0",
+		"iptables rule -t nat POSTROUTING -m mark --mark 0x400000d/0x7ff3fff -j SNAT --to-source 192.168.1.103",
+	}
+	names := []string{}
+	for _, step := range egressIPSteps(&nodesetup.Node{}, "eth0", old, owned) {
+		names = append(names, step.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Wrong egress IP steps.\nExpected %q\nGot      %q", expected, names)
+	}
+}
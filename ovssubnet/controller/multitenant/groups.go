@@ -0,0 +1,54 @@
+package multitenant
+
+import (
+	"sort"
+	"sync"
+)
+
+// groupIDs hands out the ids of the OpenFlow groups of br0. Services and
+// multicast share them, each under a prefix of its own, and a key keeps its
+// id for as long as it is used.
+type groupIDs struct {
+	lock sync.Mutex
+	ids  map[string]uint32
+}
+
+// assign releases the ids of the keys under prefix that are not in keys,
+// gives the new keys the lowest free ids and returns the ids of keys.
+func (g *groupIDs) assign(prefix string, keys []string) map[string]uint32 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.ids == nil {
+		g.ids = make(map[string]uint32)
+	}
+	used := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		used[prefix+key] = true
+	}
+	for key := range g.ids {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix && !used[key] {
+			delete(g.ids, key)
+		}
+	}
+	taken := map[uint32]bool{}
+	for _, id := range g.ids {
+		taken[id] = true
+	}
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	result := make(map[string]uint32, len(keys))
+	next := uint32(1)
+	for _, key := range sorted {
+		id, ok := g.ids[prefix+key]
+		if !ok {
+			for taken[next] {
+				next++
+			}
+			id = next
+			g.ids[prefix+key] = id
+			taken[id] = true
+		}
+		result[key] = id
+	}
+	return result
+}
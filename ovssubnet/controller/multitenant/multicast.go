@@ -0,0 +1,158 @@
+package multitenant
+
+import (
+	"sort"
+	"strconv"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// multicastNetwork holds the IPv4 multicast addresses.
+const multicastNetwork = "224.0.0.0/4"
+
+// multicastVNIDs returns the VNIDs of the namespaces with multicast enabled.
+// Namespaces sharing a VNID share its multicast, so one of them enabling it
+// is enough. VNID 0 is also that of the node's docker containers and of the
+// host, so global namespaces get none.
+func multicastVNIDs(namespaces []string, vnids map[string]uint) map[uint]bool {
+	enabled := map[uint]bool{}
+	for _, namespace := range namespaces {
+		vnid, ok := vnids[namespace]
+		if !ok {
+			log.Warningf("Multicast enabled for unknown namespace %s", namespace)
+			continue
+		}
+		if vnid == 0 {
+			log.Warningf("Multicast of global namespace %s is not supported", namespace)
+			continue
+		}
+		enabled[vnid] = true
+	}
+	return enabled
+}
+
+// SetNodeVNIDs sets the VNIDs of the pods of every node, which decide the
+// nodes the multicast traffic of a VNID is sent to. The controller keeps
+// them, so callers must not change them.
+func (c *FlowController) SetNodeVNIDs(nodes []api.NodeVNIDs) {
+	c.policyLock.Lock()
+	defer c.policyLock.Unlock()
+	c.nodeVNIDs = nodes
+}
+
+// currentMulticast returns the group of every VNID with multicast and the
+// VNIDs of the pods of every node.
+func (c *FlowController) currentMulticast() (map[uint]uint32, []api.NodeVNIDs) {
+	c.policyLock.Lock()
+	defer c.policyLock.Unlock()
+	return c.multicastGroups, c.nodeVNIDs
+}
+
+// multicastFlows returns the multicast table flows: the multicast traffic of
+// a VNID with multicast enabled, from a local pod or from the tunnel, goes to
+// the group of the VNID carrying the VNID in the tunnel key. Other multicast
+// traffic is dropped.
+func multicastFlows(groups map[uint]uint32, tunnel api.Tunnel) []*ofctl.Flow {
+	flows := []*ofctl.Flow{}
+	key, reg := vnidFields(tunnel)
+	vnids := map[uint]string{}
+	for vnid := range groups {
+		vnids[vnid] = ""
+	}
+	for _, vnid := range sortedVNIDs(vnids) {
+		flows = append(flows, &ofctl.Flow{
+			Table:    12,
+			Cookie:   uint64(cookie.ForNamespace(vnid)),
+			Priority: 100,
+			Match:    []ofctl.Field{ofctl.IP, ofctl.Eq("reg0", strconv.FormatUint(uint64(vnid), 10))},
+			Actions:  []ofctl.Action{ofctl.Move(reg, key), ofctl.ToGroup(groups[vnid])},
+		})
+	}
+	return flows
+}
+
+// multicastGroups returns the groups that replicate the multicast traffic of
+// each VNID with multicast: one bucket per local pod of the VNID and one per
+// other node of the cluster with pods of the VNID. OVS does not send a packet
+// back out of the port it came in by, so the sender does not get its own
+// traffic back and traffic from the tunnel is not tunneled again.
+func multicastGroups(groups map[uint]uint32, nodes []api.NodeVNIDs, subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Group {
+	ports := map[uint][]int{}
+	for _, pod := range pods {
+		ports[pod.VNID] = append(ports[pod.VNID], pod.Ofport)
+	}
+	inCluster := map[string]bool{}
+	for _, s := range subnets {
+		inCluster[s.Minion] = true
+	}
+	remote := map[uint][]string{}
+	for _, n := range nodes {
+		if n.Node == localIP || !inCluster[n.Node] {
+			continue
+		}
+		for _, vnid := range n.VNIDs {
+			remote[vnid] = append(remote[vnid], n.Node)
+		}
+	}
+
+	result := []*ofctl.Group{}
+	for vnid, id := range groups {
+		group := &ofctl.Group{ID: id, Type: "all"}
+		sort.Ints(ports[vnid])
+		for _, ofport := range ports[vnid] {
+			group.Buckets = append(group.Buckets, []ofctl.Action{ofctl.Output(ofport)})
+		}
+		sort.Strings(remote[vnid])
+		for _, node := range remote[vnid] {
+			group.Buckets = append(group.Buckets, []ofctl.Action{ofctl.SetField(node, "tun_dst"), ofctl.Output(1)})
+		}
+		result = append(result, group)
+	}
+	return result
+}
+
+// LocalVNIDs returns the VNIDs of pods in order, leaving out VNID 0, which
+// has no multicast.
+func LocalVNIDs(pods []podstate.Pod) []uint {
+	seen := map[uint]string{}
+	for _, pod := range pods {
+		if pod.VNID != 0 {
+			seen[pod.VNID] = ""
+		}
+	}
+	return sortedVNIDs(seen)
+}
+
+// PublishNodeVNIDs records the VNIDs of pods, the pods of node, in the
+// registry for the other nodes to send multicast traffic to, unless the
+// registry already has them.
+func PublishNodeVNIDs(registry api.SubnetRegistry, node string, pods []podstate.Pod) error {
+	vnids := LocalVNIDs(pods)
+	all, err := registry.GetNodeVNIDs()
+	if err != nil {
+		return err
+	}
+	for _, n := range all {
+		if n.Node == node && sameVNIDs(n.VNIDs, vnids) {
+			return nil
+		}
+	}
+	return registry.WriteNodeVNIDs(api.NodeVNIDs{Node: node, VNIDs: vnids})
+}
+
+func sameVNIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package multitenant
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+)
+
+func TestMulticast(t *testing.T) {
+	c := NewFlowController(nil)
+	c.EnableServiceLoadBalancing()
+	c.SetServices(testServices[:1])
+	// team and shop share VNID 10; multicast of default and of unknown
+	// namespaces is ignored
+	c.SetPolicies(&Policies{
+		Multicast: []string{"default", "nobody", "shop", "bank"},
+		VNIDs:     map[string]uint{"default": 0, "team": 10, "shop": 10, "bank": 12, "shelf": 13},
+	})
+	c.SetNodeVNIDs([]api.NodeVNIDs{
+		{Node: "172.17.0.2", VNIDs: []uint{10, 13}},
+		{Node: "172.17.0.3", VNIDs: []uint{10, 12}},
+		{Node: "172.17.0.4", VNIDs: []uint{10}},
+		// left the cluster
+		{Node: "172.17.0.9", VNIDs: []uint{10}},
+	})
+	subnets := []api.Subnet{
+		{Minion: "172.17.0.2", Sub: "10.1.2.0/24"},
+		{Minion: "172.17.0.3", Sub: "10.1.3.0/24"},
+		{Minion: "172.17.0.4", Sub: "10.1.4.0/24"},
+	}
+	pods := []podstate.Pod{
+		{Namespace: "shop", Name: "b", Ofport: 5, IP: "10.1.2.5", VNID: 10},
+		{Namespace: "team", Name: "a", Ofport: 3, IP: "10.1.2.3", VNID: 10},
+		{Namespace: "shelf", Name: "c", Ofport: 4, IP: "10.1.2.4", VNID: 13},
+	}
+
+	// the service ports hold groups 1 and 2
+	expectedFlows := []string{
+		"table=12,cookie=0x40000000000000a,priority=100,ip,reg0=10,actions=move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23],group:3",
+		"table=12,cookie=0x40000000000000c,priority=100,ip,reg0=12,actions=move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23],group:4",
+	}
+	multicast, _ := c.currentMulticast()
+	flows := []string{}
+	for _, flow := range multicastFlows(multicast, api.DefaultTunnel) {
+		flows = append(flows, flow.String())
+	}
+	if !reflect.DeepEqual(flows, expectedFlows) {
+		t.Errorf("Wrong multicast flows.\nExpected %q\nGot      %q", expectedFlows, flows)
+	}
+
+	expectedGroups := []string{
+		"group_id=1,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.2.2:8080),table=4),bucket=actions=ct(commit,zone=1,nat(dst=10.1.3.2:8080),table=4)",
+		"group_id=2,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.3.3:53),table=4)",
+		"group_id=3,type=all,bucket=actions=output:3,bucket=actions=output:5,bucket=actions=set_field:172.17.0.3->tun_dst,output:1,bucket=actions=set_field:172.17.0.4->tun_dst,output:1",
+		"group_id=4,type=all,bucket=actions=set_field:172.17.0.3->tun_dst,output:1",
+	}
+	groups := []string{}
+	for _, group := range c.DesiredGroups(subnets, pods, "172.17.0.2") {
+		groups = append(groups, group.String())
+	}
+	if !reflect.DeepEqual(groups, expectedGroups) {
+		t.Errorf("Wrong groups.\nExpected %q\nGot      %q", expectedGroups, groups)
+	}
+
+	// bank disables multicast and shelf enables it: VNID 10 keeps its group
+	// and VNID 13 takes the freed one
+	c.SetPolicies(&Policies{
+		Multicast: []string{"team", "shelf"},
+		VNIDs:     map[string]uint{"default": 0, "team": 10, "shop": 10, "bank": 12, "shelf": 13},
+	})
+	multicast, _ = c.currentMulticast()
+	if expected := map[uint]uint32{10: 3, 13: 4}; !reflect.DeepEqual(multicast, expected) {
+		t.Errorf("Wrong multicast groups after change.\nExpected %v\nGot      %v", expected, multicast)
+	}
+}
+
+func TestLocalVNIDs(t *testing.T) {
+	pods := []podstate.Pod{{VNID: 12}, {VNID: 0}, {VNID: 10}, {VNID: 12}}
+	if vnids := LocalVNIDs(pods); !reflect.DeepEqual(vnids, []uint{10, 12}) {
+		t.Errorf("Wrong local VNIDs %v", vnids)
+	}
+}
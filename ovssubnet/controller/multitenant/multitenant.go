@@ -1,57 +1,342 @@
 package multitenant
 
 import (
-	"encoding/hex"
 	"fmt"
 	log "github.com/golang/glog"
 	"net"
-	"os/exec"
+	"sort"
 	"strconv"
-	"syscall"
+	"sync"
 
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
 	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
 )
 
 type FlowController struct {
+	executor exec.Interface
+	tunnel   api.Tunnel
+	// serviceNetwork is the network of the service IPs, see
+	// SetServiceNetwork
+	serviceNetwork string
+
+	// the policies to enforce, see SetPolicies
+	policyLock sync.Mutex
+	policies   *Policies
+	// the multicast group of each VNID with multicast and the VNIDs of
+	// the pods of every node, see SetPolicies and SetNodeVNIDs
+	multicastGroups map[uint]uint32
+	nodeVNIDs       []api.NodeVNIDs
+
+	// the ids of the groups of services and multicast
+	groupIDs groupIDs
+
+	egressIPHost egressIPHost
+
+	// the services to load-balance and the group of each of their ports,
+	// see EnableServiceLoadBalancing and SetServices
+	serviceLock     sync.Mutex
+	servicesEnabled bool
+	services        []api.Service
+	serviceGroups   map[string]uint32
+
+	// br0, which the flows of nodes are added to and deleted from over
+	// OpenFlow
+	bridge *openflow.Bridge
+}
+
+func NewFlowController(executor exec.Interface) *FlowController {
+	return &FlowController{
+		executor: executor,
+		tunnel:   api.DefaultTunnel,
+		policies: &Policies{},
+		bridge:   openflow.NewOVSBridge("br0"),
+	}
 }
 
-func NewFlowController() *FlowController {
-	return &FlowController{}
+// SetTunnel sets the encapsulation used to reach other nodes.
+func (c *FlowController) SetTunnel(tunnel api.Tunnel) {
+	c.tunnel = tunnel
 }
 
-func (c *FlowController) Setup(localSubnet, containerNetwork string) error {
+func (c *FlowController) Setup(localSubnet, containerNetwork string, mtu uint) error {
 	_, ipnet, err := net.ParseCIDR(localSubnet)
-	subnetMaskLength, _ := ipnet.Mask.Size()
-	gateway := netutils.GenerateDefaultGateway(ipnet).String()
-	out, err := exec.Command("openshift-sdn-multitenant-setup.sh", gateway, ipnet.String(), containerNetwork, strconv.Itoa(subnetMaskLength), gateway).CombinedOutput()
-	log.Infof("Output of setup script:\n%s", out)
 	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if ok {
-			status := exitErr.ProcessState.Sys().(syscall.WaitStatus)
-			if status.Exited() && status.ExitStatus() == 140 {
-				// valid, do nothing, its just a benevolent restart
-				return nil
-			}
-		}
-		log.Errorf("Error executing setup script. \n\tOutput: %s\n\tError: %v\n", out, err)
+		return err
+	}
+	unlock, err := nodesetup.Lock(nodesetup.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	node, err := nodesetup.NewNode(c.executor)
+	if err != nil {
+		return err
+	}
+	defer node.Close()
+	return nodesetup.Run(setupSteps(node, ipnet, containerNetwork, c.serviceNetwork, c.tunnel, mtu))
+}
+
+// SetServiceNetwork sets the network of the service IPs, which br0 hands to
+// the host through tun0 without applying egress network policies or egress
+// IPs. It must be called before Setup.
+func (c *FlowController) SetServiceNetwork(serviceNetwork string) {
+	c.serviceNetwork = serviceNetwork
+}
+
+// setupSteps returns the node setup: pods on lbr0 reach br0 through the
+// vlinuxbr/vovsbr veth pair, other nodes through the tunnel port and the host
+// through tun0, which carries the subnet gateway.
+func setupSteps(node *nodesetup.Node, ipnet *net.IPNet, containerNetwork, serviceNetwork string, tunnel api.Tunnel, mtu uint) []nodesetup.Step {
+	ones, _ := ipnet.Mask.Size()
+	gatewayIP := netutils.GenerateDefaultGateway(ipnet)
+	gateway := gatewayIP.String()
+	gatewayCIDR := fmt.Sprintf("%s/%d", gateway, ones)
+	return []nodesetup.Step{
+		node.OVSBridge("br0"),
+		node.TunnelPort("br0", tunnel, 1),
+		node.OVSPort("br0", "tun0", &ovsdb.Interface{Type: "internal", OfportRequest: 2}),
+		nodesetup.VethPair("vlinuxbr", "vovsbr"),
+		nodesetup.MTU("vlinuxbr", mtu),
+		nodesetup.MTU("vovsbr", mtu),
+		node.OVSPort("br0", "vovsbr", &ovsdb.Interface{OfportRequest: 9}),
+		node.Flows("br0", baseFlows(gateway, ipnet.String(), containerNetwork, serviceNetwork, tunnel)),
+		nodesetup.LinuxBridge("lbr0"),
+		nodesetup.Address("lbr0", gatewayCIDR),
+		nodesetup.BridgePort("lbr0", "vlinuxbr"),
+		nodesetup.MTU("lbr0", mtu),
+		nodesetup.Address("tun0", gatewayCIDR),
+		nodesetup.MTU("tun0", mtu),
+		// br0 answers ARP for the gateway with this address
+		nodesetup.HardwareAddr("tun0", netutils.GenerateMAC(gatewayIP)),
+		nodesetup.Route(containerNetwork, "tun0", ""),
+		node.IPTables(nodesetup.IPTablesRule{Table: "nat", Chain: "POSTROUTING", Args: []string{"-s", containerNetwork, "!", "-d", containerNetwork, "-j", "MASQUERADE"}}),
+		node.TunnelIPTables(tunnel),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "INPUT", Args: []string{"-i", "tun0", "-m", "comment", "--comment", "traffic from docker for internet", "-j", "ACCEPT"}, Before: "RELATED,ESTABLISHED"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-d", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-s", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.DockerNetwork(nodesetup.DockerNetworkFile, mtu),
+		// keep lbr0 traffic out of iptables; on kernels 3.18+ the sysctl
+		// comes with br_netfilter
+		node.Sysctl("net/bridge/bridge-nf-call-iptables", "0", "br_netfilter"),
+		// pods reach the local subnet through br0, not lbr0
+		nodesetup.NoRoute(ipnet.String(), "lbr0"),
+	}
+}
+
+// baseFlows returns the flows br0 needs before any node or pod is added.
+//
+//	table 0: learn MAC addresses and continue with table 1
+//	table 1: initial dispatch by input port
+//	table 2: incoming from the tunnel; traffic to the egress IPs the node
+//	         owns is filled in from the egress IPs of namespaces
+//	table 3: incoming from a container; filled in by the pod hook
+//	table 4: general routing; traffic to the service network and to the
+//	         IPs of nodes, added by AddOFRules, goes straight to tun0
+//	         rather than through the egress tables
+//	table 5: network policy for traffic to a local container; filled in by
+//	         the pod hook from the policies of the container's namespace
+//	table 6: to a remote container; filled in by AddOFRules
+//	table 7: MAC dispatch and ARP; filled in by table 0's learn() and by
+//	         AddOFRules, which sends ARP for remote subnets to their nodes;
+//	         br0 answers ARP requests for the gateway and for local pods
+//	         (filled in by the pod hook) itself, sends other ARP for the
+//	         local subnet to the containers on lbr0 and drops the rest
+//	table 8: to a local container; filled in by the pod hook
+//	table 9: egress network policy for traffic leaving the cluster network
+//	         through tun0; filled in from the policies of namespaces
+//	table 10: egress IP, sending traffic leaving the cluster network through
+//	         the node owning the egress IP of its namespace; filled in from
+//	         the egress IPs of namespaces
+//	table 11: conntrack of service load balancing, which also adds flows to
+//	         tables 4 and 5; see serviceFlows
+//	table 12: multicast, replicating the multicast traffic of a VNID to its
+//	         pods on this and other nodes; filled in from the namespaces
+//	         with multicast enabled
+func baseFlows(gateway, subnet, containerNetwork, serviceNetwork string, tunnel api.Tunnel) []*ofctl.Flow {
+	key, reg := vnidFields(tunnel)
+	flows := []*ofctl.Flow{
+		{Table: 0, Priority: ofctl.DefaultPriority, Actions: []ofctl.Action{
+			{Name: "learn", Arg: "table=7,priority=200,hard_timeout=900,NXM_OF_ETH_DST[]=NXM_OF_ETH_SRC[],load:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],output:NXM_OF_IN_PORT[]"},
+			ofctl.GotoTable(1),
+		}},
+
+		{Table: 1, Priority: ofctl.DefaultPriority, Match: []ofctl.Field{ofctl.ARP}, Actions: []ofctl.Action{ofctl.GotoTable(7)}},
+		{Table: 1, Priority: ofctl.DefaultPriority, Match: []ofctl.Field{ofctl.Eq("in_port", "1")}, Actions: []ofctl.Action{ofctl.GotoTable(2)}}, // tunnel
+		{Table: 1, Priority: ofctl.DefaultPriority, Match: []ofctl.Field{ofctl.Eq("in_port", "2")}, Actions: []ofctl.Action{ofctl.GotoTable(4)}}, // tun0
+		{Table: 1, Priority: ofctl.DefaultPriority, Match: []ofctl.Field{ofctl.Eq("in_port", "9")}, Actions: []ofctl.Action{ofctl.GotoTable(4)}}, // vovsbr
+		{Table: 1, Priority: ofctl.DefaultPriority, Actions: []ofctl.Action{ofctl.GotoTable(3)}},                                                 // container
+
+		{Table: 2, Priority: ofctl.DefaultPriority, Match: []ofctl.Field{ofctl.ARP}, Actions: []ofctl.Action{ofctl.GotoTable(7)}},
+		{Table: 2, Priority: 200, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", gateway)}, Actions: []ofctl.Action{ofctl.Output(2)}},
+		{Table: 2, Priority: ofctl.DefaultPriority, Match: []ofctl.Field{ofctl.Eq("tun_id", "0")}, Actions: []ofctl.Action{ofctl.GotoTable(4)}},
+		{Table: 2, Priority: 100, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", subnet)}, Actions: []ofctl.Action{ofctl.Move(key, reg), ofctl.GotoTable(5)}},
+		{Table: 2, Priority: 150, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", multicastNetwork)}, Actions: []ofctl.Action{ofctl.Move(key, reg), ofctl.GotoTable(12)}},
+
+		{Table: 4, Priority: 200, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", gateway)}, Actions: []ofctl.Action{ofctl.Output(2)}},
+		{Table: 4, Priority: 150, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", subnet)}, Actions: []ofctl.Action{ofctl.GotoTable(5)}},
+		{Table: 4, Priority: 100, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", containerNetwork)}, Actions: []ofctl.Action{ofctl.GotoTable(6)}},
+		{Table: 4, Priority: 250, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", multicastNetwork)}, Actions: []ofctl.Action{ofctl.GotoTable(12)}},
+		{Table: 4, Priority: 0, Match: []ofctl.Field{ofctl.IP}, Actions: []ofctl.Action{ofctl.GotoTable(9)}},
+
+		{Table: 5, Priority: 200, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("reg0", "0")}, Actions: []ofctl.Action{ofctl.GotoTable(7)}},
+		{Table: 5, Priority: 0, Match: []ofctl.Field{ofctl.IP}, Actions: []ofctl.Action{ofctl.GotoTable(8)}},
+
+		{Table: 7, Priority: 300, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("arp_tpa", gateway)}, Actions: ofctl.ARPReply(gateway, gatewayMAC(gateway))},
+		{Table: 7, Priority: 100, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("nw_dst", subnet)}, Actions: []ofctl.Action{ofctl.Output(9)}}, // vovsbr
+		{Table: 7, Priority: 0, Match: []ofctl.Field{ofctl.ARP}},
+
+		{Table: 9, Priority: 0, Match: []ofctl.Field{ofctl.IP}, Actions: []ofctl.Action{ofctl.GotoTable(10)}},
+
+		{Table: 10, Priority: 0, Match: []ofctl.Field{ofctl.IP}, Actions: []ofctl.Action{ofctl.Output(2)}},
+
+		{Table: 12, Priority: 0, Match: []ofctl.Field{ofctl.IP}},
+	}
+	if serviceNetwork != "" {
+		flows = append(flows, &ofctl.Flow{Table: 4, Priority: 50, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", serviceNetwork)}, Actions: []ofctl.Action{ofctl.Output(2)}})
+	}
+	for _, f := range flows {
+		f.Cookie = uint64(cookie.New(cookie.System, 0))
 	}
-	return err
+	return flows
+}
+
+// gatewayMAC returns the MAC address node setup gives tun0, which carries
+// gateway.
+func gatewayMAC(gateway string) string {
+	return netutils.GenerateMAC(net.ParseIP(gateway)).String()
+}
+
+// vnidFields returns the bits of the tunnel key and of reg0 that carry the
+// VNID, which is as wide as the key of the tunnel type.
+func vnidFields(tunnel api.Tunnel) (key, reg string) {
+	bits := tunnel.KeyBits()
+	key = fmt.Sprintf("NXM_NX_TUN_ID[0..%d]", bits-1)
+	reg = fmt.Sprintf("NXM_NX_REG0[0..%d]", bits-1)
+	if bits == 32 {
+		reg = "NXM_NX_REG0[]"
+	}
+	return key, reg
 }
 
 func (c *FlowController) AddOFRules(minionIP, subnet, localIP string) error {
+	if err := c.bridge.AddFlows(nodeFlows(minionIP, subnet, localIP, c.tunnel)); err != nil {
+		return fmt.Errorf("Failed to add the flows of node %s: %v", minionIP, err)
+	}
+	log.Infof("Added the flows of node %s", minionIP)
+	return nil
+}
+
+// nodeFlows returns the flows that tunnel traffic for minionIP's subnet to it,
+// carrying the VNID of the sender in the tunnel key, and that send traffic
+// to the node's own IP to the host, which routes it, past the egress tables.
+// The local subnet is handled by the setup flows.
+func nodeFlows(minionIP, subnet, localIP string, tunnel api.Tunnel) []*ofctl.Flow {
+	owner := uint64(cookie.ForNode(minionIP))
+	host := &ofctl.Flow{Table: 4, Cookie: owner, Priority: 50, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", minionIP)}, Actions: []ofctl.Action{ofctl.Output(2)}}
 	if minionIP == localIP {
-		return nil
+		return []*ofctl.Flow{host}
 	}
 
-	cookie := generateCookie(minionIP)
-	iprule := fmt.Sprintf("table=6,cookie=0x%s,priority=100,ip,nw_dst=%s,actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:%s->tun_dst,output:1", cookie, subnet, minionIP)
-	arprule := fmt.Sprintf("table=7,cookie=0x%s,priority=100,arp,nw_dst=%s,actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:%s->tun_dst,output:1", cookie, subnet, minionIP)
-	o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", iprule).CombinedOutput()
-	log.Infof("Output of adding %s: %s (%v)", iprule, o, e)
-	o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", arprule).CombinedOutput()
-	log.Infof("Output of adding %s: %s (%v)", arprule, o, e)
-	return e
+	key, reg := vnidFields(tunnel)
+	actions := []ofctl.Action{
+		ofctl.Move(reg, key),
+		ofctl.SetField(minionIP, "tun_dst"),
+		ofctl.Output(1),
+	}
+	return []*ofctl.Flow{
+		host,
+		{Table: 6, Cookie: owner, Priority: 100, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", subnet)}, Actions: actions},
+		{Table: 7, Cookie: owner, Priority: 100, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("nw_dst", subnet)}, Actions: actions},
+	}
+}
+
+// podTables are the tables with flows owned by pods.
+var podTables = []int{3, 5, 7, 8}
+
+// podFlows returns the flows the multitenant hook installs for a local pod:
+// tagging its traffic with its VNID in table 3, admitting what the policies
+// of its namespace allow in table 5, answering ARP requests for its IP in
+// table 7 and delivering traffic of the same VNID to it in table 8. VNID 0 is
+// global and reaches every pod.
+func podFlows(pod *podstate.Pod, policies []api.NetworkPolicy, vnids map[string]uint) []*ofctl.Flow {
+	owner := uint64(cookie.ForPod(pod.Ofport))
+	ingress := &ofctl.Flow{
+		Table:    3,
+		Cookie:   owner,
+		Priority: 100,
+		Match:    []ofctl.Field{ofctl.Eq("in_port", strconv.Itoa(pod.Ofport)), ofctl.IP, ofctl.Eq("nw_src", pod.IP)},
+		Actions:  []ofctl.Action{ofctl.Load(uint64(pod.VNID), "NXM_NX_REG0[]"), ofctl.GotoTable(4)},
+	}
+	egress := &ofctl.Flow{
+		Table:    8,
+		Cookie:   owner,
+		Priority: 150,
+		Match:    []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", pod.IP)},
+		Actions:  []ofctl.Action{ofctl.Output(pod.Ofport)},
+	}
+	if pod.VNID != 0 {
+		egress.Priority = 100
+		egress.Match = append(egress.Match, ofctl.Eq("reg0", strconv.FormatUint(uint64(pod.VNID), 10)))
+	}
+	flows := []*ofctl.Flow{ingress}
+	flows = append(flows, policyFlows(pod, policies, vnids)...)
+	if mac := pod.MACAddress(); mac != "" {
+		flows = append(flows, &ofctl.Flow{
+			Table:    7,
+			Cookie:   owner,
+			Priority: 300,
+			Match:    []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("arp_tpa", pod.IP)},
+			Actions:  ofctl.ARPReply(pod.IP, mac),
+		})
+	}
+	return append(flows, egress)
+}
+
+// DesiredFlows returns the node flows for every remote subnet in the cluster,
+// the flows of every local pod under the current network policies, the
+// egress policy, egress IP and multicast flows of namespaces and the flows of
+// the services if they are load-balanced in br0.
+func (c *FlowController) DesiredFlows(subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Flow {
+	policies := c.currentPolicies()
+	flows := []*ofctl.Flow{}
+	for _, s := range subnets {
+		flows = append(flows, nodeFlows(s.Minion, s.Sub, localIP, c.tunnel)...)
+	}
+	for i := range pods {
+		flows = append(flows, podFlows(&pods[i], policies.Network, policies.VNIDs)...)
+	}
+	flows = append(flows, egressFlows(policies.Egress, policies.VNIDs)...)
+	flows = append(flows, egressIPFlows(policies.EgressIPs, policies.VNIDs, localIP, c.tunnel)...)
+	multicast, _ := c.currentMulticast()
+	flows = append(flows, multicastFlows(multicast, c.tunnel)...)
+	if services, groups := c.currentServices(); groups != nil {
+		localSubnet := ""
+		for _, s := range subnets {
+			if s.Minion == localIP {
+				localSubnet = s.Sub
+			}
+		}
+		flows = append(flows, serviceFlows(services, groups, localSubnet)...)
+	}
+	return flows
+}
+
+// DesiredGroups returns the groups br0 should have for the given cluster
+// subnets and local pods, those of the VNIDs with multicast and of the
+// services if they are load-balanced in br0, in the order of their ids.
+func (c *FlowController) DesiredGroups(subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Group {
+	multicast, nodes := c.currentMulticast()
+	groups := multicastGroups(multicast, nodes, subnets, pods, localIP)
+	groups = append(groups, c.desiredServiceGroups()...)
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups
 }
 
 func (c *FlowController) DelOFRules(minion, localIP string) error {
@@ -59,17 +344,15 @@ func (c *FlowController) DelOFRules(minion, localIP string) error {
 		return nil
 	}
 
-	log.Infof("Calling del rules for %s", minion)
-	cookie := generateCookie(minion)
-	iprule := fmt.Sprintf("table=6,cookie=0x%s/0xffffffff", cookie)
-	arprule := fmt.Sprintf("table=7,cookie=0x%s/0xffffffff", cookie)
-	o, e := exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", iprule).CombinedOutput()
-	log.Infof("Output of deleting local ip rules %s (%v)", o, e)
-	o, e = exec.Command("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", arprule).CombinedOutput()
-	log.Infof("Output of deleting local arp rules %s (%v)", o, e)
-	return e
-}
-
-func generateCookie(ip string) string {
-	return hex.EncodeToString(net.ParseIP(ip).To4())
+	owner := uint64(cookie.ForNode(minion))
+	mods := []openflow.Message{}
+	// the host, IP and ARP flows of the node
+	for _, table := range []uint8{4, 6, 7} {
+		mods = append(mods, openflow.NewFlowDelete(table, owner, cookie.OwnerMask, nil))
+	}
+	if err := c.bridge.Transact(mods...); err != nil {
+		return fmt.Errorf("Failed to delete the flows of node %s: %v", minion, err)
+	}
+	log.Infof("Deleted the flows of node %s", minion)
+	return nil
 }
@@ -0,0 +1,216 @@
+package multitenant
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow/fakeswitch"
+)
+
+const ofctlCmd = "ovs-ofctl -O OpenFlow13 "
+
+func TestSetupSteps(t *testing.T) {
+	expected := []string{
+		"OVS bridge br0",
+		"OVS port vxlan0 on br0",
+		"OVS port tun0 on br0",
+		"veth pair vlinuxbr/vovsbr",
+		"MTU 1450 on vlinuxbr",
+		"MTU 1450 on vovsbr",
+		"OVS port vovsbr on br0",
+		"base flows on br0",
+		"Linux bridge lbr0",
+		"address 10.1.2.1/24 on lbr0",
+		"port vlinuxbr on lbr0",
+		"MTU 1450 on lbr0",
+		"address 10.1.2.1/24 on tun0",
+		"MTU 1450 on tun0",
+		"MAC address 02:42:0a:01:02:01 on tun0",
+		"route 10.1.0.0/16 dev tun0",
+		"iptables rule -t nat POSTROUTING -s 10.1.0.0/16 ! -d 10.1.0.0/16 -j MASQUERADE",
+		"iptables rule -t filter INPUT -p udp -m multiport --dports 4789 -m comment --comment 001 vxlan incoming -j ACCEPT",
+		"iptables rule -t filter INPUT -i tun0 -m comment --comment traffic from docker for internet -j ACCEPT",
+		"iptables rule -t filter FORWARD -d 10.1.0.0/16 -j ACCEPT",
+		"iptables rule -t filter FORWARD -s 10.1.0.0/16 -j ACCEPT",
+		"docker network options",
+		"sysctl net.bridge.bridge-nf-call-iptables=0",
+		"no route 10.1.2.0/24 dev lbr0",
+	}
+	_, ipnet, _ := net.ParseCIDR("10.1.2.0/24")
+	names := []string{}
+	for _, step := range setupSteps(&nodesetup.Node{}, ipnet, "10.1.0.0/16", "172.30.0.0/16", api.DefaultTunnel, 1450) {
+		names = append(names, step.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Wrong setup steps.\nExpected %q\nGot      %q", expected, names)
+	}
+}
+
+func TestBaseFlows(t *testing.T) {
+	// the flows openshift-sdn-multitenant-setup.sh used to add, with the VNID
+	// narrowed to the 24 bits of a VXLAN key, a system cookie, the policy
+	// table passing on to local delivery and traffic leaving the cluster
+	// passing the egress and egress IP tables and multicast going to the
+	// multicast table, br0 answering ARP for the gateway instead of
+	// flooding it, other ARP for the local subnet going to lbr0 and the
+	// service network skipping the egress tables
+	expected := []string{
+		"table=0, actions=learn(table=7, priority=200, hard_timeout=900, NXM_OF_ETH_DST[]=NXM_OF_ETH_SRC[], load:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[], output:NXM_OF_IN_PORT[]), goto_table:1",
+		"table=1, arp, actions=goto_table:7",
+		"table=1, in_port=1, actions=goto_table:2",
+		"table=1, in_port=2, actions=goto_table:4",
+		"table=1, in_port=9, actions=goto_table:4",
+		"table=1, actions=goto_table:3",
+		"table=2, arp, actions=goto_table:7",
+		"table=2, priority=200, ip, nw_dst=10.1.2.1, actions=output:2",
+		"table=2, tun_id=0, actions=goto_table:4",
+		"table=2, priority=100, ip, nw_dst=10.1.2.0/24, actions=move:NXM_NX_TUN_ID[0..23]->NXM_NX_REG0[0..23], goto_table:5",
+		"table=2, priority=150, ip, nw_dst=224.0.0.0/4, actions=move:NXM_NX_TUN_ID[0..23]->NXM_NX_REG0[0..23], goto_table:12",
+		"table=4, priority=200, ip, nw_dst=10.1.2.1, actions=output:2",
+		"table=4, priority=150, ip, nw_dst=10.1.2.0/24, actions=goto_table:5",
+		"table=4, priority=100, ip, nw_dst=10.1.0.0/16, actions=goto_table:6",
+		"table=4, priority=250, ip, nw_dst=224.0.0.0/4, actions=goto_table:12",
+		"table=4, priority=0, ip, actions=goto_table:9",
+		"table=5, priority=200, ip, reg0=0, actions=goto_table:7",
+		"table=5, priority=0, ip, actions=goto_table:8",
+		"table=7, priority=300, arp, arp_op=1, arp_tpa=10.1.2.1, actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:02:42:0a:01:02:01->eth_src,set_field:2->arp_op,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],set_field:02:42:0a:01:02:01->arp_sha,move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],set_field:10.1.2.1->arp_spa,move:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],IN_PORT",
+		"table=7, priority=100, arp, nw_dst=10.1.2.0/24, actions=output:9",
+		"table=7, priority=0, arp, actions=drop",
+		"table=9, priority=0, ip, actions=goto_table:10",
+		"table=10, priority=0, ip, actions=output:2",
+		"table=12, priority=0, ip, actions=drop",
+		"table=4, priority=50, ip, nw_dst=172.30.0.0/16, actions=output:2",
+	}
+	flows := baseFlows("10.1.2.1", "10.1.2.0/24", "10.1.0.0/16", "172.30.0.0/16", api.DefaultTunnel)
+	if len(flows) != len(expected) {
+		t.Fatalf("Expected %d flows, got %d", len(expected), len(flows))
+	}
+	for i, s := range expected {
+		flow, err := ofctl.ParseFlow(strings.Replace(s, " ", "", -1))
+		if err != nil {
+			t.Fatalf("Error parsing %s: %v", s, err)
+		}
+		flow.Cookie = uint64(cookie.New(cookie.System, 0))
+		if !flow.Equal(flows[i]) {
+			t.Errorf("Wrong flow %d.\nExpected %s\nGot      %s", i, flow, flows[i])
+		}
+	}
+}
+
+// nodeFlowDump are the flows of node 172.17.0.3 with subnet 10.1.2.0/24 as
+// the fake switch prints them, moving the VNID with move
+func nodeFlowDump(move string) []string {
+	return []string{
+		"cookie=0x2000000ac110003,table=4,priority=50,eth_type=0x0800,nw_dst=172.17.0.3 actions=output:2",
+		"cookie=0x2000000ac110003,table=6,priority=100,eth_type=0x0800,nw_dst=10.1.2.0/24 actions=" + move + ",set_field:tun_dst=172.17.0.3,output:1",
+		"cookie=0x2000000ac110003,table=7,priority=100,eth_type=0x0806,arp_tpa=10.1.2.0/24 actions=" + move + ",set_field:tun_dst=172.17.0.3,output:1",
+	}
+}
+
+func TestAddOFRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		minionIP string
+		tunnel   api.Tunnel
+		reject   func(*openflow.FlowMod) *openflow.Error
+		flows    []string
+		fail     bool
+	}{
+		{
+			name:     "local node",
+			minionIP: "172.17.0.2",
+			flows: []string{
+				"cookie=0x2000000ac110002,table=4,priority=50,eth_type=0x0800,nw_dst=172.17.0.2 actions=output:2",
+			},
+		},
+		{
+			name:     "remote node",
+			minionIP: "172.17.0.3",
+			flows:    nodeFlowDump("move:reg0[0..23]->tun_id[0..23]"),
+		},
+		{
+			name:     "remote node over GRE",
+			minionIP: "172.17.0.3",
+			tunnel:   api.Tunnel{Type: api.TunnelGRE},
+			flows:    nodeFlowDump("move:reg0[0..31]->tun_id[0..31]"),
+		},
+		{
+			name:     "flow rejected",
+			minionIP: "172.17.0.3",
+			reject: func(f *openflow.FlowMod) *openflow.Error {
+				if f.TableID == 7 {
+					return &openflow.Error{Type: openflow.ErrFlowModFailed, Code: openflow.FlowModTableFull}
+				}
+				return nil
+			},
+			flows: nodeFlowDump("move:reg0[0..23]->tun_id[0..23]")[:2],
+			fail:  true,
+		},
+	}
+
+	for _, test := range tests {
+		sw := fakeswitch.New()
+		sw.RejectFlows(test.reject)
+		executor := exec.NewFake()
+		c := NewFlowController(executor)
+		c.bridge = openflow.NewBridge(sw.Dial)
+		if test.tunnel.Type != "" {
+			c.SetTunnel(test.tunnel)
+		}
+		err := c.AddOFRules(test.minionIP, "10.1.2.0/24", "172.17.0.2")
+		if (err != nil) != test.fail {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+		if flows := sw.DumpFlows(); !reflect.DeepEqual(flows, test.flows) {
+			t.Errorf("%s: wrong flows.\nExpected %q\nGot      %q", test.name, test.flows, flows)
+		}
+		if len(executor.CommandLines()) != 0 {
+			t.Errorf("%s: unexpected commands %q", test.name, executor.CommandLines())
+		}
+	}
+}
+
+func TestDelOFRules(t *testing.T) {
+	local := "cookie=0x2000000ac110002,table=4,priority=50,eth_type=0x0800,nw_dst=172.17.0.2 actions=output:2"
+	tests := []struct {
+		name     string
+		minionIP string
+		flows    []string
+	}{
+		{
+			name:     "local node",
+			minionIP: "172.17.0.2",
+			flows:    append([]string{local}, nodeFlowDump("move:reg0[0..23]->tun_id[0..23]")...),
+		},
+		{
+			name:     "remote node",
+			minionIP: "172.17.0.3",
+			flows:    []string{local},
+		},
+	}
+
+	for _, test := range tests {
+		sw := fakeswitch.New()
+		c := NewFlowController(exec.NewFake())
+		c.bridge = openflow.NewBridge(sw.Dial)
+		for _, node := range []string{"172.17.0.2", "172.17.0.3"} {
+			if err := c.AddOFRules(node, "10.1.2.0/24", "172.17.0.2"); err != nil {
+				t.Fatalf("%s: error adding flows: %v", test.name, err)
+			}
+		}
+		if err := c.DelOFRules(test.minionIP, "172.17.0.2"); err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if flows := sw.DumpFlows(); !reflect.DeepEqual(flows, test.flows) {
+			t.Errorf("%s: wrong flows.\nExpected %q\nGot      %q", test.name, test.flows, flows)
+		}
+	}
+}
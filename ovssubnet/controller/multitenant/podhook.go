@@ -0,0 +1,560 @@
+package multitenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+	"github.com/openshift/openshift-sdn/pkg/netns"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
+)
+
+const (
+	// DefaultDockerSocket is where the docker daemon serves its API.
+	DefaultDockerSocket = "/var/run/docker.sock"
+	// how long to wait for ovs-vswitchd to give a new port a number
+	ofportTimeout = 5 * time.Second
+)
+
+// Bridge is the part of the OVSDB client the pod hook uses.
+type Bridge interface {
+	AddPort(bridge, port string, iface *ovsdb.Interface) error
+	DeletePort(bridge, port string) error
+	GetOfport(iface string) (int, error)
+	SetIngressPolicing(iface string, rate, burst int) error
+	SetPortQoS(port string, rate uint64) error
+	ClearPortQoS(port string) error
+}
+
+// container is what the pod hook needs to know about a pod's network
+// container.
+type container struct {
+	Pid         int
+	NetworkMode string
+	IP          string
+	MAC         string
+}
+
+// PodHook attaches pods to br0 and detaches them again. The kubelet runs it
+// through the openshift-ovs-multitenant network plugin, once per pod.
+type PodHook struct {
+	Registry     api.SubnetRegistry
+	Executor     exec.Interface
+	Bridge       Bridge
+	PodStateDir  string
+	DockerSocket string
+	LockFile     string
+
+	// operations on docker and on the network namespaces of the host and
+	// the container, replaced in tests
+	inspect     func(containerID string) (*container, error)
+	hostVeth    func(pid int) (string, error)
+	detachVeth  func(veth string) error
+	matchMTU    func(pid int, veth string) error
+	addPodRoute func(pid int, network, ip string) error
+}
+
+// NewPodHook returns a pod hook that talks to the local docker daemon and
+// acts on the host's network namespace.
+func NewPodHook(registry api.SubnetRegistry, executor exec.Interface, bridge Bridge) *PodHook {
+	h := &PodHook{
+		Registry:     registry,
+		Executor:     executor,
+		Bridge:       bridge,
+		PodStateDir:  podstate.DefaultDir,
+		DockerSocket: DefaultDockerSocket,
+		LockFile:     nodesetup.LockFile,
+		hostVeth:     hostVeth,
+		detachVeth:   detachVeth,
+		matchMTU:     matchBridgeMTU,
+		addPodRoute:  addPodRoute,
+	}
+	h.inspect = h.inspectContainer
+	return h
+}
+
+// Setup attaches the pod whose network lives in containerID to br0: it moves
+// the host end of the pod's veth from lbr0 to br0, installs the flows for the
+// VNID of the pod's namespace and routes the cluster network through eth0 in
+// the pod. The pod's annotations, as the registry has them and overridden by
+// annotations, may limit its bandwidth: OVS polices the traffic from the pod
+// and shapes the traffic to it.
+func (h *PodHook) Setup(namespace, name, containerID string, annotations map[string]string) error {
+	annotations, err := h.podAnnotations(namespace, name, annotations)
+	if err != nil {
+		return err
+	}
+	ingress, egress, err := bandwidthLimits(annotations)
+	if err != nil {
+		return err
+	}
+	unlock, err := nodesetup.Lock(h.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c, err := h.inspect(containerID)
+	if err != nil {
+		return err
+	}
+	if c.NetworkMode == "host" {
+		// nothing for the SDN here
+		return nil
+	}
+	netNamespace, err := h.Registry.GetNetNamespace(namespace)
+	if err != nil {
+		return fmt.Errorf("Failed to find the VNID of namespace %s: %v", namespace, err)
+	}
+	network, err := h.Registry.GetContainerNetwork()
+	if err != nil {
+		return fmt.Errorf("Failed to get the container network: %v", err)
+	}
+
+	veth, err := h.hostVeth(c.Pid)
+	if err != nil {
+		return fmt.Errorf("Failed to find the host veth of container %s: %v", containerID, err)
+	}
+	if err := h.matchMTU(c.Pid, veth); err != nil {
+		return fmt.Errorf("Failed to set the MTU of container %s: %v", containerID, err)
+	}
+	if err := h.detachVeth(veth); err != nil {
+		return err
+	}
+	if err := h.Bridge.AddPort("br0", veth, nil); err != nil {
+		return err
+	}
+	// from here on a failure leaves no half-attached pod behind
+	ofport := 0
+	attached := false
+	defer func() {
+		if !attached {
+			h.rollback(namespace, name, veth, ofport)
+		}
+	}()
+	ofport, err = h.waitForOfport(veth)
+	if err != nil {
+		return err
+	}
+	if err := h.limitBandwidth(veth, ingress, egress); err != nil {
+		return err
+	}
+
+	pod := &podstate.Pod{
+		Namespace:   namespace,
+		Name:        name,
+		ContainerID: containerID,
+		Veth:        veth,
+		Ofport:      ofport,
+		IP:          c.IP,
+		MAC:         c.MAC,
+		VNID:        netNamespace.NetID,
+
+		IngressBandwidth: ingress,
+		EgressBandwidth:  egress,
+	}
+	policies, vnids, err := h.networkPolicies(namespace)
+	if err != nil {
+		return err
+	}
+	// record the pod before adding its flows, so that the node never takes
+	// them for strays
+	if err := podstate.Write(h.PodStateDir, pod); err != nil {
+		return fmt.Errorf("Failed to record pod %s/%s: %v", namespace, name, err)
+	}
+	for _, flow := range podFlows(pod, policies, vnids) {
+		rule := flow.String()
+		if out, err := h.Executor.Exec("ovs-ofctl", "-O", "OpenFlow13", "add-flow", "br0", rule); err != nil {
+			return fmt.Errorf("Failed to add flow %s: %v (%s)", rule, err, out)
+		}
+	}
+
+	if err := h.addPodRoute(c.Pid, network, c.IP); err != nil {
+		return fmt.Errorf("Failed to route %s in container %s: %v", network, containerID, err)
+	}
+	attached = true
+	h.publishVNIDs(pod.IP)
+	log.Infof("Attached pod %s/%s on port %d with VNID %d, ingress bandwidth %s and egress bandwidth %s",
+		namespace, name, ofport, pod.VNID, bandwidthString(ingress), bandwidthString(egress))
+	return nil
+}
+
+// Teardown removes the port and flows Setup added for the pod.
+func (h *PodHook) Teardown(namespace, name, containerID string) error {
+	unlock, err := nodesetup.Lock(h.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	pod, err := podstate.Get(h.PodStateDir, namespace, name)
+	if err != nil {
+		return err
+	}
+	if pod == nil {
+		// pods attached before the node kept records; find the port
+		// the way Setup did
+		c, err := h.inspect(containerID)
+		if err != nil {
+			return err
+		}
+		if c.NetworkMode == "host" {
+			return nil
+		}
+		veth, err := h.hostVeth(c.Pid)
+		if err != nil {
+			return fmt.Errorf("Failed to find the host veth of container %s: %v", containerID, err)
+		}
+		ofport, err := h.Bridge.GetOfport(veth)
+		if err != nil {
+			return err
+		}
+		pod = &podstate.Pod{Namespace: namespace, Name: name, ContainerID: containerID, Veth: veth, Ofport: ofport}
+	}
+
+	// the QoS rows outlive the port; leaving them behind is no reason to
+	// keep the port
+	if err := h.Bridge.ClearPortQoS(pod.Veth); err != nil {
+		log.Errorf("Failed to clear the QoS of pod %s/%s: %v", namespace, name, err)
+	}
+	if err := h.Bridge.DeletePort("br0", pod.Veth); err != nil {
+		return err
+	}
+	if err := h.deletePodFlows(pod.Ofport); err != nil {
+		return err
+	}
+	if err := podstate.Remove(h.PodStateDir, namespace, name); err != nil {
+		return fmt.Errorf("Failed to remove the record of pod %s/%s: %v", namespace, name, err)
+	}
+	h.publishVNIDs(pod.IP)
+	log.Infof("Detached pod %s/%s from port %d", namespace, name, pod.Ofport)
+	return nil
+}
+
+// podAnnotations returns the annotations of the pod in the registry, with
+// given ones taking precedence; the kubelet passes none to the hook.
+func (h *PodHook) podAnnotations(namespace, name string, given map[string]string) (map[string]string, error) {
+	annotations, err := h.Registry.GetPodAnnotations(namespace, name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the annotations of pod %s/%s: %v", namespace, name, err)
+	}
+	merged := make(map[string]string, len(annotations)+len(given))
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	for k, v := range given {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// deletePodFlows deletes the flows of the pod on ofport.
+func (h *PodHook) deletePodFlows(ofport int) error {
+	for _, table := range podTables {
+		match := fmt.Sprintf("table=%d,%s", table, cookie.ForPod(ofport).Match())
+		if out, err := h.Executor.Exec("ovs-ofctl", "-O", "OpenFlow13", "del-flows", "br0", match); err != nil {
+			return fmt.Errorf("Failed to delete flows %s: %v (%s)", match, err, out)
+		}
+	}
+	return nil
+}
+
+// rollback undoes what a failed Setup did after adding veth to br0: it
+// removes the port with its QoS, the flows of the pod if the port got
+// ofport, and the pod's record. It only logs what it cannot undo, as the
+// reconciler removes stray flows.
+func (h *PodHook) rollback(namespace, name, veth string, ofport int) {
+	log.Warningf("Failed to attach pod %s/%s, detaching it from br0", namespace, name)
+	if err := h.Bridge.ClearPortQoS(veth); err != nil {
+		log.Errorf("Failed to clear the QoS of port %s: %v", veth, err)
+	}
+	if err := h.Bridge.DeletePort("br0", veth); err != nil {
+		log.Errorf("Failed to delete port %s: %v", veth, err)
+	}
+	if ofport > 0 {
+		if err := h.deletePodFlows(ofport); err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+	if err := podstate.Remove(h.PodStateDir, namespace, name); err != nil {
+		log.Errorf("Failed to remove the record of pod %s/%s: %v", namespace, name, err)
+	}
+}
+
+// limitBandwidth limits the traffic to and from the pod on port veth to
+// ingress and egress bits per second, 0 for no limit. What the pod sends is
+// what OVS takes in from the port, so egress is policed; what the pod gets is
+// what OVS sends out of the port, so ingress is shaped.
+func (h *PodHook) limitBandwidth(veth string, ingress, egress uint64) error {
+	if egress > 0 {
+		rate := int(egress / 1000)
+		burst := rate / 10
+		if burst == 0 {
+			burst = 1
+		}
+		if err := h.Bridge.SetIngressPolicing(veth, rate, burst); err != nil {
+			return err
+		}
+	}
+	if ingress > 0 {
+		if err := h.Bridge.SetPortQoS(veth, ingress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bandwidthString describes a bandwidth limit for the logs.
+func bandwidthString(bps uint64) string {
+	if bps == 0 {
+		return "unlimited"
+	}
+	return FormatBandwidth(bps)
+}
+
+// networkPolicies returns the network policies of namespace and the VNIDs of
+// all namespaces, which the policies may admit traffic from. The node keeps
+// the flows up to date when either changes later.
+func (h *PodHook) networkPolicies(namespace string) ([]api.NetworkPolicy, map[string]uint, error) {
+	all, err := h.Registry.GetNetworkPolicies()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get the network policies: %v", err)
+	}
+	policies := []api.NetworkPolicy{}
+	for _, p := range all {
+		if p.Namespace == namespace {
+			policies = append(policies, p)
+		}
+	}
+	vnids := map[string]uint{}
+	if len(policies) == 0 {
+		return policies, vnids, nil
+	}
+	netNamespaces, err := h.Registry.GetNetNamespaces()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to get the VNIDs of namespaces: %v", err)
+	}
+	for _, ns := range netNamespaces {
+		vnids[ns.Name] = ns.NetID
+	}
+	return policies, vnids, nil
+}
+
+// publishVNIDs records the VNIDs of the node's pods in the registry, so that
+// the nodes update the multicast groups of the VNIDs. The node is the one
+// whose subnet holds podIP, the IP of a pod just set up or torn down.
+// Failures only delay multicast until the node publishes the VNIDs itself,
+// so they do not fail the hook.
+func (h *PodHook) publishVNIDs(podIP string) {
+	ip := net.ParseIP(podIP)
+	if ip == nil {
+		return
+	}
+	subnets, err := h.Registry.GetSubnets()
+	if err != nil {
+		log.Warningf("Failed to get the subnets to publish the VNIDs of this node: %v", err)
+		return
+	}
+	node := ""
+	for _, s := range *subnets {
+		if _, ipnet, err := net.ParseCIDR(s.Sub); err == nil && ipnet.Contains(ip) {
+			node = s.Minion
+		}
+	}
+	if node == "" {
+		log.Warningf("No subnet holds pod IP %s, not publishing the VNIDs of this node", podIP)
+		return
+	}
+	pods, err := podstate.List(h.PodStateDir)
+	if err != nil {
+		log.Warningf("Failed to read the pod records to publish the VNIDs of this node: %v", err)
+		return
+	}
+	netNamespaces, err := h.Registry.GetNetNamespaces()
+	if err != nil {
+		log.Warningf("Failed to get the VNIDs of namespaces to publish the VNIDs of this node: %v", err)
+		return
+	}
+	// the records keep the VNIDs pods had when they were set up
+	vnids := map[string]uint{}
+	for _, ns := range netNamespaces {
+		vnids[ns.Name] = ns.NetID
+	}
+	for i := range pods {
+		if vnid, ok := vnids[pods[i].Namespace]; ok {
+			pods[i].VNID = vnid
+		}
+	}
+	if err := PublishNodeVNIDs(h.Registry, node, pods); err != nil {
+		log.Warningf("Failed to publish the VNIDs of this node: %v", err)
+	}
+}
+
+// waitForOfport returns the OpenFlow port of a port just added to br0, which
+// ovs-vswitchd assigns shortly after the OVSDB transaction.
+func (h *PodHook) waitForOfport(veth string) (int, error) {
+	deadline := time.Now().Add(ofportTimeout)
+	for {
+		ofport, err := h.Bridge.GetOfport(veth)
+		if err == nil || time.Now().After(deadline) {
+			return ofport, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// inspectContainer asks the docker daemon about a container, like "docker
+// inspect".
+func (h *PodHook) inspectContainer(containerID string) (*container, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", h.DockerSocket)
+			},
+		},
+		Timeout: 30 * time.Second,
+	}
+	resp, err := client.Get("http://docker/containers/" + containerID + "/json")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to inspect container %s: %v", containerID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Container %s does not exist", containerID)
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to inspect container %s: %s", containerID, resp.Status)
+	}
+
+	var info struct {
+		State struct {
+			Pid int
+		}
+		HostConfig struct {
+			NetworkMode string
+		}
+		NetworkSettings struct {
+			IPAddress  string
+			MacAddress string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("Invalid inspect output of container %s: %v", containerID, err)
+	}
+	if info.State.Pid == 0 {
+		return nil, fmt.Errorf("Container %s is not running", containerID)
+	}
+	return &container{
+		Pid:         info.State.Pid,
+		NetworkMode: info.HostConfig.NetworkMode,
+		IP:          info.NetworkSettings.IPAddress,
+		MAC:         info.NetworkSettings.MacAddress,
+	}, nil
+}
+
+// hostVeth returns the name of the host end of the veth that is eth0 in the
+// network namespace of process pid.
+func hostVeth(pid int) (string, error) {
+	var peer int
+	err := netns.Do(pid, func() error {
+		eth0, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		peer = eth0.ParentIndex
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if peer == 0 {
+		return "", fmt.Errorf("eth0 of process %d is not a veth", pid)
+	}
+	link, err := netlink.LinkByIndex(peer)
+	if err != nil {
+		return "", err
+	}
+	return link.Name, nil
+}
+
+// detachVeth releases veth from the bridge docker attached it to (lbr0).
+func detachVeth(veth string) error {
+	link, err := netlink.LinkByName(veth)
+	if err != nil {
+		return err
+	}
+	if link.MasterIndex == 0 {
+		return nil
+	}
+	return netlink.LinkSetMaster(link.Index, 0)
+}
+
+// matchBridgeMTU gives both ends of the pod's veth the MTU of lbr0, which
+// node setup derives from the node's network, in case docker was told
+// otherwise.
+func matchBridgeMTU(pid int, veth string) error {
+	lbr0, err := netlink.LinkByName("lbr0")
+	if err != nil {
+		return err
+	}
+	link, err := netlink.LinkByName(veth)
+	if err != nil {
+		return err
+	}
+	if link.MTU != lbr0.MTU {
+		if err := netlink.LinkSetMTU(link.Index, lbr0.MTU); err != nil {
+			return err
+		}
+	}
+	return netns.Do(pid, func() error {
+		eth0, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		if eth0.MTU == lbr0.MTU {
+			return nil
+		}
+		return netlink.LinkSetMTU(eth0.Index, lbr0.MTU)
+	})
+}
+
+// addPodRoute routes network directly through eth0 in the network namespace
+// of process pid, like "ip route add <network> dev eth0 proto kernel scope
+// link src <ip>".
+func addPodRoute(pid int, network, ip string) error {
+	_, dst, err := net.ParseCIDR(network)
+	if err != nil {
+		return fmt.Errorf("Invalid container network %s: %v", network, err)
+	}
+	src := net.ParseIP(ip)
+	if src == nil {
+		return fmt.Errorf("Invalid pod IP %q", ip)
+	}
+	return netns.Do(pid, func() error {
+		eth0, err := netlink.LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		err = netlink.RouteAdd(&netlink.Route{
+			Dst:       dst,
+			LinkIndex: eth0.Index,
+			Src:       src,
+			Scope:     syscall.RT_SCOPE_LINK,
+			Protocol:  syscall.RTPROT_KERNEL,
+		})
+		if netlink.IsExist(err) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,477 @@
+package multitenant
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
+)
+
+type fakeBridge struct {
+	ports map[string]int
+	next  int
+	// limits are the bandwidth limits set per port, as "police
+	// <rate>/<burst>" and "qos <rate>"
+	limits map[string][]string
+	// qosErr fails ClearPortQoS
+	qosErr error
+}
+
+func (b *fakeBridge) AddPort(bridge, port string, iface *ovsdb.Interface) error {
+	if _, ok := b.ports[port]; !ok {
+		b.ports[port] = b.next
+		b.next++
+	}
+	return nil
+}
+
+func (b *fakeBridge) DeletePort(bridge, port string) error {
+	delete(b.ports, port)
+	return nil
+}
+
+func (b *fakeBridge) GetOfport(iface string) (int, error) {
+	ofport, ok := b.ports[iface]
+	if !ok {
+		return -1, fmt.Errorf("Interface %s does not exist", iface)
+	}
+	return ofport, nil
+}
+
+func (b *fakeBridge) SetIngressPolicing(iface string, rate, burst int) error {
+	b.limits[iface] = append(b.limits[iface], fmt.Sprintf("police %d/%d", rate, burst))
+	return nil
+}
+
+func (b *fakeBridge) SetPortQoS(port string, rate uint64) error {
+	b.limits[port] = append(b.limits[port], fmt.Sprintf("qos %d", rate))
+	return nil
+}
+
+func (b *fakeBridge) ClearPortQoS(port string) error {
+	if b.qosErr != nil {
+		return b.qosErr
+	}
+	delete(b.limits, port)
+	return nil
+}
+
+type fakeRegistry struct {
+	api.SubnetRegistry
+	netNamespaces map[string]uint
+	policies      []api.NetworkPolicy
+	// nodeVNIDs are the published VNIDs by node
+	nodeVNIDs map[string][]uint
+	// annotations are the annotations of the pods by namespace/name
+	annotations map[string]map[string]string
+}
+
+func (r *fakeRegistry) GetNetNamespace(name string) (api.NetNamespace, error) {
+	id, ok := r.netNamespaces[name]
+	if !ok {
+		return api.NetNamespace{}, fmt.Errorf("Key not found")
+	}
+	return api.NetNamespace{Name: name, NetID: id}, nil
+}
+
+func (r *fakeRegistry) GetNetNamespaces() ([]api.NetNamespace, error) {
+	nslist := []api.NetNamespace{}
+	for name, id := range r.netNamespaces {
+		nslist = append(nslist, api.NetNamespace{Name: name, NetID: id})
+	}
+	return nslist, nil
+}
+
+func (r *fakeRegistry) GetNetworkPolicies() ([]api.NetworkPolicy, error) {
+	return r.policies, nil
+}
+
+func (r *fakeRegistry) GetPodAnnotations(namespace, name string) (map[string]string, error) {
+	annotations, ok := r.annotations[namespace+"/"+name]
+	if !ok {
+		return map[string]string{}, nil
+	}
+	return annotations, nil
+}
+
+func (r *fakeRegistry) GetContainerNetwork() (string, error) {
+	return "10.1.0.0/16", nil
+}
+
+func (r *fakeRegistry) GetSubnets() (*[]api.Subnet, error) {
+	return &[]api.Subnet{{Minion: "172.17.0.2", Sub: "10.1.2.0/24"}, {Minion: "172.17.0.3", Sub: "10.1.3.0/24"}}, nil
+}
+
+func (r *fakeRegistry) GetNodeVNIDs() ([]api.NodeVNIDs, error) {
+	nodes := []api.NodeVNIDs{}
+	for node, vnids := range r.nodeVNIDs {
+		nodes = append(nodes, api.NodeVNIDs{Node: node, VNIDs: vnids})
+	}
+	return nodes, nil
+}
+
+func (r *fakeRegistry) WriteNodeVNIDs(nodeVNIDs api.NodeVNIDs) error {
+	if r.nodeVNIDs == nil {
+		r.nodeVNIDs = map[string][]uint{}
+	}
+	r.nodeVNIDs[nodeVNIDs.Node] = nodeVNIDs.VNIDs
+	return nil
+}
+
+// newTestHook returns a hook whose containers all have pid 1234 and whose
+// host veth is veth1234.
+func newTestHook(t *testing.T, executor exec.Interface, c *container) (*PodHook, *fakeBridge, func()) {
+	dir, err := ioutil.TempDir("", "podhook")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	bridge := &fakeBridge{ports: map[string]int{}, next: 3, limits: map[string][]string{}}
+	registry := &fakeRegistry{
+		netNamespaces: map[string]uint{"default": 0, "team": 10, "shop": 12},
+		policies: []api.NetworkPolicy{
+			{Namespace: "shop", Name: "from-team", Ingress: []api.PolicyRule{{FromNamespace: "team", Protocol: "tcp", Port: 8080}}},
+		},
+	}
+	h := NewPodHook(registry, executor, bridge)
+	h.PodStateDir = filepath.Join(dir, "pods")
+	h.LockFile = filepath.Join(dir, "lock")
+	h.inspect = func(string) (*container, error) { return c, nil }
+	h.hostVeth = func(int) (string, error) { return "veth1234", nil }
+	h.detachVeth = func(string) error { return nil }
+	h.matchMTU = func(pid int, veth string) error {
+		if pid != 1234 || veth != "veth1234" {
+			t.Errorf("Wrong MTU change for pid %d: %s", pid, veth)
+		}
+		return nil
+	}
+	h.addPodRoute = func(pid int, network, ip string) error {
+		if pid != 1234 || network != "10.1.0.0/16" || ip != "10.1.2.2" {
+			t.Errorf("Wrong route for pid %d: %s src %s", pid, network, ip)
+		}
+		return nil
+	}
+	return h, bridge, func() { os.RemoveAll(dir) }
+}
+
+// podARPReply are the actions answering ARP for the test pod, with the MAC
+// address docker gives 10.1.2.2
+const podARPReply = "move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:02:42:0a:01:02:02->eth_src,set_field:2->arp_op,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],set_field:02:42:0a:01:02:02->arp_sha,move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],set_field:10.1.2.2->arp_spa,move:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],IN_PORT"
+
+func TestPodHookSetup(t *testing.T) {
+	pod := &container{Pid: 1234, IP: "10.1.2.2"}
+	tests := []struct {
+		name        string
+		namespace   string
+		container   *container
+		annotations map[string]string
+		// stored are the annotations of the pod in the registry
+		stored   map[string]string
+		script   []exec.FakeResult
+		commands []string
+		record   *podstate.Pod
+		limits   []string
+		// published are the VNIDs published for the node, if any
+		published map[string][]uint
+		fail      bool
+	}{
+		{
+			name:      "tenant pod",
+			namespace: "team",
+			container: pod,
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "add-flow br0 table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=" + podARPReply,
+				ofctlCmd + "add-flow br0 table=8,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3",
+			},
+			record:    &podstate.Pod{Namespace: "team", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2", VNID: 10},
+			published: map[string][]uint{"172.17.0.2": {10}},
+		},
+		{
+			name:      "pod with network policy",
+			namespace: "shop",
+			container: pod,
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:12->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "add-flow br0 table=5,cookie=0x300000000000003,priority=100,tcp,nw_dst=10.1.2.2,reg0=10,tp_dst=8080,actions=load:12->NXM_NX_REG0[],goto_table:8",
+				ofctlCmd + "add-flow br0 table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=" + podARPReply,
+				ofctlCmd + "add-flow br0 table=8,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,reg0=12,actions=output:3",
+			},
+			record:    &podstate.Pod{Namespace: "shop", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2", VNID: 12},
+			published: map[string][]uint{"172.17.0.2": {12}},
+		},
+		{
+			name:      "global pod",
+			namespace: "default",
+			container: pod,
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:0->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "add-flow br0 table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=" + podARPReply,
+				ofctlCmd + "add-flow br0 table=8,cookie=0x300000000000003,priority=150,ip,nw_dst=10.1.2.2,actions=output:3",
+			},
+			record:    &podstate.Pod{Namespace: "default", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2"},
+			published: map[string][]uint{"172.17.0.2": {}},
+		},
+		{
+			name:      "pod with bandwidth limits",
+			namespace: "team",
+			container: pod,
+			annotations: map[string]string{
+				IngressBandwidthAnnotation: "20M",
+				EgressBandwidthAnnotation:  "5k",
+				"app":                      "web",
+			},
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "add-flow br0 table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=" + podARPReply,
+				ofctlCmd + "add-flow br0 table=8,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3",
+			},
+			record: &podstate.Pod{Namespace: "team", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2", VNID: 10,
+				IngressBandwidth: 20000000, EgressBandwidth: 5000},
+			limits:    []string{"police 5/1", "qos 20000000"},
+			published: map[string][]uint{"172.17.0.2": {10}},
+		},
+		{
+			name:      "bandwidth limits from the registry",
+			namespace: "team",
+			container: pod,
+			stored:    map[string]string{IngressBandwidthAnnotation: "20M"},
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "add-flow br0 table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=" + podARPReply,
+				ofctlCmd + "add-flow br0 table=8,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3",
+			},
+			record: &podstate.Pod{Namespace: "team", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2", VNID: 10,
+				IngressBandwidth: 20000000},
+			limits:    []string{"qos 20000000"},
+			published: map[string][]uint{"172.17.0.2": {10}},
+		},
+		{
+			name:        "annotations override the registry",
+			namespace:   "team",
+			container:   pod,
+			stored:      map[string]string{IngressBandwidthAnnotation: "fast", EgressBandwidthAnnotation: "5k"},
+			annotations: map[string]string{IngressBandwidthAnnotation: "20M"},
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "add-flow br0 table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=" + podARPReply,
+				ofctlCmd + "add-flow br0 table=8,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3",
+			},
+			record: &podstate.Pod{Namespace: "team", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2", VNID: 10,
+				IngressBandwidth: 20000000, EgressBandwidth: 5000},
+			limits:    []string{"police 5/1", "qos 20000000"},
+			published: map[string][]uint{"172.17.0.2": {10}},
+		},
+		{
+			name:      "pod with its own MAC address",
+			namespace: "team",
+			container: &container{Pid: 1234, IP: "10.1.2.2", MAC: "0a:58:0a:01:02:02"},
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "add-flow br0 table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=" + strings.Replace(podARPReply, "02:42:0a:01:02:02", "0a:58:0a:01:02:02", -1),
+				ofctlCmd + "add-flow br0 table=8,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3",
+			},
+			record:    &podstate.Pod{Namespace: "team", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2", MAC: "0a:58:0a:01:02:02", VNID: 10},
+			published: map[string][]uint{"172.17.0.2": {10}},
+		},
+		{
+			name:        "invalid bandwidth",
+			namespace:   "team",
+			container:   pod,
+			annotations: map[string]string{EgressBandwidthAnnotation: "fast"},
+			commands:    []string{},
+			fail:        true,
+		},
+		{
+			name:      "host network",
+			namespace: "team",
+			container: &container{Pid: 1234, NetworkMode: "host"},
+			commands:  []string{},
+		},
+		{
+			name:      "unknown namespace",
+			namespace: "nobody",
+			container: pod,
+			commands:  []string{},
+			fail:      true,
+		},
+		{
+			name:      "flow rejected",
+			namespace: "team",
+			container: pod,
+			script:    []exec.FakeResult{{Output: "ovs-ofctl: OFPT_ERROR", ExitStatus: 1}},
+			// the pod is detached again
+			commands: []string{
+				ofctlCmd + "add-flow br0 table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+				ofctlCmd + "del-flows br0 table=3,cookie=0x300000000000003/0xff000000ffffffff",
+				ofctlCmd + "del-flows br0 table=5,cookie=0x300000000000003/0xff000000ffffffff",
+				ofctlCmd + "del-flows br0 table=7,cookie=0x300000000000003/0xff000000ffffffff",
+				ofctlCmd + "del-flows br0 table=8,cookie=0x300000000000003/0xff000000ffffffff",
+			},
+			fail: true,
+		},
+	}
+
+	for _, test := range tests {
+		executor := exec.NewFake(test.script...)
+		h, bridge, cleanup := newTestHook(t, executor, test.container)
+		h.Registry.(*fakeRegistry).annotations = map[string]map[string]string{test.namespace + "/web": test.stored}
+		err := h.Setup(test.namespace, "web", "abc", test.annotations)
+		if (err != nil) != test.fail {
+			t.Errorf("%s: unexpected result %v", test.name, err)
+		}
+		if commands := executor.CommandLines(); !reflect.DeepEqual(commands, test.commands) {
+			t.Errorf("%s: wrong commands %q", test.name, commands)
+		}
+		record, err := podstate.Get(h.PodStateDir, test.namespace, "web")
+		if err != nil || !reflect.DeepEqual(record, test.record) {
+			t.Errorf("%s: wrong record %+v (%v)", test.name, record, err)
+		}
+		if limits := bridge.limits["veth1234"]; !reflect.DeepEqual(limits, test.limits) {
+			t.Errorf("%s: wrong bandwidth limits %q", test.name, limits)
+		}
+		if test.fail && len(bridge.ports) != 0 {
+			t.Errorf("%s: ports left on the bridge: %v", test.name, bridge.ports)
+		}
+		if published := h.Registry.(*fakeRegistry).nodeVNIDs; !reflect.DeepEqual(published, test.published) {
+			t.Errorf("%s: wrong published VNIDs %v", test.name, published)
+		}
+		cleanup()
+	}
+}
+
+// TestPodHookRecordsBeforeFlows checks that the pod is recorded by the time
+// its first flow goes in, so that the node does not take the flows for
+// strays.
+func TestPodHookRecordsBeforeFlows(t *testing.T) {
+	var h *PodHook
+	recorded := false
+	executor := exec.NewFake(exec.FakeResult{Run: func([]string) {
+		record, err := podstate.Get(h.PodStateDir, "team", "web")
+		recorded = err == nil && record != nil
+	}})
+	h, _, cleanup := newTestHook(t, executor, &container{Pid: 1234, IP: "10.1.2.2"})
+	defer cleanup()
+	if err := h.Setup("team", "web", "abc", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !recorded {
+		t.Errorf("Expected the pod to be recorded before its flows were added")
+	}
+}
+
+func TestPodHookTeardown(t *testing.T) {
+	deletes := []string{
+		ofctlCmd + "del-flows br0 table=3,cookie=0x30000000000000c/0xff000000ffffffff",
+		ofctlCmd + "del-flows br0 table=5,cookie=0x30000000000000c/0xff000000ffffffff",
+		ofctlCmd + "del-flows br0 table=7,cookie=0x30000000000000c/0xff000000ffffffff",
+		ofctlCmd + "del-flows br0 table=8,cookie=0x30000000000000c/0xff000000ffffffff",
+	}
+	tests := []struct {
+		name      string
+		record    *podstate.Pod
+		qosErr    error
+		commands  []string
+		published map[string][]uint
+	}{
+		{
+			name:      "recorded pod",
+			record:    &podstate.Pod{Namespace: "team", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 12, IP: "10.1.2.2", VNID: 10},
+			commands:  deletes,
+			published: map[string][]uint{"172.17.0.2": {}},
+		},
+		{
+			name:     "pod without record",
+			commands: deletes,
+		},
+		{
+			// the port goes all the same
+			name:      "QoS not cleared",
+			record:    &podstate.Pod{Namespace: "team", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 12, IP: "10.1.2.2", VNID: 10},
+			qosErr:    fmt.Errorf("ovsdb: transaction failed"),
+			commands:  deletes,
+			published: map[string][]uint{"172.17.0.2": {}},
+		},
+	}
+
+	for _, test := range tests {
+		executor := exec.NewFake()
+		h, bridge, cleanup := newTestHook(t, executor, &container{Pid: 1234, IP: "10.1.2.2"})
+		bridge.ports["veth1234"] = 12
+		bridge.limits["veth1234"] = []string{"qos 20000000"}
+		bridge.qosErr = test.qosErr
+		if test.record != nil {
+			if err := podstate.Write(h.PodStateDir, test.record); err != nil {
+				t.Fatalf("%s: error writing record: %v", test.name, err)
+			}
+		}
+
+		if err := h.Teardown("team", "web", "abc"); err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+		if commands := executor.CommandLines(); !reflect.DeepEqual(commands, test.commands) {
+			t.Errorf("%s: wrong commands %q", test.name, commands)
+		}
+		if len(bridge.ports) != 0 {
+			t.Errorf("%s: ports left on the bridge: %v", test.name, bridge.ports)
+		}
+		if len(bridge.limits) != 0 && test.qosErr == nil {
+			t.Errorf("%s: bandwidth limits left on the bridge: %v", test.name, bridge.limits)
+		}
+		if record, err := podstate.Get(h.PodStateDir, "team", "web"); err != nil || record != nil {
+			t.Errorf("%s: record left behind: %+v (%v)", test.name, record, err)
+		}
+		if published := h.Registry.(*fakeRegistry).nodeVNIDs; !reflect.DeepEqual(published, test.published) {
+			t.Errorf("%s: wrong published VNIDs %v", test.name, published)
+		}
+		cleanup()
+	}
+}
+
+func TestInspectContainer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error listening on %s: %v", socket, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/abc/json":
+			fmt.Fprint(w, `{"Id":"abc","State":{"Running":true,"Pid":1234},"HostConfig":{"NetworkMode":"default"},"NetworkSettings":{"IPAddress":"10.1.2.2","MacAddress":"02:42:0a:01:02:02"}}`)
+		case "/containers/stopped/json":
+			fmt.Fprint(w, `{"Id":"stopped","State":{"Running":false,"Pid":0}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	h := NewPodHook(nil, nil, nil)
+	h.DockerSocket = socket
+	c, err := h.inspectContainer("abc")
+	if err != nil || !reflect.DeepEqual(c, &container{Pid: 1234, NetworkMode: "default", IP: "10.1.2.2", MAC: "02:42:0a:01:02:02"}) {
+		t.Errorf("Wrong container %+v (%v)", c, err)
+	}
+	for _, id := range []string{"stopped", "missing"} {
+		if c, err := h.inspectContainer(id); err == nil {
+			t.Errorf("Expected an error inspecting %s, got %+v", id, c)
+		}
+	}
+}
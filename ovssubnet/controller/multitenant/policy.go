@@ -0,0 +1,170 @@
+package multitenant
+
+import (
+	"net"
+	"strconv"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// Policies are the settings of namespaces that the flows enforce beyond VNID
+// isolation.
+type Policies struct {
+	Network   []api.NetworkPolicy
+	Egress    []api.EgressNetworkPolicy
+	EgressIPs []api.EgressIP
+	// Multicast are the namespaces with multicast enabled
+	Multicast []string
+	// VNIDs are the VNIDs of the namespaces by name, to resolve the
+	// namespaces the others name
+	VNIDs map[string]uint
+}
+
+// SetPolicies sets the policies that DesiredFlows compiles into the policy,
+// egress, egress IP and multicast tables, giving every VNID with multicast a
+// group. The controller keeps them, so callers must not change them.
+func (c *FlowController) SetPolicies(policies *Policies) {
+	keys := []string{}
+	for vnid := range multicastVNIDs(policies.Multicast, policies.VNIDs) {
+		keys = append(keys, strconv.FormatUint(uint64(vnid), 10))
+	}
+	ids := c.groupIDs.assign("multicast/", keys)
+	groups := make(map[uint]uint32, len(ids))
+	for key, id := range ids {
+		vnid, _ := strconv.ParseUint(key, 10, 32)
+		groups[uint(vnid)] = id
+	}
+
+	c.policyLock.Lock()
+	defer c.policyLock.Unlock()
+	c.policies = policies
+	c.multicastGroups = groups
+}
+
+func (c *FlowController) currentPolicies() *Policies {
+	c.policyLock.Lock()
+	defer c.policyLock.Unlock()
+	return c.policies
+}
+
+// policyFlows returns the policy table flows of a local pod: traffic that a
+// policy of the pod's namespace admits is relabeled with the pod's VNID, so
+// that table 8 delivers it as if it came from the namespace itself. Pods of
+// VNID 0 already take traffic from everywhere and need none.
+func policyFlows(pod *podstate.Pod, policies []api.NetworkPolicy, vnids map[string]uint) []*ofctl.Flow {
+	flows := []*ofctl.Flow{}
+	if pod.VNID == 0 {
+		return flows
+	}
+	owner := uint64(cookie.ForPod(pod.Ofport))
+	for _, policy := range policies {
+		if policy.Namespace != pod.Namespace {
+			continue
+		}
+		for _, rule := range policy.Ingress {
+			match := []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", pod.IP)}
+			switch rule.Protocol {
+			case "tcp":
+				match[0] = ofctl.TCP
+			case "udp":
+				match[0] = ofctl.UDP
+			}
+			if rule.FromCIDR != "" {
+				match = append(match, cidrMatch("nw_src", rule.FromCIDR)...)
+			} else {
+				vnid, ok := vnids[rule.FromNamespace]
+				if !ok {
+					log.Warningf("Network policy %s/%s admits traffic from unknown namespace %s", policy.Namespace, policy.Name, rule.FromNamespace)
+					continue
+				}
+				if vnid == 0 || vnid == pod.VNID {
+					// admitted anyway
+					continue
+				}
+				match = append(match, ofctl.Eq("reg0", strconv.FormatUint(uint64(vnid), 10)))
+			}
+			if rule.Port != 0 {
+				match = append(match, ofctl.Eq("tp_dst", strconv.FormatUint(uint64(rule.Port), 10)))
+			}
+			flows = append(flows, &ofctl.Flow{
+				Table:    5,
+				Cookie:   owner,
+				Priority: 100,
+				Match:    match,
+				Actions:  []ofctl.Action{ofctl.Load(uint64(pod.VNID), "NXM_NX_REG0[]"), ofctl.GotoTable(8)},
+			})
+		}
+	}
+	return flows
+}
+
+// egressFlows returns the egress table flows of the egress policies: the
+// rules of a namespace match on its VNID in reg0 and are tried in order,
+// allowed packets passing on to the egress IP table and denied ones being
+// dropped.
+// Namespaces sharing a VNID share their rules, in the order of the policies.
+// VNID 0 is also that of the node's docker containers, so egress policies of
+// global namespaces are not enforced.
+func egressFlows(policies []api.EgressNetworkPolicy, vnids map[string]uint) []*ofctl.Flow {
+	flows := []*ofctl.Flow{}
+	rules := map[uint]int{}
+	for _, policy := range policies {
+		vnid, ok := vnids[policy.Namespace]
+		if !ok {
+			log.Warningf("Egress network policy of unknown namespace %s", policy.Namespace)
+			continue
+		}
+		if vnid == 0 {
+			log.Warningf("Egress network policy of global namespace %s is not enforced", policy.Namespace)
+			continue
+		}
+		owner := uint64(cookie.ForNamespace(vnid))
+		for _, rule := range policy.Egress {
+			if rules[vnid] == api.MaxEgressRules {
+				log.Warningf("Egress network policies of VNID %d have more than %d rules, ignoring the rest", vnid, api.MaxEgressRules)
+				break
+			}
+			match := []ofctl.Field{ofctl.IP, ofctl.Eq("reg0", strconv.FormatUint(uint64(vnid), 10))}
+			match = append(match, cidrMatch("nw_dst", rule.To)...)
+			switch rule.Protocol {
+			case "tcp":
+				match[0] = ofctl.TCP
+			case "udp":
+				match[0] = ofctl.UDP
+			}
+			if rule.Port != 0 {
+				match = append(match, ofctl.Eq("tp_dst", strconv.FormatUint(uint64(rule.Port), 10)))
+			}
+			var actions []ofctl.Action
+			if rule.Type == api.EgressAllow {
+				actions = []ofctl.Action{ofctl.GotoTable(10)}
+			}
+			flows = append(flows, &ofctl.Flow{
+				Table:    9,
+				Cookie:   owner,
+				Priority: api.MaxEgressRules - rules[vnid],
+				Match:    match,
+				Actions:  actions,
+			})
+			rules[vnid]++
+		}
+	}
+	return flows
+}
+
+// cidrMatch matches field against a CIDR. OVS drops a match on every address,
+// 0.0.0.0/0, from a flow, so none is returned for it and the flow dumps as
+// built.
+func cidrMatch(field, cidr string) []ofctl.Field {
+	if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+		if ones, _ := ipnet.Mask.Size(); ones == 0 {
+			return nil
+		}
+	}
+	return []ofctl.Field{ofctl.Eq(field, cidr)}
+}
@@ -0,0 +1,108 @@
+package multitenant
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+)
+
+func TestPolicyFlows(t *testing.T) {
+	vnids := map[string]uint{"default": 0, "team": 10, "shop": 12}
+	tests := []struct {
+		name  string
+		pod   podstate.Pod
+		rules []api.PolicyRule
+		flows []string
+	}{
+		{
+			name:  "from a namespace on a port",
+			pod:   podstate.Pod{Namespace: "shop", Ofport: 3, IP: "10.1.2.2", VNID: 12},
+			rules: []api.PolicyRule{{FromNamespace: "team", Protocol: "tcp", Port: 8080}},
+			flows: []string{
+				"table=5,cookie=0x300000000000003,priority=100,tcp,nw_dst=10.1.2.2,reg0=10,tp_dst=8080,actions=load:12->NXM_NX_REG0[],goto_table:8",
+			},
+		},
+		{
+			name:  "from a CIDR",
+			pod:   podstate.Pod{Namespace: "shop", Ofport: 3, IP: "10.1.2.2", VNID: 12},
+			rules: []api.PolicyRule{{FromCIDR: "10.1.3.0/24"}, {FromCIDR: "10.1.4.0/24", Protocol: "udp", Port: 53}},
+			flows: []string{
+				"table=5,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,nw_src=10.1.3.0/24,actions=load:12->NXM_NX_REG0[],goto_table:8",
+				"table=5,cookie=0x300000000000003,priority=100,udp,nw_dst=10.1.2.2,nw_src=10.1.4.0/24,tp_dst=53,actions=load:12->NXM_NX_REG0[],goto_table:8",
+			},
+		},
+		{
+			name:  "from namespaces admitted anyway or unknown",
+			pod:   podstate.Pod{Namespace: "shop", Ofport: 3, IP: "10.1.2.2", VNID: 12},
+			rules: []api.PolicyRule{{FromNamespace: "shop"}, {FromNamespace: "default"}, {FromNamespace: "nobody"}},
+			flows: []string{},
+		},
+		{
+			name:  "global pod",
+			pod:   podstate.Pod{Namespace: "default", Ofport: 3, IP: "10.1.2.2"},
+			rules: []api.PolicyRule{{FromNamespace: "team"}},
+			flows: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		policies := []api.NetworkPolicy{
+			{Namespace: test.pod.Namespace, Name: "test", Ingress: test.rules},
+			// policies of other namespaces do not apply
+			{Namespace: "elsewhere", Name: "test", Ingress: []api.PolicyRule{{FromCIDR: "0.0.0.0/0"}}},
+		}
+		flows := []string{}
+		for _, flow := range policyFlows(&test.pod, policies, vnids) {
+			flows = append(flows, flow.String())
+		}
+		if !reflect.DeepEqual(flows, test.flows) {
+			t.Errorf("%s: wrong flows.\nExpected %q\nGot      %q", test.name, test.flows, flows)
+		}
+	}
+}
+
+func TestDesiredFlowsWithPolicies(t *testing.T) {
+	c := NewFlowController(nil)
+	pods := []podstate.Pod{{Namespace: "shop", Ofport: 3, IP: "10.1.2.2", VNID: 12}}
+	if flows := c.DesiredFlows(nil, pods, "172.17.0.2"); len(flows) != 3 {
+		t.Fatalf("Expected the ingress, ARP and delivery flows of the pod, got %v", flows)
+	}
+
+	policies := []api.NetworkPolicy{{Namespace: "shop", Name: "from-team", Ingress: []api.PolicyRule{{FromNamespace: "team"}}}}
+	c.SetPolicies(&Policies{Network: policies, VNIDs: map[string]uint{"team": 10, "shop": 12}})
+	flows := c.DesiredFlows(nil, pods, "172.17.0.2")
+	if len(flows) != 4 || flows[1].Table != 5 {
+		t.Fatalf("Expected a policy flow for the pod, got %v", flows)
+	}
+}
+
+func TestEgressFlows(t *testing.T) {
+	vnids := map[string]uint{"default": 0, "team": 10, "shop": 10, "bank": 12}
+	policies := []api.EgressNetworkPolicy{
+		{Namespace: "bank", Egress: []api.EgressRule{
+			{Type: api.EgressAllow, To: "192.168.1.0/24", Protocol: "tcp", Port: 443},
+			{Type: api.EgressDeny, To: "0.0.0.0/0"},
+		}},
+		// not enforced
+		{Namespace: "default", Egress: []api.EgressRule{{Type: api.EgressDeny, To: "0.0.0.0/0"}}},
+		{Namespace: "nobody", Egress: []api.EgressRule{{Type: api.EgressDeny, To: "0.0.0.0/0"}}},
+		// namespaces sharing a VNID share their rules
+		{Namespace: "shop", Egress: []api.EgressRule{{Type: api.EgressDeny, To: "10.0.0.0/8", Protocol: "udp"}}},
+		{Namespace: "team", Egress: []api.EgressRule{{Type: api.EgressAllow, To: "10.2.0.0/16"}}},
+	}
+	expected := []string{
+		"table=9,cookie=0x40000000000000c,priority=1000,tcp,reg0=12,nw_dst=192.168.1.0/24,tp_dst=443,actions=goto_table:10",
+		"table=9,cookie=0x40000000000000c,priority=999,ip,reg0=12,actions=drop",
+		"table=9,cookie=0x40000000000000a,priority=1000,udp,reg0=10,nw_dst=10.0.0.0/8,actions=drop",
+		"table=9,cookie=0x40000000000000a,priority=999,ip,reg0=10,nw_dst=10.2.0.0/16,actions=goto_table:10",
+	}
+	flows := []string{}
+	for _, flow := range egressFlows(policies, vnids) {
+		flows = append(flows, flow.String())
+	}
+	if !reflect.DeepEqual(flows, expected) {
+		t.Errorf("Wrong egress flows.\nExpected %q\nGot      %q", expected, flows)
+	}
+}
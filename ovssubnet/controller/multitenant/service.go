@@ -0,0 +1,185 @@
+package multitenant
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// The conntrack zones of service load balancing: connections to a service
+// are DNATed to an endpoint in serviceZone, and those of a pod reaching
+// itself through a service are also SNATed to the subnet gateway in
+// hairpinZone, so that the pod does not take its own packets for spoofed.
+const (
+	serviceZone = 1
+	hairpinZone = 2
+)
+
+// EnableServiceLoadBalancing makes DesiredFlows and DesiredGroups load-balance
+// the services given to SetServices in br0. It must be called before any
+// flows are computed. The switch needs conntrack support, OVS 2.6 or later.
+func (c *FlowController) EnableServiceLoadBalancing() {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	c.servicesEnabled = true
+	c.serviceGroups = make(map[string]uint32)
+}
+
+// ServiceLoadBalancing tells whether services are load-balanced in br0.
+func (c *FlowController) ServiceLoadBalancing() bool {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	return c.servicesEnabled
+}
+
+// SetServices sets the services to load-balance, giving every port of them
+// a group. A port keeps its group for as long as it exists. The controller
+// keeps the services, so callers must not change them.
+func (c *FlowController) SetServices(services []api.Service) {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	if !c.servicesEnabled {
+		return
+	}
+	valid := []api.Service{}
+	keys := []string{}
+	for _, s := range services {
+		if err := s.Validate(); err != nil {
+			log.Errorf("Ignoring service: %v", err)
+			continue
+		}
+		valid = append(valid, s)
+		for _, p := range s.Ports {
+			keys = append(keys, servicePortKey(&s, &p))
+		}
+	}
+	c.serviceGroups = c.groupIDs.assign("service/", keys)
+	c.services = valid
+}
+
+// currentServices returns the services and the groups of their ports, or nil
+// if services are not load-balanced.
+func (c *FlowController) currentServices() ([]api.Service, map[string]uint32) {
+	c.serviceLock.Lock()
+	defer c.serviceLock.Unlock()
+	if !c.servicesEnabled {
+		return nil, nil
+	}
+	groups := make(map[string]uint32, len(c.serviceGroups))
+	for key, id := range c.serviceGroups {
+		groups[key] = id
+	}
+	return c.services, groups
+}
+
+func servicePortKey(s *api.Service, p *api.ServicePort) string {
+	return fmt.Sprintf("%s/%s/%s/%d", s.Namespace, s.Name, p.Protocol, p.Port)
+}
+
+// desiredServiceGroups returns the select groups of the service ports, or
+// none if services are not load-balanced. Each bucket DNATs
+// a new connection to one endpoint and routes it again in table 4, where
+// table 5 and table 8 apply VNID isolation and network policy to it as to
+// traffic sent to the endpoint directly.
+func (c *FlowController) desiredServiceGroups() []*ofctl.Group {
+	services, groups := c.currentServices()
+	result := []*ofctl.Group{}
+	for i := range services {
+		s := &services[i]
+		for j := range s.Ports {
+			p := &s.Ports[j]
+			group := &ofctl.Group{ID: groups[servicePortKey(s, p)], Type: "select"}
+			for _, e := range p.Endpoints {
+				nat := fmt.Sprintf("commit,zone=%d,nat(dst=%s:%d),table=4", serviceZone, e.IP, e.Port)
+				group.Buckets = append(group.Buckets, []ofctl.Action{ofctl.CT(nat)})
+			}
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// serviceFlows returns the flows of service load balancing:
+//
+//	table 4: untracked traffic passes the hairpin zone, undoing the SNAT of
+//	         replies to a pod that reached itself, on the way to table 11;
+//	         new connections to a service port go to its group
+//	table 5: untracked traffic from the tunnel passes the service zone,
+//	         undoing the DNAT of replies from remote endpoints; a local
+//	         endpoint reaching itself is SNATed to the gateway, and its
+//	         traffic marked in reg1 goes back out of the port it came in
+//	table 11: traffic passes the service zone, undoing the DNAT of replies
+//	         and applying that of established connections, back to table 4;
+//	         replies to a hairpin connection are marked in reg1
+//
+// The flows all services share have the service cookie 0, those of a
+// service port the cookie of its group and the hairpin flows of a local
+// endpoint the endpoint cookie of its IP.
+func serviceFlows(services []api.Service, groups map[string]uint32, localSubnet string) []*ofctl.Flow {
+	shared := uint64(cookie.ForService(0))
+	natServiceZone := fmt.Sprintf("zone=%d,nat", serviceZone)
+	flows := []*ofctl.Flow{
+		{Table: 4, Cookie: shared, Priority: 350, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("ct_state", "-trk")}, Actions: []ofctl.Action{ofctl.CT(fmt.Sprintf("zone=%d,nat,table=11", hairpinZone))}},
+		{Table: 5, Cookie: shared, Priority: 300, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("ct_state", "-trk")}, Actions: []ofctl.Action{ofctl.CT(natServiceZone + ",table=5")}},
+		{Table: 5, Cookie: shared, Priority: 250, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("reg1", "1")}, Actions: []ofctl.Action{{Name: "in_port"}}},
+		{Table: 11, Cookie: shared, Priority: 100, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("ct_mark", "1")}, Actions: []ofctl.Action{ofctl.Load(1, "NXM_NX_REG1[]"), ofctl.CT(natServiceZone + ",table=4")}},
+		{Table: 11, Cookie: shared, Priority: 0, Match: []ofctl.Field{ofctl.IP}, Actions: []ofctl.Action{ofctl.CT(natServiceZone + ",table=4")}},
+	}
+
+	var local *net.IPNet
+	var gateway string
+	if _, ipnet, err := net.ParseCIDR(localSubnet); err == nil {
+		local = ipnet
+		gateway = netutils.GenerateDefaultGateway(ipnet).String()
+	}
+	endpoints := map[string]bool{}
+	for i := range services {
+		s := &services[i]
+		for j := range s.Ports {
+			p := &s.Ports[j]
+			match := []ofctl.Field{ofctl.TCP}
+			if p.Protocol == "udp" {
+				match[0] = ofctl.UDP
+			}
+			match = append(match, ofctl.Eq("nw_dst", s.IP), ofctl.Eq("tp_dst", strconv.FormatUint(uint64(p.Port), 10)))
+			id := groups[servicePortKey(s, p)]
+			flows = append(flows, &ofctl.Flow{
+				Table:    4,
+				Cookie:   uint64(cookie.ForService(id)),
+				Priority: 300,
+				Match:    match,
+				Actions:  []ofctl.Action{ofctl.ToGroup(id)},
+			})
+			for _, e := range p.Endpoints {
+				if local != nil && local.Contains(net.ParseIP(e.IP)) {
+					endpoints[e.IP] = true
+				}
+			}
+		}
+	}
+
+	ips := make([]string, 0, len(endpoints))
+	for ip := range endpoints {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		snat := fmt.Sprintf("commit,zone=%d,nat(src=%s),exec(load:1->NXM_NX_CT_MARK[]),table=5", hairpinZone, gateway)
+		flows = append(flows, &ofctl.Flow{
+			Table:    5,
+			Cookie:   uint64(cookie.ForEndpoint(ip)),
+			Priority: 260,
+			Match:    []ofctl.Field{ofctl.IP, ofctl.Eq("nw_src", ip), ofctl.Eq("nw_dst", ip), ofctl.Eq("ct_state", "+trk+dnat-rpl")},
+			Actions:  []ofctl.Action{ofctl.Load(1, "NXM_NX_REG1[]"), ofctl.CT(snat)},
+		})
+	}
+	return flows
+}
@@ -0,0 +1,76 @@
+package multitenant
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+)
+
+var testServices = []api.Service{
+	{Namespace: "web", Name: "frontend", IP: "172.30.0.10", Ports: []api.ServicePort{
+		{Protocol: "tcp", Port: 80, Endpoints: []api.Endpoint{{IP: "10.1.2.2", Port: 8080}, {IP: "10.1.3.2", Port: 8080}}},
+		{Protocol: "udp", Port: 53, Endpoints: []api.Endpoint{{IP: "10.1.3.3", Port: 53}}},
+	}},
+	// invalid, ignored
+	{Namespace: "web", Name: "broken", IP: "nowhere"},
+}
+
+func TestServiceFlows(t *testing.T) {
+	c := NewFlowController(nil)
+	c.EnableServiceLoadBalancing()
+	c.SetServices(testServices)
+	services, groups := c.currentServices()
+	expected := []string{
+		"table=4,cookie=0x600000000000000,priority=350,ip,ct_state=-trk,actions=ct(zone=2,nat,table=11)",
+		"table=5,cookie=0x600000000000000,priority=300,ip,ct_state=-trk,actions=ct(zone=1,nat,table=5)",
+		"table=5,cookie=0x600000000000000,priority=250,ip,reg1=1,actions=in_port",
+		"table=11,cookie=0x600000000000000,priority=100,ip,ct_mark=1,actions=load:1->NXM_NX_REG1[],ct(zone=1,nat,table=4)",
+		"table=11,cookie=0x600000000000000,priority=0,ip,actions=ct(zone=1,nat,table=4)",
+		"table=4,cookie=0x600000000000001,priority=300,tcp,nw_dst=172.30.0.10,tp_dst=80,actions=group:1",
+		"table=4,cookie=0x600000000000002,priority=300,udp,nw_dst=172.30.0.10,tp_dst=53,actions=group:2",
+		"table=5,cookie=0x70000000a010202,priority=260,ip,nw_src=10.1.2.2,nw_dst=10.1.2.2,ct_state=+trk+dnat-rpl,actions=load:1->NXM_NX_REG1[],ct(commit,zone=2,nat(src=10.1.2.1),exec(load:1->NXM_NX_CT_MARK[]),table=5)",
+	}
+	flows := []string{}
+	for _, flow := range serviceFlows(services, groups, "10.1.2.0/24") {
+		flows = append(flows, flow.String())
+	}
+	if !reflect.DeepEqual(flows, expected) {
+		t.Errorf("Wrong service flows.\nExpected %q\nGot      %q", expected, flows)
+	}
+}
+
+func TestServiceGroups(t *testing.T) {
+	c := NewFlowController(nil)
+	if c.SetServices(testServices); len(c.DesiredGroups(nil, nil, "")) != 0 {
+		t.Fatalf("Expected no groups without service load balancing")
+	}
+	c.EnableServiceLoadBalancing()
+	c.SetServices(testServices)
+	expected := []string{
+		"group_id=1,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.2.2:8080),table=4),bucket=actions=ct(commit,zone=1,nat(dst=10.1.3.2:8080),table=4)",
+		"group_id=2,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.3.3:53),table=4)",
+	}
+	groups := []string{}
+	for _, group := range c.DesiredGroups(nil, nil, "") {
+		groups = append(groups, group.String())
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Wrong service groups.\nExpected %q\nGot      %q", expected, groups)
+	}
+
+	// the udp port goes away and a new service comes: the tcp port keeps
+	// its group and the new one takes the freed id
+	changed := []api.Service{
+		{Namespace: "web", Name: "frontend", IP: "172.30.0.10", Ports: testServices[0].Ports[:1]},
+		{Namespace: "db", Name: "primary", IP: "172.30.0.11", Ports: []api.ServicePort{
+			{Protocol: "tcp", Port: 5432, Endpoints: []api.Endpoint{{IP: "10.1.2.3", Port: 5432}}},
+		}},
+	}
+	c.SetServices(changed)
+	_, ids := c.currentServices()
+	expectedIDs := map[string]uint32{"web/frontend/tcp/80": 1, "db/primary/tcp/5432": 2}
+	if !reflect.DeepEqual(ids, expectedIDs) {
+		t.Errorf("Wrong group ids after change.\nExpected %v\nGot      %v", expectedIDs, ids)
+	}
+}
@@ -0,0 +1,350 @@
+// Package vxlan connects the nodes of a cluster through a kernel vxlan
+// device instead of Open vSwitch. Containers sit on lbr0, which carries the
+// subnet gateway and has vxlan0 as a port. Every remote subnet is routed
+// through the gateway of that subnet on lbr0, whose MAC address comes from
+// its IP, and vxlan0 sends frames for that MAC address to the node of the
+// subnet. The node keeps the routes, ARP entries and forwarding database
+// entries itself, so vxlan0 neither learns nor floods.
+package vxlan
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"syscall"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+const (
+	// Device is the kernel vxlan device, a port of lbr0.
+	Device = "vxlan0"
+	// RouteProtocol marks the routes to the subnets of other nodes ("proto
+	// 42" in "ip route"), as in host-gateway mode.
+	RouteProtocol = 42
+)
+
+type FlowController struct {
+	executor exec.Interface
+	tunnel   api.Tunnel
+}
+
+func NewFlowController(executor exec.Interface) *FlowController {
+	return &FlowController{executor: executor, tunnel: api.DefaultTunnel}
+}
+
+// SetTunnel sets the encapsulation used to reach other nodes, which must be
+// VXLAN.
+func (c *FlowController) SetTunnel(tunnel api.Tunnel) {
+	c.tunnel = tunnel
+}
+
+func (c *FlowController) Setup(localSubnet, containerNetwork string, mtu uint) error {
+	if c.tunnel.Type != api.TunnelVXLAN {
+		return fmt.Errorf("Kernel VXLAN mode needs a vxlan tunnel, the cluster uses %s", c.tunnel.Type)
+	}
+	_, ipnet, err := net.ParseCIDR(localSubnet)
+	if err != nil {
+		return err
+	}
+	unlock, err := nodesetup.Lock(nodesetup.LockFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	node := nodesetup.NewLinuxNode(c.executor)
+	steps := append(linkSteps(ipnet, c.tunnel, mtu), hostSteps(node, containerNetwork, c.tunnel, mtu)...)
+	return nodesetup.Run(steps)
+}
+
+// linkSteps returns the links of the node: lbr0 with the subnet gateway and
+// the MAC address other nodes expect of it, and vxlan0 as its port.
+func linkSteps(ipnet *net.IPNet, tunnel api.Tunnel, mtu uint) []nodesetup.Step {
+	ones, _ := ipnet.Mask.Size()
+	gateway := netutils.GenerateDefaultGateway(ipnet)
+	return []nodesetup.Step{
+		nodesetup.LinuxBridge("lbr0"),
+		nodesetup.HardwareAddr("lbr0", netutils.GenerateMAC(gateway)),
+		nodesetup.Address("lbr0", fmt.Sprintf("%s/%d", gateway, ones)),
+		nodesetup.VxlanDevice(Device, 0, uint16(tunnel.UDPPort())),
+		nodesetup.MTU(Device, mtu),
+		nodesetup.BridgePort("lbr0", Device),
+		nodesetup.MTU("lbr0", mtu),
+	}
+}
+
+// hostSteps returns the rest of the node setup: the node forwards between
+// pods and lbr0 and masquerades what leaves the cluster network. Pods must
+// keep sending through lbr0 even when the next hop is on lbr0 too.
+func hostSteps(node *nodesetup.Node, containerNetwork string, tunnel api.Tunnel, mtu uint) []nodesetup.Step {
+	return []nodesetup.Step{
+		node.Sysctl("net/ipv4/ip_forward", "1", ""),
+		node.Sysctl("net/ipv4/conf/lbr0/send_redirects", "0", ""),
+		node.IPTables(nodesetup.IPTablesRule{Table: "nat", Chain: "POSTROUTING", Args: []string{"-s", containerNetwork, "!", "-d", containerNetwork, "-j", "MASQUERADE"}}),
+		node.TunnelIPTables(tunnel),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "INPUT", Args: []string{"-i", "lbr0", "-m", "comment", "--comment", "traffic from docker", "-j", "ACCEPT"}, Before: "RELATED,ESTABLISHED"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-d", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.IPTables(nodesetup.IPTablesRule{Table: "filter", Chain: "FORWARD", Args: []string{"-s", containerNetwork, "-j", "ACCEPT"}, Before: "icmp-host-prohibited"}),
+		node.DockerNetwork(nodesetup.DockerNetworkFile, mtu),
+	}
+}
+
+// remoteSubnet is what the node needs to reach a subnet of another node.
+type remoteSubnet struct {
+	subnet  *net.IPNet
+	gateway net.IP
+	mac     net.HardwareAddr
+	node    net.IP
+}
+
+func newRemoteSubnet(minionIP, subnet string) (*remoteSubnet, error) {
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	node := net.ParseIP(minionIP)
+	if node == nil {
+		return nil, fmt.Errorf("Invalid node IP %q", minionIP)
+	}
+	gateway := netutils.GenerateDefaultGateway(ipnet)
+	return &remoteSubnet{subnet: ipnet, gateway: gateway, mac: netutils.GenerateMAC(gateway), node: node}, nil
+}
+
+// links returns the indexes of lbr0 and vxlan0.
+func links() (bridge, device int, err error) {
+	lbr0, err := netlink.LinkByName("lbr0")
+	if err != nil {
+		return 0, 0, err
+	}
+	vxlan0, err := netlink.LinkByName(Device)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lbr0.Index, vxlan0.Index, nil
+}
+
+func (r *remoteSubnet) fdb(device int) *netlink.Neigh {
+	return &netlink.Neigh{LinkIndex: device, IP: r.node, HardwareAddr: r.mac, FDB: true}
+}
+
+func (r *remoteSubnet) arp(bridge int) *netlink.Neigh {
+	return &netlink.Neigh{LinkIndex: bridge, IP: r.gateway, HardwareAddr: r.mac}
+}
+
+func (r *remoteSubnet) route(bridge int) *netlink.Route {
+	return &netlink.Route{Dst: r.subnet, LinkIndex: bridge, Gw: r.gateway, Protocol: RouteProtocol, Flags: syscall.RTNH_F_ONLINK}
+}
+
+// AddOFRules sends the traffic for subnet to minionIP through vxlan0. The
+// local subnet is on lbr0 already.
+func (c *FlowController) AddOFRules(minionIP, subnet, localIP string) error {
+	if minionIP == localIP {
+		return nil
+	}
+	r, err := newRemoteSubnet(minionIP, subnet)
+	if err != nil {
+		return err
+	}
+	bridge, device, err := links()
+	if err != nil {
+		return err
+	}
+	log.Infof("Sending %s to %s through %s", subnet, minionIP, Device)
+	// a subnet that moved to another node only needs its FDB entry replaced
+	if err := netlink.NeighSet(r.fdb(device)); err != nil {
+		return err
+	}
+	if err := netlink.NeighSet(r.arp(bridge)); err != nil {
+		return err
+	}
+	if err := netlink.RouteAdd(r.route(bridge)); err != nil && !netlink.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// DelOFRules removes the route, ARP entry and FDB entry of every subnet of
+// minion.
+func (c *FlowController) DelOFRules(minion, localIP string) error {
+	bridge, device, err := links()
+	if err != nil {
+		return err
+	}
+	fdbs, err := netlink.NeighList(device, true)
+	if err != nil {
+		return err
+	}
+	node := net.ParseIP(minion)
+	macs := map[string]bool{}
+	for _, fdb := range fdbs {
+		if fdb.IP.Equal(node) {
+			macs[fdb.HardwareAddr.String()] = true
+		}
+	}
+	if len(macs) == 0 {
+		return nil
+	}
+	arps, err := netlink.NeighList(bridge, false)
+	if err != nil {
+		return err
+	}
+	gateways := map[string]bool{}
+	for _, arp := range arps {
+		if macs[arp.HardwareAddr.String()] {
+			gateways[arp.IP.String()] = true
+		}
+	}
+	routes, err := nodeRoutes(bridge)
+	if err != nil {
+		return err
+	}
+	for _, route := range routes {
+		if gateways[route.Gw.String()] {
+			log.Infof("Removing the route of %s through %s", route.Dst, minion)
+			if err := netlink.RouteDel(route); err != nil {
+				return err
+			}
+		}
+	}
+	for _, arp := range arps {
+		if gateways[arp.IP.String()] {
+			if err := netlink.NeighDel(arp); err != nil {
+				return err
+			}
+		}
+	}
+	for _, fdb := range fdbs {
+		if macs[fdb.HardwareAddr.String()] {
+			if err := netlink.NeighDel(fdb); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DesiredFlows returns no flows, as there is no br0.
+func (c *FlowController) DesiredFlows(subnets []api.Subnet, pods []podstate.Pod, localIP string) []*ofctl.Flow {
+	return []*ofctl.Flow{}
+}
+
+// SyncRoutes makes the routes, ARP entries and FDB entries for the subnets
+// of other nodes match subnets: it adds missing and fixes wrong entries and
+// removes those of subnets that are gone. It returns how many entries it
+// changed.
+func (c *FlowController) SyncRoutes(subnets []api.Subnet, localIP string) (int, error) {
+	bridge, device, err := links()
+	if err != nil {
+		return 0, err
+	}
+	remote := []*remoteSubnet{}
+	for _, s := range subnets {
+		if s.Minion == localIP {
+			continue
+		}
+		r, err := newRemoteSubnet(s.Minion, s.Sub)
+		if err != nil {
+			log.Warningf("Ignoring subnet %s of node %s: %v", s.Sub, s.Minion, err)
+			continue
+		}
+		remote = append(remote, r)
+	}
+	sort.Slice(remote, func(i, j int) bool { return remote[i].subnet.String() < remote[j].subnet.String() })
+
+	fdbs, err := netlink.NeighList(device, true)
+	if err != nil {
+		return 0, err
+	}
+	arps, err := netlink.NeighList(bridge, false)
+	if err != nil {
+		return 0, err
+	}
+	routes, err := nodeRoutes(bridge)
+	if err != nil {
+		return 0, err
+	}
+	changes := 0
+	desiredFDBs, desiredARPs, desiredRoutes := map[string]*netlink.Neigh{}, map[string]*netlink.Neigh{}, map[string]*netlink.Route{}
+	for _, r := range remote {
+		desiredFDBs[r.mac.String()] = r.fdb(device)
+		desiredARPs[r.gateway.String()] = r.arp(bridge)
+		desiredRoutes[r.subnet.String()] = r.route(bridge)
+	}
+
+	// routes first, so that none is left through a removed ARP entry
+	for _, route := range routes {
+		if want, ok := desiredRoutes[route.Dst.String()]; ok && route.Gw.Equal(want.Gw) {
+			delete(desiredRoutes, route.Dst.String())
+			continue
+		}
+		if err := netlink.RouteDel(route); err != nil {
+			return changes, err
+		}
+		changes++
+	}
+	for _, arp := range arps {
+		if want, ok := desiredARPs[arp.IP.String()]; ok && arp.HardwareAddr.String() == want.HardwareAddr.String() {
+			delete(desiredARPs, arp.IP.String())
+			continue
+		}
+		if err := netlink.NeighDel(arp); err != nil {
+			return changes, err
+		}
+		changes++
+	}
+	for _, fdb := range fdbs {
+		if want, ok := desiredFDBs[fdb.HardwareAddr.String()]; ok && fdb.IP.Equal(want.IP) {
+			delete(desiredFDBs, fdb.HardwareAddr.String())
+			continue
+		}
+		if err := netlink.NeighDel(fdb); err != nil {
+			return changes, err
+		}
+		changes++
+	}
+
+	for _, r := range remote {
+		if fdb, ok := desiredFDBs[r.mac.String()]; ok {
+			if err := netlink.NeighSet(fdb); err != nil {
+				return changes, err
+			}
+			changes++
+		}
+		if arp, ok := desiredARPs[r.gateway.String()]; ok {
+			if err := netlink.NeighSet(arp); err != nil {
+				return changes, err
+			}
+			changes++
+		}
+		if route, ok := desiredRoutes[r.subnet.String()]; ok {
+			if err := netlink.RouteAdd(route); err != nil {
+				return changes, err
+			}
+			changes++
+		}
+	}
+	return changes, nil
+}
+
+// nodeRoutes returns the routes through lbr0 to the subnets of other nodes.
+func nodeRoutes(bridge int) ([]*netlink.Route, error) {
+	all, err := netlink.RouteList(bridge)
+	if err != nil {
+		return nil, err
+	}
+	routes := []*netlink.Route{}
+	for _, route := range all {
+		if route.Protocol == RouteProtocol && route.Dst != nil {
+			routes = append(routes, route)
+		}
+	}
+	return routes, nil
+}
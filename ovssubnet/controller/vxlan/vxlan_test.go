@@ -0,0 +1,312 @@
+package vxlan
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+	"github.com/openshift/openshift-sdn/pkg/netns"
+)
+
+// inNewNetns runs fn in a network namespace of its own, so that links and
+// routes can be added without touching the host.
+func inNewNetns(t *testing.T, fn func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root")
+	}
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			done <- err
+			return
+		}
+		fn()
+		done <- nil
+	}()
+	if err := <-done; err != nil {
+		t.Skipf("Cannot create a network namespace: %v", err)
+	}
+}
+
+// newNetns creates a network namespace held by a locked thread and returns
+// its path and a function that releases it.
+func newNetns(t *testing.T) (string, func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root")
+	}
+	ready := make(chan error)
+	release := make(chan struct{})
+	var path string
+	go func() {
+		// never unlocked: the thread exits with the goroutine
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			ready <- err
+			return
+		}
+		path = fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), syscall.Gettid())
+		ready <- nil
+		<-release
+	}()
+	if err := <-ready; err != nil {
+		t.Skipf("Cannot create a network namespace: %v", err)
+	}
+	return path, func() { close(release) }
+}
+
+func TestSetupSteps(t *testing.T) {
+	expected := []string{
+		"Linux bridge lbr0",
+		"MAC address 02:42:0a:01:02:01 on lbr0",
+		"address 10.1.2.1/24 on lbr0",
+		"vxlan device vxlan0 id 0 port 4789",
+		"MTU 1450 on vxlan0",
+		"port vxlan0 on lbr0",
+		"MTU 1450 on lbr0",
+		"sysctl net.ipv4.ip_forward=1",
+		"sysctl net.ipv4.conf.lbr0.send_redirects=0",
+		"iptables rule -t nat POSTROUTING -s 10.1.0.0/16 ! -d 10.1.0.0/16 -j MASQUERADE",
+		"iptables rule -t filter INPUT -p udp -m multiport --dports 4789 -m comment --comment 001 vxlan incoming -j ACCEPT",
+		"iptables rule -t filter INPUT -i lbr0 -m comment --comment traffic from docker -j ACCEPT",
+		"iptables rule -t filter FORWARD -d 10.1.0.0/16 -j ACCEPT",
+		"iptables rule -t filter FORWARD -s 10.1.0.0/16 -j ACCEPT",
+		"docker network options",
+	}
+	_, ipnet, _ := net.ParseCIDR("10.1.2.0/24")
+	steps := append(linkSteps(ipnet, api.DefaultTunnel, 1450), hostSteps(&nodesetup.Node{}, "10.1.0.0/16", api.DefaultTunnel, 1450)...)
+	names := []string{}
+	for _, step := range steps {
+		names = append(names, step.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Wrong setup steps.\nExpected %q\nGot      %q", expected, names)
+	}
+}
+
+func TestSetupNeedsVXLAN(t *testing.T) {
+	c := NewFlowController(exec.NewFake())
+	c.SetTunnel(api.Tunnel{Type: api.TunnelGeneve})
+	if err := c.Setup("10.1.2.0/24", "10.1.0.0/16", 1450); err == nil {
+		t.Errorf("Expected an error setting up with a geneve tunnel")
+	}
+}
+
+// entries returns the ARP entries, FDB entries and routes for the subnets
+// of other nodes, sorted, as the kernel lists FDB entries in hash order.
+func entries(t *testing.T) []string {
+	bridge, device, err := links()
+	if err != nil {
+		t.Fatalf("Error getting the links: %v", err)
+	}
+	out := []string{}
+	routes, err := nodeRoutes(bridge)
+	if err != nil {
+		t.Fatalf("Error listing routes: %v", err)
+	}
+	for _, route := range routes {
+		out = append(out, "route "+route.Dst.String()+" via "+route.Gw.String())
+	}
+	arps, err := netlink.NeighList(bridge, false)
+	if err != nil {
+		t.Fatalf("Error listing ARP entries: %v", err)
+	}
+	for _, arp := range arps {
+		out = append(out, "arp "+arp.IP.String()+" "+arp.HardwareAddr.String())
+	}
+	fdbs, err := netlink.NeighList(device, true)
+	if err != nil {
+		t.Fatalf("Error listing FDB entries: %v", err)
+	}
+	for _, fdb := range fdbs {
+		out = append(out, "fdb "+fdb.HardwareAddr.String()+" "+fdb.IP.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestRemoteSubnets(t *testing.T) {
+	inNewNetns(t, func() {
+		_, ipnet, _ := net.ParseCIDR("10.1.2.0/24")
+		if err := nodesetup.Run(linkSteps(ipnet, api.DefaultTunnel, 1450)); err != nil {
+			t.Errorf("Error setting up the links: %v", err)
+			return
+		}
+
+		c := NewFlowController(exec.NewFake())
+		subnets := []api.Subnet{
+			{Minion: "192.168.1.10", Sub: "10.1.2.0/24"},
+			{Minion: "192.168.1.11", Sub: "10.1.3.0/24"},
+		}
+		if changes, err := c.SyncRoutes(subnets, "192.168.1.10"); err != nil || changes != 3 {
+			t.Errorf("Wrong sync result %d (%v)", changes, err)
+		}
+		expected := []string{
+			"arp 10.1.3.1 02:42:0a:01:03:01",
+			"fdb 02:42:0a:01:03:01 192.168.1.11",
+			"route 10.1.3.0/24 via 10.1.3.1",
+		}
+		if got := entries(t); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Wrong entries after sync.\nExpected %q\nGot      %q", expected, got)
+		}
+		if changes, err := c.SyncRoutes(subnets, "192.168.1.10"); err != nil || changes != 0 {
+			t.Errorf("Wrong second sync result %d (%v)", changes, err)
+		}
+
+		if err := c.AddOFRules("192.168.1.10", "10.1.2.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error for the local node: %v", err)
+		}
+		if err := c.AddOFRules("192.168.1.12", "10.1.4.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error adding a subnet: %v", err)
+		}
+		if err := c.AddOFRules("192.168.1.11", "10.1.5.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error adding a subnet: %v", err)
+		}
+		// the subnet moves to another node
+		if err := c.AddOFRules("192.168.1.12", "10.1.3.0/24", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error moving a subnet: %v", err)
+		}
+		expected = []string{
+			"arp 10.1.3.1 02:42:0a:01:03:01",
+			"arp 10.1.4.1 02:42:0a:01:04:01",
+			"arp 10.1.5.1 02:42:0a:01:05:01",
+			"fdb 02:42:0a:01:03:01 192.168.1.12",
+			"fdb 02:42:0a:01:04:01 192.168.1.12",
+			"fdb 02:42:0a:01:05:01 192.168.1.11",
+			"route 10.1.3.0/24 via 10.1.3.1",
+			"route 10.1.4.0/24 via 10.1.4.1",
+			"route 10.1.5.0/24 via 10.1.5.1",
+		}
+		if got := entries(t); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Wrong entries after adding.\nExpected %q\nGot      %q", expected, got)
+		}
+
+		if err := c.DelOFRules("192.168.1.12", "192.168.1.10"); err != nil {
+			t.Errorf("Unexpected error deleting a node: %v", err)
+		}
+		expected = []string{
+			"arp 10.1.5.1 02:42:0a:01:05:01",
+			"fdb 02:42:0a:01:05:01 192.168.1.11",
+			"route 10.1.5.0/24 via 10.1.5.1",
+		}
+		if got := entries(t); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Wrong entries after deleting.\nExpected %q\nGot      %q", expected, got)
+		}
+
+		// 10.1.5.0/24 is gone from the registry and 10.1.3.0/24 back
+		if changes, err := c.SyncRoutes(subnets, "192.168.1.10"); err != nil || changes != 6 {
+			t.Errorf("Wrong repair result %d (%v)", changes, err)
+		}
+		expected = []string{
+			"arp 10.1.3.1 02:42:0a:01:03:01",
+			"fdb 02:42:0a:01:03:01 192.168.1.11",
+			"route 10.1.3.0/24 via 10.1.3.1",
+		}
+		if got := entries(t); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Wrong entries after repair.\nExpected %q\nGot      %q", expected, got)
+		}
+	})
+}
+
+// setUpNode gives the node in the namespace at path the address ip on
+// link, sets up its links for subnet and sends the subnet of the other node
+// through vxlan0.
+func setUpNode(path, link, ip, subnet, otherIP, otherSubnet string) error {
+	return netns.DoPath(path, func() error {
+		eth, err := netlink.LinkByName(link)
+		if err != nil {
+			return err
+		}
+		if err := netlink.AddrAdd(eth.Index, &net.IPNet{IP: net.ParseIP(ip), Mask: net.CIDRMask(24, 32)}); err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(eth.Index); err != nil {
+			return err
+		}
+		_, ipnet, _ := net.ParseCIDR(subnet)
+		if err := nodesetup.Run(linkSteps(ipnet, api.DefaultTunnel, 1450)); err != nil {
+			return err
+		}
+		return NewFlowController(exec.NewFake()).AddOFRules(otherIP, otherSubnet, ip)
+	})
+}
+
+func TestDatapath(t *testing.T) {
+	pathA, releaseA := newNetns(t)
+	defer releaseA()
+	pathB, releaseB := newNetns(t)
+	defer releaseB()
+
+	// the two nodes share a network through a veth pair
+	err := netns.DoPath(pathA, func() error {
+		if err := netlink.LinkAddVeth("eth0", "eth1"); err != nil {
+			return err
+		}
+		peer, err := netlink.LinkByName("eth1")
+		if err != nil {
+			return err
+		}
+		ns, err := os.Open(pathB)
+		if err != nil {
+			return err
+		}
+		defer ns.Close()
+		return netlink.LinkSetNsFd(peer.Index, int(ns.Fd()))
+	})
+	if err != nil {
+		t.Fatalf("Error connecting the nodes: %v", err)
+	}
+	if err := setUpNode(pathA, "eth0", "192.168.1.10", "10.1.2.0/24", "192.168.1.11", "10.1.3.0/24"); err != nil {
+		t.Fatalf("Error setting up node A: %v", err)
+	}
+	if err := setUpNode(pathB, "eth1", "192.168.1.11", "10.1.3.0/24", "192.168.1.10", "10.1.2.0/24"); err != nil {
+		t.Fatalf("Error setting up node B: %v", err)
+	}
+
+	// the gateway of each subnet answers for the node
+	var server, client net.PacketConn
+	err = netns.DoPath(pathB, func() error {
+		server, err = net.ListenPacket("udp4", "10.1.3.1:7000")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error listening on node B: %v", err)
+	}
+	defer server.Close()
+	err = netns.DoPath(pathA, func() error {
+		client, err = net.ListenPacket("udp4", "10.1.2.1:0")
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Error listening on node A: %v", err)
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	server.SetDeadline(deadline)
+	client.SetDeadline(deadline)
+	buf := make([]byte, 16)
+	if _, err := client.WriteTo([]byte("ping"), &net.UDPAddr{IP: net.ParseIP("10.1.3.1"), Port: 7000}); err != nil {
+		t.Fatalf("Error sending from node A: %v", err)
+	}
+	n, from, err := server.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "ping" || from.(*net.UDPAddr).IP.String() != "10.1.2.1" {
+		t.Fatalf("Node B did not get the packet through vxlan0: %q from %v (%v)", buf[:n], from, err)
+	}
+	if _, err := server.WriteTo([]byte("pong"), from); err != nil {
+		t.Fatalf("Error replying from node B: %v", err)
+	}
+	if n, _, err = client.ReadFrom(buf); err != nil || string(buf[:n]) != "pong" {
+		t.Fatalf("Node A did not get the reply through vxlan0: %q (%v)", buf[:n], err)
+	}
+}
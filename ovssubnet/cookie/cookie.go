@@ -0,0 +1,148 @@
+// Package cookie lays out the OpenFlow cookies of the flows the SDN installs
+// on br0, so that the owner of any flow can be told from its cookie alone:
+//
+//	bits 56-63  kind of owner: system, node, pod, namespace, policy,
+//	            service or endpoint
+//	bits 32-55  generation, for replacing an owner's flows with a new set
+//	bits  0-31  identity of the owner within its kind: the IPv4 address of
+//	            a node, the OpenFlow port of a pod, the VNID of a namespace,
+//	            the number of a policy, the group of a service port (0 for
+//	            the flows all services share) or the IPv4 address of a
+//	            service endpoint
+//
+// The kinds are part of the cookies on the switch and of the pod hook
+// scripts, so their values must not change.
+package cookie
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+)
+
+// Kind is the kind of owner of a flow.
+type Kind uint8
+
+const (
+	// None is the kind of cookie 0, used by learned flows, and of the
+	// cookies of older releases.
+	None Kind = iota
+	// System flows are the base flows node setup installs.
+	System
+	Node
+	Pod
+	Namespace
+	Policy
+	Service
+	Endpoint
+)
+
+func (k Kind) String() string {
+	switch k {
+	case None:
+		return "none"
+	case System:
+		return "system"
+	case Node:
+		return "node"
+	case Pod:
+		return "pod"
+	case Namespace:
+		return "namespace"
+	case Policy:
+		return "policy"
+	case Service:
+		return "service"
+	case Endpoint:
+		return "endpoint"
+	}
+	return fmt.Sprintf("kind%d", uint8(k))
+}
+
+const (
+	kindShift       = 56
+	generationShift = 32
+	generationMask  = 1<<24 - 1
+
+	// OwnerMask selects the kind and identity of a cookie, so that a match
+	// with it finds an owner's flows of every generation.
+	OwnerMask uint64 = 0xff000000ffffffff
+	// KindMask selects the kind of a cookie.
+	KindMask uint64 = 0xff << kindShift
+)
+
+// Cookie is the cookie of a flow.
+type Cookie uint64
+
+// New returns the cookie of generation 0 of the flows of owner id of kind.
+func New(kind Kind, id uint32) Cookie {
+	return Cookie(uint64(kind)<<kindShift | uint64(id))
+}
+
+// ForNode returns the cookie of the flows for the node with IPv4 address ip,
+// or a cookie of no kind if ip is not one.
+func ForNode(ip string) Cookie {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return 0
+	}
+	return New(Node, netutils.IPToUint32(addr))
+}
+
+// ForPod returns the cookie of the flows for the pod on OpenFlow port ofport.
+func ForPod(ofport int) Cookie {
+	return New(Pod, uint32(ofport))
+}
+
+// ForNamespace returns the cookie of the flows for the namespaces with vnid.
+func ForNamespace(vnid uint) Cookie {
+	return New(Namespace, uint32(vnid))
+}
+
+// ForService returns the cookie of the flows for the service port load
+// balanced by group, or for the flows all services share if group is 0.
+func ForService(group uint32) Cookie {
+	return New(Service, group)
+}
+
+// ForEndpoint returns the cookie of the flows for the local service endpoint
+// with IPv4 address ip, or a cookie of no kind if ip is not one.
+func ForEndpoint(ip string) Cookie {
+	addr := net.ParseIP(ip)
+	if addr == nil || addr.To4() == nil {
+		return 0
+	}
+	return New(Endpoint, netutils.IPToUint32(addr))
+}
+
+// WithGeneration returns c with generation g, which is truncated to 24 bits.
+func (c Cookie) WithGeneration(g uint32) Cookie {
+	return Cookie(uint64(c)&OwnerMask | uint64(g&generationMask)<<generationShift)
+}
+
+// Kind returns the kind of owner of c.
+func (c Cookie) Kind() Kind {
+	return Kind(uint64(c) >> kindShift)
+}
+
+// Generation returns the generation of c.
+func (c Cookie) Generation() uint32 {
+	return uint32(uint64(c)>>generationShift) & generationMask
+}
+
+// ID returns the identity of the owner of c within its kind.
+func (c Cookie) ID() uint32 {
+	return uint32(c)
+}
+
+// String formats c as ovs-ofctl does.
+func (c Cookie) String() string {
+	return fmt.Sprintf("0x%x", uint64(c))
+}
+
+// Match returns the ovs-ofctl match on the flows of the owner of c, of any
+// generation, e.g. for del-flows.
+func (c Cookie) Match() string {
+	return fmt.Sprintf("cookie=0x%x/0x%x", uint64(c)&OwnerMask, OwnerMask)
+}
@@ -0,0 +1,46 @@
+package cookie
+
+import (
+	"testing"
+)
+
+func TestCookies(t *testing.T) {
+	tests := []struct {
+		cookie Cookie
+		kind   Kind
+		id     uint32
+		value  string
+	}{
+		{ForNode("172.17.0.3"), Node, 0xac110003, "0x2000000ac110003"},
+		{ForPod(3), Pod, 3, "0x300000000000003"},
+		{ForNamespace(10), Namespace, 10, "0x40000000000000a"},
+		{New(System, 0), System, 0, "0x100000000000000"},
+		{ForService(2), Service, 2, "0x600000000000002"},
+		{ForEndpoint("10.1.2.2"), Endpoint, 0x0a010202, "0x70000000a010202"},
+		{ForNode("bogus"), None, 0, "0x0"},
+		// older releases used the node address or the pod port alone
+		{Cookie(0xac110003), None, 0xac110003, "0xac110003"},
+	}
+	for _, test := range tests {
+		if test.cookie.Kind() != test.kind || test.cookie.ID() != test.id || test.cookie.String() != test.value {
+			t.Errorf("Wrong cookie %s: kind %s, id %x, expected %s %s %x", test.cookie, test.cookie.Kind(), test.cookie.ID(), test.value, test.kind, test.id)
+		}
+	}
+}
+
+func TestGenerations(t *testing.T) {
+	c := ForPod(3).WithGeneration(7)
+	if c.Kind() != Pod || c.ID() != 3 || c.Generation() != 7 {
+		t.Fatalf("Wrong cookie %s: kind %s, id %d, generation %d", c, c.Kind(), c.ID(), c.Generation())
+	}
+	if g := c.WithGeneration(1 << 24).Generation(); g != 0 {
+		t.Errorf("Expected the generation to wrap, got %d", g)
+	}
+	// a pod and a node whose address ends in the port number differ in kind
+	if ForPod(3).Match() == ForNode("0.0.0.3").Match() {
+		t.Errorf("Pod and node cookies collide")
+	}
+	if m := c.Match(); m != "cookie=0x300000000000003/0xff000000ffffffff" {
+		t.Errorf("Wrong match %s", m)
+	}
+}
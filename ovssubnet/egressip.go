@@ -0,0 +1,175 @@
+package ovssubnet
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+)
+
+const (
+	// nodeHeartbeatInterval is how often a node tells the master it is
+	// alive, and nodeHeartbeatTTL how long the master believes it
+	nodeHeartbeatInterval = 10 * time.Second
+	nodeHeartbeatTTL      = 30 * time.Second
+)
+
+// heartbeat keeps the node's heartbeat in the registry alive, so that the
+// master leaves the egress IPs the node owns with it.
+func (oc *OvsController) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := oc.subnetRegistry.WriteNodeHeartbeat(oc.localIP, uint64(nodeHeartbeatTTL/time.Second)); err != nil {
+			log.Errorf("Failed to write the heartbeat of this node: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-oc.sig:
+			log.Error("Signal received. Stopping the node heartbeat.")
+			return
+		}
+	}
+}
+
+// assignEgressIPs gives every egress IP an owner among its eligible nodes
+// that are alive, and moves it to another one when the owner's heartbeat
+// expires.
+func (oc *OvsController) assignEgressIPs() {
+	heartbeatEvent := make(chan *api.MinionEvent)
+	egressIPEvent := make(chan *api.EgressIPEvent)
+	stopHeartbeats := make(chan bool)
+	stopEgressIPs := make(chan bool)
+	go oc.subnetRegistry.WatchNodeHeartbeats(heartbeatEvent, stopHeartbeats)
+	go oc.subnetRegistry.WatchEgressIPs(egressIPEvent, stopEgressIPs)
+
+	alive := map[string]bool{}
+	nodes, err := oc.subnetRegistry.GetNodeHeartbeats()
+	if err != nil {
+		log.Errorf("Failed to get the node heartbeats: %v", err)
+	}
+	for _, node := range nodes {
+		alive[node] = true
+	}
+	egressIPs := map[string]api.EgressIP{}
+	list, err := oc.subnetRegistry.GetEgressIPs()
+	if err != nil {
+		log.Errorf("Failed to get the egress IPs: %v", err)
+	}
+	for _, eip := range list {
+		egressIPs[eip.Namespace] = eip
+	}
+	oc.reassignEgressIPs(egressIPs, alive)
+
+	for {
+		select {
+		case ev := <-heartbeatEvent:
+			switch ev.Type {
+			case api.Added:
+				alive[ev.Minion] = true
+			case api.Deleted:
+				log.Infof("Heartbeat of node %s expired", ev.Minion)
+				delete(alive, ev.Minion)
+			}
+			oc.reassignEgressIPs(egressIPs, alive)
+		case ev := <-egressIPEvent:
+			switch ev.Type {
+			case api.Added:
+				egressIPs[ev.EgressIP.Namespace] = ev.EgressIP
+			case api.Deleted:
+				delete(egressIPs, ev.EgressIP.Namespace)
+			}
+			oc.reassignEgressIPs(egressIPs, alive)
+		case <-oc.sig:
+			log.Error("Signal received. Stopping the assignment of egress IPs.")
+			stopHeartbeats <- true
+			stopEgressIPs <- true
+			return
+		}
+	}
+}
+
+// reassignEgressIPs records a new owner for the egress IPs whose owner is no
+// longer the right one, updating egressIPs as they are written.
+func (oc *OvsController) reassignEgressIPs(egressIPs map[string]api.EgressIP, alive map[string]bool) {
+	namespaces := make([]string, 0, len(egressIPs))
+	for namespace := range egressIPs {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		eip := egressIPs[namespace]
+		if err := eip.Validate(); err != nil {
+			log.Errorf("Ignoring egress IP: %v", err)
+			continue
+		}
+		owner := egressIPOwner(&eip, alive)
+		if owner == eip.Node {
+			continue
+		}
+		log.Infof("Moving egress IP %s of namespace %s from node %q to %q", eip.IP, eip.Namespace, eip.Node, owner)
+		eip.Node = owner
+		if err := oc.subnetRegistry.WriteEgressIP(eip); err != nil {
+			log.Errorf("Failed to write the owner of egress IP %s: %v", eip.IP, err)
+			continue
+		}
+		egressIPs[namespace] = eip
+	}
+}
+
+// egressIPOwner returns the node that should own the egress IP: the current
+// owner while it is alive and eligible, so that the IP does not move back and
+// forth, or else the first eligible node that is alive. It is empty while no
+// eligible node is alive.
+func egressIPOwner(eip *api.EgressIP, alive map[string]bool) string {
+	if alive[eip.Node] && eip.Eligible(eip.Node) {
+		return eip.Node
+	}
+	for _, node := range eip.Nodes {
+		if alive[node] {
+			return node
+		}
+	}
+	return ""
+}
+
+// watchEgressIPs applies changes to egress IPs and their owners to br0 and
+// to the host as they happen.
+func (oc *OvsController) watchEgressIPs() {
+	egressIPEvent := make(chan *api.EgressIPEvent)
+	stop := make(chan bool)
+	go oc.subnetRegistry.WatchEgressIPs(egressIPEvent, stop)
+	for {
+		select {
+		case ev := <-egressIPEvent:
+			oc.vnidLock.Lock()
+			switch ev.Type {
+			case api.Added:
+				oc.setEgressIP(ev.EgressIP)
+			case api.Deleted:
+				delete(oc.egressIPs, ev.EgressIP.Namespace)
+			}
+			oc.vnidLock.Unlock()
+			oc.updatePolicies()
+			if err := oc.syncFlows(false); err != nil {
+				log.Errorf("Failed to apply egress IP change, it will be applied by reconciliation: %v", err)
+			}
+		case <-oc.sig:
+			log.Error("Signal received. Stopping watching of egress IPs.")
+			stop <- true
+			return
+		}
+	}
+}
+
+// setEgressIP adds or replaces an egress IP, ignoring invalid ones. The
+// caller holds vnidLock.
+func (oc *OvsController) setEgressIP(eip api.EgressIP) {
+	if err := eip.Validate(); err != nil {
+		log.Errorf("Ignoring egress IP: %v", err)
+		return
+	}
+	oc.egressIPs[eip.Namespace] = eip
+}
@@ -0,0 +1,72 @@
+package ovssubnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+)
+
+func (r *fakeRegistry) WriteEgressIP(eip api.EgressIP) error {
+	r.egressIPs = append(r.egressIPs, eip)
+	return nil
+}
+
+func TestReassignEgressIPs(t *testing.T) {
+	registry := &fakeRegistry{}
+	oc := &OvsController{subnetRegistry: registry}
+	egressIPs := map[string]api.EgressIP{
+		"bank": {Namespace: "bank", IP: "192.168.1.100", Nodes: []string{"172.17.0.2", "172.17.0.3"}},
+		"shop": {Namespace: "shop", IP: "192.168.1.101", Nodes: []string{"172.17.0.3"}, Node: "172.17.0.3"},
+	}
+
+	steps := []struct {
+		name   string
+		alive  []string
+		owners map[string]string
+		writes int
+	}{
+		{
+			name:   "first eligible node alive",
+			alive:  []string{"172.17.0.2", "172.17.0.3"},
+			owners: map[string]string{"bank": "172.17.0.2", "shop": "172.17.0.3"},
+			writes: 1,
+		},
+		{
+			name:   "owner of bank expires",
+			alive:  []string{"172.17.0.3"},
+			owners: map[string]string{"bank": "172.17.0.3", "shop": "172.17.0.3"},
+			writes: 1,
+		},
+		{
+			// the egress IP stays where it is
+			name:   "first node of bank is back",
+			alive:  []string{"172.17.0.2", "172.17.0.3"},
+			owners: map[string]string{"bank": "172.17.0.3", "shop": "172.17.0.3"},
+		},
+		{
+			name:   "owner of both expires",
+			alive:  []string{"172.17.0.2"},
+			owners: map[string]string{"bank": "172.17.0.2", "shop": ""},
+			writes: 2,
+		},
+	}
+	for _, step := range steps {
+		registry.egressIPs = nil
+		alive := map[string]bool{}
+		for _, node := range step.alive {
+			alive[node] = true
+		}
+		oc.reassignEgressIPs(egressIPs, alive)
+		owners := map[string]string{}
+		for namespace, eip := range egressIPs {
+			owners[namespace] = eip.Node
+		}
+		if !reflect.DeepEqual(owners, step.owners) {
+			t.Errorf("%s: wrong owners.\nExpected %v\nGot      %v", step.name, step.owners, owners)
+		}
+		if len(registry.egressIPs) != step.writes {
+			t.Errorf("%s: expected %d writes, got %v", step.name, step.writes, registry.egressIPs)
+		}
+	}
+}
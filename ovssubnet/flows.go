@@ -0,0 +1,96 @@
+package ovssubnet
+
+import (
+	"sort"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// flowGroup identifies the flows with one cookie in one table, which is the
+// unit the node and pod flows are installed and removed in.
+type flowGroup struct {
+	table  int
+	cookie uint64
+}
+
+func groupOf(f *ofctl.Flow) flowGroup {
+	return flowGroup{table: f.Table, cookie: f.Cookie}
+}
+
+// flowRepair replaces the flows of one group with the desired ones, or
+// removes the group when flows is empty.
+type flowRepair struct {
+	flowGroup
+	flows  []*ofctl.Flow
+	reason string
+}
+
+// diffFlows compares the desired flows with dump-flows output and returns the
+// repairs needed to make the switch match. Only flows owned by nodes, pods,
+// namespaces and policies are managed: system flows belong to node setup and
+// cookie 0 to learned flows, which expire on their own. Flows with cookies of
+// older releases have no owner and are removed as stray.
+func diffFlows(desired []*ofctl.Flow, dump string) ([]flowRepair, error) {
+	want := make(map[flowGroup][]*ofctl.Flow)
+	for _, f := range desired {
+		want[groupOf(f)] = append(want[groupOf(f)], f)
+	}
+
+	dumped, err := ofctl.ParseDump(dump)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[flowGroup][]*ofctl.Flow)
+	for _, f := range dumped {
+		if f.Cookie == 0 || cookie.Cookie(f.Cookie).Kind() == cookie.System || f.IdleTimeout != 0 || f.HardTimeout != 0 {
+			continue
+		}
+		have[groupOf(f)] = append(have[groupOf(f)], f)
+	}
+
+	repairs := []flowRepair{}
+	for group, flows := range want {
+		actual, ok := have[group]
+		switch {
+		case !ok:
+			repairs = append(repairs, flowRepair{group, flows, "missing"})
+		case !sameFlows(flows, actual):
+			repairs = append(repairs, flowRepair{group, flows, "different"})
+		}
+	}
+	for group := range have {
+		if _, ok := want[group]; !ok {
+			repairs = append(repairs, flowRepair{group, nil, "stray"})
+		}
+	}
+	sort.Slice(repairs, func(i, j int) bool {
+		if repairs[i].table != repairs[j].table {
+			return repairs[i].table < repairs[j].table
+		}
+		return repairs[i].cookie < repairs[j].cookie
+	})
+	return repairs, nil
+}
+
+// sameFlows tells whether a and b hold the same flows in any order.
+func sameFlows(a, b []*ofctl.Flow) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	canonical := func(flows []*ofctl.Flow) []string {
+		s := make([]string, 0, len(flows))
+		for _, f := range flows {
+			s = append(s, f.Canonical().String())
+		}
+		sort.Strings(s)
+		return s
+	}
+	ca, cb := canonical(a), canonical(b)
+	for i := range ca {
+		if ca[i] != cb[i] {
+			return false
+		}
+	}
+	return true
+}
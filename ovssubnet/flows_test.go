@@ -0,0 +1,52 @@
+package ovssubnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+func parseFlows(t *testing.T, flows []string) []*ofctl.Flow {
+	parsed := []*ofctl.Flow{}
+	for _, flow := range flows {
+		f, err := ofctl.ParseFlow(flow)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", flow, err)
+		}
+		parsed = append(parsed, f)
+	}
+	return parsed
+}
+
+func TestDiffFlows(t *testing.T) {
+	desired := []string{
+		// intact
+		"table=6,cookie=0xac110003,priority=100,ip,nw_dst=10.1.3.0/24,actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1",
+		// missing
+		"table=6,cookie=0xac110004,priority=100,ip,nw_dst=10.1.4.0/24,actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.4->tun_dst,output:1",
+		// different VNID
+		"table=5,cookie=0x3,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3",
+	}
+	dump := `OFPST_FLOW reply (OF1.3) (xid=0x2):
+ cookie=0x0, duration=100.1s, table=0, n_packets=5, n_bytes=400, actions=learn(table=7,hard_timeout=900,priority=200,NXM_OF_ETH_DST[]=NXM_OF_ETH_SRC[],load:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],output:NXM_OF_IN_PORT[]),goto_table:1
+ cookie=0x0, duration=10.1s, table=7, n_packets=5, n_bytes=400, hard_timeout=900, idle_age=2, priority=200,dl_dst=02:42:0a:01:02:02 actions=load:0xac110003->NXM_NX_TUN_IPV4_DST[],output:1
+ cookie=0x100000000000000, duration=100.1s, table=4, n_packets=0, n_bytes=0, priority=0,ip actions=output:2
+ cookie=0xac110003, duration=100.1s, table=6, n_packets=0, n_bytes=0, priority=100,ip,nw_dst=10.1.3.0/24 actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1
+ cookie=0x3, duration=100.1s, table=5, n_packets=0, n_bytes=0, priority=100,ip,reg0=11,nw_dst=10.1.2.2 actions=output:3
+ cookie=0xac110005, duration=100.1s, table=7, n_packets=0, n_bytes=0, priority=100,arp,arp_tpa=10.1.5.0/24 actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.5->tun_dst,output:1
+`
+	desiredFlows := parseFlows(t, desired)
+	repairs, err := diffFlows(desiredFlows, dump)
+	if err != nil {
+		t.Fatalf("Error diffing flows: %v", err)
+	}
+	expected := []flowRepair{
+		{flowGroup{5, 0x3}, desiredFlows[2:3], "different"},
+		{flowGroup{6, 0xac110004}, desiredFlows[1:2], "missing"},
+		{flowGroup{7, 0xac110005}, nil, "stray"},
+	}
+	if !reflect.DeepEqual(repairs, expected) {
+		t.Fatalf("Wrong repairs.\nExpected %+v\nGot      %+v", expected, repairs)
+	}
+}
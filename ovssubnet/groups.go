@@ -0,0 +1,99 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	log "github.com/golang/glog"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// groupRepair adds, replaces or removes one group: command is "add-group",
+// "mod-group" or "del-groups".
+type groupRepair struct {
+	command string
+	group   *ofctl.Group
+	reason  string
+}
+
+// diffGroups compares the desired groups with dump-groups output and returns
+// the repairs needed to make the switch match, those that remove groups last
+// so that they can be applied after the flows that use the groups are gone.
+func diffGroups(desired []*ofctl.Group, dump string) ([]groupRepair, error) {
+	dumped, err := ofctl.ParseGroupDump(dump)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[uint32]*ofctl.Group)
+	for _, g := range dumped {
+		have[g.ID] = g
+	}
+	repairs := []groupRepair{}
+	want := make(map[uint32]bool)
+	for _, g := range desired {
+		want[g.ID] = true
+		actual, ok := have[g.ID]
+		switch {
+		case !ok:
+			repairs = append(repairs, groupRepair{"add-group", g, "missing"})
+		case !g.Equal(actual):
+			repairs = append(repairs, groupRepair{"mod-group", g, "different"})
+		}
+	}
+	stray := []groupRepair{}
+	for id, g := range have {
+		if !want[id] {
+			stray = append(stray, groupRepair{"del-groups", g, "stray"})
+		}
+	}
+	sort.Slice(stray, func(i, j int) bool { return stray[i].group.ID < stray[j].group.ID })
+	return append(repairs, stray...), nil
+}
+
+// syncGroups brings the groups of br0 in line with desired like syncFlows.
+// Groups must exist before the flows that use them and deleting a group
+// deletes those flows, so the flows are synced in between by syncBridge,
+// which gets the groups added before and removes them after.
+func (oc *OvsController) syncGroups(desired []*ofctl.Group, repair bool, syncBridge func() error) error {
+	out, err := oc.executor.Exec("ovs-ofctl", "-O", "OpenFlow13", "dump-groups", "br0")
+	if err != nil {
+		return fmt.Errorf("Could not dump groups: %v (%s)", err, out)
+	}
+	repairs, err := diffGroups(desired, string(out))
+	if err != nil {
+		return err
+	}
+	i := 0
+	for ; i < len(repairs) && repairs[i].command != "del-groups"; i++ {
+		if err := oc.applyGroupRepair(repairs[i], repair); err != nil {
+			return err
+		}
+	}
+	if err := syncBridge(); err != nil {
+		return err
+	}
+	for ; i < len(repairs); i++ {
+		if err := oc.applyGroupRepair(repairs[i], repair); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (oc *OvsController) applyGroupRepair(r groupRepair, repair bool) error {
+	group := r.group.String()
+	if r.command == "del-groups" {
+		group = fmt.Sprintf("group_id=%d", r.group.ID)
+	}
+	if out, err := oc.executor.Exec("ovs-ofctl", "-O", "OpenFlow13", r.command, "br0", group); err != nil {
+		return fmt.Errorf("Failed to repair group %d: %v (%s)", r.group.ID, err, out)
+	}
+	if !repair {
+		log.Infof("Updated group %d", r.group.ID)
+		return nil
+	}
+	repaired := atomic.AddUint64(&oc.flowRepairs, 1)
+	log.Warningf("Repaired %s group %d (%d repairs so far)", r.reason, r.group.ID, repaired)
+	return nil
+}
@@ -0,0 +1,53 @@
+package ovssubnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+func TestSyncGroups(t *testing.T) {
+	dump := `OFPST_GROUP_DESC reply (OF1.3) (xid=0x2):
+ group_id=1,type=select,bucket=weight:100,actions=ct(commit,zone=1,nat(dst=10.1.2.2:8080),table=4)
+ group_id=2,type=select,bucket=weight:100,actions=ct(commit,zone=1,nat(dst=10.1.3.3:53),table=4)
+ group_id=3,type=select,bucket=weight:100,actions=ct(commit,zone=1,nat(dst=10.1.3.4:53),table=4)
+`
+	desired := []*ofctl.Group{}
+	for _, g := range []string{
+		// the same but for the weights
+		"group_id=1,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.2.2:8080),table=4)",
+		"group_id=2,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.3.5:53),table=4)",
+		"group_id=4,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.2.3:5432),table=4)",
+	} {
+		group, err := ofctl.ParseGroup(g)
+		if err != nil {
+			t.Fatalf("Error parsing group %q: %v", g, err)
+		}
+		desired = append(desired, group)
+	}
+
+	executor := exec.NewFake(exec.FakeResult{Output: dump})
+	oc := &OvsController{executor: executor}
+	err := oc.syncGroups(desired, true, func() error {
+		executor.Exec("sync-flows")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error syncing groups: %v", err)
+	}
+	expected := []string{
+		"ovs-ofctl -O OpenFlow13 dump-groups br0",
+		"ovs-ofctl -O OpenFlow13 mod-group br0 group_id=2,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.3.5:53),table=4)",
+		"ovs-ofctl -O OpenFlow13 add-group br0 group_id=4,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.2.3:5432),table=4)",
+		"sync-flows",
+		"ovs-ofctl -O OpenFlow13 del-groups br0 group_id=3",
+	}
+	if commands := executor.CommandLines(); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Wrong group commands.\nExpected %q\nGot      %q", expected, commands)
+	}
+	if oc.flowRepairs != 3 {
+		t.Errorf("Expected 3 repairs, got %d", oc.flowRepairs)
+	}
+}
@@ -0,0 +1,121 @@
+package ovssubnet
+
+import (
+	"sort"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+)
+
+// watchMulticastNamespaces applies namespaces enabling and disabling
+// multicast to br0 as it happens.
+func (oc *OvsController) watchMulticastNamespaces() {
+	namespaceEvent := make(chan *api.NamespaceEvent)
+	stop := make(chan bool)
+	go oc.subnetRegistry.WatchMulticastNamespaces(namespaceEvent, stop)
+	for {
+		select {
+		case ev := <-namespaceEvent:
+			oc.vnidLock.Lock()
+			switch ev.Type {
+			case api.Added:
+				oc.multicastNamespaces[ev.Name] = true
+			case api.Deleted:
+				delete(oc.multicastNamespaces, ev.Name)
+			}
+			oc.vnidLock.Unlock()
+			oc.updatePolicies()
+			if err := oc.syncFlows(false); err != nil {
+				log.Errorf("Failed to apply multicast change, it will be applied by reconciliation: %v", err)
+			}
+		case <-oc.sig:
+			log.Error("Signal received. Stopping watching of multicast namespaces.")
+			stop <- true
+			return
+		}
+	}
+}
+
+// watchNodeVNIDs updates the multicast groups of br0 as pods of a VNID come
+// to and go from nodes, this one included.
+func (oc *OvsController) watchNodeVNIDs() {
+	nodeEvent := make(chan *api.NodeVNIDsEvent)
+	stop := make(chan bool)
+	go oc.subnetRegistry.WatchNodeVNIDs(nodeEvent, stop)
+	for {
+		select {
+		case ev := <-nodeEvent:
+			oc.vnidLock.Lock()
+			switch ev.Type {
+			case api.Added:
+				oc.nodeVNIDs[ev.NodeVNIDs.Node] = ev.NodeVNIDs
+			case api.Deleted:
+				delete(oc.nodeVNIDs, ev.NodeVNIDs.Node)
+			}
+			oc.vnidLock.Unlock()
+			oc.updateNodeVNIDs()
+			if err := oc.syncFlows(false); err != nil {
+				log.Errorf("Failed to update multicast groups, reconciliation will retry: %v", err)
+			}
+		case <-oc.sig:
+			log.Error("Signal received. Stopping watching of node VNIDs.")
+			stop <- true
+			return
+		}
+	}
+}
+
+// updateNodeVNIDs hands the VNIDs of the pods of every node to the flow
+// controller, for groups computed from now on.
+func (oc *OvsController) updateNodeVNIDs() {
+	fc, ok := oc.flowController.(*multitenant.FlowController)
+	if !ok {
+		return
+	}
+	oc.vnidLock.Lock()
+	keys := make([]string, 0, len(oc.nodeVNIDs))
+	for key := range oc.nodeVNIDs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	nodes := make([]api.NodeVNIDs, 0, len(keys))
+	for _, key := range keys {
+		nodes = append(nodes, oc.nodeVNIDs[key])
+	}
+	oc.vnidLock.Unlock()
+	fc.SetNodeVNIDs(nodes)
+}
+
+// publishNodeVNIDs records the VNIDs of the local pods in the registry. The
+// pod hook does so as pods come and go; the node does at start, when VNIDs
+// of namespaces change and periodically, in case the hook failed to.
+func (oc *OvsController) publishNodeVNIDs() {
+	pods, err := oc.localPods()
+	if err != nil {
+		log.Errorf("Failed to publish the VNIDs of this node: %v", err)
+		return
+	}
+	if err := multitenant.PublishNodeVNIDs(oc.subnetRegistry, oc.localIP, pods); err != nil {
+		log.Errorf("Failed to publish the VNIDs of this node: %v", err)
+	}
+}
+
+// localPods returns the recorded local pods with the current VNIDs of their
+// namespaces.
+func (oc *OvsController) localPods() ([]podstate.Pod, error) {
+	pods, err := podstate.List(oc.podStateDir)
+	if err != nil {
+		return nil, err
+	}
+	oc.vnidLock.Lock()
+	defer oc.vnidLock.Unlock()
+	for i := range pods {
+		if netid, ok := oc.VnidMap[pods[i].Namespace]; ok {
+			pods[i].VNID = netid
+		}
+	}
+	return pods, nil
+}
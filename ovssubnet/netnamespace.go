@@ -0,0 +1,161 @@
+package ovssubnet
+
+import (
+	"fmt"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+)
+
+// JoinNetNamespace puts namespace on the network of target by giving it
+// target's VNID, so that the pods of both reach each other. The namespace's
+// old VNID is released once no namespace uses it.
+func (oc *OvsController) JoinNetNamespace(namespace, target string) error {
+	oc.vnidLock.Lock()
+	defer oc.vnidLock.Unlock()
+	if err := oc.checkNetNamespace(namespace); err != nil {
+		return err
+	}
+	if err := oc.checkNetNamespace(target); err != nil {
+		return err
+	}
+	return oc.setNetID(namespace, oc.VnidMap[target])
+}
+
+// IsolateNetNamespace gives namespace a private VNID again after it joined
+// another namespace or was made global. A namespace with a VNID of its own
+// is left alone.
+func (oc *OvsController) IsolateNetNamespace(namespace string) error {
+	oc.vnidLock.Lock()
+	defer oc.vnidLock.Unlock()
+	if err := oc.checkNetNamespace(namespace); err != nil {
+		return err
+	}
+	netid := oc.VnidMap[namespace]
+	if netid != 0 && oc.netIDUsers(netid) == 1 {
+		return nil
+	}
+	netid, err := oc.netIDManager.GetNetID()
+	if err != nil {
+		return fmt.Errorf("Failed to allocate a VNID for namespace %s: %v", namespace, err)
+	}
+	if err := oc.setNetID(namespace, netid); err != nil {
+		oc.netIDManager.ReleaseNetID(netid)
+		return err
+	}
+	return nil
+}
+
+// MakeNetNamespaceGlobal gives namespace VNID 0, so that its pods reach and
+// are reached by the pods of every namespace.
+func (oc *OvsController) MakeNetNamespaceGlobal(namespace string) error {
+	oc.vnidLock.Lock()
+	defer oc.vnidLock.Unlock()
+	if err := oc.checkNetNamespace(namespace); err != nil {
+		return err
+	}
+	return oc.setNetID(namespace, 0)
+}
+
+// watchNetNamespaceRequests carries out the requests to change the network
+// of namespaces, those made while the master was down first.
+func (oc *OvsController) watchNetNamespaceRequests() {
+	requestEvent := make(chan *api.NetNamespaceRequestEvent)
+	stop := make(chan bool)
+	go oc.subnetRegistry.WatchNetNamespaceRequests(requestEvent, stop)
+
+	requests, err := oc.subnetRegistry.GetNetNamespaceRequests()
+	if err != nil {
+		log.Errorf("Failed to get the network namespace requests: %v", err)
+	}
+	for _, request := range requests {
+		oc.handleNetNamespaceRequest(request)
+	}
+	for {
+		select {
+		case ev := <-requestEvent:
+			if ev.Type == api.Added {
+				oc.handleNetNamespaceRequest(ev.Request)
+			}
+		case <-oc.sig:
+			log.Error("Signal received. Stopping watching of network namespace requests.")
+			stop <- true
+			return
+		}
+	}
+}
+
+// handleNetNamespaceRequest carries out request and deletes it, whether it
+// succeeded or not; a failed request is logged and has to be made again.
+func (oc *OvsController) handleNetNamespaceRequest(request api.NetNamespaceRequest) {
+	err := request.Validate()
+	if err == nil {
+		switch request.Action {
+		case api.JoinNetNamespace:
+			err = oc.JoinNetNamespace(request.Namespace, request.Target)
+		case api.IsolateNetNamespace:
+			err = oc.IsolateNetNamespace(request.Namespace)
+		case api.MakeNetNamespaceGlobal:
+			err = oc.MakeNetNamespaceGlobal(request.Namespace)
+		}
+	}
+	if err != nil {
+		log.Errorf("Failed to %s namespace %s: %v", request.Action, request.Namespace, err)
+	}
+	if err := oc.subnetRegistry.DeleteNetNamespaceRequest(request.Namespace); err != nil {
+		log.Errorf("Failed to delete the network request of namespace %s: %v", request.Namespace, err)
+	}
+}
+
+// checkNetNamespace reports a namespace the master has no VNID for. The
+// caller holds vnidLock.
+func (oc *OvsController) checkNetNamespace(namespace string) error {
+	if oc.netIDManager == nil {
+		return fmt.Errorf("Network namespaces are only managed by the master in multitenant mode")
+	}
+	if _, ok := oc.VnidMap[namespace]; !ok {
+		return fmt.Errorf("Namespace %s has no VNID", namespace)
+	}
+	return nil
+}
+
+// setNetID records the VNID of namespace in the registry, from where nodes
+// pick it up, and releases the old VNID if it is no longer used. The caller
+// holds vnidLock.
+func (oc *OvsController) setNetID(namespace string, netid uint) error {
+	old := oc.VnidMap[namespace]
+	if old == netid {
+		return nil
+	}
+	if err := oc.subnetRegistry.WriteNetNamespace(namespace, netid); err != nil {
+		return fmt.Errorf("Failed to write VNID %d of namespace %s: %v", netid, namespace, err)
+	}
+	oc.VnidMap[namespace] = netid
+	oc.releaseUnusedNetID(old)
+	log.Infof("Namespace %s moved from VNID %d to %d", namespace, old, netid)
+	return nil
+}
+
+// netIDUsers returns how many namespaces have the VNID. The caller holds
+// vnidLock.
+func (oc *OvsController) netIDUsers(netid uint) int {
+	users := 0
+	for _, id := range oc.VnidMap {
+		if id == netid {
+			users++
+		}
+	}
+	return users
+}
+
+// releaseUnusedNetID returns a VNID to the allocator once no namespace has
+// it. VNID 0 is not allocated and never released. The caller holds vnidLock.
+func (oc *OvsController) releaseUnusedNetID(netid uint) {
+	if netid == 0 || oc.netIDUsers(netid) > 0 {
+		return
+	}
+	if err := oc.netIDManager.ReleaseNetID(netid); err != nil {
+		log.Warningf("Failed to release VNID %d: %v", netid, err)
+	}
+}
@@ -0,0 +1,120 @@
+package ovssubnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+)
+
+func (r *fakeRegistry) WriteNetNamespace(name string, id uint) error {
+	r.netNamespaces[name] = id
+	return nil
+}
+
+func (r *fakeRegistry) DeleteNetNamespaceRequest(namespace string) error {
+	r.requests = append(r.requests, namespace)
+	return nil
+}
+
+func TestNetNamespaceOperations(t *testing.T) {
+	vnids := map[string]uint{"default": 0, "a": 10, "b": 11}
+	registry := &fakeRegistry{netNamespaces: map[string]uint{}}
+	allocator, err := netutils.NewNetIDAllocator(10, 100, []uint{10, 11})
+	if err != nil {
+		t.Fatalf("Error creating allocator: %v", err)
+	}
+	oc := &OvsController{subnetRegistry: registry, VnidMap: vnids, netIDManager: allocator}
+
+	steps := []struct {
+		name   string
+		op     func() error
+		vnids  map[string]uint
+		writes map[string]uint
+		fail   bool
+	}{
+		{
+			name:   "join a to b",
+			op:     func() error { return oc.JoinNetNamespace("a", "b") },
+			vnids:  map[string]uint{"default": 0, "a": 11, "b": 11},
+			writes: map[string]uint{"a": 11},
+		},
+		{
+			name:   "isolate b",
+			op:     func() error { return oc.IsolateNetNamespace("b") },
+			vnids:  map[string]uint{"default": 0, "a": 11, "b": 10},
+			writes: map[string]uint{"b": 10},
+		},
+		{
+			name:  "isolate a, which is alone on its VNID",
+			op:    func() error { return oc.IsolateNetNamespace("a") },
+			vnids: map[string]uint{"default": 0, "a": 11, "b": 10},
+		},
+		{
+			name:   "make a global",
+			op:     func() error { return oc.MakeNetNamespaceGlobal("a") },
+			vnids:  map[string]uint{"default": 0, "a": 0, "b": 10},
+			writes: map[string]uint{"a": 0},
+		},
+		{
+			// 11 was released when a left it
+			name:   "isolate a again",
+			op:     func() error { return oc.IsolateNetNamespace("a") },
+			vnids:  map[string]uint{"default": 0, "a": 11, "b": 10},
+			writes: map[string]uint{"a": 11},
+		},
+		{
+			name:  "join an unknown namespace",
+			op:    func() error { return oc.JoinNetNamespace("a", "nobody") },
+			vnids: map[string]uint{"default": 0, "a": 11, "b": 10},
+			fail:  true,
+		},
+	}
+
+	for _, step := range steps {
+		registry.netNamespaces = map[string]uint{}
+		err := step.op()
+		if (err != nil) != step.fail {
+			t.Fatalf("%s: unexpected result %v", step.name, err)
+		}
+		if !reflect.DeepEqual(vnids, step.vnids) {
+			t.Fatalf("%s: wrong VNIDs %v", step.name, vnids)
+		}
+		if step.writes == nil {
+			step.writes = map[string]uint{}
+		}
+		if !reflect.DeepEqual(registry.netNamespaces, step.writes) {
+			t.Fatalf("%s: wrong registry writes %v", step.name, registry.netNamespaces)
+		}
+	}
+}
+
+func TestNetNamespaceRequests(t *testing.T) {
+	vnids := map[string]uint{"default": 0, "a": 10, "b": 11}
+	registry := &fakeRegistry{netNamespaces: map[string]uint{}}
+	allocator, err := netutils.NewNetIDAllocator(10, 100, []uint{10, 11})
+	if err != nil {
+		t.Fatalf("Error creating allocator: %v", err)
+	}
+	oc := &OvsController{subnetRegistry: registry, VnidMap: vnids, netIDManager: allocator}
+
+	requests := []api.NetNamespaceRequest{
+		{Namespace: "a", Action: api.JoinNetNamespace, Target: "b"},
+		{Namespace: "b", Action: api.MakeNetNamespaceGlobal},
+		// failed requests are dropped too
+		{Namespace: "a", Action: api.JoinNetNamespace, Target: "nobody"},
+		{Namespace: "a", Action: "merge"},
+		{Namespace: "a", Action: api.IsolateNetNamespace},
+	}
+	for _, request := range requests {
+		oc.handleNetNamespaceRequest(request)
+	}
+	// a is alone on 11 once b is global, so isolating it keeps 11
+	if expected := map[string]uint{"default": 0, "a": 11, "b": 0}; !reflect.DeepEqual(vnids, expected) {
+		t.Errorf("Wrong VNIDs %v", vnids)
+	}
+	if expected := []string{"a", "b", "a", "a", "a"}; !reflect.DeepEqual(registry.requests, expected) {
+		t.Errorf("Wrong deleted requests %q", registry.requests)
+	}
+}
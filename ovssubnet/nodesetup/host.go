@@ -0,0 +1,187 @@
+package nodesetup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/openshift-sdn/pkg/exec"
+)
+
+// Sysctl makes sure the sysctl, e.g. "net/bridge/bridge-nf-call-iptables",
+// has the value. If module is given it is loaded first when the sysctl does
+// not exist yet.
+func (n *Node) Sysctl(name, value, module string) Step {
+	path := filepath.Join(n.ProcSys, name)
+	return Step{
+		Name: fmt.Sprintf("sysctl %s=%s", strings.Replace(name, "/", ".", -1), value),
+		Check: func() (bool, error) {
+			data, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return strings.TrimSpace(string(data)) == value, err
+		},
+		Apply: func() error {
+			if _, err := os.Stat(path); os.IsNotExist(err) && module != "" {
+				if out, err := n.Executor.Exec("modprobe", module); err != nil {
+					return fmt.Errorf("Failed to load module %s: %v (%s)", module, err, out)
+				}
+			}
+			return ioutil.WriteFile(path, []byte(value+"\n"), 0644)
+		},
+	}
+}
+
+// IPTablesRule is a rule in a chain of an iptables table.
+type IPTablesRule struct {
+	Table string
+	Chain string
+	Args  []string
+	// Before is text from the last existing rule the new rule must come
+	// before, e.g. "RELATED,ESTABLISHED". If no rule matches, the rule is
+	// inserted at the top of the chain; if Before is empty it is appended.
+	Before string
+}
+
+func (r *IPTablesRule) String() string {
+	return fmt.Sprintf("-t %s %s %s", r.Table, r.Chain, strings.Join(r.Args, " "))
+}
+
+// IPTables makes sure the iptables rule exists.
+func (n *Node) IPTables(rule IPTablesRule) Step {
+	iptables := func(args ...string) ([]byte, error) {
+		return n.Executor.Exec("iptables", append([]string{"-t", rule.Table}, args...)...)
+	}
+	return Step{
+		Name: "iptables rule " + rule.String(),
+		Check: func() (bool, error) {
+			out, err := iptables(append([]string{"-C", rule.Chain}, rule.Args...)...)
+			if status, ok := exec.ExitStatus(err); ok && status == 1 {
+				return false, nil
+			} else if err != nil {
+				return false, fmt.Errorf("Failed to check rule: %v (%s)", err, out)
+			}
+			return true, nil
+		},
+		Apply: func() error {
+			args := append([]string{"-A", rule.Chain}, rule.Args...)
+			if rule.Before != "" {
+				out, err := iptables("-S", rule.Chain)
+				if err != nil {
+					return fmt.Errorf("Failed to list chain %s: %v (%s)", rule.Chain, err, out)
+				}
+				position := 1
+				number := 0
+				scanner := bufio.NewScanner(bytes.NewReader(out))
+				for scanner.Scan() {
+					if !strings.HasPrefix(scanner.Text(), "-A ") {
+						continue
+					}
+					number++
+					if strings.Contains(scanner.Text(), rule.Before) {
+						position = number
+					}
+				}
+				args = append([]string{"-I", rule.Chain, strconv.Itoa(position)}, rule.Args...)
+			}
+			if out, err := iptables(args...); err != nil {
+				return fmt.Errorf("Failed to add rule: %v (%s)", err, out)
+			}
+			return nil
+		},
+	}
+}
+
+// NoIPTables makes sure the iptables rule does not exist.
+func (n *Node) NoIPTables(rule IPTablesRule) Step {
+	iptables := func(op string) ([]byte, error) {
+		return n.Executor.Exec("iptables", append([]string{"-t", rule.Table, op, rule.Chain}, rule.Args...)...)
+	}
+	return Step{
+		Name: "no iptables rule " + rule.String(),
+		Check: func() (bool, error) {
+			out, err := iptables("-C")
+			if status, ok := exec.ExitStatus(err); ok && status == 1 {
+				return true, nil
+			} else if err != nil {
+				return false, fmt.Errorf("Failed to check rule: %v (%s)", err, out)
+			}
+			return false, nil
+		},
+		Apply: func() error {
+			if out, err := iptables("-D"); err != nil {
+				return fmt.Errorf("Failed to delete rule: %v (%s)", err, out)
+			}
+			return nil
+		},
+	}
+}
+
+// File makes sure the file at path has the content. If changed is given it
+// runs after the file is written, e.g. to restart a service reading it.
+func File(path, content string, changed func() error) Step {
+	return Step{
+		Name: "file " + path,
+		Check: func() (bool, error) {
+			data, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				return false, nil
+			}
+			return string(data) == content, err
+		},
+		Apply: func() error {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			// write and rename, so readers never see half a file
+			tmp := path + ".tmp"
+			if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+				return err
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				return err
+			}
+			if changed != nil {
+				return changed()
+			}
+			return nil
+		},
+	}
+}
+
+// DockerNetworkFile is the drop-in docker.service reads its network options
+// from, see rel-eng/docker-sdn-ovs.conf.
+const DockerNetworkFile = "/run/openshift-sdn/docker-network"
+
+// DockerNetwork makes sure the docker network options drop-in at path puts
+// containers on lbr0 with the MTU, restarting docker when it changes. The
+// DOCKER_NETWORK_OPTIONS environment variable overrides the options.
+func (n *Node) DockerNetwork(path string, mtu uint) Step {
+	options := os.Getenv("DOCKER_NETWORK_OPTIONS")
+	if options == "" {
+		options = fmt.Sprintf("-b=lbr0 --mtu=%d", mtu)
+	}
+	content := fmt.Sprintf(`# This file has been modified by openshift-sdn. Please modify the
+# DOCKER_NETWORK_OPTIONS variable in /etc/sysconfig/openshift-node if this
+# is an integrated install or /etc/sysconfig/openshift-sdn-node if this is a
+# standalone install.
+
+DOCKER_NETWORK_OPTIONS='%s'
+`, options)
+	step := File(path, content, func() error {
+		for _, args := range [][]string{{"daemon-reload"}, {"restart", "docker.service"}} {
+			if out, err := n.Executor.Exec("systemctl", args...); err != nil {
+				return fmt.Errorf("Failed to run systemctl %s: %v (%s)", strings.Join(args, " "), err, out)
+			}
+		}
+		return nil
+	})
+	step.Name = "docker network options"
+	return step
+}
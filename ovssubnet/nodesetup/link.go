@@ -0,0 +1,368 @@
+package nodesetup
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+)
+
+// linkByName returns the link called name, or nil if there is none.
+func linkByName(name string) (*netlink.Link, error) {
+	link, err := netlink.LinkByName(name)
+	if errors.Is(err, syscall.ENODEV) {
+		return nil, nil
+	}
+	return link, err
+}
+
+// VethPair makes sure the veth pair name/peer exists with both ends up and
+// without transmit queues, as used to connect lbr0 and br0.
+func VethPair(name, peer string) Step {
+	ends := func() (*netlink.Link, *netlink.Link, error) {
+		link, err := linkByName(name)
+		if err != nil || link == nil {
+			return nil, nil, err
+		}
+		other, err := linkByName(peer)
+		if err != nil || other == nil || other.Index != link.ParentIndex {
+			return link, nil, err
+		}
+		return link, other, nil
+	}
+	return Step{
+		Name: fmt.Sprintf("veth pair %s/%s", name, peer),
+		Check: func() (bool, error) {
+			link, other, err := ends()
+			if err != nil || other == nil {
+				return false, err
+			}
+			return link.Up && other.Up && link.TxQLen == 0 && other.TxQLen == 0, nil
+		},
+		Apply: func() error {
+			link, other, err := ends()
+			if err != nil {
+				return err
+			}
+			if other == nil {
+				// a lone end, or one paired with something else
+				if link != nil {
+					if err := netlink.LinkDel(link.Index); err != nil {
+						return err
+					}
+				}
+				if err := netlink.LinkAddVeth(name, peer); err != nil {
+					return err
+				}
+				if link, other, err = ends(); err != nil {
+					return err
+				} else if other == nil {
+					return fmt.Errorf("Veth pair %s/%s missing after creating it", name, peer)
+				}
+			}
+			for _, l := range []*netlink.Link{link, other} {
+				if err := netlink.LinkSetTxQLen(l.Index, 0); err != nil {
+					return err
+				}
+				if err := netlink.LinkSetUp(l.Index); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// LinuxBridge makes sure the Linux bridge exists and is up.
+func LinuxBridge(name string) Step {
+	return Step{
+		Name: "Linux bridge " + name,
+		Check: func() (bool, error) {
+			link, err := linkByName(name)
+			if err != nil || link == nil {
+				return false, err
+			}
+			return link.Up, nil
+		},
+		Apply: func() error {
+			link, err := linkByName(name)
+			if err != nil {
+				return err
+			}
+			if link == nil {
+				if err := netlink.LinkAdd(name, "bridge"); err != nil {
+					return err
+				}
+				if link, err = netlink.LinkByName(name); err != nil {
+					return err
+				}
+			}
+			return netlink.LinkSetUp(link.Index)
+		},
+	}
+}
+
+// VxlanDevice makes sure the link is a kernel vxlan device with VNI vni on
+// UDP port port, and is up. A link of that name that is anything else is
+// replaced.
+func VxlanDevice(name string, vni uint32, port uint16) Step {
+	return Step{
+		Name: fmt.Sprintf("vxlan device %s id %d port %d", name, vni, port),
+		Check: func() (bool, error) {
+			link, err := linkByName(name)
+			if err != nil || link == nil {
+				return false, err
+			}
+			return link.Kind == "vxlan" && link.VxlanID == vni && link.VxlanPort == port && link.Up, nil
+		},
+		Apply: func() error {
+			link, err := linkByName(name)
+			if err != nil {
+				return err
+			}
+			if link != nil && (link.Kind != "vxlan" || link.VxlanID != vni || link.VxlanPort != port) {
+				if err := netlink.LinkDel(link.Index); err != nil {
+					return err
+				}
+				link = nil
+			}
+			if link == nil {
+				if err := netlink.LinkAddVxlan(name, vni, port); err != nil {
+					return err
+				}
+				if link, err = netlink.LinkByName(name); err != nil {
+					return err
+				}
+			}
+			return netlink.LinkSetUp(link.Index)
+		},
+	}
+}
+
+// MTU makes sure the link has the MTU.
+func MTU(name string, mtu uint) Step {
+	return Step{
+		Name: fmt.Sprintf("MTU %d on %s", mtu, name),
+		Check: func() (bool, error) {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return false, err
+			}
+			return link.MTU == int(mtu), nil
+		},
+		Apply: func() error {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return err
+			}
+			return netlink.LinkSetMTU(link.Index, int(mtu))
+		},
+	}
+}
+
+// HardwareAddr makes sure the link has the MAC address mac.
+func HardwareAddr(name string, mac net.HardwareAddr) Step {
+	return Step{
+		Name: fmt.Sprintf("MAC address %s on %s", mac, name),
+		Check: func() (bool, error) {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return false, err
+			}
+			return link.HardwareAddr.String() == mac.String(), nil
+		},
+		Apply: func() error {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return err
+			}
+			return netlink.LinkSetHardwareAddr(link.Index, mac)
+		},
+	}
+}
+
+// BridgePort makes sure the link is a port of the Linux bridge.
+func BridgePort(bridge, name string) Step {
+	links := func() (*netlink.Link, *netlink.Link, error) {
+		br, err := netlink.LinkByName(bridge)
+		if err != nil {
+			return nil, nil, err
+		}
+		link, err := netlink.LinkByName(name)
+		return br, link, err
+	}
+	return Step{
+		Name: fmt.Sprintf("port %s on %s", name, bridge),
+		Check: func() (bool, error) {
+			br, link, err := links()
+			if err != nil {
+				return false, err
+			}
+			return link.MasterIndex == br.Index, nil
+		},
+		Apply: func() error {
+			br, link, err := links()
+			if err != nil {
+				return err
+			}
+			return netlink.LinkSetMaster(link.Index, br.Index)
+		},
+	}
+}
+
+// Address makes sure the link has the address, given in CIDR notation, and
+// is up.
+func Address(name, cidr string) Step {
+	return Step{
+		Name: fmt.Sprintf("address %s on %s", cidr, name),
+		Check: func() (bool, error) {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return false, err
+			}
+			addrs, err := netlink.AddrList(link.Index)
+			if err != nil {
+				return false, err
+			}
+			for _, addr := range addrs {
+				if addr.String() == cidr {
+					return link.Up, nil
+				}
+			}
+			return false, nil
+		},
+		Apply: func() error {
+			ip, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return err
+			}
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return err
+			}
+			err = netlink.AddrAdd(link.Index, &net.IPNet{IP: ip, Mask: ipnet.Mask})
+			if err != nil && !netlink.IsExist(err) {
+				return err
+			}
+			return netlink.LinkSetUp(link.Index)
+		},
+	}
+}
+
+// NoAddress makes sure the link does not have the address, given in CIDR
+// notation.
+func NoAddress(name, cidr string) Step {
+	find := func() (*netlink.Link, *net.IPNet, error) {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		addrs, err := netlink.AddrList(link.Index)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, addr := range addrs {
+			if addr.String() == cidr {
+				return link, addr, nil
+			}
+		}
+		return link, nil, nil
+	}
+	return Step{
+		Name: fmt.Sprintf("no address %s on %s", cidr, name),
+		Check: func() (bool, error) {
+			_, addr, err := find()
+			return addr == nil, err
+		},
+		Apply: func() error {
+			link, addr, err := find()
+			if err != nil || addr == nil {
+				return err
+			}
+			return netlink.AddrDel(link.Index, addr)
+		},
+	}
+}
+
+// findRoute returns the route to dst through the link, or nil if there is
+// none.
+func findRoute(dst, name string) (*netlink.Link, *netlink.Route, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	routes, err := netlink.RouteList(link.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, route := range routes {
+		if route.Dst != nil && route.Dst.String() == dst {
+			return link, route, nil
+		}
+	}
+	return link, nil, nil
+}
+
+// Route makes sure dst, a network in CIDR notation, is routed directly
+// through the link, like "ip route add <dst> dev <name> proto kernel scope
+// link [src <src>]". src may be empty.
+func Route(dst, name, src string) Step {
+	desc := fmt.Sprintf("route %s dev %s", dst, name)
+	if src != "" {
+		desc += " src " + src
+	}
+	return Step{
+		Name: desc,
+		Check: func() (bool, error) {
+			_, route, err := findRoute(dst, name)
+			if err != nil || route == nil {
+				return false, err
+			}
+			return src == "" || route.Src.String() == src, nil
+		},
+		Apply: func() error {
+			_, ipnet, err := net.ParseCIDR(dst)
+			if err != nil {
+				return err
+			}
+			link, route, err := findRoute(dst, name)
+			if err != nil {
+				return err
+			}
+			if route != nil {
+				// wrong source address
+				if err := netlink.RouteDel(route); err != nil {
+					return err
+				}
+			}
+			return netlink.RouteAdd(&netlink.Route{
+				Dst:       ipnet,
+				LinkIndex: link.Index,
+				Src:       net.ParseIP(src),
+				Scope:     syscall.RT_SCOPE_LINK,
+				Protocol:  syscall.RTPROT_KERNEL,
+			})
+		},
+	}
+}
+
+// NoRoute makes sure dst is not routed through the link, e.g. to drop the
+// route the kernel adds with an address.
+func NoRoute(dst, name string) Step {
+	return Step{
+		Name: fmt.Sprintf("no route %s dev %s", dst, name),
+		Check: func() (bool, error) {
+			_, route, err := findRoute(dst, name)
+			return route == nil, err
+		},
+		Apply: func() error {
+			_, route, err := findRoute(dst, name)
+			if err != nil || route == nil {
+				return err
+			}
+			return netlink.RouteDel(route)
+		},
+	}
+}
@@ -0,0 +1,103 @@
+package nodesetup
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+)
+
+// inNewNetns runs fn on a thread in a fresh network namespace. The thread is
+// never unlocked, so it exits with the goroutine. fn runs outside the test
+// goroutine and must not call t.Fatal.
+func inNewNetns(t *testing.T, fn func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root")
+	}
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			done <- err
+			return
+		}
+		fn()
+		done <- nil
+	}()
+	if err := <-done; err != nil {
+		t.Skipf("Cannot create a network namespace: %v", err)
+	}
+}
+
+func TestLinkSteps(t *testing.T) {
+	inNewNetns(t, func() {
+		// tun0 stands in for the OVS internal port
+		if err := netlink.LinkAdd("tun0", "bridge"); err != nil {
+			t.Errorf("Error adding tun0: %v", err)
+			return
+		}
+		steps := []Step{
+			VethPair("vlinuxbr", "vovsbr"),
+			MTU("vlinuxbr", 1450),
+			MTU("vovsbr", 1450),
+			LinuxBridge("lbr0"),
+			Address("lbr0", "10.1.2.1/24"),
+			BridgePort("lbr0", "vlinuxbr"),
+			MTU("lbr0", 1450),
+			Address("tun0", "10.1.2.1/24"),
+			MTU("tun0", 1450),
+			HardwareAddr("tun0", net.HardwareAddr{0x02, 0x42, 0x0a, 0x01, 0x02, 0x01}),
+			Route("10.1.0.0/16", "tun0", ""),
+			NoRoute("10.1.2.0/24", "lbr0"),
+			VxlanDevice("vxlan1", 0, 4789),
+			BridgePort("lbr0", "vxlan1"),
+		}
+		if err := Run(steps); err != nil {
+			t.Errorf("Unexpected error %v", err)
+			return
+		}
+		for _, step := range steps {
+			if done, err := step.Check(); !done || err != nil {
+				t.Errorf("Step %s not in place after setup: %v", step.Name, err)
+			}
+		}
+
+		// break a few things; setup repairs just those
+		vlinuxbr, _ := netlink.LinkByName("vlinuxbr")
+		netlink.LinkSetMaster(vlinuxbr.Index, 0)
+		vovsbr, _ := netlink.LinkByName("vovsbr")
+		netlink.LinkDel(vovsbr.Index)
+		vxlan1, _ := netlink.LinkByName("vxlan1")
+		netlink.LinkDel(vxlan1.Index)
+		netlink.LinkAddVxlan("vxlan1", 0, 8472)
+		if err := Run(steps); err != nil {
+			t.Errorf("Unexpected error repairing %v", err)
+			return
+		}
+		for _, step := range steps {
+			if done, err := step.Check(); !done || err != nil {
+				t.Errorf("Step %s not in place after repair: %v", step.Name, err)
+			}
+		}
+
+		if err := Run([]Step{Route("10.1.0.0/16", "tun0", "10.1.2.1")}); err != nil {
+			t.Errorf("Error replacing a route: %v", err)
+		}
+		if err := Run([]Step{BridgePort("lbr0", "missing0")}); err == nil {
+			t.Errorf("Expected an error adding a missing link to lbr0")
+		}
+
+		extra := []Step{Address("tun0", "192.0.2.10/32"), NoAddress("tun0", "192.0.2.10/32")}
+		for _, step := range extra {
+			if err := Run([]Step{step}); err != nil {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if done, err := step.Check(); !done || err != nil {
+				t.Errorf("Step %s not in place: %v", step.Name, err)
+			}
+		}
+	})
+}
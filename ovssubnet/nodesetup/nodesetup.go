@@ -0,0 +1,130 @@
+// Package nodesetup sets a node up for the SDN as a list of steps. Each step
+// checks whether the node already has its piece of state and changes only
+// what is missing, so setup can be rerun at any time and a failure names the
+// step that could not be completed.
+package nodesetup
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
+)
+
+// LockFile serializes node setup with the pod hooks.
+const LockFile = "/var/lock/openshift-sdn.lock"
+
+// Step is one idempotent piece of node setup.
+type Step struct {
+	Name string
+	// Check tells whether the node already has the state the step sets up.
+	Check func() (bool, error)
+	// Apply sets that state up.
+	Apply func() error
+}
+
+// StepError reports the step node setup failed at.
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return fmt.Sprintf("Node setup failed at step %q: %v", e.Step, e.Err)
+}
+
+// Run checks the steps in order and applies those the node is missing,
+// verifying after each that it took effect. It stops at the first step that
+// fails.
+func Run(steps []Step) error {
+	applied := 0
+	for _, step := range steps {
+		done, err := step.Check()
+		if err != nil {
+			return &StepError{step.Name, err}
+		}
+		if done {
+			continue
+		}
+		log.Infof("Setting up %s", step.Name)
+		if err := step.Apply(); err != nil {
+			return &StepError{step.Name, err}
+		}
+		applied++
+		done, err = step.Check()
+		if err != nil {
+			return &StepError{step.Name, err}
+		}
+		if !done {
+			return &StepError{step.Name, fmt.Errorf("still not in place after applying it")}
+		}
+	}
+	if applied == 0 {
+		log.Infof("SDN setup not required")
+	}
+	return nil
+}
+
+// Lock takes the lock on path shared by node setup and the pod hooks, like
+// flock(1), and returns the function releasing it.
+func Lock(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open lock file %s: %v", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Failed to lock %s: %v", path, err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// OVS is the part of the OVSDB client node setup uses.
+type OVS interface {
+	GetBridge(bridge string) (*ovsdb.BridgeConfig, error)
+	AddBridge(bridge, failMode string, protocols []string) error
+	SetBridge(bridge, failMode string, protocols []string) error
+	AddPort(bridge, port string, iface *ovsdb.Interface) error
+	DeletePort(bridge, port string) error
+	GetOfport(iface string) (int, error)
+	GetInterface(iface string) (*ovsdb.Interface, error)
+}
+
+// Node holds what the steps use to inspect and change the node. Links,
+// addresses and routes are handled over netlink in the current network
+// namespace.
+type Node struct {
+	OVS OVS
+	// Executor runs iptables, ovs-ofctl, modprobe and systemctl.
+	Executor exec.Interface
+	// ProcSys is where sysctls live, normally /proc/sys.
+	ProcSys string
+}
+
+// NewNode returns a Node for this host, connected to the local ovsdb-server.
+// The connection is closed by Close.
+func NewNode(executor exec.Interface) (*Node, error) {
+	client, err := ovsdb.Dial("unix", ovsdb.DefaultSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{OVS: client, Executor: executor, ProcSys: "/proc/sys"}, nil
+}
+
+// NewLinuxNode returns a Node for a host without Open vSwitch. Its OVS
+// steps must not be used.
+func NewLinuxNode(executor exec.Interface) *Node {
+	return &Node{Executor: executor, ProcSys: "/proc/sys"}
+}
+
+// Close closes the OVSDB connection of a Node made by NewNode.
+func (n *Node) Close() error {
+	if client, ok := n.OVS.(*ovsdb.Client); ok {
+		return client.Close()
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+package nodesetup
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
+)
+
+func TestRun(t *testing.T) {
+	var applied []string
+	step := func(name string, done bool, applyErr error, takes bool) Step {
+		return Step{
+			Name:  name,
+			Check: func() (bool, error) { return done, nil },
+			Apply: func() error {
+				applied = append(applied, name)
+				if applyErr == nil {
+					done = takes
+				}
+				return applyErr
+			},
+		}
+	}
+	tests := []struct {
+		name    string
+		steps   []Step
+		applied []string
+		failed  string
+	}{
+		{
+			name:    "fresh node",
+			steps:   []Step{step("bridge", false, nil, true), step("port", false, nil, true)},
+			applied: []string{"bridge", "port"},
+		},
+		{
+			name:    "node set up",
+			steps:   []Step{step("bridge", true, nil, true), step("port", true, nil, true)},
+			applied: nil,
+		},
+		{
+			name:    "step fails",
+			steps:   []Step{step("bridge", false, nil, true), step("port", false, errors.New("no such bridge"), true), step("route", false, nil, true)},
+			applied: []string{"bridge", "port"},
+			failed:  "port",
+		},
+		{
+			name:    "step has no effect",
+			steps:   []Step{step("bridge", false, nil, false), step("port", false, nil, true)},
+			applied: []string{"bridge"},
+			failed:  "bridge",
+		},
+		{
+			name: "check fails",
+			steps: []Step{{
+				Name:  "sysctl",
+				Check: func() (bool, error) { return false, errors.New("permission denied") },
+			}},
+			failed: "sysctl",
+		},
+	}
+
+	for _, test := range tests {
+		applied = nil
+		err := Run(test.steps)
+		if !reflect.DeepEqual(applied, test.applied) {
+			t.Errorf("%s: wrong steps applied %v", test.name, applied)
+		}
+		if test.failed == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+		} else if stepErr, ok := err.(*StepError); !ok || stepErr.Step != test.failed {
+			t.Errorf("%s: expected step %s to fail, got %v", test.name, test.failed, err)
+		}
+	}
+}
+
+func TestIPTables(t *testing.T) {
+	rule := IPTablesRule{Table: "filter", Chain: "INPUT", Args: []string{"-i", "tun0", "-j", "ACCEPT"}, Before: "RELATED,ESTABLISHED"}
+	chain := `-P INPUT ACCEPT
+-A INPUT -p udp -m udp --dport 53 -j ACCEPT
+-A INPUT -m state --state RELATED,ESTABLISHED -j ACCEPT
+-A INPUT -j REJECT --reject-with icmp-host-prohibited
+`
+	tests := []struct {
+		name     string
+		rule     IPTablesRule
+		script   []exec.FakeResult
+		commands []string
+		done     bool
+		fail     bool
+	}{
+		{
+			name:     "rule present",
+			rule:     rule,
+			commands: []string{"iptables -t filter -C INPUT -i tun0 -j ACCEPT"},
+			done:     true,
+		},
+		{
+			name:   "rule missing",
+			rule:   rule,
+			script: []exec.FakeResult{{ExitStatus: 1}, {Output: chain}},
+			commands: []string{
+				"iptables -t filter -C INPUT -i tun0 -j ACCEPT",
+				"iptables -t filter -S INPUT",
+				"iptables -t filter -I INPUT 2 -i tun0 -j ACCEPT",
+			},
+		},
+		{
+			name:   "no rule to insert before",
+			rule:   rule,
+			script: []exec.FakeResult{{ExitStatus: 1}, {Output: "-P INPUT ACCEPT\n"}},
+			commands: []string{
+				"iptables -t filter -C INPUT -i tun0 -j ACCEPT",
+				"iptables -t filter -S INPUT",
+				"iptables -t filter -I INPUT 1 -i tun0 -j ACCEPT",
+			},
+		},
+		{
+			name:   "appended rule",
+			rule:   IPTablesRule{Table: "nat", Chain: "POSTROUTING", Args: []string{"-s", "10.1.0.0/16", "!", "-d", "10.1.0.0/16", "-j", "MASQUERADE"}},
+			script: []exec.FakeResult{{ExitStatus: 1}},
+			commands: []string{
+				"iptables -t nat -C POSTROUTING -s 10.1.0.0/16 ! -d 10.1.0.0/16 -j MASQUERADE",
+				"iptables -t nat -A POSTROUTING -s 10.1.0.0/16 ! -d 10.1.0.0/16 -j MASQUERADE",
+			},
+		},
+		{
+			name:     "iptables broken",
+			rule:     rule,
+			script:   []exec.FakeResult{{Output: "iptables: No chain/target/match by that name.", ExitStatus: 2}},
+			commands: []string{"iptables -t filter -C INPUT -i tun0 -j ACCEPT"},
+			fail:     true,
+		},
+	}
+
+	for _, test := range tests {
+		executor := exec.NewFake(test.script...)
+		step := (&Node{Executor: executor}).IPTables(test.rule)
+		done, err := step.Check()
+		if (err != nil) != test.fail || done != test.done {
+			t.Errorf("%s: wrong check result %v (%v)", test.name, done, err)
+		}
+		if !done && err == nil {
+			if err := step.Apply(); err != nil {
+				t.Errorf("%s: unexpected error %v", test.name, err)
+			}
+		}
+		if commands := executor.CommandLines(); !reflect.DeepEqual(commands, test.commands) {
+			t.Errorf("%s: wrong commands.\nExpected %q\nGot      %q", test.name, test.commands, commands)
+		}
+	}
+}
+
+func TestNoIPTables(t *testing.T) {
+	rule := IPTablesRule{Table: "nat", Chain: "POSTROUTING", Args: []string{"-m", "mark", "--mark", "0xa", "-j", "SNAT", "--to-source", "192.0.2.10"}}
+	executor := exec.NewFake(exec.FakeResult{})
+	step := (&Node{Executor: executor}).NoIPTables(rule)
+	if done, err := step.Check(); done || err != nil {
+		t.Fatalf("Wrong check result for a present rule: %v (%v)", done, err)
+	}
+	if err := step.Apply(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []string{
+		"iptables -t nat -C POSTROUTING -m mark --mark 0xa -j SNAT --to-source 192.0.2.10",
+		"iptables -t nat -D POSTROUTING -m mark --mark 0xa -j SNAT --to-source 192.0.2.10",
+	}
+	if commands := executor.CommandLines(); !reflect.DeepEqual(commands, expected) {
+		t.Fatalf("Wrong commands.\nExpected %q\nGot      %q", expected, commands)
+	}
+
+	executor = exec.NewFake(exec.FakeResult{ExitStatus: 1})
+	step = (&Node{Executor: executor}).NoIPTables(rule)
+	if done, err := step.Check(); !done || err != nil {
+		t.Fatalf("Wrong check result for a missing rule: %v (%v)", done, err)
+	}
+}
+
+func TestFilesAndSysctls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nodesetup")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	executor := exec.NewFake(exec.FakeResult{Run: func([]string) {
+		// modprobe br_netfilter
+		os.MkdirAll(filepath.Join(dir, "net/bridge"), 0755)
+		ioutil.WriteFile(filepath.Join(dir, "net/bridge/bridge-nf-call-iptables"), []byte("1\n"), 0644)
+	}})
+	node := &Node{Executor: executor, ProcSys: dir}
+	os.Setenv("DOCKER_NETWORK_OPTIONS", "-b=lbr0 --mtu=1410")
+	defer os.Unsetenv("DOCKER_NETWORK_OPTIONS")
+	steps := []Step{
+		node.Sysctl("net/bridge/bridge-nf-call-iptables", "0", "br_netfilter"),
+		node.DockerNetwork(filepath.Join(dir, "run/docker-network"), 1450),
+		File(filepath.Join(dir, "etc/config.env"), "export OPENSHIFT_CLUSTER_SUBNET=10.1.0.0/16\n", nil),
+	}
+	if err := Run(steps); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []string{"modprobe br_netfilter", "systemctl daemon-reload", "systemctl restart docker.service"}
+	if commands := executor.CommandLines(); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Wrong commands %q", commands)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "run/docker-network")); !strings.HasSuffix(string(data), "\nDOCKER_NETWORK_OPTIONS='-b=lbr0 --mtu=1410'\n") {
+		t.Errorf("Wrong docker network options:\n%s", data)
+	}
+	// without DOCKER_NETWORK_OPTIONS the options follow the MTU
+	os.Unsetenv("DOCKER_NETWORK_OPTIONS")
+	for mtu, same := range map[uint]bool{1410: true, 1450: false} {
+		if done, _ := node.DockerNetwork(filepath.Join(dir, "run/docker-network"), mtu).Check(); done != same {
+			t.Errorf("Docker network options with MTU %d in place: %v", mtu, done)
+		}
+	}
+	os.Setenv("DOCKER_NETWORK_OPTIONS", "-b=lbr0 --mtu=1410")
+
+	// a second run finds everything in place
+	executor.Commands = nil
+	if err := Run(steps); err != nil {
+		t.Fatalf("Unexpected error on second run %v", err)
+	}
+	if commands := executor.CommandLines(); len(commands) != 0 {
+		t.Errorf("Expected no commands on second run, got %q", commands)
+	}
+}
+
+const dump = `OFPST_FLOW reply (OF1.3) (xid=0x2):
+ cookie=0x0, duration=10.1s, table=0, n_packets=0, n_bytes=0, priority=50 actions=output:2
+ cookie=0x0, duration=10.1s, table=0, n_packets=0, n_bytes=0, priority=100,arp,arp_tpa=10.1.2.1 actions=output:2
+ cookie=0xac110003, duration=9.2s, table=0, n_packets=0, n_bytes=0, priority=100,ip,nw_dst=10.1.3.0/24 actions=set_field:172.17.0.3->tun_dst,output:1
+`
+
+func TestFlows(t *testing.T) {
+	flows := []*ofctl.Flow{
+		{Priority: 50, Actions: []ofctl.Action{ofctl.Output(2)}},
+		{Priority: 100, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("nw_dst", "10.1.2.1")}, Actions: []ofctl.Action{ofctl.Output(2)}},
+		{Priority: 100, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", "10.1.2.1")}, Actions: []ofctl.Action{ofctl.Output(2)}},
+	}
+	executor := exec.NewFake(exec.FakeResult{Output: dump}, exec.FakeResult{Output: dump})
+	step := (&Node{Executor: executor}).Flows("br0", flows)
+	if done, err := step.Check(); done || err != nil {
+		t.Fatalf("Expected a missing flow, got %v (%v)", done, err)
+	}
+	if err := step.Apply(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []string{
+		"ovs-ofctl -O OpenFlow13 dump-flows br0",
+		"ovs-ofctl -O OpenFlow13 dump-flows br0",
+		"ovs-ofctl -O OpenFlow13 add-flow br0 table=0,priority=100,ip,nw_dst=10.1.2.1,actions=output:2",
+	}
+	if commands := executor.CommandLines(); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Wrong commands %q", commands)
+	}
+}
+
+func TestFlowsRemovesStraySystemFlows(t *testing.T) {
+	system := uint64(cookie.New(cookie.System, 0))
+	flows := []*ofctl.Flow{
+		{Cookie: system, Priority: 50, Actions: []ofctl.Action{ofctl.Output(2)}},
+		{Cookie: system, Priority: 100, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("nw_dst", "10.1.2.1")}, Actions: []ofctl.Action{ofctl.Output(2)}},
+	}
+	// an older release sent ARP for the gateway elsewhere and had a flow
+	// that is gone now; the node flow is not a base flow
+	dump := `OFPST_FLOW reply (OF1.3) (xid=0x2):
+ cookie=0x100000000000000, duration=10.1s, table=0, n_packets=0, n_bytes=0, priority=50 actions=output:2
+ cookie=0x100000000000000, duration=10.1s, table=0, n_packets=0, n_bytes=0, priority=100,arp,arp_tpa=10.1.2.1 actions=output:3
+ cookie=0x100000000000000, duration=10.1s, table=1, n_packets=0, n_bytes=0, priority=0 actions=drop
+ cookie=0x2000000ac110003, duration=9.2s, table=0, n_packets=0, n_bytes=0, priority=100,ip,nw_dst=10.1.3.0/24 actions=set_field:172.17.0.3->tun_dst,output:1
+`
+	executor := exec.NewFake(exec.FakeResult{Output: dump}, exec.FakeResult{Output: dump})
+	step := (&Node{Executor: executor}).Flows("br0", flows)
+	if done, err := step.Check(); done || err != nil {
+		t.Fatalf("Expected stray flows, got %v (%v)", done, err)
+	}
+	if err := step.Apply(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := []string{
+		"ovs-ofctl -O OpenFlow13 dump-flows br0",
+		"ovs-ofctl -O OpenFlow13 dump-flows br0",
+		"ovs-ofctl -O OpenFlow13 --strict del-flows br0 table=0,cookie=0x100000000000000/-1,priority=100,arp,arp_tpa=10.1.2.1",
+		"ovs-ofctl -O OpenFlow13 --strict del-flows br0 table=1,cookie=0x100000000000000/-1,priority=0",
+		"ovs-ofctl -O OpenFlow13 add-flow br0 table=0,cookie=0x100000000000000,priority=100,arp,nw_dst=10.1.2.1,actions=output:2",
+	}
+	if commands := executor.CommandLines(); !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Wrong commands.\nExpected %q\nGot      %q", expected, commands)
+	}
+}
+
+type fakeOVS struct {
+	bridges map[string]*ovsdb.BridgeConfig
+	ports   map[string]int
+	ifaces  map[string]*ovsdb.Interface
+	// ofports are the numbers ovs-vswitchd gives ports without a request
+	next int
+}
+
+func (o *fakeOVS) GetBridge(bridge string) (*ovsdb.BridgeConfig, error) {
+	return o.bridges[bridge], nil
+}
+
+func (o *fakeOVS) AddBridge(bridge, failMode string, protocols []string) error {
+	if _, ok := o.bridges[bridge]; !ok {
+		o.bridges[bridge] = &ovsdb.BridgeConfig{FailMode: failMode, Protocols: protocols}
+	}
+	return nil
+}
+
+func (o *fakeOVS) SetBridge(bridge, failMode string, protocols []string) error {
+	o.bridges[bridge] = &ovsdb.BridgeConfig{FailMode: failMode, Protocols: protocols}
+	return nil
+}
+
+func (o *fakeOVS) AddPort(bridge, port string, iface *ovsdb.Interface) error {
+	if _, ok := o.ports[port]; ok {
+		return nil
+	}
+	if iface == nil {
+		iface = &ovsdb.Interface{}
+	}
+	o.ifaces[port] = iface
+	if iface.OfportRequest != 0 {
+		o.ports[port] = iface.OfportRequest
+	} else {
+		o.ports[port] = o.next
+		o.next++
+	}
+	return nil
+}
+
+func (o *fakeOVS) DeletePort(bridge, port string) error {
+	delete(o.ports, port)
+	delete(o.ifaces, port)
+	return nil
+}
+
+func (o *fakeOVS) GetOfport(iface string) (int, error) {
+	ofport, ok := o.ports[iface]
+	if !ok {
+		return -1, fmt.Errorf("Interface %s does not exist", iface)
+	}
+	return ofport, nil
+}
+
+func (o *fakeOVS) GetInterface(iface string) (*ovsdb.Interface, error) {
+	return o.ifaces[iface], nil
+}
+
+func TestOVS(t *testing.T) {
+	ovs := &fakeOVS{
+		bridges: map[string]*ovsdb.BridgeConfig{"br0": {Protocols: []string{"OpenFlow10"}}},
+		ports:   map[string]int{"vxlan0": 3, "tun0": 2},
+		ifaces: map[string]*ovsdb.Interface{
+			"vxlan0": {Type: "vxlan", OfportRequest: 1},
+			"tun0":   {Type: "internal", OfportRequest: 2},
+		},
+		next: 10,
+	}
+	node := &Node{OVS: ovs}
+	steps := []Step{
+		node.OVSBridge("br0"),
+		node.OVSPort("br0", "vxlan0", &ovsdb.Interface{Type: "vxlan", OfportRequest: 1}),
+		node.OVSPort("br0", "tun0", &ovsdb.Interface{Type: "internal", OfportRequest: 2}),
+		node.OVSPort("br0", "veth1", nil),
+	}
+	if err := Run(steps); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if br0 := ovs.bridges["br0"]; !reflect.DeepEqual(br0, &ovsdb.BridgeConfig{FailMode: "secure", Protocols: bridgeProtocols}) {
+		t.Errorf("Wrong br0 config %+v", br0)
+	}
+	if expected := map[string]int{"vxlan0": 1, "tun0": 2, "veth1": 10}; !reflect.DeepEqual(ovs.ports, expected) {
+		t.Errorf("Wrong ports %v", ovs.ports)
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	ovs := &fakeOVS{
+		ports:  map[string]int{"vxlan0": 1},
+		ifaces: map[string]*ovsdb.Interface{"vxlan0": tunnelInterface(api.DefaultTunnel, 1)},
+	}
+	node := &Node{OVS: ovs}
+
+	if done, err := node.TunnelPort("br0", api.DefaultTunnel, 1).Check(); err != nil || !done {
+		t.Errorf("Expected the VXLAN port to be in place: %v", err)
+	}
+	geneve := api.Tunnel{Type: api.TunnelGeneve, Port: 6082}
+	if err := Run([]Step{node.TunnelPort("br0", geneve, 1)}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if expected := map[string]int{"geneve0": 1}; !reflect.DeepEqual(ovs.ports, expected) {
+		t.Errorf("Wrong ports %v", ovs.ports)
+	}
+	expected := &ovsdb.Interface{Type: "geneve", Options: map[string]string{"remote_ip": "flow", "key": "flow", "dst_port": "6082"}, OfportRequest: 1}
+	if iface := ovs.ifaces["geneve0"]; !reflect.DeepEqual(iface, expected) {
+		t.Errorf("Wrong geneve0 interface %+v", iface)
+	}
+
+	tests := []struct {
+		tunnel api.Tunnel
+		name   string
+	}{
+		{api.DefaultTunnel, "iptables rule -t filter INPUT -p udp -m multiport --dports 4789 -m comment --comment 001 vxlan incoming -j ACCEPT"},
+		{api.Tunnel{Type: api.TunnelVXLAN, Port: 8472}, "iptables rule -t filter INPUT -p udp -m multiport --dports 8472 -m comment --comment 001 vxlan incoming -j ACCEPT"},
+		{api.Tunnel{Type: api.TunnelGeneve}, "iptables rule -t filter INPUT -p udp -m multiport --dports 6081 -m comment --comment 001 geneve incoming -j ACCEPT"},
+		{api.Tunnel{Type: api.TunnelGRE}, "iptables rule -t filter INPUT -p gre -m comment --comment 001 gre incoming -j ACCEPT"},
+	}
+	for _, test := range tests {
+		if name := node.TunnelIPTables(test.tunnel).Name; name != test.name {
+			t.Errorf("Wrong rule for %+v: %s", test.tunnel, name)
+		}
+	}
+}
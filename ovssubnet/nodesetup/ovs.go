@@ -0,0 +1,147 @@
+package nodesetup
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/cookie"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
+)
+
+// bridgeProtocols are the OpenFlow versions br0 speaks: 1.3 for flows and
+// 1.4 for bundles.
+var bridgeProtocols = []string{"OpenFlow13", "OpenFlow14"}
+
+// OVSBridge makes sure the OVS bridge exists in secure fail mode, so that it
+// forwards nothing but what its flows allow.
+func (n *Node) OVSBridge(bridge string) Step {
+	return Step{
+		Name: "OVS bridge " + bridge,
+		Check: func() (bool, error) {
+			config, err := n.OVS.GetBridge(bridge)
+			if err != nil || config == nil {
+				return false, err
+			}
+			return config.FailMode == "secure" && reflect.DeepEqual(config.Protocols, bridgeProtocols), nil
+		},
+		Apply: func() error {
+			if err := n.OVS.AddBridge(bridge, "secure", bridgeProtocols); err != nil {
+				return err
+			}
+			return n.OVS.SetBridge(bridge, "secure", bridgeProtocols)
+		},
+	}
+}
+
+// OVSPort makes sure port is on bridge, with the type and options of iface
+// and the OpenFlow port number the flows expect if iface requests one. A port
+// that differs is recreated.
+func (n *Node) OVSPort(bridge, port string, iface *ovsdb.Interface) Step {
+	return Step{
+		Name: fmt.Sprintf("OVS port %s on %s", port, bridge),
+		Check: func() (bool, error) {
+			ofport, err := n.OVS.GetOfport(port)
+			if err != nil {
+				// missing, or not attached yet
+				return false, nil
+			}
+			if iface == nil {
+				return true, nil
+			}
+			config, err := n.OVS.GetInterface(port)
+			if err != nil || config == nil {
+				return false, err
+			}
+			if config.Type != iface.Type || !sameOptions(config.Options, iface.Options) {
+				return false, nil
+			}
+			return iface.OfportRequest == 0 || ofport == iface.OfportRequest, nil
+		},
+		Apply: func() error {
+			if err := n.OVS.DeletePort(bridge, port); err != nil {
+				return err
+			}
+			return n.OVS.AddPort(bridge, port, iface)
+		},
+	}
+}
+
+// sameOptions tells whether two sets of interface options are equal, taking
+// nil and empty as the same.
+func sameOptions(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// Flows makes sure bridge has flows and no other flows with the System
+// cookie, e.g. base flows of an older release. Flows with other cookies are
+// left alone.
+func (n *Node) Flows(bridge string, flows []*ofctl.Flow) Step {
+	// diff returns the flows missing from the bridge and the System flows
+	// on it that are not among flows
+	diff := func() ([]*ofctl.Flow, []*ofctl.Flow, error) {
+		out, err := n.Executor.Exec("ovs-ofctl", "-O", "OpenFlow13", "dump-flows", bridge)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to dump flows: %v (%s)", err, out)
+		}
+		dumped, err := ofctl.ParseDump(string(out))
+		if err != nil {
+			return nil, nil, err
+		}
+		want := make(map[string]bool)
+		for _, f := range flows {
+			want[f.Canonical().String()] = true
+		}
+		have := make(map[string]bool)
+		stray := []*ofctl.Flow{}
+		for _, f := range dumped {
+			key := f.Canonical().String()
+			have[key] = true
+			if cookie.Cookie(f.Cookie).Kind() == cookie.System && !want[key] {
+				stray = append(stray, f)
+			}
+		}
+		missing := []*ofctl.Flow{}
+		for _, f := range flows {
+			if !have[f.Canonical().String()] {
+				missing = append(missing, f)
+			}
+		}
+		return missing, stray, nil
+	}
+	return Step{
+		Name: "base flows on " + bridge,
+		Check: func() (bool, error) {
+			missing, stray, err := diff()
+			return len(missing) == 0 && len(stray) == 0, err
+		},
+		Apply: func() error {
+			missing, stray, err := diff()
+			if err != nil {
+				return err
+			}
+			// strays go first, as a flow replacing one of them may have
+			// the same match and priority
+			for _, f := range stray {
+				match := f.StrictMatch()
+				if out, err := n.Executor.Exec("ovs-ofctl", "-O", "OpenFlow13", "--strict", "del-flows", bridge, match); err != nil {
+					return fmt.Errorf("Failed to delete flow %s: %v (%s)", match, err, out)
+				}
+			}
+			for _, f := range missing {
+				if out, err := n.Executor.Exec("ovs-ofctl", "-O", "OpenFlow13", "add-flow", bridge, f.String()); err != nil {
+					return fmt.Errorf("Failed to add flow %s: %v (%s)", f, err, out)
+				}
+			}
+			return nil
+		},
+	}
+}
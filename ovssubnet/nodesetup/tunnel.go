@@ -0,0 +1,65 @@
+package nodesetup
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ovsdb"
+)
+
+// tunnelInterface returns the OVS interface of the tunnel port. The remote
+// end and the key are set per packet by the flows.
+func tunnelInterface(tunnel api.Tunnel, ofport int) *ovsdb.Interface {
+	iface := &ovsdb.Interface{
+		Type:          string(tunnel.Type),
+		Options:       map[string]string{"remote_ip": "flow", "key": "flow"},
+		OfportRequest: ofport,
+	}
+	// leave the standard port implicit, as on ports made by the old setup
+	// scripts
+	if tunnel.Port != 0 && tunnel.Port != (api.Tunnel{Type: tunnel.Type}).UDPPort() {
+		iface.Options["dst_port"] = strconv.FormatUint(uint64(tunnel.Port), 10)
+	}
+	return iface
+}
+
+// TunnelPort makes sure bridge has the port of the cluster tunnel at ofport,
+// and no port left over from another tunnel type.
+func (n *Node) TunnelPort(bridge string, tunnel api.Tunnel, ofport int) Step {
+	others := []string{}
+	for _, t := range api.TunnelTypes {
+		if t != tunnel.Type {
+			others = append(others, api.Tunnel{Type: t}.PortName())
+		}
+	}
+	step := n.OVSPort(bridge, tunnel.PortName(), tunnelInterface(tunnel, ofport))
+	check, apply := step.Check, step.Apply
+	step.Check = func() (bool, error) {
+		for _, port := range others {
+			if iface, err := n.OVS.GetInterface(port); err != nil || iface != nil {
+				return false, err
+			}
+		}
+		return check()
+	}
+	step.Apply = func() error {
+		for _, port := range others {
+			if err := n.OVS.DeletePort(bridge, port); err != nil {
+				return err
+			}
+		}
+		return apply()
+	}
+	return step
+}
+
+// TunnelIPTables makes sure the host accepts tunnel traffic from other nodes.
+func (n *Node) TunnelIPTables(tunnel api.Tunnel) Step {
+	args := []string{"-p", "gre"}
+	if port := tunnel.UDPPort(); port != 0 {
+		args = []string{"-p", "udp", "-m", "multiport", "--dports", strconv.FormatUint(uint64(port), 10)}
+	}
+	args = append(args, "-m", "comment", "--comment", fmt.Sprintf("001 %s incoming", tunnel.Type), "-j", "ACCEPT")
+	return n.IPTables(IPTablesRule{Table: "filter", Chain: "INPUT", Args: args, Before: "RELATED,ESTABLISHED"})
+}
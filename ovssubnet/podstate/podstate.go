@@ -0,0 +1,123 @@
+// Package podstate keeps a record of every local pod the pod hook attached to
+// br0, so that the node can work out which pod flows should exist.
+package podstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+)
+
+// DefaultDir is where the pod hooks keep their records.
+const DefaultDir = "/run/openshift-sdn/pods"
+
+// Pod is the record of a pod attached to br0.
+type Pod struct {
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	ContainerID string `json:"containerID"`
+	// Veth is the host side of the pod's veth pair, which is the br0 port.
+	Veth   string `json:"veth"`
+	Ofport int    `json:"ofport"`
+	IP     string `json:"ip"`
+	// MAC is the MAC address of eth0 in the pod, which br0 answers ARP
+	// requests for IP with. Older records have none; see MACAddress.
+	MAC string `json:"mac,omitempty"`
+	// VNID is the network ID the hook isolated the pod with (multitenant only).
+	VNID uint `json:"vnid"`
+	// IngressBandwidth and EgressBandwidth are the limits in bits per second
+	// on the traffic to and from the pod, 0 for none.
+	IngressBandwidth uint64 `json:"ingressBandwidth,omitempty"`
+	EgressBandwidth  uint64 `json:"egressBandwidth,omitempty"`
+}
+
+// MACAddress returns the MAC address of the pod, for records without one
+// the address docker gives a container of the pod's IP.
+func (p *Pod) MACAddress() string {
+	if p.MAC != "" {
+		return p.MAC
+	}
+	ip := net.ParseIP(p.IP)
+	if ip == nil || ip.To4() == nil {
+		return ""
+	}
+	return netutils.GenerateMAC(ip).String()
+}
+
+func recordPath(dir, namespace, name string) string {
+	return filepath.Join(dir, namespace+"_"+name+".json")
+}
+
+// Write records pod in dir, replacing any earlier record of the same pod.
+func Write(dir string, pod *Pod) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(pod)
+	if err != nil {
+		return err
+	}
+	// write and rename, so that readers never see a partial record
+	path := recordPath(dir, pod.Namespace, pod.Name)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Remove deletes the record of a pod, if there is one.
+func Remove(dir, namespace, name string) error {
+	err := os.Remove(recordPath(dir, namespace, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns every pod recorded in dir. A missing dir means no pods.
+func List(dir string) ([]Pod, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Pod{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	pods := make([]Pod, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		pod := Pod{}
+		if err := json.Unmarshal(data, &pod); err != nil {
+			return nil, fmt.Errorf("Invalid pod record %s: %v", f.Name(), err)
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// Get returns the record of a pod, or nil if there is none.
+func Get(dir, namespace, name string) (*Pod, error) {
+	data, err := ioutil.ReadFile(recordPath(dir, namespace, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pod := &Pod{}
+	if err := json.Unmarshal(data, pod); err != nil {
+		return nil, fmt.Errorf("Invalid record of pod %s/%s: %v", namespace, name, err)
+	}
+	return pod, nil
+}
@@ -0,0 +1,73 @@
+package podstate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "podstate")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, "pods")
+
+	pods, err := List(dir)
+	if err != nil || len(pods) != 0 {
+		t.Fatalf("Expected no pods in a missing dir, got %v (%v)", pods, err)
+	}
+
+	web := Pod{Namespace: "default", Name: "web", ContainerID: "abc", Veth: "veth1234", Ofport: 3, IP: "10.1.2.2", VNID: 10}
+	db := Pod{Namespace: "other", Name: "db", ContainerID: "def", Veth: "veth5678", Ofport: 4, IP: "10.1.2.3", MAC: "02:42:0a:01:02:63"}
+	for _, pod := range []Pod{web, db} {
+		if err := Write(dir, &pod); err != nil {
+			t.Fatalf("Error writing %s: %v", pod.Name, err)
+		}
+	}
+	web.Ofport = 5
+	if err := Write(dir, &web); err != nil {
+		t.Fatalf("Error rewriting web: %v", err)
+	}
+
+	pods, err = List(dir)
+	if err != nil || !reflect.DeepEqual(pods, []Pod{web, db}) {
+		t.Fatalf("Wrong pods: %+v (%v)", pods, err)
+	}
+	if pod, err := Get(dir, "default", "web"); err != nil || !reflect.DeepEqual(pod, &web) {
+		t.Fatalf("Wrong web pod: %+v (%v)", pod, err)
+	}
+	if pod, err := Get(dir, "default", "db"); err != nil || pod != nil {
+		t.Fatalf("Expected no pod default/db, got %+v (%v)", pod, err)
+	}
+
+	if err := Remove(dir, "default", "web"); err != nil {
+		t.Fatalf("Error removing web: %v", err)
+	}
+	if err := Remove(dir, "default", "web"); err != nil {
+		t.Fatalf("Error removing web twice: %v", err)
+	}
+	pods, err = List(dir)
+	if err != nil || !reflect.DeepEqual(pods, []Pod{db}) {
+		t.Fatalf("Wrong pods after removal: %+v (%v)", pods, err)
+	}
+}
+
+func TestMACAddress(t *testing.T) {
+	tests := []struct {
+		pod Pod
+		mac string
+	}{
+		{Pod{IP: "10.1.2.3", MAC: "02:42:0a:01:02:63"}, "02:42:0a:01:02:63"},
+		{Pod{IP: "10.1.2.3"}, "02:42:0a:01:02:03"},
+		{Pod{}, ""},
+	}
+	for _, test := range tests {
+		if mac := test.pod.MACAddress(); mac != test.mac {
+			t.Errorf("Wrong MAC address for %+v: %q", test.pod, mac)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// DefaultFlowReconcileInterval is how often a node checks br0 against the
+// flows it should have.
+const DefaultFlowReconcileInterval = 5 * time.Minute
+
+// FlowRepairs returns how many groups of flows the reconciler has repaired.
+func (oc *OvsController) FlowRepairs() uint64 {
+	return atomic.LoadUint64(&oc.flowRepairs)
+}
+
+func (oc *OvsController) reconcileFlowsPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, ok := oc.flowController.(*multitenant.FlowController); ok {
+				oc.publishNodeVNIDs()
+			}
+			if err := oc.reconcileFlows(); err != nil {
+				log.Errorf("Failed to reconcile flows: %v", err)
+			}
+		case <-oc.sig:
+			return
+		}
+	}
+}
+
+// reconcileFlows computes the node and pod flows br0 should have from the
+// registry's subnets and VNIDs and the local pod records, compares them with
+// the flows on the switch by table and cookie, and reinstalls missing or
+// modified groups and removes stray ones. The OpenFlow groups of multicast
+// and service load balancing are reconciled likewise.
+func (oc *OvsController) reconcileFlows() error {
+	return oc.syncFlows(true)
+}
+
+// lockFlows takes the locks that keep br0 from changing under a dump and
+// the changes made from it: flowLock against the watchers and the
+// reconciler, and the lock file against the pod hooks, which add a pod's
+// record before its flows and remove it after them. It returns the function
+// releasing both.
+func (oc *OvsController) lockFlows() (func(), error) {
+	oc.flowLock.Lock()
+	unlock, err := nodesetup.Lock(oc.lockFile)
+	if err != nil {
+		oc.flowLock.Unlock()
+		return nil, err
+	}
+	return func() {
+		unlock()
+		oc.flowLock.Unlock()
+	}, nil
+}
+
+// syncFlows brings br0 in line with the flows it should have like
+// reconcileFlows. Unless repair is set the differences are expected, e.g.
+// after a network policy changed, and are not counted as repairs.
+func (oc *OvsController) syncFlows(repair bool) error {
+	unlock, err := oc.lockFlows()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	subnets, err := oc.subnetRegistry.GetSubnets()
+	if err != nil {
+		return fmt.Errorf("Could not fetch subnets: %v", err)
+	}
+	if rs, ok := oc.flowController.(RouteSyncer); ok {
+		return oc.syncRoutes(rs, *subnets, repair)
+	}
+	pods, err := oc.localPods()
+	if err != nil {
+		return fmt.Errorf("Could not read pod records: %v", err)
+	}
+	desired := oc.flowController.DesiredFlows(*subnets, pods, oc.localIP)
+	if fc, ok := oc.flowController.(*multitenant.FlowController); ok {
+		return oc.syncGroups(fc.DesiredGroups(*subnets, pods, oc.localIP), repair, func() error {
+			return oc.syncBridgeFlows(desired, repair)
+		})
+	}
+	return oc.syncBridgeFlows(desired, repair)
+}
+
+func (oc *OvsController) syncBridgeFlows(desired []*ofctl.Flow, repair bool) error {
+	out, err := oc.executor.Exec("ovs-ofctl", "-O", "OpenFlow13", "dump-flows", "br0")
+	if err != nil {
+		return fmt.Errorf("Could not dump flows: %v (%s)", err, out)
+	}
+	repairs, err := diffFlows(desired, string(out))
+	if err != nil {
+		return err
+	}
+
+	if len(repairs) == 0 {
+		return nil
+	}
+	mods := []flowMod{}
+	for _, r := range repairs {
+		mods = append(mods, flowMod{"delete", fmt.Sprintf("table=%d,cookie=0x%x/-1", r.table, r.cookie)})
+		mods = append(mods, addFlows(r.flows)...)
+	}
+	if err := oc.applyFlowMods(mods); err != nil {
+		return fmt.Errorf("Failed to repair flows: %v", err)
+	}
+	if !repair {
+		log.Infof("Updated %d groups of flows", len(repairs))
+		return nil
+	}
+	for _, r := range repairs {
+		repaired := atomic.AddUint64(&oc.flowRepairs, 1)
+		log.Warningf("Repaired %s flows with cookie 0x%x in table %d (%d repairs so far)", r.reason, r.cookie, r.table, repaired)
+	}
+	return nil
+}
+
+// syncRoutes is syncFlows for the modes without br0: it brings the kernel
+// entries for the subnets of other nodes in line with the registry.
+func (oc *OvsController) syncRoutes(rs RouteSyncer, subnets []api.Subnet, repair bool) error {
+	changes, err := rs.SyncRoutes(subnets, oc.localIP)
+	if err != nil {
+		return fmt.Errorf("Failed to repair routes: %v", err)
+	}
+	if changes == 0 || !repair {
+		return nil
+	}
+	repaired := atomic.AddUint64(&oc.flowRepairs, uint64(changes))
+	log.Warningf("Repaired %d node routes or neighbour entries (%d repairs so far)", changes, repaired)
+	return nil
+}
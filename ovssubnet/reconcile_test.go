@@ -0,0 +1,260 @@
+package ovssubnet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant"
+	"github.com/openshift/openshift-sdn/ovssubnet/nodesetup"
+	"github.com/openshift/openshift-sdn/ovssubnet/podstate"
+	"github.com/openshift/openshift-sdn/pkg/exec"
+)
+
+// fakeRegistry serves a fixed set of subnets and records net namespaces and
+// egress IPs; other registry methods are not used by the tests and panic.
+type fakeRegistry struct {
+	api.SubnetRegistry
+	subnets       []api.Subnet
+	netNamespaces map[string]uint
+	egressIPs     []api.EgressIP
+	// requests are the namespaces whose network requests were deleted
+	requests []string
+}
+
+func (r *fakeRegistry) GetSubnets() (*[]api.Subnet, error) {
+	return &r.subnets, nil
+}
+
+func TestReconcileFlows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pods")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// the hook made up VNID 5; the registry says the namespace has VNID 10
+	pod := &podstate.Pod{Namespace: "web", Name: "frontend", Veth: "veth1", Ofport: 3, IP: "10.1.2.2", VNID: 5}
+	if err := podstate.Write(dir, pod); err != nil {
+		t.Fatalf("Error writing pod record: %v", err)
+	}
+
+	// br0 was flushed except for one stale node flow, with the cookie of an
+	// older release
+	dump := `OFPST_FLOW reply (OF1.3) (xid=0x2):
+ cookie=0xac110009, duration=100.1s, table=6, n_packets=0, n_bytes=0, priority=100,ip,nw_dst=10.1.9.0/24 actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.9->tun_dst,output:1
+`
+	var flowFile string
+	// no groups to dump, then the flows
+	executor := exec.NewFake(exec.FakeResult{}, exec.FakeResult{Output: dump}, exec.FakeResult{Run: func(cmd []string) {
+		flowFile = readFlowFile(t, cmd)
+	}})
+	oc := &OvsController{
+		subnetRegistry: &fakeRegistry{subnets: []api.Subnet{
+			{Minion: "172.17.0.2", Sub: "10.1.2.0/24"},
+			{Minion: "172.17.0.3", Sub: "10.1.3.0/24"},
+		}},
+		localIP:        "172.17.0.2",
+		flowController: multitenant.NewFlowController(executor),
+		VnidMap:        map[string]uint{"web": 10},
+		executor:       executor,
+		podStateDir:    dir,
+		lockFile:       filepath.Join(dir, "lock"),
+	}
+
+	if err := oc.reconcileFlows(); err != nil {
+		t.Fatalf("Error reconciling flows: %v", err)
+	}
+	commands := executor.CommandLines()
+	if len(commands) != 3 || commands[0] != "ovs-ofctl -O OpenFlow13 dump-groups br0" || commands[1] != "ovs-ofctl -O OpenFlow13 dump-flows br0" || !strings.HasPrefix(commands[2], bundleCommand) {
+		t.Fatalf("Expected dumps of groups and flows and one bundle, got %q", commands)
+	}
+	expected := `delete table=3,cookie=0x300000000000003/-1
+add table=3,cookie=0x300000000000003,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4
+delete table=4,cookie=0x2000000ac110002/-1
+add table=4,cookie=0x2000000ac110002,priority=50,ip,nw_dst=172.17.0.2,actions=output:2
+delete table=4,cookie=0x2000000ac110003/-1
+add table=4,cookie=0x2000000ac110003,priority=50,ip,nw_dst=172.17.0.3,actions=output:2
+delete table=6,cookie=0xac110009/-1
+delete table=6,cookie=0x2000000ac110003/-1
+add table=6,cookie=0x2000000ac110003,priority=100,ip,nw_dst=10.1.3.0/24,actions=move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23],set_field:172.17.0.3->tun_dst,output:1
+delete table=7,cookie=0x2000000ac110003/-1
+add table=7,cookie=0x2000000ac110003,priority=100,arp,nw_dst=10.1.3.0/24,actions=move:NXM_NX_REG0[0..23]->NXM_NX_TUN_ID[0..23],set_field:172.17.0.3->tun_dst,output:1
+delete table=7,cookie=0x300000000000003/-1
+add table=7,cookie=0x300000000000003,priority=300,arp,arp_op=1,arp_tpa=10.1.2.2,actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:02:42:0a:01:02:02->eth_src,set_field:2->arp_op,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],set_field:02:42:0a:01:02:02->arp_sha,move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],set_field:10.1.2.2->arp_spa,move:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],IN_PORT
+delete table=8,cookie=0x300000000000003/-1
+add table=8,cookie=0x300000000000003,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3
+`
+	if flowFile != expected {
+		t.Fatalf("Wrong repairs.\nExpected %s\nGot      %s", expected, flowFile)
+	}
+	if oc.FlowRepairs() != 8 {
+		t.Fatalf("Expected 8 repairs, got %d", oc.FlowRepairs())
+	}
+}
+
+func TestSyncFlowsForPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pods")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	pod := &podstate.Pod{Namespace: "web", Name: "frontend", Veth: "veth1", Ofport: 3, IP: "10.1.2.2", VNID: 10}
+	if err := podstate.Write(dir, pod); err != nil {
+		t.Fatalf("Error writing pod record: %v", err)
+	}
+
+	// br0 has the flows of the node and the pod from before the policy
+	dump := `OFPST_FLOW reply (OF1.3) (xid=0x2):
+ cookie=0x2000000ac110002, duration=100.1s, table=4, n_packets=0, n_bytes=0, priority=50,ip,nw_dst=172.17.0.2 actions=output:2
+ cookie=0x300000000000003, duration=100.1s, table=3, n_packets=0, n_bytes=0, priority=100,ip,in_port=3,nw_src=10.1.2.2 actions=load:0xa->NXM_NX_REG0[],goto_table:4
+ cookie=0x300000000000003, duration=100.1s, table=7, n_packets=0, n_bytes=0, priority=300,arp,arp_tpa=10.1.2.2,arp_op=1 actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:02:42:0a:01:02:02->eth_src,set_field:2->arp_op,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],set_field:02:42:0a:01:02:02->arp_sha,move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],set_field:10.1.2.2->arp_spa,move:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],IN_PORT
+ cookie=0x300000000000003, duration=100.1s, table=8, n_packets=0, n_bytes=0, priority=100,ip,reg0=0xa,nw_dst=10.1.2.2 actions=output:3
+`
+	var flowFile string
+	// no groups to dump, then the flows
+	executor := exec.NewFake(exec.FakeResult{}, exec.FakeResult{Output: dump}, exec.FakeResult{Run: func(cmd []string) {
+		flowFile = readFlowFile(t, cmd)
+	}})
+	oc := &OvsController{
+		subnetRegistry: &fakeRegistry{subnets: []api.Subnet{{Minion: "172.17.0.2", Sub: "10.1.2.0/24"}}},
+		localIP:        "172.17.0.2",
+		flowController: multitenant.NewFlowController(executor),
+		VnidMap:        map[string]uint{"web": 10, "db": 11},
+		networkPolicies: map[string]api.NetworkPolicy{
+			"web/from-db": {Namespace: "web", Name: "from-db", Ingress: []api.PolicyRule{{FromNamespace: "db", Protocol: "tcp", Port: 80}}},
+		},
+		executor:    executor,
+		podStateDir: dir,
+		lockFile:    filepath.Join(dir, "lock"),
+	}
+
+	oc.updatePolicies()
+	if err := oc.syncFlows(false); err != nil {
+		t.Fatalf("Error applying the policy: %v", err)
+	}
+	expected := `delete table=5,cookie=0x300000000000003/-1
+add table=5,cookie=0x300000000000003,priority=100,tcp,nw_dst=10.1.2.2,reg0=11,tp_dst=80,actions=load:10->NXM_NX_REG0[],goto_table:8
+`
+	if flowFile != expected {
+		t.Fatalf("Wrong policy flows.\nExpected %s\nGot      %s", expected, flowFile)
+	}
+	if oc.FlowRepairs() != 0 {
+		t.Fatalf("Expected a policy change not to count as a repair, got %d repairs", oc.FlowRepairs())
+	}
+}
+
+// TestSyncFlowsWaitsForHooks checks that br0 is not dumped while a pod hook
+// holds the lock, as the hook may have added a pod's record but not yet its
+// flows, or removed its flows but not yet its record.
+func TestSyncFlowsWaitsForHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pods")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	executor := exec.NewFake(exec.FakeResult{}, exec.FakeResult{})
+	oc := &OvsController{
+		subnetRegistry: &fakeRegistry{subnets: []api.Subnet{{Minion: "172.17.0.2", Sub: "10.1.2.0/24"}}},
+		localIP:        "172.17.0.2",
+		flowController: multitenant.NewFlowController(executor),
+		VnidMap:        map[string]uint{},
+		executor:       executor,
+		podStateDir:    dir,
+		lockFile:       filepath.Join(dir, "lock"),
+	}
+
+	unlock, err := nodesetup.Lock(oc.lockFile)
+	if err != nil {
+		t.Fatalf("Error taking the lock: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- oc.syncFlows(false)
+	}()
+	select {
+	case err := <-done:
+		unlock()
+		t.Fatalf("Expected syncFlows to wait for the lock, it returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if commands := executor.CommandLines(); len(commands) != 0 {
+		t.Errorf("Expected no commands while the lock is held, got %q", commands)
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("Error syncing flows: %v", err)
+	}
+	if commands := executor.CommandLines(); len(commands) == 0 {
+		t.Errorf("Expected br0 to be dumped once the lock was released")
+	}
+}
+
+// fakeFlowController records the nodes whose flows are added or deleted;
+// other flow controller methods are not used by the tests and panic.
+type fakeFlowController struct {
+	FlowController
+	nodes chan string
+}
+
+func (c *fakeFlowController) AddOFRules(minionIP, localSubnet, localIP string) error {
+	c.nodes <- "add " + minionIP
+	return nil
+}
+
+func (c *fakeFlowController) DelOFRules(minionIP, localIP string) error {
+	c.nodes <- "delete " + minionIP
+	return nil
+}
+
+// TestNodeFlowsWaitForSync checks that the flows of a node are not added or
+// deleted while a flow sync holds the locks, as the sync may have read the
+// subnets before the node was added or deleted.
+func TestNodeFlowsWaitForSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pods")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fc := &fakeFlowController{nodes: make(chan string, 1)}
+	oc := &OvsController{
+		localIP:        "172.17.0.2",
+		flowController: fc,
+		lockFile:       filepath.Join(dir, "lock"),
+	}
+	subnet := api.Subnet{Minion: "172.17.0.3", Sub: "10.1.3.0/24"}
+	for _, test := range []struct {
+		ev       *api.SubnetEvent
+		expected string
+	}{
+		{ev: &api.SubnetEvent{Type: api.Added, Sub: subnet}, expected: "add 172.17.0.3"},
+		{ev: &api.SubnetEvent{Type: api.Deleted, Sub: subnet}, expected: "delete 172.17.0.3"},
+	} {
+		unlock, err := oc.lockFlows()
+		if err != nil {
+			t.Fatalf("Error taking the locks: %v", err)
+		}
+		done := make(chan error)
+		go func(ev *api.SubnetEvent) {
+			done <- oc.updateNodeFlows(ev)
+		}(test.ev)
+		select {
+		case node := <-fc.nodes:
+			unlock()
+			t.Fatalf("Expected the flows to wait for the locks, got %s", node)
+		case <-time.After(100 * time.Millisecond):
+		}
+		unlock()
+		if err := <-done; err != nil {
+			t.Fatalf("Error updating node flows: %v", err)
+		}
+		if node := <-fc.nodes; node != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, node)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,18 +23,60 @@ type EtcdConfig struct {
 	SubnetPath       string
 	SubnetConfigPath string
 	MinionPath       string
+	NetNamespacePath string
+	// NetworkPolicyPath holds the network policies of namespaces, at
+	// <NetworkPolicyPath>/<namespace>/<name>
+	NetworkPolicyPath string
+	// EgressNetworkPolicyPath holds the egress network policies of
+	// namespaces, at <EgressNetworkPolicyPath>/<namespace>
+	EgressNetworkPolicyPath string
+	// EgressIPPath holds the egress IPs of namespaces, at
+	// <EgressIPPath>/<namespace>
+	EgressIPPath string
+	// HeartbeatPath holds a key per live node that expires unless the node
+	// refreshes it
+	HeartbeatPath string
+	// ServicePath holds the services to load-balance in br0, at
+	// <ServicePath>/<namespace>/<name>
+	ServicePath string
+	// MulticastPath holds a key per namespace with multicast enabled, at
+	// <MulticastPath>/<namespace>
+	MulticastPath string
+	// NodeVNIDPath holds the VNIDs of the pods of each node, at
+	// <NodeVNIDPath>/<node-ip>
+	NodeVNIDPath string
+	// NetNamespaceRequestPath holds the requests to change the network of
+	// namespaces, at <NetNamespaceRequestPath>/<namespace>
+	NetNamespaceRequestPath string
+	// PodPath holds the pods as the Kubernetes API server stores them, at
+	// <PodPath>/<namespace>/<name>
+	PodPath string
 }
 
+// etcd's error code for a missing key
+const etcdKeyNotFound = 100
+
 type EtcdSubnetRegistry struct {
 	mux     sync.Mutex
 	cli     *etcd.Client
 	etcdCfg *EtcdConfig
 }
 
+// isDeleteAction tells whether an etcd watch action removed the key: etcd
+// reports a key whose TTL ran out as "expire" and a conditional delete as
+// "compareAndDelete".
+func isDeleteAction(action string) bool {
+	switch action {
+	case "delete", "deleted", "expire", "expired", "compareAndDelete":
+		return true
+	}
+	return false
+}
+
 func newMinionEvent(action, key, value string) *api.MinionEvent {
 	min := &api.MinionEvent{}
-	switch action {
-	case "delete", "deleted", "expired":
+	switch {
+	case isDeleteAction(action):
 		min.Type = api.Deleted
 	default:
 		min.Type = api.Added
@@ -52,8 +95,8 @@ func newSubnetEvent(resp *etcd.Response) *api.SubnetEvent {
 	var value string
 	_, minkey := path.Split(resp.Node.Key)
 	var t api.EventType
-	switch resp.Action {
-	case "deleted", "delete", "expired":
+	switch {
+	case isDeleteAction(resp.Action):
 		t = api.Deleted
 		value = resp.PrevNode.Value
 	default:
@@ -72,6 +115,154 @@ func newSubnetEvent(resp *etcd.Response) *api.SubnetEvent {
 	return nil
 }
 
+func newNetNamespaceEvent(resp *etcd.Response) *api.NetNamespaceEvent {
+	if resp.Node.Dir {
+		return nil
+	}
+	_, name := path.Split(resp.Node.Key)
+	switch {
+	case isDeleteAction(resp.Action):
+		return &api.NetNamespaceEvent{Type: api.Deleted, Name: name}
+	}
+	var ns api.NetNamespace
+	if err := json.Unmarshal([]byte(resp.Node.Value), &ns); err != nil {
+		log.Errorf("Error unmarshalling net namespace %s: %v", resp.Node.Key, err)
+		return nil
+	}
+	return &api.NetNamespaceEvent{Type: api.Added, Name: name, NetID: ns.NetID}
+}
+
+// deletedNamespacedKey returns the namespace and name a deleted key under
+// root, laid out as <root>/<namespace>/<name>, stood for. The key names them
+// whether or not etcd sent the old value. Deleting a namespace's directory
+// deletes everything in it, which is told with an empty name.
+func deletedNamespacedKey(resp *etcd.Response, root string) (namespace, name string, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(resp.Node.Key, root), "/"), "/")
+	switch {
+	case resp.Node.Dir && len(parts) == 1 && parts[0] != "":
+		return parts[0], "", true
+	case !resp.Node.Dir && len(parts) == 2:
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
+
+// newNetworkPolicyEvent decodes a change under root, the network policy path.
+// Deleting a namespace's directory deletes all its policies, which the event
+// tells with an empty policy name.
+func newNetworkPolicyEvent(resp *etcd.Response, root string) *api.NetworkPolicyEvent {
+	switch {
+	case isDeleteAction(resp.Action):
+		namespace, name, ok := deletedNamespacedKey(resp, root)
+		if !ok {
+			log.Errorf("Ignoring deletion of %s, which is not a network policy", resp.Node.Key)
+			return nil
+		}
+		return &api.NetworkPolicyEvent{Type: api.Deleted, Policy: api.NetworkPolicy{Namespace: namespace, Name: name}}
+	}
+	if resp.Node.Dir {
+		// a new namespace directory; its policies have their own events
+		return nil
+	}
+	ev := &api.NetworkPolicyEvent{Type: api.Added}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &ev.Policy); err != nil {
+		log.Errorf("Error unmarshalling network policy %s: %v", resp.Node.Key, err)
+		return nil
+	}
+	return ev
+}
+
+// newServiceEvent decodes a change under root, the service path, like
+// newNetworkPolicyEvent.
+func newServiceEvent(resp *etcd.Response, root string) *api.ServiceEvent {
+	switch {
+	case isDeleteAction(resp.Action):
+		namespace, name, ok := deletedNamespacedKey(resp, root)
+		if !ok {
+			log.Errorf("Ignoring deletion of %s, which is not a service", resp.Node.Key)
+			return nil
+		}
+		return &api.ServiceEvent{Type: api.Deleted, Service: api.Service{Namespace: namespace, Name: name}}
+	}
+	if resp.Node.Dir {
+		return nil
+	}
+	ev := &api.ServiceEvent{Type: api.Added}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &ev.Service); err != nil {
+		log.Errorf("Error unmarshalling service %s: %v", resp.Node.Key, err)
+		return nil
+	}
+	return ev
+}
+
+func newEgressNetworkPolicyEvent(resp *etcd.Response) *api.EgressNetworkPolicyEvent {
+	if resp.Node.Dir {
+		return nil
+	}
+	switch {
+	case isDeleteAction(resp.Action):
+		_, namespace := path.Split(resp.Node.Key)
+		return &api.EgressNetworkPolicyEvent{Type: api.Deleted, Policy: api.EgressNetworkPolicy{Namespace: namespace}}
+	}
+	ev := &api.EgressNetworkPolicyEvent{Type: api.Added}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &ev.Policy); err != nil {
+		log.Errorf("Error unmarshalling egress network policy %s: %v", resp.Node.Key, err)
+		return nil
+	}
+	return ev
+}
+
+func newEgressIPEvent(resp *etcd.Response) *api.EgressIPEvent {
+	if resp.Node.Dir {
+		return nil
+	}
+	switch {
+	case isDeleteAction(resp.Action):
+		_, namespace := path.Split(resp.Node.Key)
+		return &api.EgressIPEvent{Type: api.Deleted, EgressIP: api.EgressIP{Namespace: namespace}}
+	}
+	ev := &api.EgressIPEvent{Type: api.Added}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &ev.EgressIP); err != nil {
+		log.Errorf("Error unmarshalling egress IP %s: %v", resp.Node.Key, err)
+		return nil
+	}
+	return ev
+}
+
+func newNetNamespaceRequestEvent(resp *etcd.Response) *api.NetNamespaceRequestEvent {
+	if resp.Node.Dir {
+		return nil
+	}
+	switch {
+	case isDeleteAction(resp.Action):
+		_, namespace := path.Split(resp.Node.Key)
+		return &api.NetNamespaceRequestEvent{Type: api.Deleted, Request: api.NetNamespaceRequest{Namespace: namespace}}
+	}
+	ev := &api.NetNamespaceRequestEvent{Type: api.Added}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &ev.Request); err != nil {
+		log.Errorf("Error unmarshalling network namespace request %s: %v", resp.Node.Key, err)
+		return nil
+	}
+	return ev
+}
+
+func newNodeVNIDsEvent(resp *etcd.Response) *api.NodeVNIDsEvent {
+	if resp.Node.Dir {
+		return nil
+	}
+	switch {
+	case isDeleteAction(resp.Action):
+		_, node := path.Split(resp.Node.Key)
+		return &api.NodeVNIDsEvent{Type: api.Deleted, NodeVNIDs: api.NodeVNIDs{Node: node}}
+	}
+	ev := &api.NodeVNIDsEvent{Type: api.Added}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &ev.NodeVNIDs); err != nil {
+		log.Errorf("Error unmarshalling node VNIDs %s: %v", resp.Node.Key, err)
+		return nil
+	}
+	return ev
+}
+
 func newEtcdClient(c *EtcdConfig) (*etcd.Client, error) {
 	if c.Keyfile != "" || c.Certfile != "" || c.CAFile != "" {
 		return etcd.NewTLSClient(c.Endpoints, c.Certfile, c.Keyfile, c.CAFile)
@@ -196,26 +387,25 @@ func (sub *EtcdSubnetRegistry) DeleteSubnet(minion string) error {
 	return err
 }
 
-func (sub *EtcdSubnetRegistry) WriteNetworkConfig(network string, subnetLength uint) error {
-	key := path.Join(sub.etcdCfg.SubnetConfigPath, "ContainerNetwork")
-	_, err := sub.client().Create(key, network, 0)
-	if err != nil {
-		log.Warningf("Found existing network configuration, overwriting it.")
-		_, err = sub.client().Update(key, network, 0)
-		if err != nil {
-			log.Errorf("Failed to write Network configuration to etcd: %v", err)
-			return err
-		}
+func (sub *EtcdSubnetRegistry) WriteNetworkConfig(network string, subnetLength uint, tunnel api.Tunnel) error {
+	config := []struct{ name, data string }{
+		{"ContainerNetwork", network},
+		{"SubnetLength", strconv.FormatUint(uint64(subnetLength), 10)},
+		{"TunnelType", string(tunnel.Type)},
+		{"TunnelPort", strconv.FormatUint(uint64(tunnel.Port), 10)},
 	}
-
-	key = path.Join(sub.etcdCfg.SubnetConfigPath, "SubnetLength")
-	data := strconv.FormatUint(uint64(subnetLength), 10)
-	_, err = sub.client().Create(key, data, 0)
-	if err != nil {
-		_, err = sub.client().Update(key, data, 0)
+	for i, c := range config {
+		key := path.Join(sub.etcdCfg.SubnetConfigPath, c.name)
+		_, err := sub.client().Create(key, c.data, 0)
 		if err != nil {
-			log.Errorf("Failed to write Network configuration to etcd: %v", err)
-			return err
+			if i == 0 {
+				log.Warningf("Found existing network configuration, overwriting it.")
+			}
+			_, err = sub.client().Update(key, c.data, 0)
+			if err != nil {
+				log.Errorf("Failed to write Network configuration to etcd: %v", err)
+				return err
+			}
 		}
 	}
 	return nil
@@ -239,6 +429,32 @@ func (sub *EtcdSubnetRegistry) GetSubnetLength() (uint64, error) {
 	return 0, err
 }
 
+// GetTunnel returns the tunnel configuration, or api.DefaultTunnel for a
+// cluster set up before the tunnel was configurable.
+func (sub *EtcdSubnetRegistry) GetTunnel() (api.Tunnel, error) {
+	key := path.Join(sub.etcdCfg.SubnetConfigPath, "TunnelType")
+	resp, err := sub.client().Get(key, false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return api.DefaultTunnel, nil
+		}
+		return api.Tunnel{}, err
+	}
+	tunnel := api.Tunnel{Type: api.TunnelType(resp.Node.Value)}
+
+	key = path.Join(sub.etcdCfg.SubnetConfigPath, "TunnelPort")
+	resp, err = sub.client().Get(key, false, false)
+	if err != nil {
+		return api.Tunnel{}, err
+	}
+	port, err := strconv.ParseUint(resp.Node.Value, 10, 16)
+	if err != nil {
+		return api.Tunnel{}, err
+	}
+	tunnel.Port = uint(port)
+	return tunnel, tunnel.Validate()
+}
+
 func (sub *EtcdSubnetRegistry) CreateMinion(minion string, data string) error {
 	key := path.Join(sub.etcdCfg.MinionPath, minion)
 	_, err := sub.client().Get(key, false, false)
@@ -342,29 +558,342 @@ func (sub *EtcdSubnetRegistry) WatchNamespaces(receiver chan *api.NamespaceEvent
 }
 
 func (sub *EtcdSubnetRegistry) WatchNetNamespaces(receiver chan *api.NetNamespaceEvent, stop chan bool) error {
-	// TODO
-	return nil
+	var rev uint64
+	key := sub.etcdCfg.NetNamespacePath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Net namespace watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if ev := newNetNamespaceEvent(resp); ev != nil {
+			log.Infof("New net namespace event: %v", ev)
+			receiver <- ev
+		}
+	}
 }
 
 func (sub *EtcdSubnetRegistry) GetNetNamespaces() ([]api.NetNamespace, error) {
 	nslist := make([]api.NetNamespace, 0)
-	// TODO
+	resp, err := sub.client().Get(sub.etcdCfg.NetNamespacePath, false, true)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return nslist, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		var ns api.NetNamespace
+		if err := json.Unmarshal([]byte(node.Value), &ns); err != nil {
+			log.Errorf("Error unmarshalling net namespace %s: %v", node.Key, err)
+			continue
+		}
+		nslist = append(nslist, ns)
+	}
 	return nslist, nil
 }
 
 func (sub *EtcdSubnetRegistry) GetNetNamespace(name string) (api.NetNamespace, error) {
-	// TODO
-	return api.NetNamespace{}, nil
+	var ns api.NetNamespace
+	resp, err := sub.client().Get(path.Join(sub.etcdCfg.NetNamespacePath, name), false, false)
+	if err != nil {
+		return ns, err
+	}
+	err = json.Unmarshal([]byte(resp.Node.Value), &ns)
+	return ns, err
 }
 
 func (sub *EtcdSubnetRegistry) WriteNetNamespace(name string, id uint) error {
-	// TODO
-	return nil
+	data, err := json.Marshal(&api.NetNamespace{Name: name, NetID: id})
+	if err != nil {
+		return err
+	}
+	_, err = sub.client().Set(path.Join(sub.etcdCfg.NetNamespacePath, name), string(data), 0)
+	return err
 }
 
 func (sub *EtcdSubnetRegistry) DeleteNetNamespace(name string) error {
-	// TODO
-	return nil
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.NetNamespacePath, name), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) GetNetNamespaceRequests() ([]api.NetNamespaceRequest, error) {
+	requests := make([]api.NetNamespaceRequest, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.NetNamespaceRequestPath, true, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return requests, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		var request api.NetNamespaceRequest
+		if err := json.Unmarshal([]byte(node.Value), &request); err != nil {
+			log.Errorf("Error unmarshalling network namespace request %s: %v", node.Key, err)
+			continue
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func (sub *EtcdSubnetRegistry) WriteNetNamespaceRequest(request api.NetNamespaceRequest) error {
+	if err := request.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&request)
+	if err != nil {
+		return err
+	}
+	_, err = sub.client().Set(path.Join(sub.etcdCfg.NetNamespaceRequestPath, request.Namespace), string(data), 0)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) DeleteNetNamespaceRequest(namespace string) error {
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.NetNamespaceRequestPath, namespace), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) WatchNetNamespaceRequests(receiver chan *api.NetNamespaceRequestEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.NetNamespaceRequestPath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Network namespace request watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if ev := newNetNamespaceRequestEvent(resp); ev != nil {
+			log.Infof("New network namespace request event: %v", ev)
+			receiver <- ev
+		}
+	}
+}
+
+func (sub *EtcdSubnetRegistry) GetNetworkPolicies() ([]api.NetworkPolicy, error) {
+	policies := make([]api.NetworkPolicy, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.NetworkPolicyPath, true, true)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return policies, nil
+		}
+		return nil, err
+	}
+	// one directory per namespace
+	for _, dir := range resp.Node.Nodes {
+		for _, node := range dir.Nodes {
+			var policy api.NetworkPolicy
+			if err := json.Unmarshal([]byte(node.Value), &policy); err != nil {
+				log.Errorf("Error unmarshalling network policy %s: %v", node.Key, err)
+				continue
+			}
+			policies = append(policies, policy)
+		}
+	}
+	return policies, nil
+}
+
+func (sub *EtcdSubnetRegistry) WriteNetworkPolicy(policy api.NetworkPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&policy)
+	if err != nil {
+		return err
+	}
+	_, err = sub.client().Set(path.Join(sub.etcdCfg.NetworkPolicyPath, policy.Namespace, policy.Name), string(data), 0)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) DeleteNetworkPolicy(namespace, name string) error {
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.NetworkPolicyPath, namespace, name), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) WatchNetworkPolicies(receiver chan *api.NetworkPolicyEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.NetworkPolicyPath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Network policy watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if ev := newNetworkPolicyEvent(resp, key); ev != nil {
+			log.Infof("New network policy event: %v", ev)
+			receiver <- ev
+		}
+	}
+}
+
+func (sub *EtcdSubnetRegistry) GetEgressNetworkPolicies() ([]api.EgressNetworkPolicy, error) {
+	policies := make([]api.EgressNetworkPolicy, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.EgressNetworkPolicyPath, true, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return policies, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		var policy api.EgressNetworkPolicy
+		if err := json.Unmarshal([]byte(node.Value), &policy); err != nil {
+			log.Errorf("Error unmarshalling egress network policy %s: %v", node.Key, err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func (sub *EtcdSubnetRegistry) WriteEgressNetworkPolicy(policy api.EgressNetworkPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&policy)
+	if err != nil {
+		return err
+	}
+	_, err = sub.client().Set(path.Join(sub.etcdCfg.EgressNetworkPolicyPath, policy.Namespace), string(data), 0)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) DeleteEgressNetworkPolicy(namespace string) error {
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.EgressNetworkPolicyPath, namespace), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) WatchEgressNetworkPolicies(receiver chan *api.EgressNetworkPolicyEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.EgressNetworkPolicyPath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Egress network policy watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if ev := newEgressNetworkPolicyEvent(resp); ev != nil {
+			log.Infof("New egress network policy event: %v", ev)
+			receiver <- ev
+		}
+	}
+}
+
+func (sub *EtcdSubnetRegistry) GetEgressIPs() ([]api.EgressIP, error) {
+	egressIPs := make([]api.EgressIP, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.EgressIPPath, true, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return egressIPs, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		var egressIP api.EgressIP
+		if err := json.Unmarshal([]byte(node.Value), &egressIP); err != nil {
+			log.Errorf("Error unmarshalling egress IP %s: %v", node.Key, err)
+			continue
+		}
+		egressIPs = append(egressIPs, egressIP)
+	}
+	return egressIPs, nil
+}
+
+func (sub *EtcdSubnetRegistry) WriteEgressIP(egressIP api.EgressIP) error {
+	if err := egressIP.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&egressIP)
+	if err != nil {
+		return err
+	}
+	_, err = sub.client().Set(path.Join(sub.etcdCfg.EgressIPPath, egressIP.Namespace), string(data), 0)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) DeleteEgressIP(namespace string) error {
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.EgressIPPath, namespace), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) WatchEgressIPs(receiver chan *api.EgressIPEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.EgressIPPath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Egress IP watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if ev := newEgressIPEvent(resp); ev != nil {
+			log.Infof("New egress IP event: %v", ev)
+			receiver <- ev
+		}
+	}
+}
+
+func (sub *EtcdSubnetRegistry) WriteNodeHeartbeat(node string, ttl uint64) error {
+	_, err := sub.client().Set(path.Join(sub.etcdCfg.HeartbeatPath, node), "alive", ttl)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) GetNodeHeartbeats() ([]string, error) {
+	nodes := make([]string, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.HeartbeatPath, false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return nodes, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		_, name := path.Split(node.Key)
+		nodes = append(nodes, name)
+	}
+	return nodes, nil
+}
+
+// WatchNodeHeartbeats reports a node as added when it first beats and as
+// deleted when its heartbeat expires.
+func (sub *EtcdSubnetRegistry) WatchNodeHeartbeats(receiver chan *api.MinionEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.HeartbeatPath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Heartbeat watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if resp.Node.Dir || (resp.Action == "set" && resp.PrevNode != nil) {
+			// a refresh of a live node
+			continue
+		}
+		receiver <- newMinionEvent(resp.Action, resp.Node.Key, resp.Node.Value)
+	}
 }
 
 func (sub *EtcdSubnetRegistry) client() *etcd.Client {
@@ -383,3 +912,192 @@ func (sub *EtcdSubnetRegistry) resetClient() {
 		panic(fmt.Errorf("resetClient: error recreating etcd client: %v", err))
 	}
 }
+
+func (sub *EtcdSubnetRegistry) GetServices() ([]api.Service, error) {
+	services := make([]api.Service, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.ServicePath, true, true)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return services, nil
+		}
+		return nil, err
+	}
+	// one directory per namespace
+	for _, dir := range resp.Node.Nodes {
+		for _, node := range dir.Nodes {
+			var service api.Service
+			if err := json.Unmarshal([]byte(node.Value), &service); err != nil {
+				log.Errorf("Error unmarshalling service %s: %v", node.Key, err)
+				continue
+			}
+			services = append(services, service)
+		}
+	}
+	return services, nil
+}
+
+func (sub *EtcdSubnetRegistry) WriteService(service api.Service) error {
+	if err := service.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(&service)
+	if err != nil {
+		return err
+	}
+	_, err = sub.client().Set(path.Join(sub.etcdCfg.ServicePath, service.Namespace, service.Name), string(data), 0)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) DeleteService(namespace, name string) error {
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.ServicePath, namespace, name), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) WatchServices(receiver chan *api.ServiceEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.ServicePath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Service watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if ev := newServiceEvent(resp, key); ev != nil {
+			log.Infof("New service event: %v", ev)
+			receiver <- ev
+		}
+	}
+}
+
+func (sub *EtcdSubnetRegistry) GetMulticastNamespaces() ([]string, error) {
+	namespaces := make([]string, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.MulticastPath, false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return namespaces, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		_, name := path.Split(node.Key)
+		namespaces = append(namespaces, name)
+	}
+	return namespaces, nil
+}
+
+func (sub *EtcdSubnetRegistry) EnableMulticast(namespace string) error {
+	_, err := sub.client().Set(path.Join(sub.etcdCfg.MulticastPath, namespace), "enabled", 0)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) DisableMulticast(namespace string) error {
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.MulticastPath, namespace), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) WatchMulticastNamespaces(receiver chan *api.NamespaceEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.MulticastPath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Multicast watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if resp.Node.Dir {
+			continue
+		}
+		ev := &api.NamespaceEvent{Type: api.Added}
+		switch {
+		case isDeleteAction(resp.Action):
+			ev.Type = api.Deleted
+		}
+		_, ev.Name = path.Split(resp.Node.Key)
+		log.Infof("New multicast event: %v", ev)
+		receiver <- ev
+	}
+}
+
+func (sub *EtcdSubnetRegistry) GetNodeVNIDs() ([]api.NodeVNIDs, error) {
+	nodes := make([]api.NodeVNIDs, 0)
+	resp, err := sub.client().Get(sub.etcdCfg.NodeVNIDPath, true, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return nodes, nil
+		}
+		return nil, err
+	}
+	for _, node := range resp.Node.Nodes {
+		var nodeVNIDs api.NodeVNIDs
+		if err := json.Unmarshal([]byte(node.Value), &nodeVNIDs); err != nil {
+			log.Errorf("Error unmarshalling node VNIDs %s: %v", node.Key, err)
+			continue
+		}
+		nodes = append(nodes, nodeVNIDs)
+	}
+	return nodes, nil
+}
+
+func (sub *EtcdSubnetRegistry) WriteNodeVNIDs(nodeVNIDs api.NodeVNIDs) error {
+	data, err := json.Marshal(&nodeVNIDs)
+	if err != nil {
+		return err
+	}
+	_, err = sub.client().Set(path.Join(sub.etcdCfg.NodeVNIDPath, nodeVNIDs.Node), string(data), 0)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) DeleteNodeVNIDs(node string) error {
+	_, err := sub.client().Delete(path.Join(sub.etcdCfg.NodeVNIDPath, node), false)
+	return err
+}
+
+func (sub *EtcdSubnetRegistry) WatchNodeVNIDs(receiver chan *api.NodeVNIDsEvent, stop chan bool) error {
+	var rev uint64
+	key := sub.etcdCfg.NodeVNIDPath
+	for {
+		resp, err := sub.watch(key, rev, stop)
+		if err != nil && err == etcd.ErrWatchStoppedByUser {
+			log.Infof("Node VNID watch stopped: %v", err)
+			return err
+		}
+		if resp == nil || err != nil {
+			continue
+		}
+		rev = resp.Node.ModifiedIndex + 1
+		if ev := newNodeVNIDsEvent(resp); ev != nil {
+			log.Infof("New node VNIDs event: %v", ev)
+			receiver <- ev
+		}
+	}
+}
+
+func (sub *EtcdSubnetRegistry) GetPodAnnotations(namespace, name string) (map[string]string, error) {
+	resp, err := sub.client().Get(path.Join(sub.etcdCfg.PodPath, namespace, name), false, false)
+	if err != nil {
+		if e, ok := err.(*etcd.EtcdError); ok && e.ErrorCode == etcdKeyNotFound {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	var pod struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(resp.Node.Value), &pod); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling pod %s/%s: %v", namespace, name, err)
+	}
+	if pod.Metadata.Annotations == nil {
+		return map[string]string{}, nil
+	}
+	return pod.Metadata.Annotations, nil
+}
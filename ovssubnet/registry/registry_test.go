@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+)
+
+func TestNewMinionEvent(t *testing.T) {
+	tests := []struct {
+		action   string
+		expected api.EventType
+	}{
+		{action: "set", expected: api.Added},
+		{action: "create", expected: api.Added},
+		{action: "delete", expected: api.Deleted},
+		{action: "compareAndDelete", expected: api.Deleted},
+		// a heartbeat whose TTL ran out
+		{action: "expire", expected: api.Deleted},
+	}
+	for _, test := range tests {
+		ev := newMinionEvent(test.action, "/registry/sdn/heartbeats/node1", "alive")
+		if ev == nil || ev.Type != test.expected || ev.Minion != "node1" {
+			t.Errorf("%s: expected a %s event for node1, got %+v", test.action, test.expected, ev)
+		}
+	}
+}
+
+func TestNewSubnetEvent(t *testing.T) {
+	value := `{"Minion":"172.17.0.3","Sub":"10.1.3.0/24"}`
+	tests := []struct {
+		resp     *etcd.Response
+		expected api.EventType
+	}{
+		{
+			resp:     &etcd.Response{Action: "set", Node: &etcd.Node{Key: "/registry/sdn/subnets/node1", Value: value}},
+			expected: api.Added,
+		},
+		{
+			resp:     &etcd.Response{Action: "delete", Node: &etcd.Node{Key: "/registry/sdn/subnets/node1"}, PrevNode: &etcd.Node{Value: value}},
+			expected: api.Deleted,
+		},
+		{
+			resp:     &etcd.Response{Action: "expire", Node: &etcd.Node{Key: "/registry/sdn/subnets/node1"}, PrevNode: &etcd.Node{Value: value}},
+			expected: api.Deleted,
+		},
+	}
+	for _, test := range tests {
+		ev := newSubnetEvent(test.resp)
+		if ev == nil || ev.Type != test.expected || ev.Minion != "node1" || ev.Sub.Sub != "10.1.3.0/24" {
+			t.Errorf("%s: expected a %s event for node1, got %+v", test.resp.Action, test.expected, ev)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package ovssubnet
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	log "github.com/golang/glog"
+
+	"github.com/openshift/openshift-sdn/ovssubnet/api"
+	"github.com/openshift/openshift-sdn/ovssubnet/controller/multitenant"
+)
+
+// EnableServiceLoadBalancing makes the node load-balance services in br0
+// rather than leave them to an external proxy. Only the multitenant plugin
+// can.
+func (oc *OvsController) EnableServiceLoadBalancing() error {
+	fc, ok := oc.flowController.(*multitenant.FlowController)
+	if !ok {
+		return fmt.Errorf("Service load balancing needs the multitenant plugin")
+	}
+	fc.EnableServiceLoadBalancing()
+	return nil
+}
+
+// SetServiceNetwork tells the node the network of the service IPs, which
+// pods reach through the host whatever the egress network policies and egress
+// IPs of their namespaces. Only the multitenant plugin has those.
+func (oc *OvsController) SetServiceNetwork(serviceNetwork string) error {
+	fc, ok := oc.flowController.(*multitenant.FlowController)
+	if !ok {
+		return fmt.Errorf("The service network is only used by the multitenant plugin")
+	}
+	if _, _, err := net.ParseCIDR(serviceNetwork); err != nil {
+		return fmt.Errorf("Invalid service network %q: %v", serviceNetwork, err)
+	}
+	fc.SetServiceNetwork(serviceNetwork)
+	return nil
+}
+
+// watchServices applies changes to services and their endpoints to br0 as
+// they happen.
+func (oc *OvsController) watchServices() {
+	serviceEvent := make(chan *api.ServiceEvent)
+	stop := make(chan bool)
+	go oc.subnetRegistry.WatchServices(serviceEvent, stop)
+	for {
+		select {
+		case ev := <-serviceEvent:
+			oc.serviceLock.Lock()
+			switch ev.Type {
+			case api.Added:
+				oc.services[ev.Service.Namespace+"/"+ev.Service.Name] = ev.Service
+			case api.Deleted:
+				for key, s := range oc.services {
+					if s.Namespace == ev.Service.Namespace && (ev.Service.Name == "" || s.Name == ev.Service.Name) {
+						delete(oc.services, key)
+					}
+				}
+			}
+			oc.serviceLock.Unlock()
+			oc.updateServices()
+			if err := oc.syncFlows(false); err != nil {
+				log.Errorf("Failed to apply service change, it will be applied by reconciliation: %v", err)
+			}
+		case <-oc.sig:
+			log.Error("Signal received. Stopping watching of services.")
+			stop <- true
+			return
+		}
+	}
+}
+
+// updateServices hands the current services to the flow controller, for
+// flows and groups computed from now on.
+func (oc *OvsController) updateServices() {
+	fc, ok := oc.flowController.(*multitenant.FlowController)
+	if !ok {
+		return
+	}
+	oc.serviceLock.Lock()
+	keys := make([]string, 0, len(oc.services))
+	for key := range oc.services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	services := make([]api.Service, 0, len(keys))
+	for _, key := range keys {
+		services = append(services, oc.services[key])
+	}
+	oc.serviceLock.Unlock()
+	fc.SetServices(services)
+}
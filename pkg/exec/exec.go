@@ -0,0 +1,57 @@
+// Package exec runs external commands behind an interface, so that code
+// driving tools like ovs-ofctl and the setup scripts can be tested with a fake.
+package exec
+
+import (
+	osexec "os/exec"
+	"syscall"
+)
+
+// Interface runs external commands.
+type Interface interface {
+	// Exec runs cmd with args and returns its combined stdout and stderr. If
+	// the command ran but failed, the error is an ExitError.
+	Exec(cmd string, args ...string) ([]byte, error)
+}
+
+// ExitError is returned when a command exits with a non-zero status.
+type ExitError interface {
+	error
+	ExitStatus() int
+}
+
+// ExitStatus returns the exit status of a command that failed with err, and
+// false if it did not run to completion (e.g. it was not found).
+func ExitStatus(err error) (int, bool) {
+	exitErr, ok := err.(ExitError)
+	if !ok {
+		return 0, false
+	}
+	return exitErr.ExitStatus(), true
+}
+
+// New returns an Interface running commands with os/exec.
+func New() Interface {
+	return executor{}
+}
+
+type executor struct{}
+
+func (executor) Exec(cmd string, args ...string) ([]byte, error) {
+	out, err := osexec.Command(cmd, args...).CombinedOutput()
+	if exitErr, ok := err.(*osexec.ExitError); ok {
+		return out, &exitError{exitErr}
+	}
+	return out, err
+}
+
+type exitError struct {
+	*osexec.ExitError
+}
+
+func (e *exitError) ExitStatus() int {
+	if status, ok := e.Sys().(syscall.WaitStatus); ok && status.Exited() {
+		return status.ExitStatus()
+	}
+	return -1
+}
@@ -0,0 +1,23 @@
+package exec
+
+import (
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	executor := New()
+	out, err := executor.Exec("sh", "-c", "echo benevolent; exit 140")
+	if status, ok := ExitStatus(err); !ok || status != 140 {
+		t.Fatalf("Expected exit status 140, got %d (%v)", status, err)
+	}
+	if string(out) != "benevolent\n" {
+		t.Fatalf("Wrong output %q", out)
+	}
+	if _, err := executor.Exec("sh", "-c", "true"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	_, err = executor.Exec("/nonexistent/openshift-sdn-setup.sh")
+	if _, ok := ExitStatus(err); err == nil || ok {
+		t.Fatalf("Expected a missing command to fail without an exit status, got %v", err)
+	}
+}
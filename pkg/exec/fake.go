@@ -0,0 +1,83 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeResult is the scripted outcome of one command run by a FakeExec.
+type FakeResult struct {
+	Output string
+	// ExitStatus makes the command fail with an ExitError when non-zero.
+	ExitStatus int
+	// Err makes the command fail to run at all, e.g. because it is not found.
+	Err error
+	// Run, if set, is called with the command line before the result is
+	// returned, e.g. to read a file the command was given.
+	Run func(cmd []string)
+}
+
+// FakeExec records the commands it is asked to run and answers them from
+// Script in order. Once Script is exhausted, commands succeed with no output.
+type FakeExec struct {
+	lock     sync.Mutex
+	Script   []FakeResult
+	Commands [][]string
+}
+
+// NewFake returns a FakeExec answering with script.
+func NewFake(script ...FakeResult) *FakeExec {
+	return &FakeExec{Script: script}
+}
+
+// Exec implements Interface.
+func (f *FakeExec) Exec(cmd string, args ...string) ([]byte, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	command := append([]string{cmd}, args...)
+	f.Commands = append(f.Commands, command)
+	if len(f.Script) == 0 {
+		return nil, nil
+	}
+	result := f.Script[0]
+	f.Script = f.Script[1:]
+	if result.Run != nil {
+		result.Run(command)
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	if result.ExitStatus != 0 {
+		return []byte(result.Output), &FakeExitError{Status: result.ExitStatus}
+	}
+	return []byte(result.Output), nil
+}
+
+// CommandLines returns the recorded commands with their arguments joined by
+// spaces, for comparing against expected command lines.
+func (f *FakeExec) CommandLines() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	lines := make([]string, 0, len(f.Commands))
+	for _, c := range f.Commands {
+		lines = append(lines, strings.Join(c, " "))
+	}
+	return lines
+}
+
+// FakeExitError is the ExitError returned for a scripted non-zero exit status.
+type FakeExitError struct {
+	Status int
+}
+
+func (e *FakeExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Status)
+}
+
+// ExitStatus implements ExitError.
+func (e *FakeExitError) ExitStatus() int {
+	return e.Status
+}
@@ -0,0 +1,76 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+func ifaddrmsg(index int, addr *net.IPNet) []byte {
+	ones, _ := addr.Mask.Size()
+	msg := syscall.IfAddrmsg{Family: syscall.AF_INET, Prefixlen: uint8(ones), Index: uint32(index)}
+	return (*[syscall.SizeofIfAddrmsg]byte)(unsafe.Pointer(&msg))[:]
+}
+
+// AddrAdd adds an IPv4 address to the link with index, like "ip addr add".
+func AddrAdd(index int, addr *net.IPNet) error {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return fmt.Errorf("Failed to add address %s: not IPv4", addr)
+	}
+	body := ifaddrmsg(index, addr)
+	body = append(body, attr(syscall.IFA_LOCAL, ip)...)
+	body = append(body, attr(syscall.IFA_ADDRESS, ip)...)
+	if _, err := request(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK, body); err != nil {
+		return fmt.Errorf("Failed to add address %s to link %d: %w", addr, index, err)
+	}
+	return nil
+}
+
+// AddrDel removes an IPv4 address from the link with index, like "ip addr
+// del".
+func AddrDel(index int, addr *net.IPNet) error {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return fmt.Errorf("Failed to delete address %s: not IPv4", addr)
+	}
+	body := ifaddrmsg(index, addr)
+	body = append(body, attr(syscall.IFA_LOCAL, ip)...)
+	if _, err := request(syscall.RTM_DELADDR, syscall.NLM_F_ACK, body); err != nil {
+		return fmt.Errorf("Failed to delete address %s from link %d: %w", addr, index, err)
+	}
+	return nil
+}
+
+// AddrList returns the IPv4 addresses of the link with index.
+func AddrList(index int) ([]*net.IPNet, error) {
+	msg := syscall.IfAddrmsg{Family: syscall.AF_INET}
+	replies, err := request(syscall.RTM_GETADDR, syscall.NLM_F_DUMP, (*[syscall.SizeofIfAddrmsg]byte)(unsafe.Pointer(&msg))[:])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list addresses: %w", err)
+	}
+	addrs := []*net.IPNet{}
+	for _, m := range replies {
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		info := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if int(info.Index) != index {
+			continue
+		}
+		attrs := parseAttrs(m.Data[syscall.SizeofIfAddrmsg:])
+		ip, ok := attrs[syscall.IFA_LOCAL]
+		if !ok {
+			ip = attrs[syscall.IFA_ADDRESS]
+		}
+		if len(ip) != net.IPv4len {
+			continue
+		}
+		addrs = append(addrs, &net.IPNet{
+			IP:   net.IP(append([]byte(nil), ip...)),
+			Mask: net.CIDRMask(int(info.Prefixlen), 32),
+		})
+	}
+	return addrs, nil
+}
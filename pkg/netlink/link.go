@@ -0,0 +1,215 @@
+package netlink
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Link is a network interface.
+type Link struct {
+	Index int
+	Name  string
+	MTU   int
+	Up    bool
+	// MasterIndex is the bridge or bond the link is enslaved to, or 0.
+	MasterIndex int
+	// ParentIndex is the link this one is stacked on; for a veth it is the
+	// index of the peer, which may be in another network namespace.
+	ParentIndex  int
+	HardwareAddr net.HardwareAddr
+	// TxQLen is the length of the transmit queue.
+	TxQLen int
+	// Kind is the link type, e.g. "bridge" or "vxlan", empty for physical
+	// links.
+	Kind string
+	// VxlanID and VxlanPort are the VNI and UDP port of a vxlan link.
+	VxlanID   uint32
+	VxlanPort uint16
+}
+
+func ifinfomsg(index int, flags, change uint32) []byte {
+	msg := syscall.IfInfomsg{Family: syscall.AF_UNSPEC, Index: int32(index), Flags: flags, Change: change}
+	return (*[syscall.SizeofIfInfomsg]byte)(unsafe.Pointer(&msg))[:]
+}
+
+func parseLink(m syscall.NetlinkMessage) (*Link, error) {
+	if m.Header.Type != syscall.RTM_NEWLINK || len(m.Data) < syscall.SizeofIfInfomsg {
+		return nil, fmt.Errorf("Unexpected netlink reply type %d", m.Header.Type)
+	}
+	info := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+	link := &Link{Index: int(info.Index), Up: info.Flags&syscall.IFF_UP != 0}
+	attrs := parseAttrs(m.Data[syscall.SizeofIfInfomsg:])
+	if name, ok := attrs[syscall.IFLA_IFNAME]; ok && len(name) > 0 {
+		link.Name = string(name[:len(name)-1])
+	}
+	if mtu, ok := attrs[syscall.IFLA_MTU]; ok && len(mtu) == 4 {
+		link.MTU = int(native.Uint32(mtu))
+	}
+	if master, ok := attrs[syscall.IFLA_MASTER]; ok && len(master) == 4 {
+		link.MasterIndex = int(native.Uint32(master))
+	}
+	if parent, ok := attrs[syscall.IFLA_LINK]; ok && len(parent) == 4 {
+		link.ParentIndex = int(native.Uint32(parent))
+	}
+	if txqlen, ok := attrs[syscall.IFLA_TXQLEN]; ok && len(txqlen) == 4 {
+		link.TxQLen = int(native.Uint32(txqlen))
+	}
+	if addr, ok := attrs[syscall.IFLA_ADDRESS]; ok {
+		link.HardwareAddr = net.HardwareAddr(addr)
+	}
+	if linkinfo, ok := attrs[syscall.IFLA_LINKINFO]; ok {
+		info := parseAttrs(linkinfo)
+		if kind, ok := info[iflaInfoKind]; ok {
+			link.Kind = strings.TrimRight(string(kind), "\x00")
+		}
+		if data, ok := info[iflaInfoData]; ok && link.Kind == "vxlan" {
+			vxlan := parseAttrs(data)
+			if id, ok := vxlan[iflaVxlanID]; ok && len(id) == 4 {
+				link.VxlanID = native.Uint32(id)
+			}
+			if port, ok := vxlan[iflaVxlanPort]; ok && len(port) == 2 {
+				link.VxlanPort = binary.BigEndian.Uint16(port)
+			}
+		}
+	}
+	return link, nil
+}
+
+func getLink(body []byte) (*Link, error) {
+	replies, err := request(syscall.RTM_GETLINK, 0, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(replies) == 0 {
+		return nil, fmt.Errorf("No reply to link request")
+	}
+	return parseLink(replies[0])
+}
+
+// LinkByName returns the link called name.
+func LinkByName(name string) (*Link, error) {
+	link, err := getLink(append(ifinfomsg(0, 0, 0), attrString(syscall.IFLA_IFNAME, name)...))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find link %s: %w", name, err)
+	}
+	return link, nil
+}
+
+// LinkByIndex returns the link with index.
+func LinkByIndex(index int) (*Link, error) {
+	link, err := getLink(ifinfomsg(index, 0, 0))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find link %d: %w", index, err)
+	}
+	return link, nil
+}
+
+func newLink(name, kind string, data []byte) error {
+	linkinfo := attr(iflaInfoKind, []byte(kind))
+	if data != nil {
+		linkinfo = append(linkinfo, attr(iflaInfoData, data)...)
+	}
+	body := ifinfomsg(0, 0, 0)
+	body = append(body, attrString(syscall.IFLA_IFNAME, name)...)
+	body = append(body, attr(syscall.IFLA_LINKINFO, linkinfo)...)
+	_, err := request(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK, body)
+	if err != nil {
+		return fmt.Errorf("Failed to add %s link %s: %w", kind, name, err)
+	}
+	return nil
+}
+
+// LinkAdd creates a link of a kind that needs no parameters, e.g. "bridge"
+// or "dummy", like "ip link add <name> type <kind>".
+func LinkAdd(name, kind string) error {
+	return newLink(name, kind, nil)
+}
+
+// LinkAddVeth creates a veth pair, like "ip link add <name> type veth peer
+// name <peer>".
+func LinkAddVeth(name, peer string) error {
+	peerInfo := append(ifinfomsg(0, 0, 0), attrString(syscall.IFLA_IFNAME, peer)...)
+	return newLink(name, "veth", attr(vethInfoPeer, peerInfo))
+}
+
+// LinkAddVxlan creates a vxlan link with VNI vni sending to UDP port port,
+// like "ip link add <name> type vxlan id <vni> dstport <port> nolearning".
+// It does not learn where MAC addresses are: the caller fills its forwarding
+// database with NeighSet.
+func LinkAddVxlan(name string, vni uint32, port uint16) error {
+	data := attrUint32(iflaVxlanID, vni)
+	data = append(data, attr(iflaVxlanLearning, []byte{0})...)
+	data = append(data, attr(iflaVxlanPort, []byte{byte(port >> 8), byte(port)})...)
+	return newLink(name, "vxlan", data)
+}
+
+// LinkDel deletes the link with index.
+func LinkDel(index int) error {
+	if _, err := request(syscall.RTM_DELLINK, syscall.NLM_F_ACK, ifinfomsg(index, 0, 0)); err != nil {
+		return fmt.Errorf("Failed to delete link %d: %w", index, err)
+	}
+	return nil
+}
+
+func setLink(index int, flags, change uint32, attrs ...[]byte) error {
+	body := ifinfomsg(index, flags, change)
+	for _, a := range attrs {
+		body = append(body, a...)
+	}
+	_, err := request(syscall.RTM_NEWLINK, syscall.NLM_F_ACK, body)
+	return err
+}
+
+// LinkSetUp brings the link with index up.
+func LinkSetUp(index int) error {
+	if err := setLink(index, syscall.IFF_UP, syscall.IFF_UP); err != nil {
+		return fmt.Errorf("Failed to bring up link %d: %w", index, err)
+	}
+	return nil
+}
+
+// LinkSetMTU sets the MTU of the link with index.
+func LinkSetMTU(index, mtu int) error {
+	if err := setLink(index, 0, 0, attrUint32(syscall.IFLA_MTU, uint32(mtu))); err != nil {
+		return fmt.Errorf("Failed to set the MTU of link %d: %w", index, err)
+	}
+	return nil
+}
+
+// LinkSetHardwareAddr sets the MAC address of the link with index.
+func LinkSetHardwareAddr(index int, addr net.HardwareAddr) error {
+	if err := setLink(index, 0, 0, attr(syscall.IFLA_ADDRESS, addr)); err != nil {
+		return fmt.Errorf("Failed to set the MAC address of link %d: %w", index, err)
+	}
+	return nil
+}
+
+// LinkSetTxQLen sets the transmit queue length of the link with index.
+func LinkSetTxQLen(index, qlen int) error {
+	if err := setLink(index, 0, 0, attrUint32(syscall.IFLA_TXQLEN, uint32(qlen))); err != nil {
+		return fmt.Errorf("Failed to set the transmit queue length of link %d: %w", index, err)
+	}
+	return nil
+}
+
+// LinkSetNsFd moves the link with index into the network namespace open as
+// fd, e.g. /proc/<pid>/ns/net.
+func LinkSetNsFd(index, fd int) error {
+	if err := setLink(index, 0, 0, attrUint32(iflaNetNsFd, uint32(fd))); err != nil {
+		return fmt.Errorf("Failed to move link %d to another network namespace: %w", index, err)
+	}
+	return nil
+}
+
+// LinkSetMaster enslaves the link with index to the bridge with index
+// master, or releases it from its bridge if master is 0.
+func LinkSetMaster(index, master int) error {
+	if err := setLink(index, 0, 0, attrUint32(syscall.IFLA_MASTER, uint32(master))); err != nil {
+		return fmt.Errorf("Failed to set the master of link %d: %w", index, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// Neigh is a permanent neighbour entry: an ARP entry giving the MAC address
+// of IP on the link or, with FDB set, an entry in the forwarding database of
+// a vxlan link sending frames for HardwareAddr to the node at IP.
+type Neigh struct {
+	LinkIndex    int
+	IP           net.IP
+	HardwareAddr net.HardwareAddr
+	FDB          bool
+}
+
+func (n *Neigh) String() string {
+	if n.FDB {
+		return fmt.Sprintf("fdb %s dev %d dst %s", n.HardwareAddr, n.LinkIndex, n.IP)
+	}
+	return fmt.Sprintf("%s dev %d lladdr %s", n.IP, n.LinkIndex, n.HardwareAddr)
+}
+
+// ndmsg encodes struct ndmsg, which package syscall lacks.
+func ndmsg(family uint8, index int, state uint16, flags uint8) []byte {
+	b := make([]byte, 12)
+	b[0] = family
+	native.PutUint32(b[4:8], uint32(index))
+	native.PutUint16(b[8:10], state)
+	b[10] = flags
+	return b
+}
+
+func neighBody(neigh *Neigh) []byte {
+	var body []byte
+	if neigh.FDB {
+		body = ndmsg(syscall.AF_BRIDGE, neigh.LinkIndex, nudPermanent, ntfSelf)
+	} else {
+		body = ndmsg(syscall.AF_INET, neigh.LinkIndex, nudPermanent, 0)
+	}
+	body = append(body, attr(ndaDst, neigh.IP.To4())...)
+	if neigh.HardwareAddr != nil {
+		body = append(body, attr(ndaLladdr, neigh.HardwareAddr)...)
+	}
+	return body
+}
+
+// NeighSet adds neigh or replaces the entry for the same IP, or for an FDB
+// entry the same MAC address, like "ip neigh replace <ip> lladdr <mac> dev
+// <link> nud permanent" or "bridge fdb replace <mac> dev <link> dst <ip>
+// self permanent".
+func NeighSet(neigh *Neigh) error {
+	if _, err := request(syscall.RTM_NEWNEIGH, syscall.NLM_F_CREATE|syscall.NLM_F_REPLACE|syscall.NLM_F_ACK, neighBody(neigh)); err != nil {
+		return fmt.Errorf("Failed to set neighbour %s: %w", neigh, err)
+	}
+	return nil
+}
+
+// NeighDel deletes neigh.
+func NeighDel(neigh *Neigh) error {
+	if _, err := request(syscall.RTM_DELNEIGH, syscall.NLM_F_ACK, neighBody(neigh)); err != nil {
+		return fmt.Errorf("Failed to delete neighbour %s: %w", neigh, err)
+	}
+	return nil
+}
+
+// NeighList returns the permanent ARP entries, or with fdb the forwarding
+// database entries of vxlan links, on the link with index, or on all links
+// if index is 0.
+func NeighList(index int, fdb bool) ([]*Neigh, error) {
+	family := uint8(syscall.AF_INET)
+	if fdb {
+		family = syscall.AF_BRIDGE
+	}
+	replies, err := request(syscall.RTM_GETNEIGH, syscall.NLM_F_DUMP, ndmsg(family, 0, 0, 0))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list neighbours: %w", err)
+	}
+	neighs := []*Neigh{}
+	for _, m := range replies {
+		if m.Header.Type != syscall.RTM_NEWNEIGH || len(m.Data) < 12 {
+			continue
+		}
+		linkIndex := int(int32(native.Uint32(m.Data[4:8])))
+		state, flags := native.Uint16(m.Data[8:10]), m.Data[10]
+		if m.Data[0] != family || state&nudPermanent == 0 || (index != 0 && linkIndex != index) {
+			continue
+		}
+		if fdb && flags&ntfSelf == 0 {
+			// an entry of the bridge the link is a port of
+			continue
+		}
+		attrs := parseAttrs(m.Data[12:])
+		dst, ok := attrs[ndaDst]
+		if !ok || len(dst) != net.IPv4len {
+			continue
+		}
+		neigh := &Neigh{LinkIndex: linkIndex, IP: net.IP(append([]byte(nil), dst...)), FDB: fdb}
+		if lladdr, ok := attrs[ndaLladdr]; ok {
+			neigh.HardwareAddr = net.HardwareAddr(append([]byte(nil), lladdr...))
+		}
+		neighs = append(neighs, neigh)
+	}
+	return neighs, nil
+}
@@ -0,0 +1,149 @@
+// Package netlink is a minimal rtnetlink client for the link, address,
+// route and neighbour operations openshift-sdn needs, without running ip(8). Every call
+// opens its own socket, so calls made inside netns.Do act on that namespace.
+package netlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+)
+
+// rtnetlink attributes missing from package syscall
+const (
+	iflaNetNsFd       = 28
+	iflaInfoKind      = 1
+	iflaInfoData      = 2
+	vethInfoPeer      = 1
+	iflaVxlanID       = 1
+	iflaVxlanLearning = 7
+	iflaVxlanPort     = 15
+	ndaDst            = 1
+	ndaLladdr         = 2
+	nudPermanent      = 0x80
+	ntfSelf           = 0x02
+)
+
+var (
+	seq    uint32
+	native = binary.NativeEndian
+)
+
+type socket struct {
+	fd int
+}
+
+func openSocket() (*socket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open netlink socket: %v", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("Failed to bind netlink socket: %v", err)
+	}
+	return &socket{fd: fd}, nil
+}
+
+func (s *socket) close() {
+	syscall.Close(s.fd)
+}
+
+// request sends one message and returns the replies, which for requests
+// without NLM_F_DUMP or NLM_F_ACK is at most one message.
+func request(typ, flags int, body []byte) ([]syscall.NetlinkMessage, error) {
+	s, err := openSocket()
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	n := atomic.AddUint32(&seq, 1)
+	msg := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(body))
+	msg = append(msg, body...)
+	native.PutUint32(msg[0:4], uint32(len(msg)))
+	native.PutUint16(msg[4:6], uint16(typ))
+	native.PutUint16(msg[6:8], uint16(flags|syscall.NLM_F_REQUEST))
+	native.PutUint32(msg[8:12], n)
+	if err := syscall.Sendto(s.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("Failed to send netlink request: %v", err)
+	}
+
+	replies := []syscall.NetlinkMessage{}
+	for {
+		// replies refer into buf, so every read needs a new one
+		buf := make([]byte, 65536)
+		nr, _, err := syscall.Recvfrom(s.fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read netlink reply: %v", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:nr])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid netlink reply: %v", err)
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != n {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			case syscall.NLMSG_ERROR:
+				if errno := int32(native.Uint32(m.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				return replies, nil
+			}
+			replies = append(replies, m)
+			if m.Header.Flags&syscall.NLM_F_MULTI == 0 && flags&syscall.NLM_F_ACK == 0 {
+				return replies, nil
+			}
+		}
+	}
+}
+
+// attr encodes a routing attribute, padded to 4 bytes.
+func attr(typ int, data []byte) []byte {
+	l := syscall.SizeofRtAttr + len(data)
+	b := make([]byte, (l+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	native.PutUint16(b[0:2], uint16(l))
+	native.PutUint16(b[2:4], uint16(typ))
+	copy(b[syscall.SizeofRtAttr:], data)
+	return b
+}
+
+func attrString(typ int, s string) []byte {
+	return attr(typ, append([]byte(s), 0))
+}
+
+func attrUint32(typ int, v uint32) []byte {
+	b := make([]byte, 4)
+	native.PutUint32(b, v)
+	return attr(typ, b)
+}
+
+func parseAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofRtAttr {
+		l := int(native.Uint16(b[0:2]))
+		typ := native.Uint16(b[2:4])
+		if l < syscall.SizeofRtAttr || l > len(b) {
+			break
+		}
+		attrs[typ] = b[syscall.SizeofRtAttr:l]
+		l = (l + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return attrs
+}
+
+// IsExist tells whether err reports that the link, address, route or
+// neighbour to be added already exists.
+func IsExist(err error) bool {
+	return errors.Is(err, syscall.EEXIST)
+}
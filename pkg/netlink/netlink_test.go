@@ -0,0 +1,275 @@
+package netlink
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+// inNewNetns runs fn on a thread in a fresh network namespace. The thread is
+// never unlocked, so it exits with the goroutine.
+func inNewNetns(t *testing.T, fn func() error) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root")
+	}
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			done <- &skipError{err}
+			return
+		}
+		done <- fn()
+	}()
+	if err := <-done; err != nil {
+		if skip, ok := err.(*skipError); ok {
+			t.Skipf("Cannot create a network namespace: %v", skip.err)
+		}
+		t.Fatal(err)
+	}
+}
+
+type skipError struct {
+	err error
+}
+
+func (e *skipError) Error() string {
+	return e.err.Error()
+}
+
+func TestVethPair(t *testing.T) {
+	inNewNetns(t, func() error {
+		if err := LinkAddVeth("veth-host", "eth0"); err != nil {
+			return err
+		}
+		host, err := LinkByName("veth-host")
+		if err != nil {
+			return err
+		}
+		peer, err := LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		if host.ParentIndex != peer.Index || peer.ParentIndex != host.Index {
+			t.Errorf("Veth ends do not point at each other: %+v %+v", host, peer)
+		}
+		if host.Up || len(host.HardwareAddr) != 6 {
+			t.Errorf("Wrong new veth: %+v", host)
+		}
+
+		if err := LinkSetUp(peer.Index); err != nil {
+			return err
+		}
+		if err := LinkSetMTU(peer.Index, 1450); err != nil {
+			return err
+		}
+		if err := LinkSetTxQLen(peer.Index, 0); err != nil {
+			return err
+		}
+		mac := net.HardwareAddr{0x02, 0x42, 0x0a, 0x01, 0x02, 0x02}
+		if err := LinkSetHardwareAddr(peer.Index, mac); err != nil {
+			return err
+		}
+		if peer, err = LinkByIndex(peer.Index); err != nil {
+			return err
+		}
+		if !peer.Up || peer.MTU != 1450 || peer.TxQLen != 0 || peer.Name != "eth0" || peer.HardwareAddr.String() != mac.String() {
+			t.Errorf("Wrong updated veth: %+v", peer)
+		}
+
+		if err := LinkAdd("lbr0", "bridge"); err != nil {
+			return err
+		}
+		bridge, err := LinkByName("lbr0")
+		if err != nil {
+			return err
+		}
+		if err := LinkSetMaster(host.Index, bridge.Index); err != nil {
+			return err
+		}
+		if host, _ = LinkByIndex(host.Index); host.MasterIndex != bridge.Index {
+			t.Errorf("Veth not enslaved to lbr0: %+v", host)
+		}
+		if err := LinkSetMaster(host.Index, 0); err != nil {
+			return err
+		}
+		if host, _ = LinkByIndex(host.Index); host.MasterIndex != 0 {
+			t.Errorf("Veth still enslaved: %+v", host)
+		}
+
+		if err := LinkDel(host.Index); err != nil {
+			return err
+		}
+		if _, err := LinkByName("eth0"); err == nil {
+			t.Errorf("Deleting one end of a veth pair should delete both")
+		}
+		if _, err := LinkByName("missing0"); err == nil {
+			t.Errorf("Expected an error looking up a missing link")
+		}
+		return nil
+	})
+}
+
+func TestAddrAndRoute(t *testing.T) {
+	inNewNetns(t, func() error {
+		if err := LinkAddVeth("veth0", "eth0"); err != nil {
+			return err
+		}
+		link, err := LinkByName("eth0")
+		if err != nil {
+			return err
+		}
+		for _, index := range []int{link.Index, link.ParentIndex} {
+			if err := LinkSetUp(index); err != nil {
+				return err
+			}
+		}
+		addr := &net.IPNet{IP: net.ParseIP("10.1.2.2"), Mask: net.CIDRMask(24, 32)}
+		if err := AddrAdd(link.Index, addr); err != nil {
+			return err
+		}
+		addrs, err := AddrList(link.Index)
+		if err != nil {
+			return err
+		}
+		if len(addrs) != 1 || addrs[0].String() != "10.1.2.2/24" {
+			t.Errorf("Wrong addresses: %v", addrs)
+		}
+		if err := AddrAdd(link.Index, addr); err == nil {
+			t.Errorf("Expected an error adding an address twice")
+		}
+		extra := &net.IPNet{IP: net.ParseIP("192.0.2.10"), Mask: net.CIDRMask(32, 32)}
+		if err := AddrAdd(link.Index, extra); err != nil {
+			return err
+		}
+		if err := AddrDel(link.Index, extra); err != nil {
+			return err
+		}
+		if addrs, _ = AddrList(link.Index); len(addrs) != 1 {
+			t.Errorf("Expected one address after deleting, got %v", addrs)
+		}
+
+		_, cluster, _ := net.ParseCIDR("10.1.0.0/16")
+		route := &Route{Dst: cluster, LinkIndex: link.Index, Src: addr.IP, Scope: syscall.RT_SCOPE_LINK, Protocol: syscall.RTPROT_KERNEL}
+		if err := RouteAdd(route); err != nil {
+			return err
+		}
+		if err := RouteAdd(route); !IsExist(err) {
+			t.Errorf("Expected EEXIST adding a route twice, got %v", err)
+		}
+		routes, err := RouteList(link.Index)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, r := range routes {
+			if r.Dst != nil && r.Dst.String() == "10.1.0.0/16" {
+				found = true
+				if !r.Src.Equal(addr.IP) || r.Scope != syscall.RT_SCOPE_LINK || r.Protocol != syscall.RTPROT_KERNEL {
+					t.Errorf("Wrong route: %+v", r)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Route to 10.1.0.0/16 missing from %v", routes)
+		}
+
+		if err := RouteDel(route); err != nil {
+			return err
+		}
+		if routes, _ = RouteList(link.Index); len(routes) != 1 || routes[0].Dst.String() != "10.1.2.0/24" {
+			t.Errorf("Expected only the address route after deleting, got %v", routes)
+		}
+		return nil
+	})
+}
+
+func TestVxlanAndNeigh(t *testing.T) {
+	inNewNetns(t, func() error {
+		if err := LinkAddVxlan("vxlan0", 0, 4789); err != nil {
+			return err
+		}
+		vxlan, err := LinkByName("vxlan0")
+		if err != nil {
+			return err
+		}
+		if vxlan.Kind != "vxlan" || vxlan.VxlanID != 0 || vxlan.VxlanPort != 4789 {
+			t.Errorf("Wrong vxlan link: %+v", vxlan)
+		}
+		if err := LinkAdd("lbr0", "bridge"); err != nil {
+			return err
+		}
+		bridge, err := LinkByName("lbr0")
+		if err != nil {
+			return err
+		}
+		if bridge.Kind != "bridge" {
+			t.Errorf("Wrong bridge link: %+v", bridge)
+		}
+		for _, index := range []int{vxlan.Index, bridge.Index} {
+			if err := LinkSetUp(index); err != nil {
+				return err
+			}
+		}
+
+		mac := net.HardwareAddr{0x02, 0x42, 0x0a, 0x01, 0x03, 0x01}
+		fdb := &Neigh{LinkIndex: vxlan.Index, IP: net.ParseIP("192.168.1.11"), HardwareAddr: mac, FDB: true}
+		arp := &Neigh{LinkIndex: bridge.Index, IP: net.ParseIP("10.1.3.1"), HardwareAddr: mac}
+		for _, neigh := range []*Neigh{fdb, arp} {
+			if err := NeighSet(neigh); err != nil {
+				return err
+			}
+		}
+		// replacing the destination of the MAC address
+		fdb.IP = net.ParseIP("192.168.1.12")
+		if err := NeighSet(fdb); err != nil {
+			return err
+		}
+		fdbs, err := NeighList(vxlan.Index, true)
+		if err != nil {
+			return err
+		}
+		if len(fdbs) != 1 || fdbs[0].String() != fdb.String() {
+			t.Errorf("Wrong forwarding database entries: %v", fdbs)
+		}
+		arps, err := NeighList(0, false)
+		if err != nil {
+			return err
+		}
+		if len(arps) != 1 || arps[0].String() != arp.String() {
+			t.Errorf("Wrong ARP entries: %v", arps)
+		}
+
+		// the kernel only takes a gateway on a link with an address
+		if err := AddrAdd(bridge.Index, &net.IPNet{IP: net.ParseIP("10.1.2.1"), Mask: net.CIDRMask(24, 32)}); err != nil {
+			return err
+		}
+		_, subnet, _ := net.ParseCIDR("10.1.3.0/24")
+		route := &Route{Dst: subnet, LinkIndex: bridge.Index, Gw: arp.IP, Flags: syscall.RTNH_F_ONLINK}
+		if err := RouteAdd(route); err != nil {
+			return err
+		}
+		routes, err := RouteList(bridge.Index)
+		if err != nil {
+			return err
+		}
+		if len(routes) != 2 || routes[1].Dst.String() != "10.1.3.0/24" || !routes[1].Gw.Equal(arp.IP) || routes[1].Flags&syscall.RTNH_F_ONLINK == 0 {
+			t.Errorf("Wrong routes: %v", routes)
+		}
+
+		for _, neigh := range []*Neigh{fdb, arp} {
+			if err := NeighDel(neigh); err != nil {
+				return err
+			}
+		}
+		if fdbs, _ = NeighList(vxlan.Index, true); len(fdbs) != 0 {
+			t.Errorf("Expected no forwarding database entries after deleting, got %v", fdbs)
+		}
+		if arps, _ = NeighList(bridge.Index, false); len(arps) != 0 {
+			t.Errorf("Expected no ARP entries after deleting, got %v", arps)
+		}
+		return nil
+	})
+}
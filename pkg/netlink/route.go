@@ -0,0 +1,119 @@
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Route is an IPv4 route in the main table.
+type Route struct {
+	Dst       *net.IPNet
+	LinkIndex int
+	Gw        net.IP
+	Src       net.IP
+	// Scope is e.g. syscall.RT_SCOPE_LINK; the zero value is universe.
+	Scope uint8
+	// Protocol is who installed the route, e.g. syscall.RTPROT_KERNEL;
+	// RTPROT_BOOT is used when it is zero.
+	Protocol uint8
+	// Flags are e.g. syscall.RTNH_F_ONLINK, to use Gw through the link even
+	// though no address of the link covers it.
+	Flags uint32
+}
+
+func (r *Route) String() string {
+	return fmt.Sprintf("%s dev %d via %s src %s", r.Dst, r.LinkIndex, r.Gw, r.Src)
+}
+
+// rtmsg is syscall.RtMsg in wire form.
+func rtmsg(msg *syscall.RtMsg) []byte {
+	return (*[syscall.SizeofRtMsg]byte)(unsafe.Pointer(msg))[:]
+}
+
+// routeBody encodes route for a RTM_NEWROUTE or RTM_DELROUTE request.
+func routeBody(route *Route) []byte {
+	msg := &syscall.RtMsg{
+		Family:   syscall.AF_INET,
+		Table:    syscall.RT_TABLE_MAIN,
+		Protocol: route.Protocol,
+		Scope:    route.Scope,
+		Type:     syscall.RTN_UNICAST,
+		Flags:    route.Flags,
+	}
+	if msg.Protocol == 0 {
+		msg.Protocol = syscall.RTPROT_BOOT
+	}
+	body := []byte{}
+	if route.Dst != nil {
+		ones, _ := route.Dst.Mask.Size()
+		msg.Dst_len = uint8(ones)
+		body = append(body, attr(syscall.RTA_DST, route.Dst.IP.To4())...)
+	}
+	if route.Gw != nil {
+		body = append(body, attr(syscall.RTA_GATEWAY, route.Gw.To4())...)
+	}
+	if route.Src != nil {
+		body = append(body, attr(syscall.RTA_PREFSRC, route.Src.To4())...)
+	}
+	if route.LinkIndex != 0 {
+		body = append(body, attrUint32(syscall.RTA_OIF, uint32(route.LinkIndex))...)
+	}
+	return append(rtmsg(msg), body...)
+}
+
+// RouteAdd adds route, like "ip route add".
+func RouteAdd(route *Route) error {
+	if _, err := request(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK, routeBody(route)); err != nil {
+		return fmt.Errorf("Failed to add route %s: %w", route, err)
+	}
+	return nil
+}
+
+// RouteDel deletes route, like "ip route del".
+func RouteDel(route *Route) error {
+	if _, err := request(syscall.RTM_DELROUTE, syscall.NLM_F_ACK, routeBody(route)); err != nil {
+		return fmt.Errorf("Failed to delete route %s: %w", route, err)
+	}
+	return nil
+}
+
+// RouteList returns the IPv4 routes in the main table through the link with
+// index, or all of them if index is 0.
+func RouteList(index int) ([]*Route, error) {
+	msg := &syscall.RtMsg{Family: syscall.AF_INET}
+	replies, err := request(syscall.RTM_GETROUTE, syscall.NLM_F_DUMP, rtmsg(msg))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list routes: %w", err)
+	}
+	routes := []*Route{}
+	for _, m := range replies {
+		if m.Header.Type != syscall.RTM_NEWROUTE || len(m.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		info := (*syscall.RtMsg)(unsafe.Pointer(&m.Data[0]))
+		if info.Table != syscall.RT_TABLE_MAIN {
+			continue
+		}
+		attrs := parseAttrs(m.Data[syscall.SizeofRtMsg:])
+		route := &Route{Scope: info.Scope, Protocol: info.Protocol, Flags: info.Flags}
+		if oif, ok := attrs[syscall.RTA_OIF]; ok && len(oif) == 4 {
+			route.LinkIndex = int(native.Uint32(oif))
+		}
+		if index != 0 && route.LinkIndex != index {
+			continue
+		}
+		if dst, ok := attrs[syscall.RTA_DST]; ok && len(dst) == net.IPv4len {
+			route.Dst = &net.IPNet{IP: net.IP(append([]byte(nil), dst...)), Mask: net.CIDRMask(int(info.Dst_len), 32)}
+		}
+		if gw, ok := attrs[syscall.RTA_GATEWAY]; ok && len(gw) == net.IPv4len {
+			route.Gw = net.IP(append([]byte(nil), gw...))
+		}
+		if src, ok := attrs[syscall.RTA_PREFSRC]; ok && len(src) == net.IPv4len {
+			route.Src = net.IP(append([]byte(nil), src...))
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
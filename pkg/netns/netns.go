@@ -0,0 +1,61 @@
+// Package netns runs code inside the network namespace of another process,
+// e.g. a container, like "nsenter -n -t <pid>".
+package netns
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// Path returns the network namespace file of process pid.
+func Path(pid int) string {
+	return fmt.Sprintf("/proc/%d/ns/net", pid)
+}
+
+func setns(f *os.File) error {
+	if _, _, errno := syscall.RawSyscall(sysSetns, f.Fd(), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Do runs fn with the calling goroutine in the network namespace of process
+// pid. Sockets, and so netlink requests, opened by fn belong to that
+// namespace; fn must not start goroutines that rely on it.
+func Do(pid int, fn func() error) error {
+	return DoPath(Path(pid), fn)
+}
+
+// DoPath is Do for the network namespace file at path.
+func DoPath(path string, fn func() error) error {
+	target, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Failed to open network namespace %s: %v", path, err)
+	}
+	defer target.Close()
+
+	// the namespace belongs to the thread, so keep the goroutine on it
+	runtime.LockOSThread()
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Failed to open current network namespace: %v", err)
+	}
+	defer origin.Close()
+	if err := setns(target); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("Failed to enter network namespace %s: %v", path, err)
+	}
+
+	fnErr := fn()
+
+	if err := setns(origin); err != nil {
+		// leave the thread locked, so that it exits with the goroutine
+		// rather than running other goroutines in the wrong namespace
+		return fmt.Errorf("Failed to return from network namespace %s: %v", path, err)
+	}
+	runtime.UnlockOSThread()
+	return fnErr
+}
@@ -0,0 +1,69 @@
+package netns
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/netlink"
+)
+
+// newNetns creates a network namespace held by a locked thread and returns
+// its path and a function that releases it.
+func newNetns(t *testing.T) (string, func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("Creating network namespaces needs root")
+	}
+	ready := make(chan error)
+	release := make(chan struct{})
+	var path string
+	go func() {
+		// never unlocked: the thread exits with the goroutine
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			ready <- err
+			return
+		}
+		path = fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), syscall.Gettid())
+		ready <- nil
+		<-release
+	}()
+	if err := <-ready; err != nil {
+		t.Skipf("Cannot create a network namespace: %v", err)
+	}
+	return path, func() { close(release) }
+}
+
+func TestDoPath(t *testing.T) {
+	path, release := newNetns(t)
+	defer release()
+
+	err := DoPath(path, func() error {
+		return netlink.LinkAddVeth("veth-test0", "eth-test0")
+	})
+	if err != nil {
+		t.Fatalf("Error creating veth in namespace: %v", err)
+	}
+	if _, err := netlink.LinkByName("veth-test0"); err == nil {
+		t.Fatalf("Veth created in the namespace leaked into the test's namespace")
+	}
+
+	var link *netlink.Link
+	err = DoPath(path, func() error {
+		link, err = netlink.LinkByName("eth-test0")
+		return err
+	})
+	if err != nil || link.ParentIndex == 0 {
+		t.Fatalf("Veth missing from namespace: %+v (%v)", link, err)
+	}
+
+	fnErr := fmt.Errorf("fn failed")
+	if err := DoPath(path, func() error { return fnErr }); err != fnErr {
+		t.Fatalf("Expected the error of fn, got %v", err)
+	}
+	if err := DoPath("/nonexistent/ns/net", func() error { return nil }); err == nil {
+		t.Fatalf("Expected an error entering a missing namespace")
+	}
+}
@@ -0,0 +1,4 @@
+package netns
+
+// sysSetns is the setns(2) system call number, which package syscall lacks.
+const sysSetns = 346
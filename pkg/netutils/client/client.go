@@ -0,0 +1,309 @@
+// Package client is a Go client for the netutils IPAM server.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+	maxBackoff     = 5 * time.Second
+)
+
+// Options configures a Client. The zero value is usable.
+type Options struct {
+	// TLSConfig is used for https:// servers, including the client
+	// certificate when the server requires one.
+	TLSConfig *tls.Config
+	// Timeout bounds each HTTP request. It defaults to 30 seconds.
+	Timeout time.Duration
+	// Retries is the number of extra attempts after a transient failure.
+	// Negative values disable retrying; zero selects the default of 3.
+	Retries int
+	// Backoff is the delay before the first retry. It doubles on every
+	// further attempt, up to 5 seconds. It defaults to 200ms.
+	Backoff time.Duration
+}
+
+// Client talks to a netutils IPAM server over TCP, TLS or a unix socket.
+// Address requests go to the server's default pool unless the client was
+// obtained from Pool.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	pool       string
+}
+
+// StatusError is returned when the server answers with a non-2xx status.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("IPAM server returned %d: %s", e.Code, e.Message)
+}
+
+// New returns a client for the server at serverURL, which is one of
+// http://host:port, https://host:port or unix:///path/to/socket.
+func New(serverURL string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server URL %q: %v", serverURL, err)
+	}
+
+	transport := &http.Transport{
+		Proxy:           nil,
+		TLSClientConfig: opts.TLSConfig,
+	}
+	var baseURL string
+	switch u.Scheme {
+	case "http", "https":
+		baseURL = strings.TrimSuffix(serverURL, "/")
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("Missing socket path in server URL %q", serverURL)
+		}
+		socket := u.Path
+		dialer := &net.Dialer{}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://unix"
+	default:
+		return nil, fmt.Errorf("Unsupported scheme %q in server URL %q", u.Scheme, serverURL)
+	}
+
+	c := &Client{
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+		},
+		retries: opts.Retries,
+		backoff: opts.Backoff,
+	}
+	if c.httpClient.Timeout == 0 {
+		c.httpClient.Timeout = defaultTimeout
+	}
+	if c.retries == 0 {
+		c.retries = defaultRetries
+	} else if c.retries < 0 {
+		c.retries = 0
+	}
+	if c.backoff == 0 {
+		c.backoff = defaultBackoff
+	}
+	return c, nil
+}
+
+// Allocate asks the server for a free address.
+func (c *Client) Allocate(ctx context.Context) (*net.IPNet, error) {
+	body, err := c.do(ctx, "GET", c.path("ip"))
+	if err != nil {
+		return nil, err
+	}
+	return parseIPNet(body)
+}
+
+// AllocateWithLease asks the server for a free address leased to owner for ttl.
+// The lease must be renewed before it expires, or the server may reclaim the
+// address once owner is gone.
+func (c *Client) AllocateWithLease(ctx context.Context, owner string, ttl time.Duration) (*net.IPNet, error) {
+	body, err := c.do(ctx, "GET", c.path("ip")+"?"+leaseQuery(owner, ttl))
+	if err != nil {
+		return nil, err
+	}
+	return parseIPNet(body)
+}
+
+// Renew extends the lease that owner holds on ip by ttl from now.
+func (c *Client) Renew(ctx context.Context, ip *net.IPNet, owner string, ttl time.Duration) error {
+	_, err := c.do(ctx, "PUT", c.path("ip")+"/"+ip.String()+"?"+leaseQuery(owner, ttl))
+	return err
+}
+
+// Release returns a previously allocated address to the server.
+func (c *Client) Release(ctx context.Context, ip *net.IPNet) error {
+	_, err := c.do(ctx, "DELETE", c.path("ip")+"/"+ip.String())
+	return err
+}
+
+// Subnet returns the network the server allocates from.
+func (c *Client) Subnet(ctx context.Context) (*net.IPNet, error) {
+	body, err := c.do(ctx, "GET", c.path("subnet"))
+	if err != nil {
+		return nil, err
+	}
+	_, ipnet, err := net.ParseCIDR(strings.TrimSpace(string(body)))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid subnet from IPAM server: %v", err)
+	}
+	return ipnet, nil
+}
+
+// Gateway returns the default gateway of the server's network.
+func (c *Client) Gateway(ctx context.Context) (net.IP, error) {
+	body, err := c.do(ctx, "GET", c.path("gateway"))
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid gateway from IPAM server: %q", body)
+	}
+	return ip, nil
+}
+
+// Stats returns the server's allocation statistics.
+func (c *Client) Stats(ctx context.Context) (*netutils.IPStats, error) {
+	body, err := c.do(ctx, "GET", c.path("stats"))
+	if err != nil {
+		return nil, err
+	}
+	stats := &netutils.IPStats{}
+	if err := json.Unmarshal(body, stats); err != nil {
+		return nil, fmt.Errorf("Invalid stats from IPAM server: %v", err)
+	}
+	return stats, nil
+}
+
+// Pool returns a client whose address requests go to the named pool.
+func (c *Client) Pool(name string) *Client {
+	pc := *c
+	pc.pool = name
+	return &pc
+}
+
+// AddPool asks the server to serve a new pool allocating from network.
+func (c *Client) AddPool(ctx context.Context, name, network string) error {
+	_, err := c.do(ctx, "POST", "/netutils/pools/"+url.PathEscape(name)+"?"+url.Values{"network": {network}}.Encode())
+	return err
+}
+
+// RemovePool asks the server to stop serving a pool. Unless force is set, the
+// server refuses to remove a pool that still has addresses allocated.
+func (c *Client) RemovePool(ctx context.Context, name string, force bool) error {
+	path := "/netutils/pools/" + url.PathEscape(name)
+	if force {
+		path += "?force=true"
+	}
+	_, err := c.do(ctx, "DELETE", path)
+	return err
+}
+
+// PoolStats returns the allocation statistics of every pool, keyed by name.
+func (c *Client) PoolStats(ctx context.Context) (map[string]netutils.IPStats, error) {
+	body, err := c.do(ctx, "GET", "/netutils/pools")
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]netutils.IPStats)
+	if err := json.Unmarshal(body, &stats); err != nil {
+		return nil, fmt.Errorf("Invalid stats from IPAM server: %v", err)
+	}
+	return stats, nil
+}
+
+// path returns the request path of a pool resource (ip, subnet, gateway or stats).
+func (c *Client) path(resource string) string {
+	if c.pool != "" {
+		return "/netutils/pools/" + url.PathEscape(c.pool) + "/" + resource
+	}
+	if resource == "stats" {
+		return "/stats"
+	}
+	return "/netutils/" + resource
+}
+
+// do issues the request, retrying transient failures with exponential backoff
+// until the retries are used up or ctx is done.
+func (c *Client) do(ctx context.Context, method, path string) ([]byte, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		body, err := c.doOnce(ctx, method, path)
+		if err == nil || attempt >= c.retries || !isTransient(err) {
+			return body, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *Client) doOnce(ctx context.Context, method, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &StatusError{Code: res.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
+
+// isTransient reports whether a request may be retried. Only failures where the
+// server cannot have acted on the request are retried, so that an allocation is
+// never handed out twice.
+func isTransient(err error) bool {
+	if se, ok := err.(*StatusError); ok {
+		switch se.Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if oe, ok := err.(*net.OpError); ok {
+		return oe.Op == "dial"
+	}
+	return false
+}
+
+func leaseQuery(owner string, ttl time.Duration) string {
+	return url.Values{"owner": {owner}, "ttl": {ttl.String()}}.Encode()
+}
+
+func parseIPNet(body []byte) (*net.IPNet, error) {
+	ip, ipnet, err := net.ParseCIDR(strings.TrimSpace(string(body)))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid address from IPAM server: %v", err)
+	}
+	return &net.IPNet{IP: ip, Mask: ipnet.Mask}, nil
+}
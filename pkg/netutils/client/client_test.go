@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransientErrors(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("10.20.30.5/24"))
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, &Options{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	ip, err := c.Allocate(context.Background())
+	if err != nil {
+		t.Fatalf("Error allocating IP: %v", err)
+	}
+	if ip.String() != "10.20.30.5/24" {
+		t.Fatalf("Wrong IP. Expected 10.20.30.5/24, got %s", ip)
+	}
+	if calls != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", calls)
+	}
+}
+
+func TestNoRetryOnServerError(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "Internal Error: No IPs available.", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	c, err := New(ts.URL, &Options{Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	_, err = c.Allocate(context.Background())
+	se, ok := err.(*StatusError)
+	if !ok || se.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected a 500 StatusError, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", calls)
+	}
+}
+
+func TestContextTimeout(t *testing.T) {
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-block
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	c, err := New(ts.URL, nil)
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.Gateway(ctx); err == nil {
+		t.Fatalf("Expected the request to time out")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("Request did not honour the context deadline")
+	}
+}
+
+func TestInvalidURL(t *testing.T) {
+	for _, serverURL := range []string{"ftp://127.0.0.1:21", "unix://", "://bad"} {
+		if _, err := New(serverURL, nil); err == nil {
+			t.Errorf("Expected an error for server URL %q", serverURL)
+		}
+	}
+}
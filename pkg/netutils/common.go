@@ -2,6 +2,7 @@ package netutils
 
 import (
 	"encoding/binary"
+	"fmt"
 	"net"
 )
 
@@ -20,3 +21,53 @@ func GenerateDefaultGateway(sna *net.IPNet) net.IP {
 	ip := sna.IP.To4()
 	return net.IPv4(ip[0], ip[1], ip[2], ip[3]|0x1)
 }
+
+// GenerateMAC returns the MAC address docker gives the container with IP
+// address ip, 02:42 followed by the address. The SDN also gives it to tun0
+// for the subnet gateway, so that br0 can answer ARP for it.
+func GenerateMAC(ip net.IP) net.HardwareAddr {
+	ip = ip.To4()
+	return net.HardwareAddr{0x02, 0x42, ip[0], ip[1], ip[2], ip[3]}
+}
+
+// GetInterfaceMTU returns the MTU of the interface that has the IP address ip
+func GetInterfaceMTU(ip string) (int, error) {
+	iface, err := interfaceByIP(ip)
+	if err != nil {
+		return 0, err
+	}
+	return iface.MTU, nil
+}
+
+// GetInterfaceName returns the name of the interface that has the IP address
+// ip
+func GetInterfaceName(ip string) (string, error) {
+	iface, err := interfaceByIP(ip)
+	if err != nil {
+		return "", err
+	}
+	return iface.Name, nil
+}
+
+func interfaceByIP(ip string) (*net.Interface, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("Invalid IP address %q", ip)
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(addr) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No interface has the address %s", ip)
+}
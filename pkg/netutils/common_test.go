@@ -20,3 +20,27 @@ func TestConversion(t *testing.T) {
 		t.Fatal("Conversion back and forth failed")
 	}
 }
+
+func TestGenerateMAC(t *testing.T) {
+	if mac := GenerateMAC(net.ParseIP("10.1.2.1")).String(); mac != "02:42:0a:01:02:01" {
+		t.Errorf("Wrong MAC for 10.1.2.1: %s", mac)
+	}
+}
+
+func TestGetInterfaceMTU(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+	if mtu, err := GetInterfaceMTU("127.0.0.1"); err != nil || mtu != lo.MTU {
+		t.Errorf("Wrong MTU for 127.0.0.1: %d (%v), expected %d", mtu, err, lo.MTU)
+	}
+	for _, ip := range []string{"192.0.2.1", "bogus"} {
+		if mtu, err := GetInterfaceMTU(ip); err == nil {
+			t.Errorf("Expected an error for %s, got %d", ip, mtu)
+		}
+	}
+	if name, err := GetInterfaceName("127.0.0.1"); err != nil || name != lo.Name {
+		t.Errorf("Wrong interface for 127.0.0.1: %q (%v), expected %q", name, err, lo.Name)
+	}
+}
@@ -10,6 +10,17 @@ type IPAllocator struct {
 	allocMap map[string]bool
 }
 
+// IPStats summarizes the address usage of an IPAllocator. Leased and Reclaimed
+// are filled in by servers that hand out addresses with leases.
+type IPStats struct {
+	Network   string `json:"network"`
+	Total     uint32 `json:"total"`
+	Allocated uint32 `json:"allocated"`
+	Available uint32 `json:"available"`
+	Leased    uint32 `json:"leased,omitempty"`
+	Reclaimed uint64 `json:"reclaimed,omitempty"`
+}
+
 func NewIPAllocator(network string, inUse []string) (*IPAllocator, error) {
 	_, netIP, err := net.ParseCIDR(network)
 	if err != nil {
@@ -73,3 +84,34 @@ func (ipa *IPAllocator) ReleaseIP(ip *net.IPNet) error {
 
 	return nil
 }
+
+func (ipa *IPAllocator) GetNetwork() *net.IPNet {
+	return &net.IPNet{IP: ipa.network.IP, Mask: ipa.network.Mask}
+}
+
+func (ipa *IPAllocator) GetStats() IPStats {
+	netMaskSize, _ := ipa.network.Mask.Size()
+	// Neither the network nor the broadcast address is handed out, which
+	// leaves no address at all in a /31 or a /32
+	var total uint32
+	if netMaskSize <= 30 {
+		total = uint32(1)<<(32-uint(netMaskSize)) - 2
+	}
+
+	var allocated uint32
+	for ipStr, taken := range ipa.allocMap {
+		if taken && ipStr != ipa.network.String() {
+			allocated++
+		}
+	}
+	var available uint32
+	if allocated < total {
+		available = total - allocated
+	}
+	return IPStats{
+		Network:   ipa.network.String(),
+		Total:     total,
+		Allocated: allocated,
+		Available: available,
+	}
+}
@@ -7,7 +7,7 @@ import (
 func TestAllocateIP(t *testing.T) {
 	ipa, err := NewIPAllocator("10.1.2.0/24", nil)
 	if err != nil {
-		t.Fatal("Failed to initialize IP allocator: %v", err)
+		t.Fatalf("Failed to initialize IP allocator: %v", err)
 	}
 
 	ip, err := ipa.GetIP()
@@ -37,7 +37,7 @@ func TestAllocateIPInUse(t *testing.T) {
 	inUse := []string{"10.1.2.1/24", "10.1.2.2/24", "10.2.2.3/24", "Invalid"}
 	ipa, err := NewIPAllocator("10.1.2.0/24", inUse)
 	if err != nil {
-		t.Fatal("Failed to initialize IP allocator: %v", err)
+		t.Fatalf("Failed to initialize IP allocator: %v", err)
 	}
 
 	ip, err := ipa.GetIP()
@@ -59,7 +59,7 @@ func TestAllocateIPInUse(t *testing.T) {
 func TestAllocateReleaseIP(t *testing.T) {
 	ipa, err := NewIPAllocator("10.1.2.0/24", nil)
 	if err != nil {
-		t.Fatal("Failed to initialize IP allocator: %v", err)
+		t.Fatalf("Failed to initialize IP allocator: %v", err)
 	}
 
 	ip, err := ipa.GetIP()
@@ -81,3 +81,43 @@ func TestAllocateReleaseIP(t *testing.T) {
 		t.Fatal("Did not get expected IP")
 	}
 }
+
+func TestIPStats(t *testing.T) {
+	inUse := []string{"10.1.2.1/24", "10.1.2.2/24"}
+	ipa, err := NewIPAllocator("10.1.2.0/24", inUse)
+	if err != nil {
+		t.Fatalf("Failed to initialize IP allocator: %v", err)
+	}
+	if _, err := ipa.GetIP(); err != nil {
+		t.Fatal("Failed to get IP: ", err)
+	}
+
+	stats := ipa.GetStats()
+	if stats.Network != "10.1.2.0/24" || stats.Total != 254 || stats.Allocated != 3 || stats.Available != 251 {
+		t.Fatalf("Did not get expected stats: %+v", stats)
+	}
+	if ipa.GetNetwork().String() != "10.1.2.0/24" {
+		t.Fatal("Did not get expected network", ipa.GetNetwork())
+	}
+}
+
+func TestIPStatsSmallNetworks(t *testing.T) {
+	testcases := []struct {
+		network string
+		total   uint32
+	}{
+		{"10.1.2.0/30", 2},
+		{"10.1.2.0/31", 0},
+		{"10.1.2.0/32", 0},
+	}
+	for _, tc := range testcases {
+		ipa, err := NewIPAllocator(tc.network, nil)
+		if err != nil {
+			t.Fatalf("Failed to initialize IP allocator for %s: %v", tc.network, err)
+		}
+		stats := ipa.GetStats()
+		if stats.Total != tc.total || stats.Allocated != 0 || stats.Available != tc.total {
+			t.Errorf("Did not get expected stats for %s: %+v", tc.network, stats)
+		}
+	}
+}
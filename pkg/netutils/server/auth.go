@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/golang/glog"
+)
+
+// Operation is a class of requests served by the netutils server, used to
+// decide what an authenticated client may do.
+type Operation string
+
+const (
+	// OpAllocate covers requests that hand out addresses from the pool.
+	OpAllocate Operation = "allocate"
+	// OpRelease covers requests that return addresses to the pool.
+	OpRelease Operation = "release"
+	// OpInfo covers read-only requests for subnet and gateway information.
+	OpInfo Operation = "info"
+	// OpStats covers requests for allocation statistics.
+	OpStats Operation = "stats"
+	// OpAdmin covers adding and removing pools.
+	OpAdmin Operation = "admin"
+	// OpAll grants every operation when used in a SubjectAuthorizer rule.
+	OpAll Operation = "*"
+)
+
+// Authorizer decides whether the client identified by its verified certificate
+// may perform an operation. A nil certificate means the client did not
+// present one.
+type Authorizer interface {
+	Authorize(cert *x509.Certificate, op Operation) error
+}
+
+// SubjectAuthorizer maps certificate subjects to the operations they may perform.
+// Rules are keyed by either the subject's common name or one of its
+// organizations, so that a group of clients (e.g. the pod hooks) can share a
+// single rule.
+type SubjectAuthorizer struct {
+	Rules map[string][]Operation
+}
+
+// NewSubjectAuthorizer returns an Authorizer enforcing the given subject rules.
+func NewSubjectAuthorizer(rules map[string][]Operation) *SubjectAuthorizer {
+	return &SubjectAuthorizer{Rules: rules}
+}
+
+// Authorize implements Authorizer.
+func (a *SubjectAuthorizer) Authorize(cert *x509.Certificate, op Operation) error {
+	if cert == nil {
+		return fmt.Errorf("no client certificate presented")
+	}
+	names := append([]string{cert.Subject.CommonName}, cert.Subject.Organization...)
+	for _, name := range names {
+		for _, allowed := range a.Rules[name] {
+			if allowed == op || allowed == OpAll {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("subject %q is not allowed to %s", subjectString(cert), op)
+}
+
+// authorize checks the request against the server's authorizer and writes a
+// 403 response if it is denied. Denials are always logged for audit.
+func (s *Server) authorize(w http.ResponseWriter, req *http.Request, op Operation) bool {
+	if s.authorizer == nil {
+		return true
+	}
+
+	var cert *x509.Certificate
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		cert = req.TLS.PeerCertificates[0]
+	}
+	err := s.authorizer.Authorize(cert, op)
+	if err != nil {
+		log.Warningf("AUDIT: denied %s %s from %s (subject %q): %v", req.Method, req.URL.Path, req.RemoteAddr, subjectString(cert), err)
+		http.Error(w, fmt.Sprintf("Forbidden: %v", err), http.StatusForbidden)
+		return false
+	}
+	log.V(4).Infof("AUDIT: allowed %s %s from %s (subject %q)", req.Method, req.URL.Path, req.RemoteAddr, subjectString(cert))
+	return true
+}
+
+func subjectString(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	parts := []string{"CN=" + cert.Subject.CommonName}
+	for _, o := range cert.Subject.Organization {
+		parts = append(parts, "O="+o)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/netutils/client"
+)
+
+type testCA struct {
+	cert   *x509.Certificate
+	key    *rsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{serial: 1}
+	ca.cert, ca.key = ca.sign(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "netutils-test-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	return ca
+}
+
+// sign issues a certificate from template, self-signed if the CA has no certificate yet.
+func (ca *testCA) sign(t *testing.T, template *x509.Certificate) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(ca.serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	ca.serial++
+
+	parent, signer := template, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %v", err)
+	}
+	return cert, key
+}
+
+func (ca *testCA) clientCert(t *testing.T, subject pkix.Name) tls.Certificate {
+	cert, key := ca.sign(t, &x509.Certificate{
+		Subject:     subject,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Error writing %s: %v", path, err)
+	}
+}
+
+func tlsClient(t *testing.T, serverURL string, ca *testCA, cert *tls.Certificate) *client.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return newClient(t, serverURL, &client.Options{TLSConfig: config, Retries: -1})
+}
+
+func TestClientCertAuthorization(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netutils-auth")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.sign(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	tlsOptions := &TLSOptions{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+		Authorizer: NewSubjectAuthorizer(map[string][]Operation{
+			"system:sdn-hooks": {OpAllocate, OpRelease, OpInfo},
+			"admin":            {OpAll},
+		}),
+	}
+	writePEM(t, tlsOptions.CertFile, "CERTIFICATE", serverCert.Raw)
+	writePEM(t, tlsOptions.KeyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(serverKey))
+	writePEM(t, tlsOptions.ClientCAFile, "CERTIFICATE", ca.cert.Raw)
+
+	ipam, err := netutils.NewIPAllocator("10.20.30.40/24", make([]string, 0))
+	if err != nil {
+		t.Fatalf("Error while initializing IPAM: %v", err)
+	}
+	l, err := ListenNetutilServer("https://127.0.0.1:0", tlsOptions, nil)
+	if err != nil {
+		t.Fatalf("Error while listening: %v", err)
+	}
+	defer l.Close()
+	server := NewServer(ipam)
+	server.SetAuthorizer(tlsOptions.Authorizer)
+	go ServeNetutilServer(server, l)
+	baseURL := "https://" + l.Addr().String()
+
+	hookCert := ca.clientCert(t, pkix.Name{CommonName: "node-1", Organization: []string{"system:sdn-hooks"}})
+	adminCert := ca.clientCert(t, pkix.Name{CommonName: "admin"})
+	otherCert := ca.clientCert(t, pkix.Name{CommonName: "someone"})
+
+	allocate := func(c *client.Client) error {
+		_, err := c.Allocate(context.Background())
+		return err
+	}
+	release := func(c *client.Client) error {
+		_, ip, _ := net.ParseCIDR("10.20.30.1/24")
+		return c.Release(context.Background(), ip)
+	}
+	stats := func(c *client.Client) error {
+		_, err := c.Stats(context.Background())
+		return err
+	}
+
+	tests := []struct {
+		name   string
+		cert   *tls.Certificate
+		call   func(*client.Client) error
+		status int
+	}{
+		{"hook allocates", &hookCert, allocate, http.StatusOK},
+		{"hook releases", &hookCert, release, http.StatusOK},
+		{"hook reads stats", &hookCert, stats, http.StatusForbidden},
+		{"admin reads stats", &adminCert, stats, http.StatusOK},
+		{"admin allocates", &adminCert, allocate, http.StatusOK},
+		{"unknown subject allocates", &otherCert, allocate, http.StatusForbidden},
+		{"no certificate allocates", nil, allocate, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		err := test.call(tlsClient(t, baseURL, ca, test.cert))
+		status := http.StatusOK
+		if se, ok := err.(*client.StatusError); ok {
+			status = se.Code
+		} else if err != nil {
+			t.Fatalf("%s: error talking to IPAM server: %v", test.name, err)
+		}
+		if status != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, status)
+		}
+	}
+
+	// a certificate from an unknown CA must not get past the handshake
+	rogue := newTestCA(t)
+	rogueCert := rogue.clientCert(t, pkix.Name{CommonName: "admin"})
+	if err := stats(tlsClient(t, baseURL, ca, &rogueCert)); err == nil {
+		t.Errorf("Expected a certificate signed by an unknown CA to be rejected")
+	} else if _, ok := err.(*client.StatusError); ok {
+		t.Errorf("Expected the handshake to fail, got %v", err)
+	}
+}
+
+func TestClientCAsFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netutils-auth")
+	if err != nil {
+		t.Fatalf("Error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	serverCert, serverKey := ca.sign(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	tlsOptions := &TLSOptions{
+		Config:   &tls.Config{ClientCAs: pool},
+		CertFile: filepath.Join(dir, "server.crt"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+	}
+	writePEM(t, tlsOptions.CertFile, "CERTIFICATE", serverCert.Raw)
+	writePEM(t, tlsOptions.KeyFile, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(serverKey))
+
+	ipam, err := netutils.NewIPAllocator("10.20.30.40/24", make([]string, 0))
+	if err != nil {
+		t.Fatalf("Error while initializing IPAM: %v", err)
+	}
+	l, err := ListenNetutilServer("https://127.0.0.1:0", tlsOptions, nil)
+	if err != nil {
+		t.Fatalf("Error while listening: %v", err)
+	}
+	defer l.Close()
+	go ServeNetutilServer(NewServer(ipam), l)
+	baseURL := "https://" + l.Addr().String()
+
+	cert := ca.clientCert(t, pkix.Name{CommonName: "node-1"})
+	if _, err := tlsClient(t, baseURL, ca, &cert).Allocate(context.Background()); err != nil {
+		t.Errorf("Unexpected error with a client certificate: %v", err)
+	}
+	if _, err := tlsClient(t, baseURL, ca, nil).Allocate(context.Background()); err == nil {
+		t.Errorf("Expected a client without a certificate to be rejected")
+	} else if _, ok := err.(*client.StatusError); ok {
+		t.Errorf("Expected the handshake to fail, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// LivenessChecker tells the lease collector whether the owner of an expired
+// lease still exists, so that addresses of live containers are never reclaimed.
+type LivenessChecker interface {
+	IsAlive(owner string) (bool, error)
+}
+
+// LivenessCheckerFunc adapts an ordinary function to the LivenessChecker interface.
+type LivenessCheckerFunc func(owner string) (bool, error)
+
+// IsAlive implements LivenessChecker.
+func (f LivenessCheckerFunc) IsAlive(owner string) (bool, error) {
+	return f(owner)
+}
+
+// DockerLivenessChecker treats lease owners as docker container IDs.
+type DockerLivenessChecker struct{}
+
+// IsAlive implements LivenessChecker.
+func (DockerLivenessChecker) IsAlive(owner string) (bool, error) {
+	out, err := exec.Command("docker", "inspect", "--format", "{{.State.Running}}", owner).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "No such") {
+			return false, nil
+		}
+		return false, fmt.Errorf("docker inspect %s failed: %v (%s)", owner, err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)) == "true", nil
+}
+
+type lease struct {
+	ip     *net.IPNet
+	owner  string
+	expiry time.Time
+}
+
+type leaseError struct {
+	status int
+	msg    string
+}
+
+func (e *leaseError) Error() string {
+	return e.msg
+}
+
+// leaseTable tracks the leased allocations of a pool. It is protected by the
+// server lock.
+type leaseTable struct {
+	leases    map[string]*lease
+	reclaimed uint64
+}
+
+func newLeaseTable() *leaseTable {
+	return &leaseTable{leases: make(map[string]*lease)}
+}
+
+func (t *leaseTable) add(ip *net.IPNet, owner string, expiry time.Time) {
+	t.leases[ip.String()] = &lease{ip: ip, owner: owner, expiry: expiry}
+}
+
+func (t *leaseTable) renew(ip *net.IPNet, owner string, expiry time.Time) error {
+	l, ok := t.leases[ip.String()]
+	if !ok {
+		return &leaseError{http.StatusNotFound, fmt.Sprintf("No lease for %s", ip)}
+	}
+	if l.owner != owner {
+		return &leaseError{http.StatusConflict, fmt.Sprintf("Lease for %s is owned by %s", ip, l.owner)}
+	}
+	l.expiry = expiry
+	return nil
+}
+
+func (t *leaseTable) remove(ip *net.IPNet) {
+	delete(t.leases, ip.String())
+}
+
+func (t *leaseTable) stats() (uint32, uint64) {
+	return uint32(len(t.leases)), t.reclaimed
+}
+
+// StartLeaseCollector enables leased allocations in every pool of the server
+// and reclaims expired leases every interval until stop is signalled. An
+// expired lease is only reclaimed once checker confirms that its owner is gone;
+// leases of live owners stay expired and are checked again on the next pass.
+func (s *Server) StartLeaseCollector(checker LivenessChecker, interval time.Duration, stop chan bool) {
+	s.lock.Lock()
+	s.leaseChecker = checker
+	for _, p := range s.pools {
+		if p.leases == nil {
+			p.leases = newLeaseTable()
+		}
+	}
+	s.lock.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.collectLeases()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+type expiredLease struct {
+	pool *pool
+	lease
+}
+
+// collectLeases makes a single garbage collection pass over the lease tables.
+func (s *Server) collectLeases() {
+	s.lock.Lock()
+	now := s.now()
+	expired := make([]expiredLease, 0)
+	for _, p := range s.pools {
+		for _, l := range p.leases.leases {
+			if now.After(l.expiry) {
+				expired = append(expired, expiredLease{p, *l})
+			}
+		}
+	}
+	checker := s.leaseChecker
+	s.lock.Unlock()
+
+	// the liveness check may be slow, so it runs without holding the lock
+	for _, e := range expired {
+		alive, err := checker.IsAlive(e.owner)
+		if err != nil {
+			log.Warningf("Could not check owner %s of expired lease for %s in pool %s: %v", e.owner, e.ip, e.pool.name, err)
+			continue
+		}
+		if alive {
+			log.Warningf("Lease for %s in pool %s expired but owner %s is still alive, not reclaiming", e.ip, e.pool.name, e.owner)
+			continue
+		}
+		s.reclaimLease(e)
+	}
+}
+
+func (s *Server) reclaimLease(e expiredLease) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the lease may have been renewed or released, or the pool removed, while
+	// the owner was checked
+	if s.pools[e.pool.name] != e.pool {
+		return
+	}
+	leases := e.pool.leases
+	l, ok := leases.leases[e.ip.String()]
+	if !ok || l.owner != e.owner || !s.now().After(l.expiry) {
+		return
+	}
+	if err := e.pool.ipam.ReleaseIP(l.ip); err != nil {
+		log.Errorf("Failed to reclaim %s from expired lease of %s in pool %s: %v", l.ip, l.owner, e.pool.name, err)
+		return
+	}
+	leases.remove(l.ip)
+	leases.reclaimed++
+	log.Infof("Reclaimed %s from expired lease of %s in pool %s", l.ip, l.owner, e.pool.name)
+}
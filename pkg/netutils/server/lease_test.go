@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/netutils/client"
+)
+
+func TestLeaseCollection(t *testing.T) {
+	ipam, err := netutils.NewIPAllocator("10.20.30.40/24", make([]string, 0))
+	if err != nil {
+		t.Fatalf("Error while initializing IPAM: %v", err)
+	}
+	l, err := ListenNetutilServer("http://127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatalf("Error while listening: %v", err)
+	}
+	defer l.Close()
+
+	alive := map[string]bool{"live": true, "dead": false, "renewing": false}
+	checker := LivenessCheckerFunc(func(owner string) (bool, error) {
+		isAlive, ok := alive[owner]
+		if !ok {
+			return false, fmt.Errorf("unknown owner %s", owner)
+		}
+		return isAlive, nil
+	})
+	stop := make(chan bool)
+	defer close(stop)
+	server := NewServer(ipam)
+	// run the collector by hand below rather than on a ticker
+	server.StartLeaseCollector(checker, time.Hour, stop)
+	now := time.Now()
+	server.now = func() time.Time { return now }
+	go ServeNetutilServer(server, l)
+
+	c := newClient(t, "http://"+l.Addr().String(), &client.Options{Retries: -1})
+	ctx := context.Background()
+
+	leased := make(map[string]string)
+	for _, owner := range []string{"live", "dead", "renewing", "unknown"} {
+		ip, err := c.AllocateWithLease(ctx, owner, time.Minute)
+		if err != nil {
+			t.Fatalf("Error allocating leased IP for %s: %v", owner, err)
+		}
+		leased[owner] = ip.String()
+	}
+	// an allocation without a lease is never collected
+	if _, err := c.Allocate(ctx); err != nil {
+		t.Fatalf("Error allocating IP: %v", err)
+	}
+
+	now = now.Add(45 * time.Second)
+	renewIP := parseCIDR(t, leased["renewing"])
+	if err := c.Renew(ctx, renewIP, "renewing", time.Minute); err != nil {
+		t.Fatalf("Error renewing lease: %v", err)
+	}
+	if err := c.Renew(ctx, renewIP, "someone-else", time.Minute); !isStatus(err, http.StatusConflict) {
+		t.Fatalf("Expected a conflict renewing another owner's lease, got %v", err)
+	}
+
+	now = now.Add(30 * time.Second)
+	server.collectLeases()
+
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Error while getting stats: %v", err)
+	}
+	if stats.Reclaimed != 1 || stats.Leased != 3 || stats.Allocated != 4 {
+		t.Fatalf("Expected only the dead owner's lease to be reclaimed, got %+v", stats)
+	}
+	deadIP := parseCIDR(t, leased["dead"])
+	if err := c.Renew(ctx, deadIP, "dead", time.Minute); !isStatus(err, http.StatusNotFound) {
+		t.Fatalf("Expected the reclaimed lease to be gone, got %v", err)
+	}
+
+	// the reclaimed address is handed out again
+	next, err := c.Allocate(ctx)
+	if err != nil || next.String() != leased["dead"] {
+		t.Fatalf("Expected reclaimed IP %s to be reused, got %v (%v)", leased["dead"], next, err)
+	}
+
+	// releasing drops the lease as well
+	liveIP := parseCIDR(t, leased["live"])
+	if err := c.Release(ctx, liveIP); err != nil {
+		t.Fatalf("Error releasing IP: %v", err)
+	}
+	if stats, err = c.Stats(ctx); err != nil || stats.Leased != 2 {
+		t.Fatalf("Expected 2 leases after release, got %+v (%v)", stats, err)
+	}
+}
+
+func TestLeaseRequiresCollector(t *testing.T) {
+	ipam, err := netutils.NewIPAllocator("10.20.30.40/24", make([]string, 0))
+	if err != nil {
+		t.Fatalf("Error while initializing IPAM: %v", err)
+	}
+	l, err := ListenNetutilServer("http://127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatalf("Error while listening: %v", err)
+	}
+	defer l.Close()
+	go ServeNetutilServer(NewServer(ipam), l)
+
+	c := newClient(t, "http://"+l.Addr().String(), &client.Options{Retries: -1})
+	if _, err := c.AllocateWithLease(context.Background(), "owner", time.Minute); !isStatus(err, http.StatusBadRequest) {
+		t.Fatalf("Expected leases to be rejected without a collector, got %v", err)
+	}
+}
+
+func parseCIDR(t *testing.T, cidr string) *net.IPNet {
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Error parsing %s: %v", cidr, err)
+	}
+	return &net.IPNet{IP: ip, Mask: ipnet.Mask}
+}
+
+func isStatus(err error, code int) bool {
+	se, ok := err.(*client.StatusError)
+	return ok && se.Code == code
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+)
+
+// DefaultPool is the name of the pool served on the unqualified /netutils paths.
+const DefaultPool = "default"
+
+// PoolFactory creates the allocator for a pool added through the admin endpoint.
+type PoolFactory func(network string) (IpamInterface, error)
+
+func defaultPoolFactory(network string) (IpamInterface, error) {
+	return netutils.NewIPAllocator(network, make([]string, 0))
+}
+
+// pool is a named address pool. It is protected by the server lock.
+type pool struct {
+	name   string
+	ipam   IpamInterface
+	leases *leaseTable
+}
+
+func (p *pool) stats() netutils.IPStats {
+	stats := p.ipam.GetStats()
+	if p.leases != nil {
+		stats.Leased, stats.Reclaimed = p.leases.stats()
+	}
+	return stats
+}
+
+// SetPoolFactory replaces the allocator used for pools added through the
+// admin endpoint, which by default is a netutils.IPAllocator.
+func (s *Server) SetPoolFactory(factory PoolFactory) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.poolFactory = factory
+}
+
+// AddPool serves ipam as the pool called name.
+func (s *Server) AddPool(name string, ipam IpamInterface) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("Invalid pool name %q", name)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.pools[name]; ok {
+		return fmt.Errorf("Pool %q already exists", name)
+	}
+	p := &pool{name: name, ipam: ipam}
+	if s.leaseChecker != nil {
+		p.leases = newLeaseTable()
+	}
+	s.pools[name] = p
+	return nil
+}
+
+// RemovePool stops serving the pool called name. Unless force is set, a pool
+// with addresses still allocated is not removed.
+func (s *Server) RemovePool(name string, force bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	p, ok := s.pools[name]
+	if !ok {
+		return fmt.Errorf("Pool %q does not exist", name)
+	}
+	if stats := p.ipam.GetStats(); stats.Allocated > 0 && !force {
+		return fmt.Errorf("Pool %q still has %d addresses allocated", name, stats.Allocated)
+	}
+	delete(s.pools, name)
+	return nil
+}
+
+func (s *Server) getPool(name string) *pool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pools[name]
+}
+
+// handlePools handles the /netutils/pools admin endpoint and routes requests
+// for /netutils/pools/<name>/<resource> to the named pool:
+//
+//	GET    /netutils/pools                        stats of every pool
+//	POST   /netutils/pools/<name>?network=<cidr>  add a pool
+//	DELETE /netutils/pools/<name>[?force=true]    remove a pool
+//	*      /netutils/pools/<name>/ip[/<address>]  as /netutils/ip
+//	GET    /netutils/pools/<name>/subnet          as /netutils/subnet
+//	GET    /netutils/pools/<name>/gateway         as /netutils/gateway
+//	GET    /netutils/pools/<name>/stats           as /stats
+func (s *Server) handlePools(w http.ResponseWriter, req *http.Request) {
+	parts := strings.SplitN(strings.Trim(strings.TrimPrefix(req.URL.Path, "/netutils/pools"), "/"), "/", 3)
+	name := parts[0]
+
+	if name == "" {
+		if req.Method != "GET" {
+			http.Error(w, "Method can only be GET", http.StatusNotFound)
+			return
+		}
+		if !s.authorize(w, req, OpStats) {
+			return
+		}
+		s.lock.Lock()
+		stats := make(map[string]netutils.IPStats)
+		for name, p := range s.pools {
+			stats[name] = p.stats()
+		}
+		s.lock.Unlock()
+		s.writeJSON(w, stats)
+		return
+	}
+
+	if len(parts) == 1 {
+		s.handlePoolAdmin(w, req, name)
+		return
+	}
+
+	p := s.getPool(name)
+	if p == nil {
+		http.Error(w, fmt.Sprintf("Pool %q does not exist", name), http.StatusNotFound)
+		return
+	}
+	arg := ""
+	if len(parts) == 3 {
+		arg = parts[2]
+	}
+	switch parts[1] {
+	case "ip":
+		s.handleIP(w, req, p, arg)
+	case "subnet":
+		s.handleSubnet(w, req, p, arg)
+	case "gateway":
+		s.handleGateway(w, req, p, arg)
+	case "stats":
+		s.handleStats(w, req, p, arg)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (s *Server) handlePoolAdmin(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != "POST" && req.Method != "DELETE" {
+		http.Error(w, "Method can only be POST/DELETE", http.StatusNotFound)
+		return
+	}
+	if !s.authorize(w, req, OpAdmin) {
+		return
+	}
+
+	if req.Method == "POST" {
+		network := req.URL.Query().Get("network")
+		if network == "" {
+			http.Error(w, "Bad Request: adding a pool requires the network parameter", http.StatusBadRequest)
+			return
+		}
+		s.lock.Lock()
+		factory := s.poolFactory
+		s.lock.Unlock()
+		ipam, err := factory(network)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.AddPool(name, ipam); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Infof("Added IPAM pool %s for %s", name, network)
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		if s.getPool(name) == nil {
+			http.Error(w, fmt.Sprintf("Pool %q does not exist", name), http.StatusNotFound)
+			return
+		}
+		if err := s.RemovePool(name, req.URL.Query().Get("force") == "true"); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Infof("Removed IPAM pool %s", name)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/netutils/client"
+)
+
+func TestNamedPools(t *testing.T) {
+	ipam, err := netutils.NewIPAllocator("10.20.30.40/24", make([]string, 0))
+	if err != nil {
+		t.Fatalf("Error while initializing IPAM: %v", err)
+	}
+	l, err := ListenNetutilServer("http://127.0.0.1:0", nil, nil)
+	if err != nil {
+		t.Fatalf("Error while listening: %v", err)
+	}
+	defer l.Close()
+	go ServeNetutilServer(NewServer(ipam), l)
+
+	c := newClient(t, "http://"+l.Addr().String(), &client.Options{Retries: -1})
+	ctx := context.Background()
+
+	if err := c.AddPool(ctx, "vnid-10", "10.1.10.0/24"); err != nil {
+		t.Fatalf("Error adding pool: %v", err)
+	}
+	if err := c.AddPool(ctx, "infra", "10.1.11.0/28"); err != nil {
+		t.Fatalf("Error adding pool: %v", err)
+	}
+	if err := c.AddPool(ctx, "infra", "10.1.12.0/24"); !isStatus(err, http.StatusConflict) {
+		t.Fatalf("Expected a conflict adding an existing pool, got %v", err)
+	}
+	if err := c.AddPool(ctx, "bad", "10.1.300.0/24"); !isStatus(err, http.StatusBadRequest) {
+		t.Fatalf("Expected a bad request adding an invalid network, got %v", err)
+	}
+
+	tenant, infra := c.Pool("vnid-10"), c.Pool("infra")
+	ip, err := tenant.Allocate(ctx)
+	if err != nil || ip.String() != "10.1.10.1/24" {
+		t.Fatalf("Wrong IP from tenant pool. Expected 10.1.10.1/24, got %v (%v)", ip, err)
+	}
+	ip, err = infra.Allocate(ctx)
+	if err != nil || ip.String() != "10.1.11.1/28" {
+		t.Fatalf("Wrong IP from infra pool. Expected 10.1.11.1/28, got %v (%v)", ip, err)
+	}
+	ip, err = c.Allocate(ctx)
+	if err != nil || ip.String() != "10.20.30.1/24" {
+		t.Fatalf("Wrong IP from default pool. Expected 10.20.30.1/24, got %v (%v)", ip, err)
+	}
+	gateway, err := infra.Gateway(ctx)
+	if err != nil || gateway.String() != "10.1.11.1" {
+		t.Fatalf("Wrong gateway from infra pool. Expected 10.1.11.1, got %v (%v)", gateway, err)
+	}
+	if _, err := c.Pool("missing").Allocate(ctx); !isStatus(err, http.StatusNotFound) {
+		t.Fatalf("Expected not found from a missing pool, got %v", err)
+	}
+
+	stats, err := c.PoolStats(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool stats: %v", err)
+	}
+	if len(stats) != 3 || stats["infra"].Total != 14 || stats["infra"].Allocated != 1 || stats["vnid-10"].Allocated != 1 {
+		t.Fatalf("Wrong pool stats: %+v", stats)
+	}
+	infraStats, err := infra.Stats(ctx)
+	if err != nil || infraStats.Network != "10.1.11.0/28" {
+		t.Fatalf("Wrong infra pool stats: %+v (%v)", infraStats, err)
+	}
+
+	// pools with allocated addresses are only removed when forced
+	if err := c.RemovePool(ctx, "vnid-10", false); !isStatus(err, http.StatusConflict) {
+		t.Fatalf("Expected a conflict removing a pool in use, got %v", err)
+	}
+	if err := tenant.Release(ctx, parseCIDR(t, "10.1.10.1/24")); err != nil {
+		t.Fatalf("Error releasing IP: %v", err)
+	}
+	if err := c.RemovePool(ctx, "vnid-10", false); err != nil {
+		t.Fatalf("Error removing pool: %v", err)
+	}
+	if err := c.RemovePool(ctx, "infra", true); err != nil {
+		t.Fatalf("Error force removing pool: %v", err)
+	}
+	if err := c.RemovePool(ctx, "infra", true); !isStatus(err, http.StatusNotFound) {
+		t.Fatalf("Expected not found removing a removed pool, got %v", err)
+	}
+	if stats, err = c.PoolStats(ctx); err != nil || len(stats) != 1 {
+		t.Fatalf("Expected only the default pool to remain, got %+v (%v)", stats, err)
+	}
+}
@@ -2,66 +2,246 @@ package server
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/openshift/openshift-sdn/pkg/netutils"
 )
 
 // Server is a http.Handler which exposes netutils functionality over HTTP.
 type Server struct {
-	ipam IpamInterface
-	mux  *http.ServeMux
+	// lock serializes access to the pools, their ipam interfaces and lease tables
+	lock         sync.Mutex
+	pools        map[string]*pool
+	poolFactory  PoolFactory
+	leaseChecker LivenessChecker
+	now          func() time.Time
+	mux          *http.ServeMux
+	authorizer   Authorizer
 }
 
+// TLSOptions configures the server side of TLS. When ClientCAFile is set, or
+// Config carries ClientCAs, clients must present a certificate signed by one
+// of the CAs. When an Authorizer is also set, the certificate subject decides
+// which requests are allowed and clients without a certificate are answered
+// with 403 rather than rejected during the handshake, so that the denial is
+// logged.
 type TLSOptions struct {
-	Config   *tls.Config
-	CertFile string
-	KeyFile  string
+	Config       *tls.Config
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	Authorizer   Authorizer
+}
+
+// SocketOptions controls the permissions of a unix socket created for the server.
+// A zero Mode leaves the permissions to the process umask, and negative UID/GID
+// values leave the respective owner unchanged.
+type SocketOptions struct {
+	Mode os.FileMode
+	UID  int
+	GID  int
 }
 
 // IpamInterface contains all the methods required by the server.
 type IpamInterface interface {
 	GetIP() (*net.IPNet, error)
 	ReleaseIP(ip *net.IPNet) error
-	//GetStats() string
+	GetNetwork() *net.IPNet
+	GetStats() netutils.IPStats
 }
 
 // ListenAndServeNetutilServer initializes a server to respond to HTTP network requests on the ipam interface
 func ListenAndServeNetutilServer(ipam IpamInterface, address net.IP, port uint, tlsOptions *TLSOptions) {
-	handler := NewServer(ipam)
+	scheme := "http"
+	if tlsOptions != nil {
+		scheme = "https"
+	}
+	serverURL := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(address.String(), strconv.FormatUint(uint64(port), 10)))
+	ListenAndServeNetutilServerURL(ipam, serverURL, tlsOptions, nil)
+}
+
+// ListenAndServeNetutilServerURL serves the ipam interface on the listener described by serverURL.
+// Supported schemes are http://host:port, https://host:port and unix:///path/to/socket.
+func ListenAndServeNetutilServerURL(ipam IpamInterface, serverURL string, tlsOptions *TLSOptions, socketOptions *SocketOptions) error {
+	l, err := ListenNetutilServer(serverURL, tlsOptions, socketOptions)
+	if err != nil {
+		return err
+	}
+	server := NewServer(ipam)
+	if tlsOptions != nil {
+		server.SetAuthorizer(tlsOptions.Authorizer)
+	}
+	return ServeNetutilServer(server, l)
+}
+
+// ListenNetutilServer creates the listener described by serverURL. A stale unix
+// socket left behind by a previous run is removed before binding.
+func ListenNetutilServer(serverURL string, tlsOptions *TLSOptions, socketOptions *SocketOptions) (net.Listener, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid server URL %q: %v", serverURL, err)
+	}
+
+	var l net.Listener
+	switch u.Scheme {
+	case "http", "https":
+		l, err = net.Listen("tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+	case "unix":
+		l, err = listenUnix(u.Path, socketOptions)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported scheme %q in server URL %q", u.Scheme, serverURL)
+	}
+
+	if u.Scheme == "https" && tlsOptions == nil {
+		l.Close()
+		return nil, fmt.Errorf("TLS options are required to serve %q", serverURL)
+	}
+	if tlsOptions != nil {
+		config, err := tlsOptions.serverConfig()
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l = tls.NewListener(l, config)
+	}
+	return l, nil
+}
+
+// ServeNetutilServer responds to HTTP network requests arriving on l with server.
+// It does not return until the listener fails or is closed.
+func ServeNetutilServer(server *Server, l net.Listener) error {
 	s := &http.Server{
-		Addr:           net.JoinHostPort(address.String(), strconv.FormatUint(uint64(port), 10)),
-		Handler:        handler,
+		Handler:        server,
 		ReadTimeout:    5 * time.Minute,
 		WriteTimeout:   5 * time.Minute,
 		MaxHeaderBytes: 1 << 20,
 	}
-	if tlsOptions != nil {
-		s.TLSConfig = tlsOptions.Config
-		s.ListenAndServeTLS(tlsOptions.CertFile, tlsOptions.KeyFile)
-	} else {
-		s.ListenAndServe()
+	return s.Serve(l)
+}
+
+func listenUnix(path string, socketOptions *SocketOptions) (net.Listener, error) {
+	if path == "" {
+		return nil, fmt.Errorf("Missing socket path in unix server URL")
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("Refusing to replace %s: not a unix socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
 	}
+	if socketOptions != nil {
+		if socketOptions.Mode != 0 {
+			if err := os.Chmod(path, socketOptions.Mode); err != nil {
+				l.Close()
+				return nil, err
+			}
+		}
+		if socketOptions.UID >= 0 || socketOptions.GID >= 0 {
+			if err := os.Chown(path, socketOptions.UID, socketOptions.GID); err != nil {
+				l.Close()
+				return nil, err
+			}
+		}
+	}
+	return l, nil
+}
+
+func (o *TLSOptions) serverConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if o.Config != nil {
+		config = o.Config.Clone()
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		return nil, fmt.Errorf("No server certificate configured for TLS")
+	}
+	if o.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No valid CA certificates found in %s", o.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	} else if o.Authorizer != nil && config.ClientCAs == nil {
+		return nil, fmt.Errorf("An authorizer requires a client CA to verify certificates against")
+	}
+	// Client CAs from Config are enforced just like those from ClientCAFile,
+	// unless Config already asks for verified client certificates.
+	if config.ClientCAs != nil && config.ClientAuth < tls.VerifyClientCertIfGiven {
+		if o.Authorizer != nil {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, nil
 }
 
 // NewServer initializes and configures the netutils_server.Server object to handle HTTP requests.
+// A non-nil ipam is served as the default pool.
 func NewServer(ipam IpamInterface) *Server {
 	server := Server{
-		ipam: ipam,
-		mux:  http.NewServeMux(),
+		pools:       make(map[string]*pool),
+		poolFactory: defaultPoolFactory,
+		now:         time.Now,
+		mux:         http.NewServeMux(),
+	}
+	if ipam != nil {
+		server.pools[DefaultPool] = &pool{name: DefaultPool, ipam: ipam}
 	}
 	server.InstallDefaultHandlers()
 	return &server
 }
 
+// SetAuthorizer makes the server check every request against authorizer.
+// A nil authorizer allows all requests.
+func (s *Server) SetAuthorizer(authorizer Authorizer) {
+	s.authorizer = authorizer
+}
+
 // InstallDefaultHandlers registers the default set of supported HTTP request patterns with the mux.
+// The unqualified /netutils paths and /stats act on the default pool.
 func (s *Server) InstallDefaultHandlers() {
-	s.mux.HandleFunc("/netutils/subnet", s.handleSubnet)
-	s.mux.HandleFunc("/netutils/ip/", s.handleIP)
-	s.mux.HandleFunc("/netutils/gateway", s.handleGateway)
-	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/netutils/subnet", s.defaultPoolHandler(s.handleSubnet))
+	s.mux.HandleFunc("/netutils/ip", s.defaultPoolHandler(s.handleIP))
+	s.mux.HandleFunc("/netutils/ip/", s.defaultPoolHandler(s.handleIP))
+	s.mux.HandleFunc("/netutils/gateway", s.defaultPoolHandler(s.handleGateway))
+	s.mux.HandleFunc("/netutils/pools", s.handlePools)
+	s.mux.HandleFunc("/netutils/pools/", s.handlePools)
+	s.mux.HandleFunc("/stats", s.defaultPoolHandler(s.handleStats))
 }
 
 // error serializes an error object into an HTTP response.
@@ -70,51 +250,165 @@ func (s *Server) error(w http.ResponseWriter, err error) {
 	http.Error(w, msg, http.StatusInternalServerError)
 }
 
+// poolHandlerFunc handles a request for pool p. arg is whatever follows the
+// resource name in the path, e.g. the address of /netutils/ip/<address>.
+type poolHandlerFunc func(w http.ResponseWriter, req *http.Request, p *pool, arg string)
+
+func (s *Server) defaultPoolHandler(handler poolHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		p := s.getPool(DefaultPool)
+		if p == nil {
+			http.Error(w, "No default pool configured", http.StatusNotFound)
+			return
+		}
+		arg := ""
+		if strings.HasPrefix(req.URL.Path, "/netutils/ip/") {
+			arg = strings.TrimPrefix(req.URL.Path, "/netutils/ip/")
+		}
+		handler(w, req, p, arg)
+	}
+}
+
 // handleSubnet handles gateway requests
-func (s *Server) handleSubnet(w http.ResponseWriter, req *http.Request) {
-	w.Header().Add("Content-type", "application/json")
-	w.Write([]byte("Not implemented"))
+func (s *Server) handleSubnet(w http.ResponseWriter, req *http.Request, p *pool, arg string) {
+	if !s.authorize(w, req, OpInfo) {
+		return
+	}
+	w.Header().Add("Content-type", "text/plain")
+	w.Write([]byte(p.ipam.GetNetwork().String()))
 	return
 }
 
 // handleGateway handles gateway requests
-func (s *Server) handleGateway(w http.ResponseWriter, req *http.Request) {
-	w.Header().Add("Content-type", "application/json")
-	w.Write([]byte("Not implemented"))
+func (s *Server) handleGateway(w http.ResponseWriter, req *http.Request, p *pool, arg string) {
+	if !s.authorize(w, req, OpInfo) {
+		return
+	}
+	w.Header().Add("Content-type", "text/plain")
+	w.Write([]byte(netutils.GenerateDefaultGateway(p.ipam.GetNetwork()).String()))
 	return
 }
 
-// handleIP handles IP requests
-func (s *Server) handleIP(w http.ResponseWriter, req *http.Request) {
+// handleIP handles IP requests. Allocations carrying an owner and a ttl query
+// parameter are leased, and the owner must renew them with a PUT before the
+// ttl runs out.
+func (s *Server) handleIP(w http.ResponseWriter, req *http.Request, p *pool, arg string) {
 	if req.Method == "GET" {
-		w.Header().Add("Content-type", "application/json")
-		ipnet, err := s.ipam.GetIP()
+		if !s.authorize(w, req, OpAllocate) {
+			return
+		}
+		owner, ttl, err := parseLease(req, p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		w.Header().Add("Content-type", "text/plain")
+		ipnet, err := p.ipam.GetIP()
 		if err != nil {
 			s.error(w, err)
-		} else {
-			w.Write([]byte(ipnet.String()))
+			return
+		}
+		if ttl != 0 {
+			p.leases.add(ipnet, owner, s.now().Add(ttl))
+		}
+		w.Write([]byte(ipnet.String()))
+	} else if req.Method == "PUT" {
+		if !s.authorize(w, req, OpAllocate) {
+			return
+		}
+		ipnet, err := parseIPNet(arg)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
+		}
+		owner, ttl, err := parseLease(req, p)
+		if err == nil && ttl == 0 {
+			err = fmt.Errorf("renewing a lease requires the owner and ttl parameters")
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
+		}
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if err := p.leases.renew(ipnet, owner, s.now().Add(ttl)); err != nil {
+			http.Error(w, err.Error(), err.(*leaseError).status)
 		}
 	} else if req.Method == "DELETE" {
-		ip, ipNet, err := net.ParseCIDR(req.URL.Path[len("/netutils/ip/"):])
+		if !s.authorize(w, req, OpRelease) {
+			return
+		}
+		delIP, err := parseIPNet(arg)
 		if err != nil {
-			s.error(w, err)
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
 		}
-		delIP := &net.IPNet{IP: ip, Mask: ipNet.Mask}
-		err = s.ipam.ReleaseIP(delIP)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		err = p.ipam.ReleaseIP(delIP)
 		if err != nil {
 			s.error(w, err)
+			return
+		}
+		if p.leases != nil {
+			p.leases.remove(delIP)
 		}
 	} else {
-		http.Error(w, "Method can only be GET/DELETE", http.StatusNotFound)
+		http.Error(w, "Method can only be GET/PUT/DELETE", http.StatusNotFound)
 	}
 	return
 }
 
+func parseIPNet(cidr string) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return &net.IPNet{IP: ip, Mask: ipNet.Mask}, nil
+}
+
+// parseLease returns the owner and ttl query parameters of a request; a zero
+// ttl means no lease was asked for.
+func parseLease(req *http.Request, p *pool) (string, time.Duration, error) {
+	query := req.URL.Query()
+	owner, ttlStr := query.Get("owner"), query.Get("ttl")
+	if ttlStr == "" {
+		return owner, 0, nil
+	}
+	if p.leases == nil {
+		return "", 0, fmt.Errorf("leases are not enabled on this server")
+	}
+	if owner == "" {
+		return "", 0, fmt.Errorf("a lease requires an owner")
+	}
+	ttl, err := time.ParseDuration(ttlStr)
+	if err != nil || ttl <= 0 {
+		return "", 0, fmt.Errorf("invalid lease ttl %q", ttlStr)
+	}
+	return owner, ttl, nil
+}
+
 // handleStats handles stats requests
-func (s *Server) handleStats(w http.ResponseWriter, req *http.Request) {
+func (s *Server) handleStats(w http.ResponseWriter, req *http.Request, p *pool, arg string) {
+	if !s.authorize(w, req, OpStats) {
+		return
+	}
+	s.lock.Lock()
+	stats := p.stats()
+	s.lock.Unlock()
+	s.writeJSON(w, stats)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.error(w, err)
+		return
+	}
 	w.Header().Add("Content-type", "application/json")
-	w.Write([]byte("Not implemented"))
-	return
+	w.Write(data)
 }
 
 // ServeHTTP responds to HTTP requests
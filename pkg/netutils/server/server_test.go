@@ -1,73 +1,132 @@
 package server
 
 import (
-	"fmt"
+	"context"
 	"io/ioutil"
 	"net"
-	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/openshift/openshift-sdn/pkg/netutils"
+	"github.com/openshift/openshift-sdn/pkg/netutils/client"
 )
 
-func delIP(t *testing.T, delip string) error {
-	url := fmt.Sprintf("http://127.0.0.1:9080/netutils/ip/%s", delip)
-	req, err := http.NewRequest("DELETE", url, nil)
+func startServer(t *testing.T, serverURL string, socketOptions *SocketOptions) net.Listener {
+	ipam, err := netutils.NewIPAllocator("10.20.30.40/24", make([]string, 0))
 	if err != nil {
-		t.Fatal("Error in forming request to IPAM server")
+		t.Fatalf("Error while initializing IPAM: %v", err)
 	}
-	res, err := http.DefaultClient.Do(req)
+	l, err := ListenNetutilServer(serverURL, nil, socketOptions)
 	if err != nil {
-		t.Fatal("Error in connecting to IPAM server")
+		t.Fatalf("Error while listening on %s: %v", serverURL, err)
 	}
-	if res.StatusCode > 400 {
-		return fmt.Errorf("Bad response from server: %d", res.StatusCode)
+	go ServeNetutilServer(NewServer(ipam), l)
+	return l
+}
+
+func newClient(t *testing.T, serverURL string, opts *client.Options) *client.Client {
+	c, err := client.New(serverURL, opts)
+	if err != nil {
+		t.Fatalf("Error creating client for %s: %v", serverURL, err)
 	}
-	return err
+	return c
 }
 
-func getIP(t *testing.T) string {
-	res, err := http.Get("http://127.0.0.1:9080/netutils/ip")
+func testIPServe(t *testing.T, c *client.Client) {
+	ctx := context.Background()
+
+	// get, get, delete, get
+	ip, err := c.Allocate(ctx)
+	if err != nil || ip.String() != "10.20.30.1/24" {
+		t.Fatalf("Wrong IP. Expected 10.20.30.1/24, got %v (%v)", ip, err)
+	}
+	ip, err = c.Allocate(ctx)
+	if err != nil || ip.String() != "10.20.30.2/24" {
+		t.Fatalf("Wrong IP. Expected 10.20.30.2/24, got %v (%v)", ip, err)
+	}
+	err = c.Release(ctx, ip)
 	if err != nil {
-		t.Fatal("Error in connecting to IPAM server")
+		t.Fatalf("Error while deleting IP address %s: %v", ip, err)
 	}
-	ip, err := ioutil.ReadAll(res.Body)
+	// get it again
+	ip, err = c.Allocate(ctx)
+	if err != nil || ip.String() != "10.20.30.2/24" {
+		t.Fatalf("Wrong IP. Expected 10.20.30.2/24, got %v (%v)", ip, err)
+	}
+	// delete the wrong one and fail if there is no error
+	_, wrong, _ := net.ParseCIDR("10.10.10.10/23")
+	err = c.Release(ctx, wrong)
+	if err == nil {
+		t.Fatalf("Expected an error while deleting IP address %s", wrong)
+	}
+
+	subnet, err := c.Subnet(ctx)
+	if err != nil || subnet.String() != "10.20.30.0/24" {
+		t.Fatalf("Wrong subnet. Expected 10.20.30.0/24, got %v (%v)", subnet, err)
+	}
+	gateway, err := c.Gateway(ctx)
+	if err != nil || gateway.String() != "10.20.30.1" {
+		t.Fatalf("Wrong gateway. Expected 10.20.30.1, got %v (%v)", gateway, err)
+	}
+	stats, err := c.Stats(ctx)
 	if err != nil {
-		t.Fatal("Error in obtaining IP address through server")
+		t.Fatalf("Error while getting stats: %v", err)
+	}
+	if stats.Network != "10.20.30.0/24" || stats.Allocated != 2 || stats.Available != 252 {
+		t.Fatalf("Wrong stats: %+v", stats)
 	}
-	res.Body.Close()
-	return string(ip)
 }
 
 func TestIPServe(t *testing.T) {
-	inuse := make([]string, 0)
-	ipam, err := netutils.NewIPAllocator("10.20.30.40/24", inuse)
+	l := startServer(t, "http://127.0.0.1:9080", nil)
+	defer l.Close()
+
+	testIPServe(t, newClient(t, "http://127.0.0.1:9080", nil))
+}
+
+func TestIPServeUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netutils-server")
 	if err != nil {
-		t.Fatalf("Error while initializing IPAM: %v", err)
+		t.Fatalf("Error creating temporary directory: %v", err)
 	}
-	go ListenAndServeNetutilServer(ipam, net.ParseIP("127.0.0.1"), 9080, nil)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "ipam.sock")
 
-	// get, get, delete, get
-	ip := getIP(t)
-	if ip != "10.20.30.1/24" {
-		t.Fatalf("Wrong IP. Expected 10.20.30.1/24, got %s", ip)
+	// a stale socket from a previous run must not prevent binding
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error creating stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l := startServer(t, "unix://"+socket, &SocketOptions{Mode: 0600, UID: -1, GID: -1})
+	defer l.Close()
+
+	fi, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Error checking socket: %v", err)
 	}
-	ip = getIP(t)
-	if ip != "10.20.30.2/24" {
-		t.Fatalf("Wrong IP. Expected 10.20.30.2/24, got %s", ip)
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("Wrong socket permissions. Expected 0600, got %v", fi.Mode().Perm())
 	}
-	err = delIP(t, ip)
+
+	testIPServe(t, newClient(t, "unix://"+socket, nil))
+}
+
+func TestListenRejectsNonSocket(t *testing.T) {
+	f, err := ioutil.TempFile("", "netutils-server")
 	if err != nil {
-		t.Fatalf("Error while deleting IP address %s: %v", ip, err)
+		t.Fatalf("Error creating temporary file: %v", err)
 	}
-	// get it again
-	ip = getIP(t)
-	if ip != "10.20.30.2/24" {
-		t.Fatalf("Wrong IP. Expected 10.20.30.2/24, got %s", ip)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if _, err := ListenNetutilServer("unix://"+f.Name(), nil, nil); err == nil {
+		t.Fatalf("Expected an error when binding over regular file %s", f.Name())
 	}
-	// delete the wrong one and fail if there is no error
-	err = delIP(t, "10.10.10.10/23")
-	if err == nil {
-		t.Fatalf("Error while deleting IP address %s: %v", ip, err)
+	if _, err := ListenNetutilServer("ftp://127.0.0.1:9081", nil, nil); err == nil {
+		t.Fatalf("Expected an error for an unsupported scheme")
 	}
 }
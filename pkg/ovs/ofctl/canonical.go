@@ -0,0 +1,209 @@
+package ofctl
+
+import (
+	"fmt"
+	"math/bits"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	number = regexp.MustCompile(`^(0x[0-9a-fA-F]+|[0-9]+)$`)
+	// a full register load, which newer OVS prints as set_field
+	setFieldReg = regexp.MustCompile(`^(0x[0-9a-fA-F]+|[0-9]+)->reg([0-7])$`)
+	loadValue   = regexp.MustCompile(`^(0x[0-9a-fA-F]+|[0-9]+)->(.*)$`)
+	// a load of some bits of a field, which newer OVS prints as a masked
+	// set_field
+	maskedValue = regexp.MustCompile(`^(0x[0-9a-fA-F]+|[0-9]+)/(0x[0-9a-fA-F]+|[0-9]+)->(.*)$`)
+	ctStateFlag = regexp.MustCompile(`[+-][a-z]+`)
+)
+
+// fields other than registers whose loads newer OVS prints as set_field
+var setFieldLoads = map[string]string{
+	"pkt_mark": "NXM_NX_PKT_MARK[]",
+	"ct_mark":  "NXM_NX_CT_MARK[]",
+}
+
+// learn() parameters that dump-flows may print in a different order
+var learnParams = map[string]bool{
+	"table":            true,
+	"priority":         true,
+	"idle_timeout":     true,
+	"hard_timeout":     true,
+	"cookie":           true,
+	"fin_idle_timeout": true,
+	"fin_hard_timeout": true,
+}
+
+// Canonical returns a copy of the flow in a normal form, so that a flow as
+// given to add-flow and the same flow as printed by dump-flows are equal:
+// dl_type is spelled as a protocol shorthand, ARP addresses use their ARP
+// names, transport ports are tp_src and tp_dst, numbers are decimal, host masks are dropped, match fields are
+// sorted, conntrack state flags and arguments are sorted and register and
+// mark loads are spelled as load actions.
+func (f *Flow) Canonical() *Flow {
+	c := *f
+	c.Match = make([]Field, 0, len(f.Match))
+	isARP := false
+	for _, m := range f.Match {
+		if m.Name == "dl_type" {
+			switch canonicalNumber(m.Value) {
+			case "2048":
+				m = Field{Name: "ip"}
+			case "2054":
+				m = Field{Name: "arp"}
+			}
+		}
+		if m.Name == "arp" {
+			isARP = true
+		}
+		// newer OVS names transport ports after the protocol
+		switch m.Name {
+		case "tcp_src", "udp_src":
+			m.Name = "tp_src"
+		case "tcp_dst", "udp_dst":
+			m.Name = "tp_dst"
+		}
+		if m.Name == "ct_state" {
+			m.Value = canonicalCTState(m.Value)
+		}
+		m.Value = canonicalNumber(strings.TrimSuffix(m.Value, "/32"))
+		c.Match = append(c.Match, m)
+	}
+	for i, m := range c.Match {
+		if isARP {
+			switch m.Name {
+			case "nw_src":
+				c.Match[i].Name = "arp_spa"
+			case "nw_dst":
+				c.Match[i].Name = "arp_tpa"
+			}
+		}
+	}
+	sort.Slice(c.Match, func(i, j int) bool {
+		return c.Match[i].String() < c.Match[j].String()
+	})
+
+	c.Actions = canonicalActions(f.Actions)
+	return &c
+}
+
+func canonicalActions(actions []Action) []Action {
+	canonical := make([]Action, 0, len(actions))
+	for _, a := range actions {
+		a.Name = strings.ToLower(a.Name)
+		switch a.Name {
+		case "set_field":
+			if m := setFieldReg.FindStringSubmatch(a.Arg); m != nil {
+				a = Action{"load", fmt.Sprintf("%s->NXM_NX_REG%s[]", m[1], m[2])}
+			} else if m := loadValue.FindStringSubmatch(a.Arg); m != nil && setFieldLoads[m[2]] != "" {
+				a = Action{"load", m[1] + "->" + setFieldLoads[m[2]]}
+			} else if m := maskedValue.FindStringSubmatch(a.Arg); m != nil && setFieldLoads[m[3]] != "" {
+				if load, ok := maskedLoad(m[1], m[2], setFieldLoads[m[3]]); ok {
+					a = Action{"load", load}
+				}
+			}
+		case "learn":
+			a.Arg = canonicalLearn(a.Arg)
+		case "ct":
+			a.Arg = canonicalCT(a.Arg)
+		}
+		if a.Name == "load" {
+			if m := loadValue.FindStringSubmatch(a.Arg); m != nil {
+				a.Arg = canonicalNumber(m[1]) + "->" + m[2]
+			}
+		}
+		canonical = append(canonical, a)
+	}
+	return canonical
+}
+
+// maskedLoad turns the value and mask of a masked set_field into the
+// argument of the load of the same bits of field, e.g. 0x50000/0x3ff0000 into
+// 5->NXM_NX_PKT_MARK[16..25]. Masks that are not one run of bits have no
+// such load.
+func maskedLoad(value, mask, field string) (string, bool) {
+	v, err := strconv.ParseUint(value, 0, 64)
+	if err != nil {
+		return "", false
+	}
+	m, err := strconv.ParseUint(mask, 0, 64)
+	if err != nil || m == 0 {
+		return "", false
+	}
+	lo := bits.TrailingZeros64(m)
+	run := m >> uint(lo)
+	if run&(run+1) != 0 {
+		return "", false
+	}
+	hi := lo + bits.Len64(run) - 1
+	bitRange := fmt.Sprintf("[%d..%d]", lo, hi)
+	if lo == hi {
+		bitRange = fmt.Sprintf("[%d]", lo)
+	}
+	return fmt.Sprintf("%d->%s%s", (v&m)>>uint(lo), strings.TrimSuffix(field, "[]"), bitRange), true
+}
+
+// Equal tells whether two flows are the same flow.
+func (f *Flow) Equal(other *Flow) bool {
+	return f.Canonical().String() == other.Canonical().String()
+}
+
+func canonicalNumber(s string) string {
+	if !number.MatchString(s) {
+		return s
+	}
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return s
+	}
+	return strconv.FormatUint(n, 10)
+}
+
+// canonicalCTState sorts the flags of a ct_state match, e.g. "+trk+dnat-rpl",
+// which dump-flows prints in the order of their bits.
+func canonicalCTState(value string) string {
+	flags := ctStateFlag.FindAllString(value, -1)
+	if strings.Join(flags, "") != value {
+		return value
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i][1:] < flags[j][1:] })
+	return strings.Join(flags, "")
+}
+
+// canonicalCT sorts the arguments of a ct() action, which dump-flows prints in
+// its own order, with numbers in decimal and the actions of exec() canonical.
+func canonicalCT(arg string) string {
+	parts := []string{}
+	for _, part := range splitTopLevel(arg) {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 && !strings.Contains(kv[0], "(") {
+			part = kv[0] + "=" + canonicalNumber(kv[1])
+		} else if strings.HasPrefix(part, "exec(") && strings.HasSuffix(part, ")") {
+			exec := []string{}
+			for _, a := range canonicalActions(parseActions(part[len("exec(") : len(part)-1])) {
+				exec = append(exec, a.String())
+			}
+			part = "exec(" + strings.Join(exec, ",") + ")"
+		}
+		parts = append(parts, part)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// canonicalLearn sorts the leading parameters of a learn() action, which
+// dump-flows prints in its own order, ahead of the field specifications.
+func canonicalLearn(arg string) string {
+	params, specs := []string{}, []string{}
+	for _, part := range splitTopLevel(arg) {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 && learnParams[kv[0]] {
+			params = append(params, kv[0]+"="+canonicalNumber(kv[1]))
+		} else {
+			specs = append(specs, part)
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(append(params, specs...), ",")
+}
@@ -0,0 +1,305 @@
+// Package ofctl models OpenFlow flows the way ovs-ofctl reads and prints
+// them, so that flows can be built as values, rendered for "add-flow" and
+// compared with what "dump-flows" reports.
+package ofctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPriority is the priority of a flow that does not give one.
+const DefaultPriority = 32768
+
+// Field is one match field, e.g. {"nw_dst", "10.1.2.0/24"}. Value is empty
+// for protocol shorthands such as "ip" and "arp".
+type Field struct {
+	Name  string
+	Value string
+}
+
+func (f Field) String() string {
+	if f.Value == "" {
+		return f.Name
+	}
+	return f.Name + "=" + f.Value
+}
+
+// Protocol shorthands for matching IPv4, ARP, TCP and UDP packets.
+var (
+	IP  = Field{Name: "ip"}
+	ARP = Field{Name: "arp"}
+	TCP = Field{Name: "tcp"}
+	UDP = Field{Name: "udp"}
+)
+
+// Eq matches field name against value, e.g. Eq("nw_dst", "10.1.2.0/24").
+func Eq(name, value string) Field {
+	return Field{Name: name, Value: value}
+}
+
+// Action is one action, e.g. {"output", "1"} or {"learn", "table=7,..."}.
+// Arg is empty for actions without an argument such as "flood".
+type Action struct {
+	Name string
+	Arg  string
+}
+
+func (a Action) String() string {
+	switch {
+	case a.Arg == "":
+		return a.Name
+	case a.Name == "learn", a.Name == "ct":
+		return a.Name + "(" + a.Arg + ")"
+	default:
+		return a.Name + ":" + a.Arg
+	}
+}
+
+// Output sends the packet out of port.
+func Output(port int) Action {
+	return Action{"output", strconv.Itoa(port)}
+}
+
+// GotoTable continues processing in table.
+func GotoTable(table int) Action {
+	return Action{"goto_table", strconv.Itoa(table)}
+}
+
+// Load loads value into dst, e.g. Load(10, "NXM_NX_REG0[]").
+func Load(value uint64, dst string) Action {
+	return Action{"load", fmt.Sprintf("%d->%s", value, dst)}
+}
+
+// Move copies src into dst, e.g. Move("NXM_NX_REG0[]", "NXM_NX_TUN_ID[0..31]").
+func Move(src, dst string) Action {
+	return Action{"move", src + "->" + dst}
+}
+
+// SetField sets field to value, e.g. SetField("172.17.0.3", "tun_dst").
+func SetField(value, field string) Action {
+	return Action{"set_field", value + "->" + field}
+}
+
+// ToGroup processes the packet with group id.
+func ToGroup(id uint32) Action {
+	return Action{"group", strconv.FormatUint(uint64(id), 10)}
+}
+
+// CT sends the packet through the connection tracker with arguments arg,
+// e.g. CT("zone=1,nat,table=8").
+func CT(arg string) Action {
+	return Action{"ct", arg}
+}
+
+// ARPReply turns an ARP request for ip into the reply of the host with MAC
+// address mac and sends it back where it came from, through the tunnel to
+// the sending node if it came through the tunnel.
+func ARPReply(ip, mac string) []Action {
+	return []Action{
+		Move("NXM_OF_ETH_SRC[]", "NXM_OF_ETH_DST[]"),
+		SetField(mac, "eth_src"),
+		SetField("2", "arp_op"),
+		Move("NXM_NX_ARP_SHA[]", "NXM_NX_ARP_THA[]"),
+		SetField(mac, "arp_sha"),
+		Move("NXM_OF_ARP_SPA[]", "NXM_OF_ARP_TPA[]"),
+		SetField(ip, "arp_spa"),
+		Move("NXM_NX_TUN_IPV4_SRC[]", "NXM_NX_TUN_IPV4_DST[]"),
+		{Name: "IN_PORT"},
+	}
+}
+
+// GeneratedARPReply turns an ARP request into the reply of the host with the
+// MAC address docker gives the requested IP, 02:42 followed by the address,
+// and sends it back where it came from. It uses reg1 to swap the addresses.
+func GeneratedARPReply() []Action {
+	return []Action{
+		Move("NXM_OF_ETH_SRC[]", "NXM_OF_ETH_DST[]"),
+		SetField("2", "arp_op"),
+		Move("NXM_NX_ARP_SHA[]", "NXM_NX_ARP_THA[]"),
+		SetField("02:42:00:00:00:00", "arp_sha"),
+		Move("NXM_OF_ARP_TPA[]", "NXM_NX_ARP_SHA[0..31]"),
+		Move("NXM_NX_ARP_SHA[]", "NXM_OF_ETH_SRC[]"),
+		Move("NXM_OF_ARP_SPA[]", "NXM_NX_REG1[]"),
+		Move("NXM_OF_ARP_TPA[]", "NXM_OF_ARP_SPA[]"),
+		Move("NXM_NX_REG1[]", "NXM_OF_ARP_TPA[]"),
+		{Name: "IN_PORT"},
+	}
+}
+
+// Flow is an OpenFlow flow. A zero Priority is rendered as "priority=0";
+// use DefaultPriority for flows that do not care.
+type Flow struct {
+	Table       int
+	Cookie      uint64
+	Priority    int
+	IdleTimeout int
+	HardTimeout int
+	Match       []Field
+	Actions     []Action
+}
+
+// String renders the flow in "ovs-ofctl add-flow" syntax.
+func (f *Flow) String() string {
+	parts := []string{fmt.Sprintf("table=%d", f.Table)}
+	if f.Cookie != 0 {
+		parts = append(parts, fmt.Sprintf("cookie=0x%x", f.Cookie))
+	}
+	if f.Priority != DefaultPriority {
+		parts = append(parts, fmt.Sprintf("priority=%d", f.Priority))
+	}
+	if f.IdleTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("idle_timeout=%d", f.IdleTimeout))
+	}
+	if f.HardTimeout != 0 {
+		parts = append(parts, fmt.Sprintf("hard_timeout=%d", f.HardTimeout))
+	}
+	for _, m := range f.Match {
+		parts = append(parts, m.String())
+	}
+	actions := make([]string, 0, len(f.Actions))
+	for _, a := range f.Actions {
+		actions = append(actions, a.String())
+	}
+	if len(actions) == 0 {
+		actions = append(actions, "drop")
+	}
+	return strings.Join(parts, ",") + ",actions=" + strings.Join(actions, ",")
+}
+
+// StrictMatch renders the table, cookie, priority and match of the flow for
+// "ovs-ofctl --strict del-flows", which then deletes just this flow.
+func (f *Flow) StrictMatch() string {
+	parts := []string{fmt.Sprintf("table=%d", f.Table), fmt.Sprintf("cookie=0x%x/-1", f.Cookie)}
+	if f.Priority != DefaultPriority {
+		parts = append(parts, fmt.Sprintf("priority=%d", f.Priority))
+	}
+	for _, m := range f.Match {
+		parts = append(parts, m.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// flow metadata that dump-flows prints but that is not part of the flow
+var statistics = map[string]bool{
+	"duration":      true,
+	"n_packets":     true,
+	"n_bytes":       true,
+	"idle_age":      true,
+	"hard_age":      true,
+	"reset_counts":  true,
+	"send_flow_rem": true,
+	"check_overlap": true,
+	"importance":    true,
+}
+
+// ParseFlow parses a flow in "add-flow" syntax or a line of "dump-flows"
+// output. Counters and other statistics are ignored.
+func ParseFlow(flow string) (*Flow, error) {
+	i := strings.Index(flow, "actions=")
+	if i < 0 {
+		return nil, fmt.Errorf("No actions in flow %q", flow)
+	}
+	f := &Flow{Priority: DefaultPriority}
+	for _, field := range splitTopLevel(flow[:i]) {
+		kv := strings.SplitN(field, "=", 2)
+		name := kv[0]
+		if len(kv) == 1 {
+			f.Match = append(f.Match, Field{Name: name})
+			continue
+		}
+		var err error
+		switch {
+		case name == "table":
+			f.Table, err = strconv.Atoi(kv[1])
+		case name == "cookie":
+			f.Cookie, err = strconv.ParseUint(kv[1], 0, 64)
+		case name == "priority":
+			f.Priority, err = strconv.Atoi(kv[1])
+		case name == "idle_timeout":
+			f.IdleTimeout, err = strconv.Atoi(kv[1])
+		case name == "hard_timeout":
+			f.HardTimeout, err = strconv.Atoi(kv[1])
+		case statistics[name]:
+		default:
+			f.Match = append(f.Match, Field{Name: name, Value: kv[1]})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s in flow %q: %v", name, flow, err)
+		}
+	}
+
+	f.Actions = parseActions(flow[i+len("actions="):])
+	return f, nil
+}
+
+// parseActions parses a comma-separated list of actions, as of a flow or a
+// group bucket.
+func parseActions(s string) []Action {
+	var actions []Action
+	for _, action := range splitTopLevel(s) {
+		if strings.HasSuffix(action, ")") {
+			if j := strings.Index(action, "("); j > 0 && !strings.Contains(action[:j], ":") {
+				actions = append(actions, Action{Name: action[:j], Arg: action[j+1 : len(action)-1]})
+				continue
+			}
+		}
+		kv := strings.SplitN(action, ":", 2)
+		if len(kv) == 1 {
+			if action != "drop" {
+				actions = append(actions, Action{Name: action})
+			}
+			continue
+		}
+		actions = append(actions, Action{Name: kv[0], Arg: kv[1]})
+	}
+	return actions
+}
+
+// ParseDump parses "ovs-ofctl dump-flows" output, skipping the reply headers.
+func ParseDump(out string) ([]*Flow, error) {
+	flows := []*Flow{}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, "actions=") {
+			continue
+		}
+		f, err := ParseFlow(line)
+		if err != nil {
+			return nil, err
+		}
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
+
+// splitTopLevel splits s at commas outside parentheses and brackets,
+// dropping whitespace, which ovs-ofctl allows anywhere between tokens.
+func splitTopLevel(s string) []string {
+	parts := []string{}
+	depth := 0
+	var cur []rune
+	for _, r := range s {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ' ', '\t', '\n':
+			continue
+		case ',':
+			if depth == 0 {
+				if len(cur) > 0 {
+					parts = append(parts, string(cur))
+				}
+				cur = cur[:0]
+				continue
+			}
+		}
+		cur = append(cur, r)
+	}
+	if len(cur) > 0 {
+		parts = append(parts, string(cur))
+	}
+	return parts
+}
@@ -0,0 +1,406 @@
+package ofctl
+
+import (
+	"reflect"
+	"testing"
+)
+
+// installedFlows are the flows openshift-sdn installs, as the controllers,
+// hooks and setup scripts give them to add-flow, with the line dump-flows
+// prints for each.
+var installedFlows = []struct {
+	name   string
+	added  string
+	dumped string
+}{
+	// kube controller
+	{
+		"kube default route",
+		"table=0,priority=50,actions=output:2",
+		" cookie=0x0, duration=51.29s, table=0, n_packets=12, n_bytes=1008, idle_age=3, priority=50 actions=output:2",
+	},
+	{
+		"kube gateway arp",
+		"table=0,priority=100,arp,nw_dst=10.1.2.1,actions=output:2",
+		" cookie=0x0, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=100,arp,arp_tpa=10.1.2.1 actions=output:2",
+	},
+	{
+		"kube gateway ip",
+		"table=0,priority=100,ip,nw_dst=10.1.2.1,actions=output:2",
+		" cookie=0x0, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=100,ip,nw_dst=10.1.2.1 actions=output:2",
+	},
+	{
+		"kube local subnet ip",
+		"table=0,cookie=0xac110002,priority=75,ip,nw_dst=10.1.2.0/24,actions=output:9",
+		" cookie=0xac110002, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=75,ip,nw_dst=10.1.2.0/24 actions=output:9",
+	},
+	{
+		"kube local subnet arp",
+		"table=0,cookie=0xac110002,priority=75,arp,nw_dst=10.1.2.0/24,actions=output:9",
+		" cookie=0xac110002, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=75,arp,arp_tpa=10.1.2.0/24 actions=output:9",
+	},
+	{
+		"kube remote subnet ip",
+		"table=0,cookie=0xac110003,priority=100,ip,nw_dst=10.1.3.0/24,actions=set_field:172.17.0.3->tun_dst,output:1",
+		" cookie=0xac110003, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=100,ip,nw_dst=10.1.3.0/24 actions=set_field:172.17.0.3->tun_dst,output:1",
+	},
+	{
+		"kube remote subnet arp",
+		"table=0,cookie=0xac110003,priority=100,arp,nw_dst=10.1.3.0/24,actions=set_field:172.17.0.3->tun_dst,output:1",
+		" cookie=0xac110003, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=100,arp,arp_tpa=10.1.3.0/24 actions=set_field:172.17.0.3->tun_dst,output:1",
+	},
+	{
+		"kube pod ip",
+		"table=0,cookie=0x10,priority=100,ip,nw_dst=10.1.2.5,actions=output:10",
+		" cookie=0x10, duration=5.2s, table=0, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip,nw_dst=10.1.2.5 actions=output:10",
+	},
+	{
+		"kube pod arp",
+		"table=0,cookie=0x10,priority=100,arp,nw_dst=10.1.2.5,actions=output:10",
+		" cookie=0x10, duration=5.2s, table=0, n_packets=0, n_bytes=0, idle_age=5, priority=100,arp,arp_tpa=10.1.2.5 actions=output:10",
+	},
+	{
+		"kube pod arp on older OVS",
+		"table=0,cookie=0x10,priority=100,arp,nw_dst=10.1.2.5,actions=output:10",
+		" cookie=0x10, duration=5.2s, table=0, n_packets=0, n_bytes=0, idle_age=5, priority=100,dl_type=0x0806,arp_tpa=10.1.2.5 actions=output:10",
+	},
+
+	// lbr controller
+	{
+		"lbr local subnet ip",
+		"table=0,cookie=0xac110002,priority=200,ip,in_port=10,nw_dst=10.1.2.0/24,actions=output:9",
+		" cookie=0xac110002, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=200,ip,in_port=10,nw_dst=10.1.2.0/24 actions=output:9",
+	},
+	{
+		"lbr local subnet arp",
+		"table=0,cookie=0xac110002,priority=200,arp,in_port=10,nw_dst=10.1.2.0/24,actions=output:9",
+		" cookie=0xac110002, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=200,arp,in_port=10,arp_tpa=10.1.2.0/24 actions=output:9",
+	},
+	{
+		"lbr remote subnet ip",
+		"table=0,cookie=0xac110003,priority=200,ip,in_port=9,nw_dst=10.1.3.0/24,actions=set_field:172.17.0.3->tun_dst,output:10",
+		" cookie=0xac110003, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=200,ip,in_port=9,nw_dst=10.1.3.0/24 actions=set_field:172.17.0.3->tun_dst,output:10",
+	},
+	{
+		"lbr remote subnet arp",
+		"table=0,cookie=0xac110003,priority=200,arp,in_port=9,nw_dst=10.1.3.0/24,actions=set_field:172.17.0.3->tun_dst,output:10",
+		" cookie=0xac110003, duration=51.29s, table=0, n_packets=0, n_bytes=0, idle_age=51, priority=200,arp,in_port=9,arp_tpa=10.1.3.0/24 actions=set_field:172.17.0.3->tun_dst,output:10",
+	},
+
+	// multitenant setup script
+	{
+		"multitenant learn",
+		"table=0, actions=learn(table=7, priority=200, hard_timeout=900, NXM_OF_ETH_DST[]=NXM_OF_ETH_SRC[], load:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[], output:NXM_OF_IN_PORT[]), goto_table:1",
+		" cookie=0x0, duration=51.29s, table=0, n_packets=30, n_bytes=2520, idle_age=1, actions=learn(table=7,hard_timeout=900,priority=200,NXM_OF_ETH_DST[]=NXM_OF_ETH_SRC[],load:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],output:NXM_OF_IN_PORT[]),goto_table:1",
+	},
+	{
+		"multitenant table 1 arp",
+		"table=1, arp, actions=goto_table:7",
+		" cookie=0x0, duration=51.29s, table=1, n_packets=0, n_bytes=0, idle_age=51, arp actions=goto_table:7",
+	},
+	{
+		"multitenant table 1 vxlan0",
+		"table=1, in_port=1, actions=goto_table:2",
+		" cookie=0x0, duration=51.29s, table=1, n_packets=0, n_bytes=0, idle_age=51, in_port=1 actions=goto_table:2",
+	},
+	{
+		"multitenant table 1 tun0",
+		"table=1, in_port=2, actions=goto_table:4",
+		" cookie=0x0, duration=51.29s, table=1, n_packets=0, n_bytes=0, idle_age=51, in_port=2 actions=goto_table:4",
+	},
+	{
+		"multitenant table 1 vovsbr",
+		"table=1, in_port=9, actions=goto_table:4",
+		" cookie=0x0, duration=51.29s, table=1, n_packets=0, n_bytes=0, idle_age=51, in_port=9 actions=goto_table:4",
+	},
+	{
+		"multitenant table 1 container",
+		"table=1, actions=goto_table:3",
+		" cookie=0x0, duration=51.29s, table=1, n_packets=0, n_bytes=0, idle_age=51, actions=goto_table:3",
+	},
+	{
+		"multitenant table 2 arp",
+		"table=2, arp, actions=goto_table:7",
+		" cookie=0x0, duration=51.29s, table=2, n_packets=0, n_bytes=0, idle_age=51, arp actions=goto_table:7",
+	},
+	{
+		"multitenant table 2 gateway",
+		"table=2, priority=200, ip, nw_dst=10.1.2.1, actions=output:2",
+		" cookie=0x0, duration=51.29s, table=2, n_packets=0, n_bytes=0, idle_age=51, priority=200,ip,nw_dst=10.1.2.1 actions=output:2",
+	},
+	{
+		"multitenant table 2 global",
+		"table=2, tun_id=0, actions=goto_table:4",
+		" cookie=0x0, duration=51.29s, table=2, n_packets=0, n_bytes=0, idle_age=51, tun_id=0 actions=goto_table:4",
+	},
+	{
+		"multitenant table 2 local subnet",
+		"table=2, priority=100, ip, nw_dst=10.1.2.0/24, actions=move:NXM_NX_TUN_ID[0..31]->NXM_NX_REG0[], goto_table:5",
+		" cookie=0x0, duration=51.29s, table=2, n_packets=0, n_bytes=0, idle_age=51, priority=100,ip,nw_dst=10.1.2.0/24 actions=move:NXM_NX_TUN_ID[0..31]->NXM_NX_REG0[],goto_table:5",
+	},
+	{
+		"multitenant table 4 gateway",
+		"table=4, priority=200, ip, nw_dst=10.1.2.1, actions=output:2",
+		" cookie=0x0, duration=51.29s, table=4, n_packets=0, n_bytes=0, idle_age=51, priority=200,ip,nw_dst=10.1.2.1 actions=output:2",
+	},
+	{
+		"multitenant table 4 local subnet",
+		"table=4, priority=150, ip, nw_dst=10.1.2.0/24, actions=goto_table:5",
+		" cookie=0x0, duration=51.29s, table=4, n_packets=0, n_bytes=0, idle_age=51, priority=150,ip,nw_dst=10.1.2.0/24 actions=goto_table:5",
+	},
+	{
+		"multitenant table 4 cluster",
+		"table=4, priority=100, ip, nw_dst=10.1.0.0/16, actions=goto_table:6",
+		" cookie=0x0, duration=51.29s, table=4, n_packets=0, n_bytes=0, idle_age=51, priority=100,ip,nw_dst=10.1.0.0/16 actions=goto_table:6",
+	},
+	{
+		"multitenant table 4 default",
+		"table=4, priority=0, ip, actions=output:2",
+		" cookie=0x0, duration=51.29s, table=4, n_packets=0, n_bytes=0, idle_age=51, priority=0,ip actions=output:2",
+	},
+	{
+		"multitenant table 5 global",
+		"table=5, priority=200, ip, reg0=0, actions=goto_table:7",
+		" cookie=0x0, duration=51.29s, table=5, n_packets=0, n_bytes=0, idle_age=51, priority=200,ip,reg0=0 actions=goto_table:7",
+	},
+	{
+		"multitenant table 7 flood",
+		"table=7, priority=0, arp, actions=flood",
+		" cookie=0x0, duration=51.29s, table=7, n_packets=0, n_bytes=0, idle_age=51, priority=0,arp actions=FLOOD",
+	},
+	{
+		"multitenant learned",
+		"table=7,priority=200,hard_timeout=900,dl_dst=02:42:0a:01:03:02,actions=load:0xac110003->NXM_NX_TUN_IPV4_DST[],output:1",
+		" cookie=0x0, duration=2.1s, table=7, n_packets=4, n_bytes=392, hard_timeout=900, idle_age=0, priority=200,dl_dst=02:42:0a:01:03:02 actions=load:0xac110003->NXM_NX_TUN_IPV4_DST[],output:1",
+	},
+
+	// multitenant controller
+	{
+		"multitenant remote subnet ip",
+		"table=6,cookie=0xac110003,priority=100,ip,nw_dst=10.1.3.0/24,actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1",
+		" cookie=0xac110003, duration=51.29s, table=6, n_packets=0, n_bytes=0, idle_age=51, priority=100,ip,nw_dst=10.1.3.0/24 actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1",
+	},
+	{
+		"multitenant remote subnet arp",
+		"table=7,cookie=0xac110003,priority=100,arp,nw_dst=10.1.3.0/24,actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1",
+		" cookie=0xac110003, duration=51.29s, table=7, n_packets=0, n_bytes=0, idle_age=51, priority=100,arp,arp_tpa=10.1.3.0/24 actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1",
+	},
+
+	// multitenant hook
+	{
+		"multitenant pod ingress",
+		"table=3,cookie=0x3,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+		" cookie=0x3, duration=5.2s, table=3, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip,in_port=3,nw_src=10.1.2.2 actions=load:0xa->NXM_NX_REG0[],goto_table:4",
+	},
+	{
+		"multitenant pod ingress on newer OVS",
+		"table=3,cookie=0x3,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4",
+		" cookie=0x3, duration=5.2s, table=3, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip,in_port=3,nw_src=10.1.2.2 actions=set_field:0xa->reg0,goto_table:4",
+	},
+	{
+		"multitenant pod egress",
+		"table=5,cookie=0x3,priority=100,ip,nw_dst=10.1.2.2,reg0=10,actions=output:3",
+		" cookie=0x3, duration=5.2s, table=5, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip,reg0=0xa,nw_dst=10.1.2.2 actions=output:3",
+	},
+	{
+		"multitenant global pod egress",
+		"table=5,cookie=0x3,priority=150,ip,nw_dst=10.1.2.2,actions=output:3",
+		" cookie=0x3, duration=5.2s, table=5, n_packets=0, n_bytes=0, idle_age=5, priority=150,ip,nw_dst=10.1.2.2 actions=output:3",
+	},
+	{
+		"multitenant network policy",
+		"table=5,cookie=0x3,priority=100,tcp,nw_dst=10.1.2.2,reg0=11,tp_dst=80,actions=load:10->NXM_NX_REG0[],goto_table:8",
+		" cookie=0x3, duration=5.2s, table=5, n_packets=0, n_bytes=0, idle_age=5, priority=100,tcp,reg0=0xb,nw_dst=10.1.2.2,tp_dst=80 actions=load:0xa->NXM_NX_REG0[],goto_table:8",
+	},
+	{
+		"multitenant network policy on newer OVS",
+		"table=5,cookie=0x3,priority=100,udp,nw_src=192.168.0.0/16,nw_dst=10.1.2.2,tp_dst=53,actions=load:10->NXM_NX_REG0[],goto_table:8",
+		" cookie=0x3, duration=5.2s, table=5, n_packets=0, n_bytes=0, idle_age=5, priority=100,udp,nw_src=192.168.0.0/16,nw_dst=10.1.2.2,udp_dst=53 actions=set_field:0xa->reg0,goto_table:8",
+	},
+	{
+		"multitenant local egress IP",
+		"table=10,cookie=0x400000000004005,priority=100,ip,reg0=16389,actions=load:5->NXM_NX_PKT_MARK[0..13],load:1->NXM_NX_PKT_MARK[16..25],load:1->NXM_NX_PKT_MARK[26],output:2",
+		" cookie=0x400000000004005, duration=5.2s, table=10, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip,reg0=0x4005 actions=load:0x5->NXM_NX_PKT_MARK[0..13],load:0x1->NXM_NX_PKT_MARK[16..25],load:0x1->NXM_NX_PKT_MARK[26],output:2",
+	},
+	{
+		"multitenant local egress IP on newer OVS",
+		"table=10,cookie=0x400000000004005,priority=100,ip,reg0=16389,actions=load:5->NXM_NX_PKT_MARK[0..13],load:1->NXM_NX_PKT_MARK[16..25],load:1->NXM_NX_PKT_MARK[26],output:2",
+		" cookie=0x400000000004005, duration=5.2s, table=10, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip,reg0=0x4005 actions=set_field:0x5/0x3fff->pkt_mark,set_field:0x10000/0x3ff0000->pkt_mark,set_field:0x4000000/0x4000000->pkt_mark,output:2",
+	},
+	{
+		"full packet mark on newer OVS",
+		"table=10,priority=100,ip,actions=load:10->NXM_NX_PKT_MARK[],output:2",
+		" cookie=0x0, duration=5.2s, table=10, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip actions=set_field:0xa->pkt_mark,output:2",
+	},
+	{
+		"multitenant remote egress IP",
+		"table=10,cookie=0x40000000000000a,priority=100,ip,reg0=10,actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1",
+		" cookie=0x40000000000000a, duration=5.2s, table=10, n_packets=0, n_bytes=0, idle_age=5, priority=100,ip,reg0=0xa actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.3->tun_dst,output:1",
+	},
+	{
+		"multitenant egress IP from the tunnel",
+		"table=2,cookie=0x40000000000000a,priority=50,ip,tun_id=10,actions=move:NXM_NX_TUN_ID[0..31]->NXM_NX_REG0[],goto_table:10",
+		" cookie=0x40000000000000a, duration=5.2s, table=2, n_packets=0, n_bytes=0, idle_age=5, priority=50,ip,tun_id=0xa actions=move:NXM_NX_TUN_ID[0..31]->NXM_NX_REG0[],goto_table:10",
+	},
+}
+
+func TestInstalledFlowsRoundTrip(t *testing.T) {
+	for _, test := range installedFlows {
+		added, err := ParseFlow(test.added)
+		if err != nil {
+			t.Fatalf("%s: error parsing %q: %v", test.name, test.added, err)
+		}
+		rendered, err := ParseFlow(added.String())
+		if err != nil {
+			t.Fatalf("%s: error parsing rendered %q: %v", test.name, added, err)
+		}
+		if !reflect.DeepEqual(rendered, added) {
+			t.Fatalf("%s: flow changed in a round trip.\nParsed   %#v\nRendered %#v", test.name, added, rendered)
+		}
+
+		dumped, err := ParseFlow(test.dumped)
+		if err != nil {
+			t.Fatalf("%s: error parsing %q: %v", test.name, test.dumped, err)
+		}
+		if !added.Equal(dumped) {
+			t.Fatalf("%s: dumped flow differs.\nAdded  %s\nDumped %s", test.name, added.Canonical(), dumped.Canonical())
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	flow := &Flow{
+		Table:    3,
+		Cookie:   0x3,
+		Priority: 100,
+		Match:    []Field{{"in_port", "3"}, {Name: "ip"}, {"nw_src", "10.1.2.2"}},
+		Actions:  []Action{Load(10, "NXM_NX_REG0[]"), GotoTable(4)},
+	}
+	expected := "table=3,cookie=0x3,priority=100,in_port=3,ip,nw_src=10.1.2.2,actions=load:10->NXM_NX_REG0[],goto_table:4"
+	if flow.String() != expected {
+		t.Fatalf("Wrong rendering.\nExpected %s\nGot      %s", expected, flow)
+	}
+	if match := flow.StrictMatch(); match != "table=3,cookie=0x3/-1,priority=100,in_port=3,ip,nw_src=10.1.2.2" {
+		t.Fatalf("Wrong strict match %s", match)
+	}
+
+	flow = &Flow{Table: 6, Priority: DefaultPriority, Actions: nil}
+	if flow.String() != "table=6,actions=drop" {
+		t.Fatalf("Wrong rendering of a drop flow: %s", flow)
+	}
+	dropped, err := ParseFlow(" cookie=0x0, duration=1s, table=6, n_packets=0, n_bytes=0, actions=drop")
+	if err != nil || !reflect.DeepEqual(dropped, flow) {
+		t.Fatalf("Wrong drop flow: %#v (%v)", dropped, err)
+	}
+}
+
+func TestParseDump(t *testing.T) {
+	dump := "OFPST_FLOW reply (OF1.3) (xid=0x2):\n"
+	for _, test := range installedFlows {
+		dump += test.dumped + "\n"
+	}
+	flows, err := ParseDump(dump)
+	if err != nil {
+		t.Fatalf("Error parsing dump: %v", err)
+	}
+	if len(flows) != len(installedFlows) {
+		t.Fatalf("Expected %d flows, got %d", len(installedFlows), len(flows))
+	}
+	var learned *Flow
+	for i, test := range installedFlows {
+		if test.name == "multitenant learned" {
+			learned = flows[i]
+		}
+	}
+	if learned == nil || learned.Table != 7 || learned.HardTimeout != 900 || learned.Priority != 200 {
+		t.Fatalf("Wrong learned flow: %#v", learned)
+	}
+
+	if _, err := ParseFlow("table=0,priority=100"); err == nil {
+		t.Fatalf("Expected an error for a flow without actions")
+	}
+	if _, err := ParseFlow("table=zero,actions=drop"); err == nil {
+		t.Fatalf("Expected an error for a bad table")
+	}
+}
+
+func TestGeneratedARPReply(t *testing.T) {
+	flow := &Flow{
+		Priority: 250,
+		Match:    []Field{ARP, Eq("arp_op", "1"), Eq("in_port", "9"), Eq("arp_tpa", "10.1.3.0/24")},
+		Actions:  GeneratedARPReply(),
+	}
+	expected := "table=0,priority=250,arp,arp_op=1,in_port=9,arp_tpa=10.1.3.0/24,actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:2->arp_op," +
+		"move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],set_field:02:42:00:00:00:00->arp_sha,move:NXM_OF_ARP_TPA[]->NXM_NX_ARP_SHA[0..31]," +
+		"move:NXM_NX_ARP_SHA[]->NXM_OF_ETH_SRC[],move:NXM_OF_ARP_SPA[]->NXM_NX_REG1[],move:NXM_OF_ARP_TPA[]->NXM_OF_ARP_SPA[]," +
+		"move:NXM_NX_REG1[]->NXM_OF_ARP_TPA[],IN_PORT"
+	if flow.String() != expected {
+		t.Fatalf("Wrong rendering.\nExpected %s\nGot      %s", expected, flow)
+	}
+	dumped, err := ParseFlow(" cookie=0x0, duration=5.2s, table=0, n_packets=0, n_bytes=0, idle_age=5, priority=250,arp,in_port=9,arp_tpa=10.1.3.0/24,arp_op=1 " +
+		"actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:2->arp_op,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],set_field:02:42:00:00:00:00->arp_sha," +
+		"move:NXM_OF_ARP_TPA[]->NXM_NX_ARP_SHA[0..31],move:NXM_NX_ARP_SHA[]->NXM_OF_ETH_SRC[],move:NXM_OF_ARP_SPA[]->NXM_NX_REG1[]," +
+		"move:NXM_OF_ARP_TPA[]->NXM_OF_ARP_SPA[],move:NXM_NX_REG1[]->NXM_OF_ARP_TPA[],IN_PORT")
+	if err != nil {
+		t.Fatalf("Error parsing dumped flow: %v", err)
+	}
+	if !flow.Equal(dumped) {
+		t.Errorf("Flows differ.\nAdded  %s\nDumped %s", flow.Canonical(), dumped.Canonical())
+	}
+}
+
+func TestARPReply(t *testing.T) {
+	flow := &Flow{
+		Table:    7,
+		Priority: 300,
+		Match:    []Field{ARP, Eq("arp_op", "1"), Eq("arp_tpa", "10.1.2.1")},
+		Actions:  ARPReply("10.1.2.1", "02:42:0a:01:02:01"),
+	}
+	expected := "table=7,priority=300,arp,arp_op=1,arp_tpa=10.1.2.1,actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:02:42:0a:01:02:01->eth_src," +
+		"set_field:2->arp_op,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],set_field:02:42:0a:01:02:01->arp_sha,move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[]," +
+		"set_field:10.1.2.1->arp_spa,move:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],IN_PORT"
+	if flow.String() != expected {
+		t.Fatalf("Wrong rendering.\nExpected %s\nGot      %s", expected, flow)
+	}
+	dumped, err := ParseFlow(" cookie=0x0, duration=5.2s, table=7, n_packets=0, n_bytes=0, idle_age=5, priority=300,arp,arp_tpa=10.1.2.1,arp_op=1 " +
+		"actions=move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],set_field:02:42:0a:01:02:01->eth_src,set_field:2->arp_op,move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[]," +
+		"set_field:02:42:0a:01:02:01->arp_sha,move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],set_field:10.1.2.1->arp_spa,move:NXM_NX_TUN_IPV4_SRC[]->NXM_NX_TUN_IPV4_DST[],IN_PORT")
+	if err != nil {
+		t.Fatalf("Error parsing dumped flow: %v", err)
+	}
+	if !flow.Equal(dumped) {
+		t.Errorf("Flows differ.\nAdded  %s\nDumped %s", flow.Canonical(), dumped.Canonical())
+	}
+}
+
+func TestConntrackFlows(t *testing.T) {
+	tests := []struct {
+		added  string
+		dumped string
+	}{
+		{
+			"table=8,cookie=0x600000000000000,priority=300,ip,ct_state=+trk+dnat-rpl,actions=ct(commit,zone=2,nat(src=10.1.2.1),exec(load:1->NXM_NX_CT_MARK[]),table=8)",
+			" cookie=0x600000000000000, duration=5.2s, table=8, n_packets=0, n_bytes=0, idle_age=5, priority=300,ct_state=-rpl+trk+dnat,ip actions=ct(commit,table=8,zone=2,exec(set_field:0x1->ct_mark),nat(src=10.1.2.1))",
+		},
+		{
+			"table=4,cookie=0x600000000000001,priority=300,tcp,nw_dst=172.30.0.10,tp_dst=80,actions=group:1",
+			" cookie=0x600000000000001, duration=5.2s, table=4, n_packets=0, n_bytes=0, idle_age=5, priority=300,tcp,nw_dst=172.30.0.10,tp_dst=80 actions=group:1",
+		},
+	}
+	for _, test := range tests {
+		added, err := ParseFlow(test.added)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %v", test.added, err)
+		}
+		if added.String() != test.added {
+			t.Errorf("Flow does not round-trip.\nExpected %s\nGot      %s", test.added, added)
+		}
+		dumped, err := ParseFlow(test.dumped)
+		if err != nil {
+			t.Fatalf("Error parsing %s: %v", test.dumped, err)
+		}
+		if !added.Equal(dumped) {
+			t.Errorf("Flows differ.\nAdded  %s\nDumped %s", added.Canonical(), dumped.Canonical())
+		}
+	}
+}
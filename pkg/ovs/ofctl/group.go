@@ -0,0 +1,100 @@
+package ofctl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Group is an OpenFlow group of buckets of actions. Buckets have no weights,
+// so a select group spreads flows evenly across them.
+type Group struct {
+	ID      uint32
+	Type    string
+	Buckets [][]Action
+}
+
+// String renders the group in "ovs-ofctl add-group" syntax.
+func (g *Group) String() string {
+	parts := []string{fmt.Sprintf("group_id=%d", g.ID), "type=" + g.Type}
+	for _, bucket := range g.Buckets {
+		actions := make([]string, 0, len(bucket))
+		for _, a := range bucket {
+			actions = append(actions, a.String())
+		}
+		if len(actions) == 0 {
+			actions = append(actions, "drop")
+		}
+		parts = append(parts, "bucket=actions="+strings.Join(actions, ","))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseGroup parses a group in "add-group" syntax or a line of "dump-groups"
+// output. Bucket ids and weights are ignored.
+func ParseGroup(group string) (*Group, error) {
+	g := &Group{}
+	fields := strings.Split(strings.TrimSpace(group), ",bucket=")
+	for _, field := range splitTopLevel(fields[0]) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid field %q in group %q", field, group)
+		}
+		switch kv[0] {
+		case "group_id":
+			id, err := strconv.ParseUint(kv[1], 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid group_id in group %q: %v", group, err)
+			}
+			g.ID = uint32(id)
+		case "type":
+			g.Type = kv[1]
+		}
+	}
+	for _, bucket := range fields[1:] {
+		i := strings.Index(bucket, "actions=")
+		if i < 0 {
+			return nil, fmt.Errorf("No actions in bucket %q of group %q", bucket, group)
+		}
+		g.Buckets = append(g.Buckets, parseActions(bucket[i+len("actions="):]))
+	}
+	return g, nil
+}
+
+// ParseGroupDump parses "ovs-ofctl dump-groups" output, skipping the reply
+// headers.
+func ParseGroupDump(out string) ([]*Group, error) {
+	groups := []*Group{}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, "group_id=") {
+			continue
+		}
+		g, err := ParseGroup(line)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return groups, nil
+}
+
+// Canonical returns a copy of the group in a normal form, like Flow.Canonical,
+// with the buckets sorted as the switch may reorder them.
+func (g *Group) Canonical() *Group {
+	c := *g
+	c.Type = strings.ToLower(g.Type)
+	c.Buckets = make([][]Action, 0, len(g.Buckets))
+	for _, bucket := range g.Buckets {
+		c.Buckets = append(c.Buckets, canonicalActions(bucket))
+	}
+	sort.Slice(c.Buckets, func(i, j int) bool {
+		return fmt.Sprint(c.Buckets[i]) < fmt.Sprint(c.Buckets[j])
+	})
+	return &c
+}
+
+// Equal tells whether two groups are the same group.
+func (g *Group) Equal(other *Group) bool {
+	return g.Canonical().String() == other.Canonical().String()
+}
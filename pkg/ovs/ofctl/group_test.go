@@ -0,0 +1,45 @@
+package ofctl
+
+import (
+	"testing"
+)
+
+func TestGroups(t *testing.T) {
+	added := "group_id=1,type=select,bucket=actions=ct(commit,zone=1,nat(dst=10.1.2.2:8080),table=4),bucket=actions=ct(commit,zone=1,nat(dst=10.1.3.2:8080),table=4)"
+	g, err := ParseGroup(added)
+	if err != nil {
+		t.Fatalf("Error parsing %s: %v", added, err)
+	}
+	if g.ID != 1 || g.Type != "select" || len(g.Buckets) != 2 {
+		t.Fatalf("Wrong group %#v", g)
+	}
+	if g.String() != added {
+		t.Errorf("Group does not round-trip.\nExpected %s\nGot      %s", added, g)
+	}
+
+	dumps := []string{
+		// buckets in another order, with weights as older OVS prints them
+		`OFPST_GROUP_DESC reply (OF1.3) (xid=0x2):
+ group_id=1,type=select,bucket=weight:100,actions=ct(commit,table=4,zone=1,nat(dst=10.1.3.2:8080)),bucket=weight:100,actions=ct(commit,table=4,zone=1,nat(dst=10.1.2.2:8080))
+`,
+		// with bucket ids as newer OVS prints them
+		`OFPST_GROUP_DESC reply (OF1.3) (xid=0x2):
+ group_id=1,type=select,bucket=bucket_id:0,actions=ct(commit,table=4,zone=1,nat(dst=10.1.2.2:8080)),bucket=bucket_id:1,actions=ct(commit,table=4,zone=1,nat(dst=10.1.3.2:8080))
+ group_id=2,type=select
+`,
+	}
+	for _, dump := range dumps {
+		groups, err := ParseGroupDump(dump)
+		if err != nil {
+			t.Fatalf("Error parsing dump: %v", err)
+		}
+		if !g.Equal(groups[0]) {
+			t.Errorf("Groups differ.\nAdded  %s\nDumped %s", g.Canonical(), groups[0].Canonical())
+		}
+	}
+
+	empty := &Group{ID: 2, Type: "select"}
+	if s := empty.String(); s != "group_id=2,type=select" {
+		t.Errorf("Wrong empty group %s", s)
+	}
+}
@@ -0,0 +1,249 @@
+package openflow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Reserved port numbers.
+const (
+	PortInPort     uint32 = 0xfffffff8
+	PortController uint32 = 0xfffffffd
+	PortLocal      uint32 = 0xfffffffe
+	PortAny        uint32 = 0xffffffff
+)
+
+const nxExperimenterID uint32 = 0x00002320
+
+// Action is an OpenFlow action, applied by an ApplyActions instruction.
+type Action interface {
+	marshal() []byte
+	String() string
+}
+
+// Output sends the packet to Port.
+type Output struct {
+	Port uint32
+}
+
+func (a Output) marshal() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint16(buf[0:], 0) // OFPAT_OUTPUT
+	binary.BigEndian.PutUint16(buf[2:], 16)
+	binary.BigEndian.PutUint32(buf[4:], a.Port)
+	binary.BigEndian.PutUint16(buf[8:], 0xffff) // OFPCML_NO_BUFFER
+	return buf
+}
+
+func (a Output) String() string {
+	switch a.Port {
+	case PortInPort:
+		return "output:in_port"
+	case PortLocal:
+		return "output:LOCAL"
+	case PortController:
+		return "output:CONTROLLER"
+	}
+	return fmt.Sprintf("output:%d", a.Port)
+}
+
+// SetField rewrites a header field, e.g. the tunnel destination.
+type SetField struct {
+	MatchField
+}
+
+func (a SetField) marshal() []byte {
+	oxm := a.MatchField.marshal()
+	length := pad8(4 + len(oxm))
+	buf := make([]byte, length)
+	binary.BigEndian.PutUint16(buf[0:], 25) // OFPAT_SET_FIELD
+	binary.BigEndian.PutUint16(buf[2:], uint16(length))
+	copy(buf[4:], oxm)
+	return buf
+}
+
+func (a SetField) String() string {
+	return "set_field:" + a.MatchField.String()
+}
+
+// RegMove copies NBits bits from Src to Dst (Nicira NXAST_REG_MOVE), e.g.
+// move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31].
+type RegMove struct {
+	Src, Dst       Field
+	SrcOfs, DstOfs uint16
+	NBits          uint16
+}
+
+func (a RegMove) marshal() []byte {
+	buf := nxHeader(6, 24) // NXAST_REG_MOVE
+	binary.BigEndian.PutUint16(buf[10:], a.NBits)
+	binary.BigEndian.PutUint16(buf[12:], a.SrcOfs)
+	binary.BigEndian.PutUint16(buf[14:], a.DstOfs)
+	binary.BigEndian.PutUint32(buf[16:], a.Src.header(false))
+	binary.BigEndian.PutUint32(buf[20:], a.Dst.header(false))
+	return buf
+}
+
+func (a RegMove) String() string {
+	return fmt.Sprintf("move:%s[%d..%d]->%s[%d..%d]", a.Src, a.SrcOfs, a.SrcOfs+a.NBits-1, a.Dst, a.DstOfs, a.DstOfs+a.NBits-1)
+}
+
+// RegLoad loads Value into NBits bits of Dst starting at Ofs (Nicira
+// NXAST_REG_LOAD), e.g. load:42->NXM_NX_REG0[].
+type RegLoad struct {
+	Dst   Field
+	Ofs   uint16
+	NBits uint16
+	Value uint64
+}
+
+func (a RegLoad) marshal() []byte {
+	buf := nxHeader(7, 24) // NXAST_REG_LOAD
+	binary.BigEndian.PutUint16(buf[10:], a.Ofs<<6|(a.NBits-1))
+	binary.BigEndian.PutUint32(buf[12:], a.Dst.header(false))
+	binary.BigEndian.PutUint64(buf[16:], a.Value)
+	return buf
+}
+
+func (a RegLoad) String() string {
+	return fmt.Sprintf("load:%d->%s[%d..%d]", a.Value, a.Dst, a.Ofs, a.Ofs+a.NBits-1)
+}
+
+func nxHeader(subtype uint16, length int) []byte {
+	buf := make([]byte, length)
+	binary.BigEndian.PutUint16(buf[0:], 0xffff) // OFPAT_EXPERIMENTER
+	binary.BigEndian.PutUint16(buf[2:], uint16(length))
+	binary.BigEndian.PutUint32(buf[4:], nxExperimenterID)
+	binary.BigEndian.PutUint16(buf[8:], subtype)
+	return buf
+}
+
+func unmarshalActions(data []byte) ([]Action, error) {
+	var actions []Action
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("action too short (%d bytes)", len(data))
+		}
+		t, length := binary.BigEndian.Uint16(data[0:]), int(binary.BigEndian.Uint16(data[2:]))
+		if length < 8 || length%8 != 0 || length > len(data) {
+			return nil, fmt.Errorf("bad action length %d", length)
+		}
+		a, err := unmarshalAction(t, data[:length])
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+		data = data[length:]
+	}
+	return actions, nil
+}
+
+func unmarshalAction(t uint16, data []byte) (Action, error) {
+	switch {
+	case t == 0 && len(data) == 16:
+		return Output{Port: binary.BigEndian.Uint32(data[4:])}, nil
+	case t == 25:
+		f, _, err := unmarshalMatchField(data[4:])
+		if err != nil {
+			return nil, err
+		}
+		return SetField{f}, nil
+	case t == 0xffff && len(data) == 24 && binary.BigEndian.Uint32(data[4:]) == nxExperimenterID:
+		switch binary.BigEndian.Uint16(data[8:]) {
+		case 6:
+			src, _ := fieldFromHeader(binary.BigEndian.Uint32(data[16:]))
+			dst, _ := fieldFromHeader(binary.BigEndian.Uint32(data[20:]))
+			return RegMove{
+				Src:    src,
+				Dst:    dst,
+				NBits:  binary.BigEndian.Uint16(data[10:]),
+				SrcOfs: binary.BigEndian.Uint16(data[12:]),
+				DstOfs: binary.BigEndian.Uint16(data[14:]),
+			}, nil
+		case 7:
+			ofsNBits := binary.BigEndian.Uint16(data[10:])
+			dst, _ := fieldFromHeader(binary.BigEndian.Uint32(data[12:]))
+			return RegLoad{
+				Dst:   dst,
+				Ofs:   ofsNBits >> 6,
+				NBits: ofsNBits&0x3f + 1,
+				Value: binary.BigEndian.Uint64(data[16:]),
+			}, nil
+		}
+	}
+	return nil, &Error{Type: ErrBadAction, Code: 0, Data: data}
+}
+
+// Instruction is an OpenFlow 1.3 flow instruction.
+type Instruction interface {
+	marshal() []byte
+	String() string
+}
+
+// GotoTable continues processing in table TableID.
+type GotoTable struct {
+	TableID uint8
+}
+
+func (i GotoTable) marshal() []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint16(buf[0:], 1) // OFPIT_GOTO_TABLE
+	binary.BigEndian.PutUint16(buf[2:], 8)
+	buf[4] = i.TableID
+	return buf
+}
+
+func (i GotoTable) String() string {
+	return fmt.Sprintf("goto_table:%d", i.TableID)
+}
+
+// ApplyActions applies Actions to the packet immediately.
+type ApplyActions struct {
+	Actions []Action
+}
+
+func (i ApplyActions) marshal() []byte {
+	buf := make([]byte, 8)
+	for _, a := range i.Actions {
+		buf = append(buf, a.marshal()...)
+	}
+	binary.BigEndian.PutUint16(buf[0:], 4) // OFPIT_APPLY_ACTIONS
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)))
+	return buf
+}
+
+func (i ApplyActions) String() string {
+	parts := make([]string, 0, len(i.Actions))
+	for _, a := range i.Actions {
+		parts = append(parts, a.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func unmarshalInstructions(data []byte) ([]Instruction, error) {
+	var instructions []Instruction
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("instruction too short (%d bytes)", len(data))
+		}
+		t, length := binary.BigEndian.Uint16(data[0:]), int(binary.BigEndian.Uint16(data[2:]))
+		if length < 8 || length > len(data) {
+			return nil, fmt.Errorf("bad instruction length %d", length)
+		}
+		switch t {
+		case 1:
+			instructions = append(instructions, GotoTable{TableID: data[4]})
+		case 4:
+			actions, err := unmarshalActions(data[8:length])
+			if err != nil {
+				return nil, err
+			}
+			instructions = append(instructions, ApplyActions{Actions: actions})
+		default:
+			return nil, &Error{Type: ErrBadInstruction, Code: 0, Data: data[:length]}
+		}
+		data = data[length:]
+	}
+	return instructions, nil
+}
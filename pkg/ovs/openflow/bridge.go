@@ -0,0 +1,74 @@
+package openflow
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// Bridge is an OpenFlow connection to a switch that is made on first use and
+// made again after it failed. It is safe for concurrent use.
+type Bridge struct {
+	lock sync.Mutex
+	conn *Conn
+	dial func() (*Conn, error)
+}
+
+// NewBridge returns a Bridge connecting with dial.
+func NewBridge(dial func() (*Conn, error)) *Bridge {
+	return &Bridge{dial: dial}
+}
+
+// NewOVSBridge returns a Bridge connecting to the management socket of an
+// OVS bridge, e.g. br0.
+func NewOVSBridge(bridge string) *Bridge {
+	return NewBridge(func() (*Conn, error) { return DialBridge(bridge) })
+}
+
+// Transact is Conn.Transact over the connection to the switch. A message the
+// switch rejects leaves the connection alone; any other failure drops it, and
+// the next transaction connects again.
+func (b *Bridge) Transact(msgs ...Message) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn == nil {
+		conn, err := b.dial()
+		if err != nil {
+			return fmt.Errorf("Failed to connect to the switch: %v", err)
+		}
+		b.conn = conn
+	}
+	err := b.conn.Transact(msgs...)
+	if _, rejected := err.(*Error); err != nil && !rejected {
+		b.conn.Close()
+		b.conn = nil
+	}
+	return err
+}
+
+// AddFlows installs flows, given the way the flow controllers build flows for
+// ovs-ofctl, in one transaction; see FromFlow.
+func (b *Bridge) AddFlows(flows []*ofctl.Flow) error {
+	mods := []Message{}
+	for _, flow := range flows {
+		mod, err := FromFlow(flow)
+		if err != nil {
+			return err
+		}
+		mods = append(mods, mod)
+	}
+	return b.Transact(mods...)
+}
+
+// Close closes the connection, if any.
+func (b *Bridge) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
@@ -0,0 +1,258 @@
+package openflow
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// DefaultTimeout bounds how long a transaction waits for the switch.
+const DefaultTimeout = 10 * time.Second
+
+// BridgeSocket returns the management socket OVS serves for bridge, which
+// accepts OpenFlow connections without configuring a controller.
+func BridgeSocket(bridge string) string {
+	return "/var/run/openvswitch/" + bridge + ".mgmt"
+}
+
+// Conn is an OpenFlow 1.3 connection to a switch. It answers echo requests
+// from the switch and matches replies and errors to the requests that caused
+// them. It is safe for concurrent use.
+type Conn struct {
+	conn    net.Conn
+	Timeout time.Duration
+
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	xid      uint32
+	requests map[uint32]Message
+	errors   map[uint32]*Error
+	replies  map[uint32]chan Message
+	closed   chan struct{}
+	readErr  error
+}
+
+// DialBridge connects to the management socket of an OVS bridge, e.g. br0.
+func DialBridge(bridge string) (*Conn, error) {
+	return Dial("unix", BridgeSocket(bridge))
+}
+
+// Dial connects to a switch listening on address and negotiates OpenFlow 1.3.
+func Dial(network, address string) (*Conn, error) {
+	c, err := net.DialTimeout(network, address, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := NewConn(c)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// NewConn negotiates OpenFlow 1.3 over an established connection.
+func NewConn(c net.Conn) (*Conn, error) {
+	conn := &Conn{
+		conn:     c,
+		Timeout:  DefaultTimeout,
+		requests: make(map[uint32]Message),
+		errors:   make(map[uint32]*Error),
+		replies:  make(map[uint32]chan Message),
+		closed:   make(chan struct{}),
+	}
+	if err := conn.handshake(); err != nil {
+		return nil, err
+	}
+	go conn.readLoop()
+	return conn, nil
+}
+
+func (c *Conn) handshake() error {
+	c.conn.SetDeadline(time.Now().Add(DefaultTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if err := WriteMessage(c.conn, c.nextXid(), Hello{}); err != nil {
+		return fmt.Errorf("Failed to send OpenFlow hello: %v", err)
+	}
+	for {
+		version, _, msg, err := ReadMessage(c.conn)
+		if err != nil {
+			return fmt.Errorf("Failed to read OpenFlow hello: %v", err)
+		}
+		switch m := msg.(type) {
+		case Hello:
+			if version < Version {
+				e := &Error{Type: ErrHelloFailed, Data: []byte("OpenFlow 1.3 is required")}
+				WriteMessage(c.conn, 0, ErrorMsg{e})
+				return fmt.Errorf("Switch only supports OpenFlow version 0x%02x: %v", version, e)
+			}
+			return nil
+		case ErrorMsg:
+			return m.Err
+		}
+	}
+}
+
+func (c *Conn) nextXid() uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.xid++
+	return c.xid
+}
+
+func (c *Conn) write(xid uint32, msg Message) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return WriteMessage(c.conn, xid, msg)
+}
+
+func (c *Conn) readLoop() {
+	var err error
+	for {
+		var xid uint32
+		var msg Message
+		_, xid, msg, err = ReadMessage(c.conn)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			if _, ok := msg.(*Unknown); ok {
+				log.Warningf("Ignoring malformed OpenFlow message %d: %v", msg.Type(), err)
+				continue
+			}
+			break
+		}
+
+		switch m := msg.(type) {
+		case EchoRequest:
+			if err := c.write(xid, EchoReply{Data: m.Data}); err != nil {
+				log.Warningf("Failed to answer OpenFlow echo request: %v", err)
+			}
+		case ErrorMsg:
+			c.lock.Lock()
+			if req, ok := c.requests[xid]; ok {
+				m.Err.Request = req
+				c.errors[xid] = m.Err
+			} else if ch, ok := c.replies[xid]; ok {
+				delete(c.replies, xid)
+				ch <- m
+			} else {
+				log.Warningf("Unsolicited %v (xid %d)", m.Err, xid)
+			}
+			c.lock.Unlock()
+		default:
+			c.lock.Lock()
+			if ch, ok := c.replies[xid]; ok {
+				delete(c.replies, xid)
+				ch <- msg
+			} else {
+				log.V(5).Infof("Ignoring unsolicited OpenFlow message %d (xid %d)", msg.Type(), xid)
+			}
+			c.lock.Unlock()
+		}
+	}
+
+	c.lock.Lock()
+	if err == nil || err == io.EOF {
+		err = fmt.Errorf("OpenFlow connection closed")
+	}
+	c.readErr = err
+	close(c.closed)
+	c.lock.Unlock()
+}
+
+// request sends msg and waits for the reply with the same xid.
+func (c *Conn) request(msg Message) (Message, error) {
+	ch := make(chan Message, 1)
+	c.lock.Lock()
+	c.xid++
+	xid := c.xid
+	c.replies[xid] = ch
+	c.lock.Unlock()
+
+	if err := c.write(xid, msg); err != nil {
+		c.lock.Lock()
+		delete(c.replies, xid)
+		c.lock.Unlock()
+		return nil, err
+	}
+
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-ch:
+		if e, ok := reply.(ErrorMsg); ok {
+			e.Err.Request = msg
+			return nil, e.Err
+		}
+		return reply, nil
+	case <-c.closed:
+		return nil, c.readErr
+	case <-timer.C:
+		c.lock.Lock()
+		delete(c.replies, xid)
+		c.lock.Unlock()
+		return nil, fmt.Errorf("Timed out waiting for reply to OpenFlow message %d", msg.Type())
+	}
+}
+
+// Echo checks that the switch is responsive.
+func (c *Conn) Echo() error {
+	_, err := c.request(EchoRequest{})
+	return err
+}
+
+// Transact sends msgs followed by a barrier and waits for the switch to
+// process them all. If the switch rejected any of them, the first rejection
+// is returned as an *Error whose Request is the offending message; messages
+// after it may still have been applied.
+func (c *Conn) Transact(msgs ...Message) error {
+	c.lock.Lock()
+	xids := make([]uint32, len(msgs))
+	for i, msg := range msgs {
+		c.xid++
+		xids[i] = c.xid
+		c.requests[c.xid] = msg
+	}
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		for _, xid := range xids {
+			delete(c.requests, xid)
+			delete(c.errors, xid)
+		}
+		c.lock.Unlock()
+	}()
+
+	c.writeLock.Lock()
+	for i, msg := range msgs {
+		if err := WriteMessage(c.conn, xids[i], msg); err != nil {
+			c.writeLock.Unlock()
+			return fmt.Errorf("Failed to send OpenFlow message: %v", err)
+		}
+	}
+	c.writeLock.Unlock()
+
+	if _, err := c.request(BarrierRequest{}); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, xid := range xids {
+		if err, ok := c.errors[xid]; ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,196 @@
+package openflow_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow"
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow/fakeswitch"
+)
+
+func dial(t *testing.T, sw *fakeswitch.Switch) *openflow.Conn {
+	conn, err := sw.Dial()
+	if err != nil {
+		t.Fatalf("Error connecting to fake switch: %v", err)
+	}
+	return conn
+}
+
+func subnet(t *testing.T, cidr string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Error parsing %s: %v", cidr, err)
+	}
+	return ipnet
+}
+
+// nodeFlows are the flows the multitenant controller installs for a remote node.
+func nodeFlows(t *testing.T, cookie uint64, nodeIP net.IP, cidr string) []openflow.Message {
+	tunDst := openflow.SetField{MatchField: openflow.MatchField{Field: openflow.FieldTunIPv4Dst, Value: []byte(nodeIP.To4())}}
+	arp := openflow.NewFlowAdd(6, 100,
+		openflow.Match{openflow.MatchEthType(openflow.EthTypeARP), openflow.MatchArpTPA(subnet(t, cidr))},
+		openflow.ApplyActions{Actions: []openflow.Action{
+			openflow.RegMove{Src: openflow.FieldReg(0), Dst: openflow.FieldTunnelID, NBits: 32},
+			tunDst,
+			openflow.Output{Port: 1},
+		}})
+	ip := openflow.NewFlowAdd(6, 100,
+		openflow.Match{openflow.MatchEthType(openflow.EthTypeIPv4), openflow.MatchIPv4Dst(subnet(t, cidr))},
+		openflow.ApplyActions{Actions: []openflow.Action{
+			openflow.RegMove{Src: openflow.FieldReg(0), Dst: openflow.FieldTunnelID, NBits: 32},
+			tunDst,
+			openflow.Output{Port: 1},
+		}})
+	arp.Cookie, ip.Cookie = cookie, cookie
+	return []openflow.Message{arp, ip}
+}
+
+func TestTransactInstallsAndDeletesFlows(t *testing.T) {
+	sw := fakeswitch.New()
+	conn := dial(t, sw)
+	defer conn.Close()
+
+	if err := conn.Echo(); err != nil {
+		t.Fatalf("Error sending echo: %v", err)
+	}
+
+	flows := append(nodeFlows(t, 0xac110002, net.ParseIP("172.17.0.2"), "10.1.2.0/24"),
+		nodeFlows(t, 0xac110003, net.ParseIP("172.17.0.3"), "10.1.3.0/24")...)
+	if err := conn.Transact(flows...); err != nil {
+		t.Fatalf("Error installing flows: %v", err)
+	}
+	if n := len(sw.Flows()); n != 4 {
+		t.Fatalf("Expected 4 flows, got %d: %v", n, sw.DumpFlows())
+	}
+	if !reflect.DeepEqual(sw.Flows()[0], flows[0]) {
+		t.Fatalf("Installed flow differs.\nExpected %s\nGot      %s", flows[0], sw.Flows()[0])
+	}
+
+	// re-adding an identical flow replaces it
+	if err := conn.Transact(flows[0]); err != nil {
+		t.Fatalf("Error re-adding flow: %v", err)
+	}
+	if n := len(sw.Flows()); n != 4 {
+		t.Fatalf("Expected re-adding a flow to replace it, got %v", sw.DumpFlows())
+	}
+
+	// del-flows br0 cookie=0xac110002/-1
+	if err := conn.Transact(openflow.NewFlowDelete(openflow.TableAll, 0xac110002, 0xffffffff, nil)); err != nil {
+		t.Fatalf("Error deleting flows: %v", err)
+	}
+	for _, f := range sw.Flows() {
+		if f.Cookie != 0xac110003 {
+			t.Fatalf("Expected only the flows of 172.17.0.3 to remain, got %v", sw.DumpFlows())
+		}
+	}
+
+	// del-flows br0 table=6,ip,nw_dst=10.1.3.0/24
+	match := openflow.Match{openflow.MatchEthType(openflow.EthTypeIPv4), openflow.MatchIPv4Dst(subnet(t, "10.1.3.0/24"))}
+	if err := conn.Transact(openflow.NewFlowDelete(6, 0, 0, match)); err != nil {
+		t.Fatalf("Error deleting flows: %v", err)
+	}
+	if n := len(sw.Flows()); n != 1 {
+		t.Fatalf("Expected 1 flow, got %v", sw.DumpFlows())
+	}
+}
+
+func TestTransactReportsStructuredErrors(t *testing.T) {
+	sw := fakeswitch.New()
+	sw.NumTables = 8
+	conn := dial(t, sw)
+	defer conn.Close()
+
+	good := openflow.NewFlowAdd(0, 100, openflow.Match{openflow.MatchInPort(1)}, openflow.GotoTable{TableID: 1})
+	badTable := openflow.NewFlowAdd(9, 100, nil)
+	badPrereq := openflow.NewFlowAdd(1, 100, openflow.Match{openflow.MatchIPv4Dst(subnet(t, "10.1.0.0/16"))})
+	badGoto := openflow.NewFlowAdd(3, 100, nil, openflow.GotoTable{TableID: 2})
+
+	tests := []struct {
+		flow    *openflow.FlowMod
+		errType openflow.ErrorType
+		code    uint16
+	}{
+		{badTable, openflow.ErrFlowModFailed, openflow.FlowModBadTableID},
+		{badPrereq, openflow.ErrBadMatch, openflow.BadMatchBadPrereq},
+		{badGoto, openflow.ErrBadInstruction, openflow.BadInstructionBadTableID},
+	}
+	for _, test := range tests {
+		err := conn.Transact(good, test.flow)
+		e, ok := err.(*openflow.Error)
+		if !ok {
+			t.Fatalf("Expected an *openflow.Error for %s, got %v", test.flow, err)
+		}
+		if e.Type != test.errType || e.Code != test.code {
+			t.Fatalf("Wrong error for %s: %v", test.flow, e)
+		}
+		if e.Request != test.flow {
+			t.Fatalf("Error for %s blamed the wrong request: %v", test.flow, e.Request)
+		}
+	}
+	if n := len(sw.Flows()); n != 1 {
+		t.Fatalf("Expected only the good flow to be installed, got %v", sw.DumpFlows())
+	}
+
+	sw.RejectFlows(func(*openflow.FlowMod) *openflow.Error {
+		return &openflow.Error{Type: openflow.ErrFlowModFailed, Code: openflow.FlowModTableFull}
+	})
+	if err := conn.Transact(good); err == nil || err.Error() != "OpenFlow error OFPET_FLOW_MOD_FAILED/OFPFMFC_TABLE_FULL for flow "+good.String() {
+		t.Fatalf("Expected a table full error, got %v", err)
+	}
+
+	// the connection stays usable after errors
+	if err := conn.Echo(); err != nil {
+		t.Fatalf("Error sending echo after errors: %v", err)
+	}
+}
+
+func TestDialRejectsOldSwitch(t *testing.T) {
+	sw := fakeswitch.New()
+	sw.Version = 0x01
+	if conn, err := sw.Dial(); err == nil {
+		conn.Close()
+		t.Fatalf("Expected connecting to an OpenFlow 1.0 switch to fail")
+	}
+}
+
+func TestBridgeReconnects(t *testing.T) {
+	sw := fakeswitch.New()
+	conns := []*openflow.Conn{}
+	bridge := openflow.NewBridge(func() (*openflow.Conn, error) {
+		conn, err := sw.Dial()
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, err
+	})
+	defer bridge.Close()
+	flows := nodeFlows(t, 0xac110003, net.ParseIP("172.17.0.3"), "10.1.3.0/24")
+
+	if err := bridge.Transact(flows...); err != nil {
+		t.Fatalf("Error installing flows: %v", err)
+	}
+	// a rejected flow keeps the connection
+	sw.RejectFlows(func(*openflow.FlowMod) *openflow.Error {
+		return &openflow.Error{Type: openflow.ErrFlowModFailed, Code: openflow.FlowModTableFull}
+	})
+	if _, ok := bridge.Transact(flows[0]).(*openflow.Error); !ok {
+		t.Fatalf("Expected the flow to be rejected")
+	}
+	sw.RejectFlows(nil)
+	if len(conns) != 1 {
+		t.Fatalf("Expected one connection after a rejection, got %d", len(conns))
+	}
+
+	// a lost connection fails the transaction and is made again for the next
+	conns[0].Close()
+	if err := bridge.Transact(flows...); err == nil {
+		t.Fatalf("Expected an error over a closed connection")
+	}
+	if err := bridge.Transact(flows...); err != nil {
+		t.Fatalf("Error after reconnecting: %v", err)
+	}
+	if len(conns) != 2 || len(sw.Flows()) != 2 {
+		t.Fatalf("Wrong reconnection: %d connections, flows %q", len(conns), sw.DumpFlows())
+	}
+}
@@ -0,0 +1,111 @@
+package openflow
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ErrorType is the type of an OpenFlow error message.
+type ErrorType uint16
+
+const (
+	ErrHelloFailed    ErrorType = 0
+	ErrBadRequest     ErrorType = 1
+	ErrBadAction      ErrorType = 2
+	ErrBadInstruction ErrorType = 3
+	ErrBadMatch       ErrorType = 4
+	ErrFlowModFailed  ErrorType = 5
+)
+
+// Codes of ErrBadMatch errors.
+const (
+	BadMatchBadField  uint16 = 6
+	BadMatchBadPrereq uint16 = 9
+)
+
+// Codes of ErrBadInstruction errors.
+const (
+	BadInstructionBadTableID uint16 = 2
+)
+
+// Codes of ErrFlowModFailed errors.
+const (
+	FlowModTableFull  uint16 = 1
+	FlowModBadTableID uint16 = 2
+	FlowModOverlap    uint16 = 3
+	FlowModBadCommand uint16 = 6
+)
+
+var errorTypeNames = map[ErrorType]string{
+	ErrHelloFailed:    "OFPET_HELLO_FAILED",
+	ErrBadRequest:     "OFPET_BAD_REQUEST",
+	ErrBadAction:      "OFPET_BAD_ACTION",
+	ErrBadInstruction: "OFPET_BAD_INSTRUCTION",
+	ErrBadMatch:       "OFPET_BAD_MATCH",
+	ErrFlowModFailed:  "OFPET_FLOW_MOD_FAILED",
+}
+
+var errorCodeNames = map[ErrorType]map[uint16]string{
+	ErrBadInstruction: {
+		BadInstructionBadTableID: "OFPBIC_BAD_TABLE_ID",
+	},
+	ErrBadMatch: {
+		BadMatchBadField:  "OFPBMC_BAD_FIELD",
+		BadMatchBadPrereq: "OFPBMC_BAD_PREREQ",
+	},
+	ErrFlowModFailed: {
+		FlowModTableFull:  "OFPFMFC_TABLE_FULL",
+		FlowModBadTableID: "OFPFMFC_BAD_TABLE_ID",
+		FlowModOverlap:    "OFPFMFC_OVERLAP",
+		FlowModBadCommand: "OFPFMFC_BAD_COMMAND",
+	},
+}
+
+// Error is an OpenFlow error reported by the switch. Request is the message
+// that caused it, when the connection still knows about it.
+type Error struct {
+	Type    ErrorType
+	Code    uint16
+	Data    []byte
+	Request Message
+}
+
+func (e *Error) Error() string {
+	t, ok := errorTypeNames[e.Type]
+	if !ok {
+		t = fmt.Sprintf("type %d", e.Type)
+	}
+	c, ok := errorCodeNames[e.Type][e.Code]
+	if !ok {
+		c = fmt.Sprintf("code %d", e.Code)
+	}
+	if flow, ok := e.Request.(*FlowMod); ok {
+		return fmt.Sprintf("OpenFlow error %s/%s for flow %s", t, c, flow)
+	}
+	return fmt.Sprintf("OpenFlow error %s/%s", t, c)
+}
+
+// ErrorMsg carries an Error on the wire.
+type ErrorMsg struct {
+	Err *Error
+}
+
+func (ErrorMsg) Type() MessageType { return TypeError }
+
+func (m ErrorMsg) marshalBody() []byte {
+	buf := make([]byte, 4, 4+len(m.Err.Data))
+	binary.BigEndian.PutUint16(buf[0:], uint16(m.Err.Type))
+	binary.BigEndian.PutUint16(buf[2:], m.Err.Code)
+	return append(buf, m.Err.Data...)
+}
+
+func unmarshalError(data []byte) (ErrorMsg, error) {
+	if len(data) < 4 {
+		return ErrorMsg{}, fmt.Errorf("error message too short (%d bytes)", len(data))
+	}
+	return ErrorMsg{&Error{
+		Type: ErrorType(binary.BigEndian.Uint16(data[0:])),
+		Code: binary.BigEndian.Uint16(data[2:]),
+		Data: data[4:],
+	}}, nil
+}
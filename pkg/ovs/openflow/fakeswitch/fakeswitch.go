@@ -0,0 +1,230 @@
+// Package fakeswitch provides an in-process OpenFlow 1.3 switch for testing
+// code that programs br0 through the openflow package.
+package fakeswitch
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/openshift/openshift-sdn/pkg/ovs/openflow"
+)
+
+// Switch keeps a flow table, answers hellos, echoes and barriers, and rejects
+// flow mods the way OVS does for the mistakes the flow controllers could make:
+// unknown tables, backwards goto_table and missing match prerequisites.
+type Switch struct {
+	// Version is the OpenFlow version offered in the hello, 1.3 by default.
+	Version uint8
+	// NumTables is the number of flow tables, 0 to NumTables-1.
+	NumTables uint8
+
+	lock   sync.Mutex
+	flows  []*openflow.FlowMod
+	reject func(*openflow.FlowMod) *openflow.Error
+}
+
+// New returns a switch with an empty flow table.
+func New() *Switch {
+	return &Switch{Version: openflow.Version, NumTables: 254}
+}
+
+// Dial starts serving a new in-process connection and returns the
+// controller side of it.
+func (s *Switch) Dial() (*openflow.Conn, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			s.ServeConn(c)
+		}
+	}()
+	return openflow.Dial("tcp", l.Addr().String())
+}
+
+// Serve serves OpenFlow connections accepted on l until it is closed.
+func (s *Switch) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves a single OpenFlow connection until it is closed.
+func (s *Switch) ServeConn(c net.Conn) {
+	defer c.Close()
+	writeLock := sync.Mutex{}
+	write := func(xid uint32, msg openflow.Message) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		writeMessage(c, s.Version, xid, msg)
+	}
+	go write(0, openflow.Hello{})
+
+	for {
+		_, xid, msg, err := openflow.ReadMessage(c)
+		if err != nil {
+			if _, ok := msg.(*openflow.Unknown); ok {
+				write(xid, openflow.ErrorMsg{Err: &openflow.Error{Type: openflow.ErrBadRequest}})
+				continue
+			}
+			return
+		}
+		switch m := msg.(type) {
+		case openflow.EchoRequest:
+			write(xid, openflow.EchoReply{Data: m.Data})
+		case openflow.BarrierRequest:
+			write(xid, openflow.BarrierReply{})
+		case *openflow.FlowMod:
+			if e := s.flowMod(m); e != nil {
+				write(xid, openflow.ErrorMsg{Err: e})
+			}
+		case *openflow.Unknown:
+			write(xid, openflow.ErrorMsg{Err: &openflow.Error{Type: openflow.ErrBadRequest, Code: 1}})
+		}
+	}
+}
+
+// RejectFlows makes the switch reject every flow mod for which reject returns
+// an error, before any other validation.
+func (s *Switch) RejectFlows(reject func(*openflow.FlowMod) *openflow.Error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reject = reject
+}
+
+// Flows returns the installed flows ordered by table and descending priority.
+func (s *Switch) Flows() []*openflow.FlowMod {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	flows := append([]*openflow.FlowMod(nil), s.flows...)
+	sort.SliceStable(flows, func(i, j int) bool {
+		if flows[i].TableID != flows[j].TableID {
+			return flows[i].TableID < flows[j].TableID
+		}
+		return flows[i].Priority > flows[j].Priority
+	})
+	return flows
+}
+
+// DumpFlows returns the installed flows rendered as strings, in Flows order.
+func (s *Switch) DumpFlows() []string {
+	dump := []string{}
+	for _, f := range s.Flows() {
+		dump = append(dump, f.String())
+	}
+	return dump
+}
+
+func (s *Switch) flowMod(m *openflow.FlowMod) *openflow.Error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.reject != nil {
+		if e := s.reject(m); e != nil {
+			return e
+		}
+	}
+	if m.TableID >= s.NumTables && !(m.TableID == openflow.TableAll && isDelete(m.Command)) {
+		return &openflow.Error{Type: openflow.ErrFlowModFailed, Code: openflow.FlowModBadTableID}
+	}
+	if e := checkPrereqs(m.Match); e != nil {
+		return e
+	}
+
+	switch m.Command {
+	case openflow.FlowAdd:
+		for _, i := range m.Instructions {
+			if g, ok := i.(openflow.GotoTable); ok && (g.TableID <= m.TableID || g.TableID >= s.NumTables) {
+				return &openflow.Error{Type: openflow.ErrBadInstruction, Code: openflow.BadInstructionBadTableID}
+			}
+		}
+		kept := s.flows[:0]
+		for _, f := range s.flows {
+			if !(f.TableID == m.TableID && f.Priority == m.Priority && f.Match.Equal(m.Match)) {
+				kept = append(kept, f)
+			}
+		}
+		s.flows = append(kept, m)
+	case openflow.FlowDelete, openflow.FlowDeleteStrict:
+		kept := s.flows[:0]
+		for _, f := range s.flows {
+			if !deletes(m, f) {
+				kept = append(kept, f)
+			}
+		}
+		s.flows = kept
+	default:
+		return &openflow.Error{Type: openflow.ErrFlowModFailed, Code: openflow.FlowModBadCommand}
+	}
+	return nil
+}
+
+func isDelete(cmd openflow.FlowModCommand) bool {
+	return cmd == openflow.FlowDelete || cmd == openflow.FlowDeleteStrict
+}
+
+func deletes(del, f *openflow.FlowMod) bool {
+	if del.TableID != openflow.TableAll && del.TableID != f.TableID {
+		return false
+	}
+	if f.Cookie&del.CookieMask != del.Cookie&del.CookieMask {
+		return false
+	}
+	if del.Command == openflow.FlowDeleteStrict {
+		return f.Priority == del.Priority && f.Match.Equal(del.Match)
+	}
+	return f.Match.Covers(del.Match)
+}
+
+// checkPrereqs enforces the OXM prerequisites of the L3 fields.
+func checkPrereqs(match openflow.Match) *openflow.Error {
+	ethType := uint16(0)
+	for _, f := range match {
+		if f.Field == openflow.FieldEthType {
+			ethType = uint16(f.Value[0])<<8 | uint16(f.Value[1])
+		}
+	}
+	for _, f := range match {
+		var required uint16
+		switch f.Field {
+		case openflow.FieldIPv4Src, openflow.FieldIPv4Dst:
+			required = openflow.EthTypeIPv4
+		case openflow.FieldArpOp, openflow.FieldArpSPA, openflow.FieldArpTPA, openflow.FieldArpSHA, openflow.FieldArpTHA:
+			required = openflow.EthTypeARP
+		default:
+			continue
+		}
+		if ethType != required {
+			return &openflow.Error{Type: openflow.ErrBadMatch, Code: openflow.BadMatchBadPrereq}
+		}
+	}
+	return nil
+}
+
+// writeMessage writes msg with the switch's version in the header, so that
+// tests can offer an older protocol.
+func writeMessage(c net.Conn, version uint8, xid uint32, msg openflow.Message) error {
+	if version == openflow.Version {
+		return openflow.WriteMessage(c, xid, msg)
+	}
+	buf := &versionWriter{c: c, version: version}
+	return openflow.WriteMessage(buf, xid, msg)
+}
+
+type versionWriter struct {
+	c       net.Conn
+	version uint8
+}
+
+func (w *versionWriter) Write(b []byte) (int, error) {
+	b[0] = w.version
+	return w.c.Write(b)
+}
@@ -0,0 +1,285 @@
+package openflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// OXM classes understood by this package.
+const (
+	ClassNXM0          uint16 = 0x0000
+	ClassNXM1          uint16 = 0x0001
+	ClassOpenFlowBasic uint16 = 0x8000
+)
+
+// Field identifies a match field or register by its OXM/NXM header, without
+// the has-mask bit.
+type Field struct {
+	Class  uint16
+	Field  uint8
+	Length uint8
+	Name   string
+}
+
+// Fields used by the openshift-sdn flow controllers.
+var (
+	FieldInPort     = Field{ClassOpenFlowBasic, 0, 4, "in_port"}
+	FieldEthDst     = Field{ClassOpenFlowBasic, 3, 6, "eth_dst"}
+	FieldEthSrc     = Field{ClassOpenFlowBasic, 4, 6, "eth_src"}
+	FieldEthType    = Field{ClassOpenFlowBasic, 5, 2, "eth_type"}
+	FieldIPv4Src    = Field{ClassOpenFlowBasic, 11, 4, "nw_src"}
+	FieldIPv4Dst    = Field{ClassOpenFlowBasic, 12, 4, "nw_dst"}
+	FieldArpOp      = Field{ClassOpenFlowBasic, 21, 2, "arp_op"}
+	FieldArpSPA     = Field{ClassOpenFlowBasic, 22, 4, "arp_spa"}
+	FieldArpTPA     = Field{ClassOpenFlowBasic, 23, 4, "arp_tpa"}
+	FieldArpSHA     = Field{ClassOpenFlowBasic, 24, 6, "arp_sha"}
+	FieldArpTHA     = Field{ClassOpenFlowBasic, 25, 6, "arp_tha"}
+	FieldTunnelID   = Field{ClassOpenFlowBasic, 38, 8, "tun_id"}
+	FieldTunIPv4Src = Field{ClassNXM1, 31, 4, "tun_src"}
+	FieldTunIPv4Dst = Field{ClassNXM1, 32, 4, "tun_dst"}
+)
+
+var knownFields = []Field{
+	FieldInPort, FieldEthDst, FieldEthSrc, FieldEthType, FieldIPv4Src, FieldIPv4Dst,
+	FieldArpOp, FieldArpSPA, FieldArpTPA, FieldArpSHA, FieldArpTHA, FieldTunnelID,
+	FieldTunIPv4Src, FieldTunIPv4Dst,
+}
+
+// Ethernet types for FieldEthType.
+const (
+	EthTypeIPv4 uint16 = 0x0800
+	EthTypeARP  uint16 = 0x0806
+)
+
+// FieldReg returns the Nicira register field regN (NXM_NX_REGn).
+func FieldReg(n uint8) Field {
+	return Field{ClassNXM1, n, 4, fmt.Sprintf("reg%d", n)}
+}
+
+func (f Field) header(hasMask bool) uint32 {
+	length := uint32(f.Length)
+	h := uint32(f.Class)<<16 | uint32(f.Field)<<9
+	if hasMask {
+		h |= 1 << 8
+		length *= 2
+	}
+	return h | length
+}
+
+func (f Field) String() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return fmt.Sprintf("oxm(0x%04x:%d)", f.Class, f.Field)
+}
+
+func fieldFromHeader(h uint32) (Field, bool) {
+	class, field, hasMask, length := uint16(h>>16), uint8(h>>9&0x7f), h&(1<<8) != 0, uint8(h&0xff)
+	if hasMask {
+		length /= 2
+	}
+	for _, f := range knownFields {
+		if f.Class == class && f.Field == field {
+			return f, hasMask
+		}
+	}
+	if class == ClassNXM1 && field < 8 && length == 4 {
+		return FieldReg(field), hasMask
+	}
+	return Field{Class: class, Field: field, Length: length}, hasMask
+}
+
+// MatchField is a single OXM match entry. A nil Mask matches the value exactly.
+type MatchField struct {
+	Field
+	Value []byte
+	Mask  []byte
+}
+
+func (m MatchField) String() string {
+	switch m.Length {
+	case 4:
+		if m.Field == FieldInPort || m.Class == ClassNXM1 && m.Field.Field < 8 {
+			return fmt.Sprintf("%s=%d", m.Field, binary.BigEndian.Uint32(m.Value))
+		}
+		ip := net.IP(m.Value).String()
+		if m.Mask != nil {
+			ones, _ := net.IPMask(m.Mask).Size()
+			return fmt.Sprintf("%s=%s/%d", m.Field, ip, ones)
+		}
+		return fmt.Sprintf("%s=%s", m.Field, ip)
+	case 6:
+		return fmt.Sprintf("%s=%s", m.Field, net.HardwareAddr(m.Value))
+	case 2:
+		return fmt.Sprintf("%s=0x%04x", m.Field, binary.BigEndian.Uint16(m.Value))
+	case 8:
+		return fmt.Sprintf("%s=0x%x", m.Field, binary.BigEndian.Uint64(m.Value))
+	}
+	return fmt.Sprintf("%s=%x", m.Field, m.Value)
+}
+
+// Match is an OpenFlow 1.3 OXM match.
+type Match []MatchField
+
+// MatchInPort matches packets received on port.
+func MatchInPort(port uint32) MatchField {
+	return MatchField{Field: FieldInPort, Value: be32(port)}
+}
+
+// MatchEthType matches the ethernet type, e.g. EthTypeIPv4.
+func MatchEthType(ethType uint16) MatchField {
+	return MatchField{Field: FieldEthType, Value: be16(ethType)}
+}
+
+// MatchIPv4Src matches the IPv4 source address against a host or subnet.
+func MatchIPv4Src(ipnet *net.IPNet) MatchField {
+	return ipMatch(FieldIPv4Src, ipnet)
+}
+
+// MatchIPv4Dst matches the IPv4 destination address against a host or subnet.
+func MatchIPv4Dst(ipnet *net.IPNet) MatchField {
+	return ipMatch(FieldIPv4Dst, ipnet)
+}
+
+// MatchArpTPA matches the ARP target protocol address against a host or subnet.
+func MatchArpTPA(ipnet *net.IPNet) MatchField {
+	return ipMatch(FieldArpTPA, ipnet)
+}
+
+// MatchTunnelID matches the tunnel key (VNI) of packets received on a tunnel.
+func MatchTunnelID(id uint64) MatchField {
+	return MatchField{Field: FieldTunnelID, Value: be64(id)}
+}
+
+// MatchReg matches the value of Nicira register regN.
+func MatchReg(n uint8, value uint32) MatchField {
+	return MatchField{Field: FieldReg(n), Value: be32(value)}
+}
+
+func ipMatch(f Field, ipnet *net.IPNet) MatchField {
+	m := MatchField{Field: f, Value: []byte(ipnet.IP.To4())}
+	if ones, bits := ipnet.Mask.Size(); ones != bits {
+		m.Mask = []byte(net.IP(ipnet.Mask).To4())
+		if m.Mask == nil {
+			m.Mask = []byte(ipnet.Mask)
+		}
+	}
+	return m
+}
+
+func (m Match) String() string {
+	parts := make([]string, 0, len(m))
+	for _, f := range m {
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// Equal reports whether both matches contain the same fields, in any order.
+func (m Match) Equal(other Match) bool {
+	return len(m) == len(other) && m.Covers(other)
+}
+
+// Covers reports whether every field of other is also matched, with the same
+// value and mask, by m. This is how a non-strict delete selects flows.
+func (m Match) Covers(other Match) bool {
+	for _, o := range other {
+		found := false
+		for _, f := range m {
+			if f.Field == o.Field && bytes.Equal(f.Value, o.Value) && bytes.Equal(f.Mask, o.Mask) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// marshal encodes the match as an ofp_match structure padded to 8 bytes.
+func (m Match) marshal() []byte {
+	oxm := &bytes.Buffer{}
+	for _, f := range m {
+		oxm.Write(f.marshal())
+	}
+	length := 4 + oxm.Len()
+	buf := make([]byte, pad8(length))
+	binary.BigEndian.PutUint16(buf[0:], 1) // OFPMT_OXM
+	binary.BigEndian.PutUint16(buf[2:], uint16(length))
+	copy(buf[4:], oxm.Bytes())
+	return buf
+}
+
+func (f MatchField) marshal() []byte {
+	buf := make([]byte, 4, 4+len(f.Value)+len(f.Mask))
+	binary.BigEndian.PutUint32(buf, f.Field.header(f.Mask != nil))
+	buf = append(buf, f.Value...)
+	return append(buf, f.Mask...)
+}
+
+func unmarshalMatch(data []byte) (Match, int, error) {
+	if len(data) < 4 {
+		return nil, 0, fmt.Errorf("match too short (%d bytes)", len(data))
+	}
+	if t := binary.BigEndian.Uint16(data[0:]); t != 1 {
+		return nil, 0, fmt.Errorf("unsupported match type %d", t)
+	}
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length < 4 || pad8(length) > len(data) {
+		return nil, 0, fmt.Errorf("bad match length %d", length)
+	}
+	var m Match
+	for oxm := data[4:length]; len(oxm) > 0; {
+		f, n, err := unmarshalMatchField(oxm)
+		if err != nil {
+			return nil, 0, err
+		}
+		m = append(m, f)
+		oxm = oxm[n:]
+	}
+	return m, pad8(length), nil
+}
+
+func unmarshalMatchField(data []byte) (MatchField, int, error) {
+	if len(data) < 4 {
+		return MatchField{}, 0, fmt.Errorf("OXM field too short (%d bytes)", len(data))
+	}
+	h := binary.BigEndian.Uint32(data)
+	field, hasMask := fieldFromHeader(h)
+	n := 4 + int(h&0xff)
+	if n > len(data) {
+		return MatchField{}, 0, fmt.Errorf("OXM field %s overruns match", field)
+	}
+	f := MatchField{Field: field, Value: append([]byte(nil), data[4:4+int(field.Length)]...)}
+	if hasMask {
+		f.Mask = append([]byte(nil), data[4+int(field.Length):n]...)
+	}
+	return f, n, nil
+}
+
+func pad8(n int) int {
+	return (n + 7) / 8 * 8
+}
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
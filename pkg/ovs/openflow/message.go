@@ -0,0 +1,253 @@
+package openflow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Version is the OpenFlow protocol version spoken by this package (1.3).
+const Version uint8 = 0x04
+
+// MessageType is the type field of the OpenFlow header.
+type MessageType uint8
+
+const (
+	TypeHello          MessageType = 0
+	TypeError          MessageType = 1
+	TypeEchoRequest    MessageType = 2
+	TypeEchoReply      MessageType = 3
+	TypeFlowMod        MessageType = 14
+	TypeBarrierRequest MessageType = 20
+	TypeBarrierReply   MessageType = 21
+)
+
+const headerLen = 8
+
+// Message is an OpenFlow message body.
+type Message interface {
+	Type() MessageType
+	marshalBody() []byte
+}
+
+// Hello starts version negotiation.
+type Hello struct{}
+
+func (Hello) Type() MessageType   { return TypeHello }
+func (Hello) marshalBody() []byte { return nil }
+
+// EchoRequest is a keepalive; the peer answers with an EchoReply carrying Data.
+type EchoRequest struct {
+	Data []byte
+}
+
+func (EchoRequest) Type() MessageType     { return TypeEchoRequest }
+func (m EchoRequest) marshalBody() []byte { return m.Data }
+
+// EchoReply answers an EchoRequest.
+type EchoReply struct {
+	Data []byte
+}
+
+func (EchoReply) Type() MessageType     { return TypeEchoReply }
+func (m EchoReply) marshalBody() []byte { return m.Data }
+
+// BarrierRequest asks the switch to finish processing all earlier messages.
+type BarrierRequest struct{}
+
+func (BarrierRequest) Type() MessageType   { return TypeBarrierRequest }
+func (BarrierRequest) marshalBody() []byte { return nil }
+
+// BarrierReply answers a BarrierRequest.
+type BarrierReply struct{}
+
+func (BarrierReply) Type() MessageType   { return TypeBarrierReply }
+func (BarrierReply) marshalBody() []byte { return nil }
+
+// FlowModCommand is the command of a FlowMod.
+type FlowModCommand uint8
+
+const (
+	FlowAdd          FlowModCommand = 0
+	FlowModify       FlowModCommand = 1
+	FlowModifyStrict FlowModCommand = 2
+	FlowDelete       FlowModCommand = 3
+	FlowDeleteStrict FlowModCommand = 4
+)
+
+// TableAll selects every table in a FlowDelete.
+const TableAll uint8 = 0xff
+
+// FlowMod adds, modifies or deletes flows.
+type FlowMod struct {
+	Cookie       uint64
+	CookieMask   uint64
+	TableID      uint8
+	Command      FlowModCommand
+	IdleTimeout  uint16
+	HardTimeout  uint16
+	Priority     uint16
+	OutPort      uint32
+	OutGroup     uint32
+	Flags        uint16
+	Match        Match
+	Instructions []Instruction
+}
+
+// NewFlowAdd returns a FlowMod adding a flow to table with the given priority,
+// match and instructions.
+func NewFlowAdd(table uint8, priority uint16, match Match, instructions ...Instruction) *FlowMod {
+	return &FlowMod{
+		TableID:      table,
+		Command:      FlowAdd,
+		Priority:     priority,
+		OutPort:      PortAny,
+		OutGroup:     PortAny,
+		Match:        match,
+		Instructions: instructions,
+	}
+}
+
+// NewFlowDelete returns a FlowMod deleting every flow in table (or TableAll)
+// whose match includes match and whose cookie equals cookie under cookieMask,
+// like "ovs-ofctl del-flows br0 table=N,cookie=C/M,<match>".
+func NewFlowDelete(table uint8, cookie, cookieMask uint64, match Match) *FlowMod {
+	return &FlowMod{
+		Cookie:     cookie,
+		CookieMask: cookieMask,
+		TableID:    table,
+		Command:    FlowDelete,
+		OutPort:    PortAny,
+		OutGroup:   PortAny,
+		Match:      match,
+	}
+}
+
+func (*FlowMod) Type() MessageType { return TypeFlowMod }
+
+func (m *FlowMod) marshalBody() []byte {
+	buf := make([]byte, 40)
+	binary.BigEndian.PutUint64(buf[0:], m.Cookie)
+	binary.BigEndian.PutUint64(buf[8:], m.CookieMask)
+	buf[16] = m.TableID
+	buf[17] = uint8(m.Command)
+	binary.BigEndian.PutUint16(buf[18:], m.IdleTimeout)
+	binary.BigEndian.PutUint16(buf[20:], m.HardTimeout)
+	binary.BigEndian.PutUint16(buf[22:], m.Priority)
+	binary.BigEndian.PutUint32(buf[24:], 0xffffffff) // OFP_NO_BUFFER
+	binary.BigEndian.PutUint32(buf[28:], m.OutPort)
+	binary.BigEndian.PutUint32(buf[32:], m.OutGroup)
+	binary.BigEndian.PutUint16(buf[36:], m.Flags)
+	buf = append(buf, m.Match.marshal()...)
+	for _, i := range m.Instructions {
+		buf = append(buf, i.marshal()...)
+	}
+	return buf
+}
+
+// String renders the flow roughly the way ovs-ofctl dump-flows does.
+func (m *FlowMod) String() string {
+	parts := []string{fmt.Sprintf("cookie=0x%x", m.Cookie), fmt.Sprintf("table=%d", m.TableID), fmt.Sprintf("priority=%d", m.Priority)}
+	if len(m.Match) > 0 {
+		parts = append(parts, m.Match.String())
+	}
+	actions := make([]string, 0, len(m.Instructions))
+	for _, i := range m.Instructions {
+		actions = append(actions, i.String())
+	}
+	return strings.Join(parts, ",") + " actions=" + strings.Join(actions, ",")
+}
+
+func unmarshalFlowMod(data []byte) (*FlowMod, error) {
+	if len(data) < 40 {
+		return nil, fmt.Errorf("flow_mod too short (%d bytes)", len(data))
+	}
+	m := &FlowMod{
+		Cookie:      binary.BigEndian.Uint64(data[0:]),
+		CookieMask:  binary.BigEndian.Uint64(data[8:]),
+		TableID:     data[16],
+		Command:     FlowModCommand(data[17]),
+		IdleTimeout: binary.BigEndian.Uint16(data[18:]),
+		HardTimeout: binary.BigEndian.Uint16(data[20:]),
+		Priority:    binary.BigEndian.Uint16(data[22:]),
+		OutPort:     binary.BigEndian.Uint32(data[28:]),
+		OutGroup:    binary.BigEndian.Uint32(data[32:]),
+		Flags:       binary.BigEndian.Uint16(data[36:]),
+	}
+	match, n, err := unmarshalMatch(data[40:])
+	if err != nil {
+		return nil, err
+	}
+	m.Match = match
+	if m.Instructions, err = unmarshalInstructions(data[40+n:]); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WriteMessage writes msg to w with transaction id xid.
+func WriteMessage(w io.Writer, xid uint32, msg Message) error {
+	body := msg.marshalBody()
+	buf := make([]byte, headerLen, headerLen+len(body))
+	buf[0] = Version
+	buf[1] = uint8(msg.Type())
+	binary.BigEndian.PutUint16(buf[2:], uint16(headerLen+len(body)))
+	binary.BigEndian.PutUint32(buf[4:], xid)
+	_, err := w.Write(append(buf, body...))
+	return err
+}
+
+// ReadMessage reads the next message from r and returns its version,
+// transaction id and body. Messages of types this package does not decode are
+// returned as *Unknown.
+func ReadMessage(r io.Reader) (uint8, uint32, Message, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, nil, err
+	}
+	version, t, xid := header[0], MessageType(header[1]), binary.BigEndian.Uint32(header[4:])
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < headerLen {
+		return 0, 0, nil, fmt.Errorf("bad OpenFlow message length %d", length)
+	}
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+
+	var msg Message
+	var err error
+	switch t {
+	case TypeHello:
+		msg = Hello{}
+	case TypeError:
+		msg, err = unmarshalError(body)
+	case TypeEchoRequest:
+		msg = EchoRequest{Data: body}
+	case TypeEchoReply:
+		msg = EchoReply{Data: body}
+	case TypeBarrierRequest:
+		msg = BarrierRequest{}
+	case TypeBarrierReply:
+		msg = BarrierReply{}
+	case TypeFlowMod:
+		msg, err = unmarshalFlowMod(body)
+	default:
+		msg = &Unknown{MsgType: t, Body: body}
+	}
+	if err != nil {
+		// report the malformed message to the caller without losing the stream
+		return version, xid, &Unknown{MsgType: t, Body: body}, err
+	}
+	return version, xid, msg, nil
+}
+
+// Unknown is a message type this package does not decode.
+type Unknown struct {
+	MsgType MessageType
+	Body    []byte
+}
+
+func (m *Unknown) Type() MessageType   { return m.MsgType }
+func (m *Unknown) marshalBody() []byte { return m.Body }
@@ -0,0 +1,103 @@
+package openflow
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Error parsing %s: %v", cidr, err)
+	}
+	return ipnet
+}
+
+func TestFlowModRoundTrip(t *testing.T) {
+	flows := []*FlowMod{
+		// table=0,priority=100,in_port=1,ip,nw_src=10.1.0.0/16 actions=move:NXM_NX_REG0[]->NXM_NX_TUN_ID[0..31],set_field:172.17.0.2->tun_dst,output:1
+		NewFlowAdd(0, 100,
+			Match{MatchInPort(1), MatchEthType(EthTypeIPv4), MatchIPv4Src(mustParseCIDR(t, "10.1.0.0/16"))},
+			ApplyActions{Actions: []Action{
+				RegMove{Src: FieldReg(0), Dst: FieldTunnelID, NBits: 32},
+				SetField{MatchField{Field: FieldTunIPv4Dst, Value: []byte{172, 17, 0, 2}}},
+				Output{Port: 1},
+			}}),
+		// table=3,priority=100,arp,arp_tpa=10.1.2.3 actions=load:42->NXM_NX_REG0[],goto_table:5
+		NewFlowAdd(3, 100,
+			Match{MatchEthType(EthTypeARP), MatchArpTPA(mustParseCIDR(t, "10.1.2.3/32"))},
+			ApplyActions{Actions: []Action{RegLoad{Dst: FieldReg(0), NBits: 32, Value: 42}}},
+			GotoTable{TableID: 5}),
+		NewFlowDelete(TableAll, 0x0a000001, 0xffffffff, Match{MatchTunnelID(7)}),
+	}
+	flows[0].Cookie = 0xac110002
+
+	for _, flow := range flows {
+		buf := &bytes.Buffer{}
+		if err := WriteMessage(buf, 42, flow); err != nil {
+			t.Fatalf("Error writing %s: %v", flow, err)
+		}
+		if buf.Len()%8 != 0 {
+			t.Fatalf("Flow mod %s is not 8 byte aligned (%d bytes)", flow, buf.Len())
+		}
+		version, xid, msg, err := ReadMessage(buf)
+		if err != nil {
+			t.Fatalf("Error reading %s: %v", flow, err)
+		}
+		if version != Version || xid != 42 {
+			t.Fatalf("Wrong header for %s: version %d, xid %d", flow, version, xid)
+		}
+		if !reflect.DeepEqual(msg, flow) {
+			t.Fatalf("Flow mod did not round trip.\nExpected %s\nGot      %s", flow, msg)
+		}
+	}
+}
+
+func TestFlowModString(t *testing.T) {
+	flow := NewFlowAdd(1, 100,
+		Match{MatchEthType(EthTypeIPv4), MatchIPv4Dst(mustParseCIDR(t, "10.1.2.0/24")), MatchReg(0, 10)},
+		ApplyActions{Actions: []Action{RegLoad{Dst: FieldReg(1), NBits: 32, Value: 3}, Output{Port: PortLocal}}},
+		GotoTable{TableID: 2})
+	expected := "cookie=0x0,table=1,priority=100,eth_type=0x0800,nw_dst=10.1.2.0/24,reg0=10 actions=load:3->reg1[0..31],output:LOCAL,goto_table:2"
+	if flow.String() != expected {
+		t.Fatalf("Wrong flow string.\nExpected %s\nGot      %s", expected, flow)
+	}
+}
+
+func TestMatchCovers(t *testing.T) {
+	flow := Match{MatchInPort(3), MatchEthType(EthTypeIPv4), MatchIPv4Dst(mustParseCIDR(t, "10.1.2.3/32"))}
+	if !flow.Covers(Match{MatchIPv4Dst(mustParseCIDR(t, "10.1.2.3/32"))}) {
+		t.Fatalf("Expected %s to cover its own nw_dst", flow)
+	}
+	if !flow.Covers(Match{}) {
+		t.Fatalf("Expected %s to cover the empty match", flow)
+	}
+	if flow.Covers(Match{MatchInPort(4)}) {
+		t.Fatalf("Expected %s not to cover in_port=4", flow)
+	}
+	reordered := Match{flow[2], flow[0], flow[1]}
+	if !flow.Equal(reordered) || flow.Equal(flow[:2]) {
+		t.Fatalf("Wrong match equality for %s", flow)
+	}
+}
+
+func TestReadErrorMessage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	e := &Error{Type: ErrFlowModFailed, Code: FlowModBadTableID, Data: []byte{1, 2, 3}}
+	if err := WriteMessage(buf, 7, ErrorMsg{e}); err != nil {
+		t.Fatalf("Error writing error message: %v", err)
+	}
+	_, xid, msg, err := ReadMessage(buf)
+	if err != nil || xid != 7 {
+		t.Fatalf("Error reading error message: %v (xid %d)", err, xid)
+	}
+	got, ok := msg.(ErrorMsg)
+	if !ok || !reflect.DeepEqual(got.Err, e) {
+		t.Fatalf("Wrong error message: %#v", msg)
+	}
+	if got.Err.Error() != "OpenFlow error OFPET_FLOW_MOD_FAILED/OFPFMFC_BAD_TABLE_ID" {
+		t.Fatalf("Wrong error string: %s", got.Err)
+	}
+}
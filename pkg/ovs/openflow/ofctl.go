@@ -0,0 +1,268 @@
+package openflow
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+// a field in move and load actions, e.g. NXM_NX_REG0[0..23]
+var nxmRange = regexp.MustCompile(`^([A-Z0-9_]+)\[(?:([0-9]+)\.\.([0-9]+))?\]$`)
+
+// the fields of move and load actions FromFlow knows besides the registers
+var nxmFields = map[string]Field{
+	"NXM_OF_ETH_DST":      FieldEthDst,
+	"NXM_OF_ETH_SRC":      FieldEthSrc,
+	"NXM_OF_ARP_SPA":      FieldArpSPA,
+	"NXM_OF_ARP_TPA":      FieldArpTPA,
+	"NXM_NX_ARP_SHA":      FieldArpSHA,
+	"NXM_NX_ARP_THA":      FieldArpTHA,
+	"NXM_NX_TUN_ID":       FieldTunnelID,
+	"NXM_NX_TUN_IPV4_SRC": FieldTunIPv4Src,
+	"NXM_NX_TUN_IPV4_DST": FieldTunIPv4Dst,
+}
+
+// the fields of set_field actions FromFlow knows
+var setFields = map[string]Field{
+	"eth_dst": FieldEthDst,
+	"eth_src": FieldEthSrc,
+	"arp_op":  FieldArpOp,
+	"arp_spa": FieldArpSPA,
+	"arp_tpa": FieldArpTPA,
+	"arp_sha": FieldArpSHA,
+	"arp_tha": FieldArpTHA,
+	"tun_dst": FieldTunIPv4Dst,
+}
+
+// FromFlow returns the FlowMod adding flow, given the way the flow
+// controllers build flows for ovs-ofctl. It knows the matches and actions of
+// the flows the controllers add for nodes; others are an error.
+func FromFlow(flow *ofctl.Flow) (*FlowMod, error) {
+	if flow.Table < 0 || flow.Table >= int(TableAll) || flow.Priority < 0 || flow.Priority > 0xffff {
+		return nil, fmt.Errorf("Invalid table or priority in flow %s", flow)
+	}
+	match, err := fromMatch(flow.Match)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to translate flow %s: %v", flow, err)
+	}
+	actions := []Action{}
+	instructions := []Instruction{}
+	for _, a := range flow.Actions {
+		if a.Name == "goto_table" {
+			table, err := strconv.ParseUint(a.Arg, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to translate flow %s: invalid table %q", flow, a.Arg)
+			}
+			instructions = append(instructions, GotoTable{TableID: uint8(table)})
+			continue
+		}
+		action, err := fromAction(a)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to translate flow %s: %v", flow, err)
+		}
+		actions = append(actions, action)
+	}
+	if len(actions) > 0 {
+		instructions = append([]Instruction{ApplyActions{Actions: actions}}, instructions...)
+	}
+	mod := NewFlowAdd(uint8(flow.Table), uint16(flow.Priority), match, instructions...)
+	mod.Cookie = flow.Cookie
+	mod.IdleTimeout = uint16(flow.IdleTimeout)
+	mod.HardTimeout = uint16(flow.HardTimeout)
+	return mod, nil
+}
+
+func fromMatch(fields []ofctl.Field) (Match, error) {
+	arp := false
+	for _, f := range fields {
+		if f.Name == "arp" {
+			arp = true
+		}
+	}
+	match := Match{}
+	for _, f := range fields {
+		name := f.Name
+		if arp && name == "nw_src" {
+			name = "arp_spa"
+		} else if arp && name == "nw_dst" {
+			name = "arp_tpa"
+		}
+		switch name {
+		case "ip":
+			match = append(match, MatchEthType(EthTypeIPv4))
+		case "arp":
+			match = append(match, MatchEthType(EthTypeARP))
+		case "in_port":
+			port, err := strconv.ParseUint(f.Value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q", f.Value)
+			}
+			match = append(match, MatchInPort(uint32(port)))
+		case "nw_src", "nw_dst", "arp_spa", "arp_tpa":
+			ipnet, err := parseIPNet(f.Value)
+			if err != nil {
+				return nil, err
+			}
+			field := map[string]Field{"nw_src": FieldIPv4Src, "nw_dst": FieldIPv4Dst, "arp_spa": FieldArpSPA, "arp_tpa": FieldArpTPA}[name]
+			match = append(match, ipMatch(field, ipnet))
+		case "arp_op":
+			op, err := strconv.ParseUint(f.Value, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ARP opcode %q", f.Value)
+			}
+			match = append(match, MatchField{Field: FieldArpOp, Value: be16(uint16(op))})
+		case "tun_id":
+			id, err := strconv.ParseUint(f.Value, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tunnel ID %q", f.Value)
+			}
+			match = append(match, MatchTunnelID(id))
+		default:
+			n, err := regNumber(name)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported match field %s", f)
+			}
+			value, err := strconv.ParseUint(f.Value, 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s", f)
+			}
+			match = append(match, MatchReg(n, uint32(value)))
+		}
+	}
+	return match, nil
+}
+
+func fromAction(a ofctl.Action) (Action, error) {
+	switch a.Name {
+	case "output":
+		port, err := strconv.ParseUint(a.Arg, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", a.Arg)
+		}
+		return Output{Port: uint32(port)}, nil
+	case "IN_PORT":
+		return Output{Port: PortInPort}, nil
+	case "set_field":
+		parts := strings.SplitN(a.Arg, "->", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid action %s", a)
+		}
+		field, ok := setFields[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported action %s", a)
+		}
+		value, err := parseValue(field, parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in %s", a)
+		}
+		return SetField{MatchField{Field: field, Value: value}}, nil
+	case "move":
+		parts := strings.SplitN(a.Arg, "->", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid action %s", a)
+		}
+		src, srcOfs, srcBits, err := parseNXMRange(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		dst, dstOfs, dstBits, err := parseNXMRange(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if srcBits != dstBits {
+			return nil, fmt.Errorf("different widths in %s", a)
+		}
+		return RegMove{Src: src, Dst: dst, SrcOfs: srcOfs, DstOfs: dstOfs, NBits: srcBits}, nil
+	case "load":
+		parts := strings.SplitN(a.Arg, "->", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid action %s", a)
+		}
+		value, err := strconv.ParseUint(parts[0], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in %s", a)
+		}
+		dst, ofs, nbits, err := parseNXMRange(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return RegLoad{Dst: dst, Ofs: ofs, NBits: nbits, Value: value}, nil
+	}
+	return nil, fmt.Errorf("unsupported action %s", a)
+}
+
+// parseIPNet parses an address or a subnet.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		s += "/32"
+	}
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 address %q", s)
+	}
+	ipnet.IP = ip.To4().Mask(ipnet.Mask)
+	return ipnet, nil
+}
+
+// parseValue parses the value of field in a set_field action: an IP address,
+// a MAC address or a number, depending on the width of the field.
+func parseValue(field Field, s string) ([]byte, error) {
+	switch field.Length {
+	case 4:
+		if ip := net.ParseIP(s).To4(); ip != nil {
+			return []byte(ip), nil
+		}
+	case 6:
+		if mac, err := net.ParseMAC(s); err == nil && len(mac) == 6 {
+			return []byte(mac), nil
+		}
+	case 2:
+		if n, err := strconv.ParseUint(s, 0, 16); err == nil {
+			return be16(uint16(n)), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid value %q of %s", s, field)
+}
+
+// regNumber returns n of register regN.
+func regNumber(name string) (uint8, error) {
+	if !strings.HasPrefix(name, "reg") {
+		return 0, fmt.Errorf("not a register: %s", name)
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(name, "reg"), 10, 8)
+	if err != nil || n > 7 {
+		return 0, fmt.Errorf("not a register: %s", name)
+	}
+	return uint8(n), nil
+}
+
+// parseNXMRange parses a field of a move or load action, e.g. NXM_NX_REG0[]
+// or NXM_NX_TUN_ID[0..23].
+func parseNXMRange(s string) (Field, uint16, uint16, error) {
+	m := nxmRange.FindStringSubmatch(s)
+	if m == nil {
+		return Field{}, 0, 0, fmt.Errorf("invalid field %q", s)
+	}
+	field, ok := nxmFields[m[1]]
+	if !ok {
+		n, err := regNumber(strings.ToLower(strings.TrimPrefix(m[1], "NXM_NX_")))
+		if err != nil {
+			return Field{}, 0, 0, fmt.Errorf("unsupported field %q", s)
+		}
+		field = FieldReg(n)
+	}
+	width := uint16(field.Length) * 8
+	if m[2] == "" {
+		return field, 0, width, nil
+	}
+	start, _ := strconv.ParseUint(m[2], 10, 16)
+	end, _ := strconv.ParseUint(m[3], 10, 16)
+	if start > end || end >= uint64(width) {
+		return Field{}, 0, 0, fmt.Errorf("invalid bits of field %q", s)
+	}
+	return field, uint16(start), uint16(end-start) + 1, nil
+}
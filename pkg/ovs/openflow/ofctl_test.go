@@ -0,0 +1,78 @@
+package openflow
+
+import (
+	"testing"
+
+	"github.com/openshift/openshift-sdn/pkg/ovs/ofctl"
+)
+
+func TestFromFlow(t *testing.T) {
+	tests := []struct {
+		flow     *ofctl.Flow
+		expected string
+	}{
+		{
+			flow: &ofctl.Flow{Table: 6, Cookie: 0x2000000ac110003, Priority: 100, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", "10.1.2.0/24")},
+				Actions: []ofctl.Action{ofctl.Move("NXM_NX_REG0[0..23]", "NXM_NX_TUN_ID[0..23]"), ofctl.SetField("172.17.0.3", "tun_dst"), ofctl.Output(1)}},
+			expected: "cookie=0x2000000ac110003,table=6,priority=100,eth_type=0x0800,nw_dst=10.1.2.0/24 actions=move:reg0[0..23]->tun_id[0..23],set_field:tun_dst=172.17.0.3,output:1",
+		},
+		{
+			// nw_dst of ARP is the target address
+			flow: &ofctl.Flow{Table: 7, Priority: 100, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("nw_dst", "10.1.2.0/24")},
+				Actions: []ofctl.Action{ofctl.Move("NXM_NX_REG0[]", "NXM_NX_TUN_ID[0..31]"), ofctl.Output(1)}},
+			expected: "cookie=0x0,table=7,priority=100,eth_type=0x0806,arp_tpa=10.1.2.0/24 actions=move:reg0[0..31]->tun_id[0..31],output:1",
+		},
+		{
+			flow:     &ofctl.Flow{Table: 4, Priority: 50, Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", "172.17.0.3")}, Actions: []ofctl.Action{ofctl.Output(2)}},
+			expected: "cookie=0x0,table=4,priority=50,eth_type=0x0800,nw_dst=172.17.0.3 actions=output:2",
+		},
+		{
+			flow: &ofctl.Flow{Table: 3, Priority: 100, Match: []ofctl.Field{ofctl.Eq("in_port", "3"), ofctl.IP, ofctl.Eq("reg1", "0x7")},
+				Actions: []ofctl.Action{ofctl.Load(10, "NXM_NX_REG0[]"), ofctl.GotoTable(4)}},
+			expected: "cookie=0x0,table=3,priority=100,in_port=3,eth_type=0x0800,reg1=7 actions=load:10->reg0[0..31],goto_table:4",
+		},
+		{
+			flow: &ofctl.Flow{Priority: 150, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("arp_tpa", "10.1.2.2")},
+				Actions: ofctl.ARPReply("10.1.2.2", "0a:58:0a:01:02:02")},
+			expected: "cookie=0x0,table=0,priority=150,eth_type=0x0806,arp_op=0x0001,arp_tpa=10.1.2.2 actions=move:eth_src[0..47]->eth_dst[0..47]," +
+				"set_field:eth_src=0a:58:0a:01:02:02,set_field:arp_op=0x0002,move:arp_sha[0..47]->arp_tha[0..47],set_field:arp_sha=0a:58:0a:01:02:02," +
+				"move:arp_spa[0..31]->arp_tpa[0..31],set_field:arp_spa=10.1.2.2,move:tun_src[0..31]->tun_dst[0..31],output:in_port",
+		},
+		{
+			flow: &ofctl.Flow{Priority: 250, Match: []ofctl.Field{ofctl.ARP, ofctl.Eq("arp_op", "1"), ofctl.Eq("in_port", "9"), ofctl.Eq("arp_tpa", "10.1.3.0/24")},
+				Actions: ofctl.GeneratedARPReply()},
+			expected: "cookie=0x0,table=0,priority=250,eth_type=0x0806,arp_op=0x0001,in_port=9,arp_tpa=10.1.3.0/24 actions=move:eth_src[0..47]->eth_dst[0..47]," +
+				"set_field:arp_op=0x0002,move:arp_sha[0..47]->arp_tha[0..47],set_field:arp_sha=02:42:00:00:00:00,move:arp_tpa[0..31]->arp_sha[0..31]," +
+				"move:arp_sha[0..47]->eth_src[0..47],move:arp_spa[0..31]->reg1[0..31],move:arp_tpa[0..31]->arp_spa[0..31],move:reg1[0..31]->arp_tpa[0..31],output:in_port",
+		},
+		{
+			flow:     &ofctl.Flow{Table: 7, Priority: 0, Match: []ofctl.Field{ofctl.ARP}},
+			expected: "cookie=0x0,table=7,priority=0,eth_type=0x0806 actions=",
+		},
+	}
+	for _, test := range tests {
+		mod, err := FromFlow(test.flow)
+		if err != nil {
+			t.Errorf("Unexpected error translating %s: %v", test.flow, err)
+			continue
+		}
+		if mod.String() != test.expected {
+			t.Errorf("Wrong translation of %s.\nExpected %s\nGot      %s", test.flow, test.expected, mod)
+		}
+	}
+
+	for _, flow := range []*ofctl.Flow{
+		{Match: []ofctl.Field{ofctl.TCP}},
+		{Match: []ofctl.Field{ofctl.IP, ofctl.Eq("nw_dst", "10.1.2")}},
+		{Actions: []ofctl.Action{{Name: "flood"}}},
+		{Actions: []ofctl.Action{ofctl.SetField("10.1.2.1", "nw_dst")}},
+		{Actions: []ofctl.Action{ofctl.SetField("10.1.2.1", "eth_src")}},
+		{Actions: []ofctl.Action{ofctl.SetField("02:42:0a:01:02:01", "arp_spa")}},
+		{Actions: []ofctl.Action{ofctl.Move("NXM_NX_REG0[0..23]", "NXM_NX_TUN_ID[0..31]")}},
+		{Actions: []ofctl.Action{ofctl.Move("NXM_NX_REG0[0..32]", "NXM_NX_TUN_ID[0..32]")}},
+	} {
+		if mod, err := FromFlow(flow); err == nil {
+			t.Errorf("Expected an error translating %s, got %s", flow, mod)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Package ovsdb is a minimal OVSDB (RFC 7047) JSON-RPC client for
+// configuring the Open_vSwitch database without running ovs-vsctl.
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// DefaultSocket is where ovsdb-server listens by default.
+const DefaultSocket = "/var/run/openvswitch/db.sock"
+
+// DefaultTimeout bounds how long a call waits for ovsdb-server.
+const DefaultTimeout = 10 * time.Second
+
+// Client is a JSON-RPC connection to ovsdb-server. It answers the server's
+// inactivity probes and is safe for concurrent use.
+type Client struct {
+	conn    net.Conn
+	Timeout time.Duration
+
+	writeLock sync.Mutex
+	enc       *json.Encoder
+
+	lock    sync.Mutex
+	id      uint64
+	pending map[uint64]chan *response
+	closed  chan struct{}
+	readErr error
+}
+
+type request struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     interface{}   `json:"id"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	ID     interface{}     `json:"id"`
+}
+
+// incoming is either a response to one of our requests or a request
+// (e.g. an echo) from the server.
+type incoming struct {
+	response
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// RPCError is a JSON-RPC level error returned by ovsdb-server.
+type RPCError struct {
+	Method string
+	Err    interface{}
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("OVSDB %s failed: %v", e.Method, e.Err)
+}
+
+// Dial connects to ovsdb-server, e.g. Dial("unix", DefaultSocket).
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.DialTimeout(network, address, DefaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient returns a client speaking OVSDB over an established connection.
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		conn:    conn,
+		Timeout: DefaultTimeout,
+		enc:     json.NewEncoder(conn),
+		pending: make(map[uint64]chan *response),
+		closed:  make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *Client) send(v interface{}) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.enc.Encode(v)
+}
+
+func (c *Client) readLoop() {
+	dec := json.NewDecoder(c.conn)
+	var err error
+	for {
+		msg := &incoming{}
+		if err = dec.Decode(msg); err != nil {
+			break
+		}
+		if msg.Method != "" {
+			// answer without blocking the reader, which may be needed to
+			// drain a response the server is writing concurrently
+			go c.handleRequest(msg)
+			continue
+		}
+
+		id, ok := msg.ID.(float64)
+		c.lock.Lock()
+		ch, found := c.pending[uint64(id)]
+		delete(c.pending, uint64(id))
+		c.lock.Unlock()
+		if !ok || !found {
+			log.Warningf("Ignoring OVSDB response with unknown id %v", msg.ID)
+			continue
+		}
+		ch <- &msg.response
+	}
+
+	c.lock.Lock()
+	c.readErr = fmt.Errorf("OVSDB connection closed: %v", err)
+	close(c.closed)
+	c.lock.Unlock()
+}
+
+func (c *Client) handleRequest(msg *incoming) {
+	switch msg.Method {
+	case "echo":
+		if err := c.send(map[string]interface{}{"result": msg.Params, "error": nil, "id": msg.ID}); err != nil {
+			log.Warningf("Failed to answer OVSDB echo: %v", err)
+		}
+	default:
+		log.V(5).Infof("Ignoring OVSDB %s from server", msg.Method)
+	}
+}
+
+// call sends a request and decodes its result into result.
+func (c *Client) call(method string, params []interface{}, result interface{}) error {
+	ch := make(chan *response, 1)
+	c.lock.Lock()
+	c.id++
+	id := c.id
+	c.pending[id] = ch
+	c.lock.Unlock()
+	cancel := func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}
+
+	if err := c.send(&request{Method: method, Params: params, ID: id}); err != nil {
+		cancel()
+		return fmt.Errorf("Failed to send OVSDB %s: %v", method, err)
+	}
+
+	timer := time.NewTimer(c.Timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return &RPCError{Method: method, Err: resp.Error}
+		}
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("Failed to decode OVSDB %s result: %v", method, err)
+		}
+		return nil
+	case <-c.closed:
+		return c.readErr
+	case <-timer.C:
+		cancel()
+		return fmt.Errorf("Timed out waiting for OVSDB %s", method)
+	}
+}
+
+// Echo checks that ovsdb-server is responsive.
+func (c *Client) Echo() error {
+	return c.call("echo", []interface{}{"ping"}, nil)
+}
+
+// ListDbs returns the databases served by ovsdb-server.
+func (c *Client) ListDbs() ([]string, error) {
+	dbs := []string{}
+	err := c.call("list_dbs", []interface{}{}, &dbs)
+	return dbs, err
+}
+
+// Transact runs ops atomically against db. If an operation fails, the whole
+// transaction is rolled back and an *Error identifying the operation is returned.
+func (c *Client) Transact(db string, ops ...Operation) ([]Result, error) {
+	params := []interface{}{db}
+	for _, op := range ops {
+		params = append(params, op)
+	}
+	results := []Result{}
+	if err := c.call("transact", params, &results); err != nil {
+		return nil, err
+	}
+	for i, r := range results {
+		if r.Error == "" {
+			continue
+		}
+		e := &Error{Index: i, Err: r.Error, Details: r.Details}
+		// a result beyond the last operation reports a failed commit
+		if i < len(ops) {
+			e.Op, _ = ops[i]["op"].(string)
+		}
+		return nil, e
+	}
+	if len(results) < len(ops) {
+		return nil, fmt.Errorf("OVSDB returned %d results for %d operations", len(results), len(ops))
+	}
+	return results, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,355 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeServer answers JSON-RPC requests with handler and records the
+// transactions it receives. Before each response it probes the client with
+// an echo, as ovsdb-server does for idle connections, and waits for the reply.
+type fakeServer struct {
+	handler      func(method string, params []interface{}) (interface{}, interface{})
+	transactions [][]interface{}
+	echoes       int
+}
+
+func (s *fakeServer) serve(t *testing.T, conn net.Conn) {
+	dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+	type message struct {
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+		ID     interface{}   `json:"id"`
+		Result interface{}   `json:"result"`
+	}
+	for {
+		req := &message{}
+		if err := dec.Decode(req); err != nil {
+			return
+		}
+		if req.Method == "transact" {
+			s.transactions = append(s.transactions, req.Params)
+		}
+
+		enc.Encode(map[string]interface{}{"method": "echo", "params": []string{"probe"}, "id": "echo"})
+		reply := &message{}
+		if err := dec.Decode(reply); err != nil {
+			return
+		}
+		if reply.ID != "echo" || !reflect.DeepEqual(reply.Result, []interface{}{"probe"}) {
+			t.Errorf("Wrong echo reply: %+v", reply)
+		}
+		s.echoes++
+
+		result, rpcErr := s.handler(req.Method, req.Params)
+		enc.Encode(map[string]interface{}{"result": result, "error": rpcErr, "id": req.ID})
+	}
+}
+
+func newTestClient(t *testing.T, handler func(string, []interface{}) (interface{}, interface{})) (*Client, *fakeServer) {
+	server := &fakeServer{handler: handler}
+	clientConn, serverConn := net.Pipe()
+	go server.serve(t, serverConn)
+	return NewClient(clientConn), server
+}
+
+// ops decodes the JSON encoding of ops the way the server sees them.
+func ops(t *testing.T, v ...interface{}) []interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Error encoding %v: %v", v, err)
+	}
+	decoded := []interface{}{}
+	json.Unmarshal(data, &decoded)
+	return decoded
+}
+
+func TestListDbsAndEcho(t *testing.T) {
+	c, server := newTestClient(t, func(method string, params []interface{}) (interface{}, interface{}) {
+		switch method {
+		case "list_dbs":
+			return []string{"Open_vSwitch"}, nil
+		case "echo":
+			return params, nil
+		}
+		return nil, "unknown method"
+	})
+	defer c.Close()
+
+	dbs, err := c.ListDbs()
+	if err != nil || !reflect.DeepEqual(dbs, []string{"Open_vSwitch"}) {
+		t.Fatalf("Wrong databases: %v (%v)", dbs, err)
+	}
+	if err := c.Echo(); err != nil {
+		t.Fatalf("Error sending echo: %v", err)
+	}
+	if _, err := c.Transact("Open_vSwitch"); err == nil {
+		t.Fatalf("Expected an RPC error for an unknown method")
+	} else if e, ok := err.(*RPCError); !ok || e.Err != "unknown method" {
+		t.Fatalf("Wrong RPC error: %#v", err)
+	}
+	if server.echoes != 3 {
+		t.Fatalf("Expected the client to answer 3 server probes, got %d", server.echoes)
+	}
+}
+
+func TestAddPort(t *testing.T) {
+	c, server := newTestClient(t, func(method string, params []interface{}) (interface{}, interface{}) {
+		if len(params) == 2 {
+			// the existence check
+			return []interface{}{map[string]interface{}{"rows": []interface{}{}}}, nil
+		}
+		return []interface{}{
+			map[string]interface{}{"uuid": []string{"uuid", "1"}},
+			map[string]interface{}{"uuid": []string{"uuid", "2"}},
+			map[string]interface{}{"count": 1},
+		}, nil
+	})
+	defer c.Close()
+
+	iface := &Interface{Type: "vxlan", Options: map[string]string{"remote_ip": "flow", "key": "flow"}, OfportRequest: 1}
+	if err := c.AddPort("br0", "vxlan0", iface); err != nil {
+		t.Fatalf("Error adding port: %v", err)
+	}
+
+	expected := ops(t, "Open_vSwitch",
+		Operation{"op": "insert", "table": "Interface", "uuid-name": "iface", "row": Row{
+			"name": "vxlan0", "type": "vxlan", "ofport_request": 1,
+			"options": []interface{}{"map", [][]string{{"key", "flow"}, {"remote_ip", "flow"}}},
+		}},
+		Operation{"op": "insert", "table": "Port", "uuid-name": "port", "row": Row{
+			"name": "vxlan0", "interfaces": []string{"named-uuid", "iface"},
+		}},
+		Operation{"op": "mutate", "table": "Bridge",
+			"where":     [][]interface{}{{"name", "==", "br0"}},
+			"mutations": [][]interface{}{{"ports", "insert", []string{"named-uuid", "port"}}},
+		},
+	)
+	if len(server.transactions) != 2 {
+		t.Fatalf("Expected 2 transactions, got %v", server.transactions)
+	}
+	got := server.transactions[1]
+	// map ordering is not significant
+	options := got[1].(map[string]interface{})["row"].(map[string]interface{})["options"].([]interface{})
+	if pairs := options[1].([]interface{}); pairs[0].([]interface{})[0] != "key" {
+		pairs[0], pairs[1] = pairs[1], pairs[0]
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Wrong add-port transaction.\nExpected %v\nGot      %v", expected, got)
+	}
+}
+
+func TestTransactErrors(t *testing.T) {
+	c, _ := newTestClient(t, func(method string, params []interface{}) (interface{}, interface{}) {
+		return []interface{}{
+			map[string]interface{}{"count": 1},
+			map[string]interface{}{"error": "constraint violation", "details": "Transaction causes multiple rows in \"Port\" table to have identical values"},
+		}, nil
+	})
+	defer c.Close()
+
+	_, err := c.Transact(VSwitchDB,
+		Mutate("Bridge", []Condition{Equal("name", "br0")}, Mutation{"ports", "insert", NamedUUID("port")}),
+		Insert("Port", Row{"name": "tun0"}, "port"),
+	)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Expected an *ovsdb.Error, got %v", err)
+	}
+	if e.Index != 1 || e.Op != "insert" || e.Err != "constraint violation" {
+		t.Fatalf("Wrong error: %v", e)
+	}
+}
+
+func TestGetOfport(t *testing.T) {
+	rows := map[string]interface{}{
+		"tun0":   map[string]interface{}{"ofport": 2},
+		"vovsbr": map[string]interface{}{"ofport": []interface{}{"set", []interface{}{}}},
+		"veth1":  map[string]interface{}{"ofport": -1},
+	}
+	c, _ := newTestClient(t, func(method string, params []interface{}) (interface{}, interface{}) {
+		where := params[1].(map[string]interface{})["where"].([]interface{})[0].([]interface{})
+		if row, ok := rows[where[2].(string)]; ok {
+			return []interface{}{map[string]interface{}{"rows": []interface{}{row}}}, nil
+		}
+		return []interface{}{map[string]interface{}{"rows": []interface{}{}}}, nil
+	})
+	defer c.Close()
+
+	if ofport, err := c.GetOfport("tun0"); err != nil || ofport != 2 {
+		t.Fatalf("Wrong ofport for tun0: %d (%v)", ofport, err)
+	}
+	for _, iface := range []string{"vovsbr", "veth1", "missing"} {
+		if ofport, err := c.GetOfport(iface); err == nil {
+			t.Fatalf("Expected an error getting the ofport of %s, got %d", iface, ofport)
+		}
+	}
+}
+
+func TestGetInterface(t *testing.T) {
+	rows := map[string]interface{}{
+		"vxlan0": map[string]interface{}{
+			"type":           "vxlan",
+			"options":        []interface{}{"map", []interface{}{[]interface{}{"key", "flow"}, []interface{}{"remote_ip", "flow"}}},
+			"ofport_request": 1,
+		},
+		"vovsbr": map[string]interface{}{
+			"type":           "",
+			"options":        []interface{}{"map", []interface{}{}},
+			"ofport_request": []interface{}{"set", []interface{}{}},
+		},
+	}
+	c, _ := newTestClient(t, func(method string, params []interface{}) (interface{}, interface{}) {
+		where := params[1].(map[string]interface{})["where"].([]interface{})[0].([]interface{})
+		if row, ok := rows[where[2].(string)]; ok {
+			return []interface{}{map[string]interface{}{"rows": []interface{}{row}}}, nil
+		}
+		return []interface{}{map[string]interface{}{"rows": []interface{}{}}}, nil
+	})
+	defer c.Close()
+
+	tests := []struct {
+		iface  string
+		config *Interface
+	}{
+		{"vxlan0", &Interface{Type: "vxlan", Options: map[string]string{"remote_ip": "flow", "key": "flow"}, OfportRequest: 1}},
+		{"vovsbr", &Interface{Options: map[string]string{}}},
+		{"missing", nil},
+	}
+	for _, test := range tests {
+		if config, err := c.GetInterface(test.iface); err != nil || !reflect.DeepEqual(config, test.config) {
+			t.Errorf("Wrong config for %s: %+v (%v)", test.iface, config, err)
+		}
+	}
+}
+
+func TestGetAndSetBridge(t *testing.T) {
+	rows := map[string]interface{}{
+		"br0":  map[string]interface{}{"fail_mode": "secure", "protocols": []interface{}{"set", []interface{}{"OpenFlow13", "OpenFlow14"}}},
+		"br1":  map[string]interface{}{"fail_mode": []interface{}{"set", []interface{}{}}, "protocols": "OpenFlow10"},
+		"lbr0": nil,
+	}
+	c, server := newTestClient(t, func(method string, params []interface{}) (interface{}, interface{}) {
+		op := params[1].(map[string]interface{})
+		name := op["where"].([]interface{})[0].([]interface{})[2].(string)
+		if op["op"] == "update" {
+			count := 0
+			if _, ok := rows[name]; ok {
+				count = 1
+			}
+			return []interface{}{map[string]interface{}{"count": count}}, nil
+		}
+		if row := rows[name]; row != nil {
+			return []interface{}{map[string]interface{}{"rows": []interface{}{row}}}, nil
+		}
+		return []interface{}{map[string]interface{}{"rows": []interface{}{}}}, nil
+	})
+	defer c.Close()
+
+	tests := []struct {
+		bridge string
+		config *BridgeConfig
+	}{
+		{"br0", &BridgeConfig{FailMode: "secure", Protocols: []string{"OpenFlow13", "OpenFlow14"}}},
+		{"br1", &BridgeConfig{Protocols: []string{"OpenFlow10"}}},
+		{"lbr0", nil},
+	}
+	for _, test := range tests {
+		if config, err := c.GetBridge(test.bridge); err != nil || !reflect.DeepEqual(config, test.config) {
+			t.Errorf("Wrong config for %s: %+v (%v)", test.bridge, config, err)
+		}
+	}
+
+	if err := c.SetBridge("br1", "secure", []string{"OpenFlow13"}); err != nil {
+		t.Fatalf("Error setting up br1: %v", err)
+	}
+	expected := ops(t, "Open_vSwitch", Operation{"op": "update", "table": "Bridge",
+		"where": [][]interface{}{{"name", "==", "br1"}},
+		"row":   Row{"fail_mode": "secure", "protocols": []interface{}{"set", []string{"OpenFlow13"}}},
+	})
+	if got := server.transactions[len(server.transactions)-1]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wrong update.\nExpected %v\nGot      %v", expected, got)
+	}
+	if err := c.SetBridge("br2", "secure", nil); err == nil {
+		t.Errorf("Expected an error setting up a missing bridge")
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	c, server := newTestClient(t, func(method string, params []interface{}) (interface{}, interface{}) {
+		results := []interface{}{}
+		for _, p := range params[1:] {
+			op := p.(map[string]interface{})
+			count := 1
+			if where, ok := op["where"].([]interface{}); ok && where[0].([]interface{})[2] == "veth-gone" {
+				count = 0
+			}
+			results = append(results, map[string]interface{}{"count": count})
+		}
+		return results, nil
+	})
+	defer c.Close()
+
+	if err := c.SetIngressPolicing("veth1", 10000, 1000); err != nil {
+		t.Fatalf("Error policing veth1: %v", err)
+	}
+	expected := ops(t, "Open_vSwitch", Operation{"op": "update", "table": "Interface",
+		"where": [][]interface{}{{"name", "==", "veth1"}},
+		"row":   Row{"ingress_policing_rate": 10000, "ingress_policing_burst": 1000},
+	})
+	if got := server.transactions[len(server.transactions)-1]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wrong policing update.\nExpected %v\nGot      %v", expected, got)
+	}
+
+	owner := [][]interface{}{{"external_ids", "includes", []interface{}{"map", [][]string{{"openshift-sdn-port", "veth1"}}}}}
+	clear := []interface{}{
+		Operation{"op": "update", "table": "Port",
+			"where": [][]interface{}{{"name", "==", "veth1"}},
+			"row":   Row{"qos": []interface{}{"set", []interface{}{}}},
+		},
+		Operation{"op": "delete", "table": "QoS", "where": owner},
+		Operation{"op": "delete", "table": "Queue", "where": owner},
+	}
+	config := []interface{}{"map", [][]string{{"max-rate", "20000000"}}}
+	ids := []interface{}{"map", [][]string{{"openshift-sdn-port", "veth1"}}}
+
+	if err := c.SetPortQoS("veth1", 20000000); err != nil {
+		t.Fatalf("Error shaping veth1: %v", err)
+	}
+	expected = ops(t, append(append([]interface{}{"Open_vSwitch"}, clear...),
+		Operation{"op": "insert", "table": "Queue", "uuid-name": "queue", "row": Row{
+			"other_config": config, "external_ids": ids,
+		}},
+		Operation{"op": "insert", "table": "QoS", "uuid-name": "qos", "row": Row{
+			"type": "linux-htb", "other_config": config, "external_ids": ids,
+			"queues": []interface{}{"map", [][]interface{}{{0, []string{"named-uuid", "queue"}}}},
+		}},
+		Operation{"op": "update", "table": "Port",
+			"where": [][]interface{}{{"name", "==", "veth1"}},
+			"row":   Row{"qos": []string{"named-uuid", "qos"}},
+		},
+	)...)
+	if got := server.transactions[len(server.transactions)-1]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wrong QoS transaction.\nExpected %v\nGot      %v", expected, got)
+	}
+
+	if err := c.ClearPortQoS("veth1"); err != nil {
+		t.Fatalf("Error clearing the QoS of veth1: %v", err)
+	}
+	expected = ops(t, append([]interface{}{"Open_vSwitch"}, clear...)...)
+	if got := server.transactions[len(server.transactions)-1]; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Wrong QoS removal.\nExpected %v\nGot      %v", expected, got)
+	}
+
+	if err := c.SetIngressPolicing("veth-gone", 10000, 1000); err == nil {
+		t.Errorf("Expected an error policing a missing interface")
+	}
+	if err := c.SetPortQoS("veth-gone", 20000000); err == nil {
+		t.Errorf("Expected an error shaping a missing port")
+	}
+	if err := c.ClearPortQoS("veth-gone"); err != nil {
+		t.Errorf("Error clearing the QoS of a missing port: %v", err)
+	}
+}
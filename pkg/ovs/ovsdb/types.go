@@ -0,0 +1,226 @@
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Operation is a single OVSDB transaction operation; use the constructors
+// below to build one.
+type Operation map[string]interface{}
+
+// Condition is a [column, function, value] triple in a "where" clause.
+type Condition [3]interface{}
+
+// Mutation is a [column, mutator, value] triple in a mutate operation.
+type Mutation [3]interface{}
+
+// Row is a table row, keyed by column name.
+type Row map[string]interface{}
+
+// Equal returns the condition column == value.
+func Equal(column string, value interface{}) Condition {
+	return Condition{column, "==", value}
+}
+
+// Includes returns the condition that the map or set column includes value,
+// e.g. a Map of some of its pairs.
+func Includes(column string, value interface{}) Condition {
+	return Condition{column, "includes", value}
+}
+
+// Insert returns an operation inserting row into table. If uuidName is set,
+// later operations can refer to the new row as NamedUUID(uuidName).
+func Insert(table string, row Row, uuidName string) Operation {
+	op := Operation{"op": "insert", "table": table, "row": row}
+	if uuidName != "" {
+		op["uuid-name"] = uuidName
+	}
+	return op
+}
+
+// Select returns an operation reading columns of the rows of table matching where.
+func Select(table string, where []Condition, columns ...string) Operation {
+	op := Operation{"op": "select", "table": table, "where": conditions(where)}
+	if len(columns) > 0 {
+		op["columns"] = columns
+	}
+	return op
+}
+
+// Update returns an operation setting the columns in row on every row of table
+// matching where.
+func Update(table string, where []Condition, row Row) Operation {
+	return Operation{"op": "update", "table": table, "where": conditions(where), "row": row}
+}
+
+// Mutate returns an operation applying mutations to every row of table
+// matching where.
+func Mutate(table string, where []Condition, mutations ...Mutation) Operation {
+	return Operation{"op": "mutate", "table": table, "where": conditions(where), "mutations": mutations}
+}
+
+// Delete returns an operation deleting the rows of table matching where.
+func Delete(table string, where []Condition) Operation {
+	return Operation{"op": "delete", "table": table, "where": conditions(where)}
+}
+
+// conditions makes sure an empty where clause is sent as [] rather than null.
+func conditions(where []Condition) []Condition {
+	if where == nil {
+		return []Condition{}
+	}
+	return where
+}
+
+// Result is the outcome of a single operation.
+type Result struct {
+	Count   int    `json:"count,omitempty"`
+	UUID    UUID   `json:"uuid,omitempty"`
+	Rows    []Row  `json:"rows,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+// Error is a failed OVSDB operation. Index is the position of the failed
+// operation in the transaction; an Index past the last operation means the
+// commit itself failed.
+type Error struct {
+	Index   int
+	Op      string
+	Err     string
+	Details string
+}
+
+func (e *Error) Error() string {
+	if e.Op == "" {
+		return fmt.Sprintf("OVSDB transaction failed: %s (%s)", e.Err, e.Details)
+	}
+	return fmt.Sprintf("OVSDB %s (operation %d) failed: %s (%s)", e.Op, e.Index, e.Err, e.Details)
+}
+
+// UUID is a row reference.
+type UUID string
+
+func (u UUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"uuid", string(u)})
+}
+
+func (u *UUID) UnmarshalJSON(data []byte) error {
+	var pair []string
+	if err := json.Unmarshal(data, &pair); err != nil || len(pair) != 2 || pair[0] != "uuid" {
+		return fmt.Errorf("invalid OVSDB uuid %s", data)
+	}
+	*u = UUID(pair[1])
+	return nil
+}
+
+// NamedUUID refers to a row inserted earlier in the same transaction.
+type NamedUUID string
+
+func (u NamedUUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]string{"named-uuid", string(u)})
+}
+
+// Set is an OVSDB set value.
+type Set []interface{}
+
+func (s Set) MarshalJSON() ([]byte, error) {
+	elems := []interface{}(s)
+	if elems == nil {
+		elems = []interface{}{}
+	}
+	return json.Marshal([]interface{}{"set", elems})
+}
+
+// Map is an OVSDB map value with string keys and values, such as the options
+// column of an Interface.
+type Map map[string]string
+
+func (m Map) MarshalJSON() ([]byte, error) {
+	pairs := [][]string{}
+	for k, v := range m {
+		pairs = append(pairs, []string{k, v})
+	}
+	return json.Marshal([]interface{}{"map", pairs})
+}
+
+// Int returns the integer value of column in a row returned by a select. An
+// empty optional column (["set",[]]) is reported as not present.
+func (r Row) Int(column string) (int, bool) {
+	switch v := r[column].(type) {
+	case float64:
+		return int(v), true
+	case []interface{}:
+		if len(v) == 2 && v[0] == "set" {
+			if elems, ok := v[1].([]interface{}); ok && len(elems) == 1 {
+				if n, ok := elems[0].(float64); ok {
+					return int(n), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// String returns the value of a string column in a row returned by a select.
+// An empty optional column is reported as not present.
+func (r Row) String(column string) (string, bool) {
+	if elems := r.Strings(column); len(elems) == 1 {
+		return elems[0], true
+	}
+	return "", false
+}
+
+// Strings returns the elements of a set of strings column in a row returned
+// by a select. A set with one element is sent as the bare element.
+func (r Row) Strings(column string) []string {
+	switch v := r[column].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		if len(v) == 2 && v[0] == "set" {
+			elems, _ := v[1].([]interface{})
+			strs := []string{}
+			for _, e := range elems {
+				if s, ok := e.(string); ok {
+					strs = append(strs, s)
+				}
+			}
+			return strs
+		}
+	}
+	return nil
+}
+
+// Map returns the value of a map column with string keys and values, such
+// as options, in a row returned by a select.
+func (r Row) Map(column string) map[string]string {
+	v, ok := r[column].([]interface{})
+	if !ok || len(v) != 2 || v[0] != "map" {
+		return nil
+	}
+	pairs, _ := v[1].([]interface{})
+	m := make(map[string]string)
+	for _, p := range pairs {
+		pair, ok := p.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		k, _ := pair[0].(string)
+		val, _ := pair[1].(string)
+		m[k] = val
+	}
+	return m
+}
+
+// UUID returns the value of a uuid column, such as _uuid, in a row returned
+// by a select.
+func (r Row) UUID(column string) (UUID, bool) {
+	v, ok := r[column].([]interface{})
+	if !ok || len(v) != 2 || v[0] != "uuid" {
+		return "", false
+	}
+	s, ok := v[1].(string)
+	return UUID(s), ok
+}
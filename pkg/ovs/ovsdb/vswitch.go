@@ -0,0 +1,273 @@
+package ovsdb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// VSwitchDB is the database managed by ovs-vswitchd.
+const VSwitchDB = "Open_vSwitch"
+
+// Interface describes the Interface row created for a port.
+type Interface struct {
+	// Type is the interface type, e.g. "internal" or "vxlan"; empty for a
+	// system device such as a veth.
+	Type string
+	// Options are type specific settings, e.g. {"remote_ip": "flow"}.
+	Options map[string]string
+	// OfportRequest asks for a specific OpenFlow port number when non-zero.
+	OfportRequest int
+}
+
+func (i *Interface) row(name string) Row {
+	row := Row{"name": name}
+	if i.Type != "" {
+		row["type"] = i.Type
+	}
+	if len(i.Options) > 0 {
+		row["options"] = Map(i.Options)
+	}
+	if i.OfportRequest != 0 {
+		row["ofport_request"] = i.OfportRequest
+	}
+	return row
+}
+
+// AddBridge creates bridge, like "ovs-vsctl --may-exist add-br", setting its
+// fail mode (e.g. "secure") and OpenFlow protocols when given. It does nothing
+// if the bridge already exists.
+func (c *Client) AddBridge(bridge, failMode string, protocols []string) error {
+	exists, err := c.exists("Bridge", bridge)
+	if err != nil || exists {
+		return err
+	}
+
+	row := Row{"name": bridge, "ports": NamedUUID("port")}
+	if failMode != "" {
+		row["fail_mode"] = failMode
+	}
+	if len(protocols) > 0 {
+		set := Set{}
+		for _, p := range protocols {
+			set = append(set, p)
+		}
+		row["protocols"] = set
+	}
+	_, err = c.Transact(VSwitchDB,
+		Insert("Interface", Row{"name": bridge, "type": "internal"}, "iface"),
+		Insert("Port", Row{"name": bridge, "interfaces": NamedUUID("iface")}, "port"),
+		Insert("Bridge", row, "bridge"),
+		Mutate("Open_vSwitch", nil, Mutation{"bridges", "insert", NamedUUID("bridge")}),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to add bridge %s: %v", bridge, err)
+	}
+	return nil
+}
+
+// BridgeConfig is the configuration of a bridge that node setup manages.
+type BridgeConfig struct {
+	FailMode  string
+	Protocols []string
+}
+
+// GetBridge returns the configuration of bridge, or nil if there is no such
+// bridge.
+func (c *Client) GetBridge(bridge string) (*BridgeConfig, error) {
+	results, err := c.Transact(VSwitchDB, Select("Bridge", []Condition{Equal("name", bridge)}, "fail_mode", "protocols"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up bridge %s: %v", bridge, err)
+	}
+	if len(results[0].Rows) == 0 {
+		return nil, nil
+	}
+	row := results[0].Rows[0]
+	failMode, _ := row.String("fail_mode")
+	return &BridgeConfig{FailMode: failMode, Protocols: row.Strings("protocols")}, nil
+}
+
+// SetBridge sets the fail mode and OpenFlow protocols of bridge, like
+// "ovs-vsctl set Bridge <bridge> fail_mode=<failMode> protocols=<protocols>".
+func (c *Client) SetBridge(bridge, failMode string, protocols []string) error {
+	set := Set{}
+	for _, p := range protocols {
+		set = append(set, p)
+	}
+	results, err := c.Transact(VSwitchDB,
+		Update("Bridge", []Condition{Equal("name", bridge)}, Row{"fail_mode": failMode, "protocols": set}),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to configure bridge %s: %v", bridge, err)
+	}
+	if results[0].Count != 1 {
+		return fmt.Errorf("Failed to configure bridge %s: it does not exist", bridge)
+	}
+	return nil
+}
+
+// AddPort adds port with a single interface of the same name to bridge, like
+// "ovs-vsctl --may-exist add-port". It does nothing if the port already exists.
+func (c *Client) AddPort(bridge, port string, iface *Interface) error {
+	exists, err := c.exists("Port", port)
+	if err != nil || exists {
+		return err
+	}
+	if iface == nil {
+		iface = &Interface{}
+	}
+
+	results, err := c.Transact(VSwitchDB,
+		Insert("Interface", iface.row(port), "iface"),
+		Insert("Port", Row{"name": port, "interfaces": NamedUUID("iface")}, "port"),
+		Mutate("Bridge", []Condition{Equal("name", bridge)}, Mutation{"ports", "insert", NamedUUID("port")}),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to add port %s to %s: %v", port, bridge, err)
+	}
+	if results[2].Count != 1 {
+		// nothing references the new rows, so ovsdb-server has already
+		// garbage collected them
+		return fmt.Errorf("Failed to add port %s: bridge %s does not exist", port, bridge)
+	}
+	return nil
+}
+
+// DeletePort removes port from bridge, like "ovs-vsctl --if-exists del-port".
+func (c *Client) DeletePort(bridge, port string) error {
+	results, err := c.Transact(VSwitchDB, Select("Port", []Condition{Equal("name", port)}, "_uuid"))
+	if err != nil {
+		return fmt.Errorf("Failed to look up port %s: %v", port, err)
+	}
+	if len(results[0].Rows) == 0 {
+		return nil
+	}
+	uuid, ok := results[0].Rows[0].UUID("_uuid")
+	if !ok {
+		return fmt.Errorf("Failed to look up port %s: no uuid in %v", port, results[0].Rows[0])
+	}
+
+	// the Port and its Interface are garbage collected once unreferenced
+	_, err = c.Transact(VSwitchDB,
+		Mutate("Bridge", []Condition{Equal("name", bridge)}, Mutation{"ports", "delete", uuid}),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to delete port %s from %s: %v", port, bridge, err)
+	}
+	return nil
+}
+
+// GetOfport returns the OpenFlow port number of an interface, like
+// "ovs-vsctl get Interface <iface> ofport".
+func (c *Client) GetOfport(iface string) (int, error) {
+	results, err := c.Transact(VSwitchDB, Select("Interface", []Condition{Equal("name", iface)}, "ofport"))
+	if err != nil {
+		return -1, fmt.Errorf("Failed to look up interface %s: %v", iface, err)
+	}
+	if len(results[0].Rows) == 0 {
+		return -1, fmt.Errorf("Interface %s does not exist", iface)
+	}
+	ofport, ok := results[0].Rows[0].Int("ofport")
+	if !ok {
+		return -1, fmt.Errorf("Interface %s has no OpenFlow port yet", iface)
+	}
+	if ofport < 0 {
+		return -1, fmt.Errorf("Interface %s could not be attached to the bridge", iface)
+	}
+	return ofport, nil
+}
+
+// GetInterface returns the configuration of an interface, or nil if there is
+// no such interface.
+func (c *Client) GetInterface(iface string) (*Interface, error) {
+	results, err := c.Transact(VSwitchDB, Select("Interface", []Condition{Equal("name", iface)}, "type", "options", "ofport_request"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up interface %s: %v", iface, err)
+	}
+	if len(results[0].Rows) == 0 {
+		return nil, nil
+	}
+	row := results[0].Rows[0]
+	config := &Interface{Options: row.Map("options")}
+	config.Type, _ = row.String("type")
+	config.OfportRequest, _ = row.Int("ofport_request")
+	return config, nil
+}
+
+func (c *Client) exists(table, name string) (bool, error) {
+	results, err := c.Transact(VSwitchDB, Select(table, []Condition{Equal("name", name)}, "name"))
+	if err != nil {
+		return false, fmt.Errorf("Failed to look up %s %s: %v", table, name, err)
+	}
+	return len(results[0].Rows) > 0, nil
+}
+
+// SetIngressPolicing limits the traffic OVS takes in from iface to rate kbps
+// with bursts of up to burst kb, like "ovs-vsctl set Interface <iface>
+// ingress_policing_rate=<rate> ingress_policing_burst=<burst>". A rate of 0
+// removes the limit.
+func (c *Client) SetIngressPolicing(iface string, rate, burst int) error {
+	results, err := c.Transact(VSwitchDB,
+		Update("Interface", []Condition{Equal("name", iface)}, Row{"ingress_policing_rate": rate, "ingress_policing_burst": burst}),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to police interface %s: %v", iface, err)
+	}
+	if results[0].Count != 1 {
+		return fmt.Errorf("Failed to police interface %s: it does not exist", iface)
+	}
+	return nil
+}
+
+// portQoSOwner marks the QoS and Queue rows of a port. Unlike ports, they are
+// not garbage collected once unreferenced, so they are found by the mark to
+// remove them.
+const portQoSOwner = "openshift-sdn-port"
+
+// clearPortQoS returns the operations that detach the QoS of port and delete
+// its rows.
+func clearPortQoS(port string) []Operation {
+	owner := []Condition{Includes("external_ids", Map{portQoSOwner: port})}
+	return []Operation{
+		Update("Port", []Condition{Equal("name", port)}, Row{"qos": Set{}}),
+		Delete("QoS", owner),
+		Delete("Queue", owner),
+	}
+}
+
+// SetPortQoS shapes the traffic OVS sends out of port to rate bits per second
+// with a linux-htb QoS of one queue, replacing any QoS the port had, like
+// "ovs-vsctl set Port <port> qos=@qos -- --id=@qos create QoS
+// type=linux-htb other-config:max-rate=<rate> queues=0=@q -- --id=@q create
+// Queue other-config:max-rate=<rate>".
+func (c *Client) SetPortQoS(port string, rate uint64) error {
+	owner := Map{portQoSOwner: port}
+	config := Map{"max-rate": strconv.FormatUint(rate, 10)}
+	ops := clearPortQoS(port)
+	ops = append(ops,
+		Insert("Queue", Row{"other_config": config, "external_ids": owner}, "queue"),
+		Insert("QoS", Row{
+			"type":         "linux-htb",
+			"other_config": config,
+			"queues":       []interface{}{"map", [][]interface{}{{0, NamedUUID("queue")}}},
+			"external_ids": owner,
+		}, "qos"),
+		Update("Port", []Condition{Equal("name", port)}, Row{"qos": NamedUUID("qos")}),
+	)
+	results, err := c.Transact(VSwitchDB, ops...)
+	if err != nil {
+		return fmt.Errorf("Failed to shape port %s: %v", port, err)
+	}
+	if results[len(results)-1].Count != 1 {
+		return fmt.Errorf("Failed to shape port %s: it does not exist", port)
+	}
+	return nil
+}
+
+// ClearPortQoS removes the QoS SetPortQoS gave port, if any. It also removes
+// the QoS of a port that no longer exists.
+func (c *Client) ClearPortQoS(port string) error {
+	if _, err := c.Transact(VSwitchDB, clearPortQoS(port)...); err != nil {
+		return fmt.Errorf("Failed to remove the QoS of port %s: %v", port, err)
+	}
+	return nil
+}